@@ -1,27 +1,71 @@
+//go:build ignore
+
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"local-rag/filewalk"
 )
 
-// Statistics for the file filtering
+// Statistics for the file filtering. The counters are updated with
+// sync/atomic by the walk and the workers concurrently; the largest file and
+// the extension counts are guarded by mu.
 type FilterStats struct {
-	TotalFiles          int
-	IncludedFiles       int
-	ExcludedByDir       int
-	ExcludedByExt       int
-	ExcludedByPattern   int
-	ExcludedBySize      int
-	ExcludedHidden      int
-	TotalSizeIncluded   int64
-	TotalSizeExcluded   int64
-	LargestIncluded     string
-	LargestIncludedSize int64
+	TotalFiles        int64 `json:"total_files"`
+	IncludedFiles     int64 `json:"included_files"`
+	ExcludedByDir     int64 `json:"excluded_by_dir"`
+	ExcludedByExt     int64 `json:"excluded_by_ext"`
+	ExcludedByPattern int64 `json:"excluded_by_pattern"`
+	ExcludedBySize    int64 `json:"excluded_by_size"`
+	ExcludedHidden    int64 `json:"excluded_hidden"`
+	TotalSizeIncluded int64 `json:"total_size_included"`
+	TotalSizeExcluded int64 `json:"total_size_excluded"`
+
+	LargestIncluded     string           `json:"largest_included"`
+	LargestIncludedSize int64            `json:"largest_included_size"`
+	Extensions          map[string]int64 `json:"extensions"` // Included files per lowercase extension
+	ElapsedSeconds      float64          `json:"elapsed_seconds"`
+
+	// Sizes of every file seen, included or not, per lowercase extension
+	// for the histogram export
+	sizesByExt map[string][]int64
+
+	mu sync.Mutex
+}
+
+// addSeen records the size of a file seen by the walk, included or not
+func (s *FilterStats) addSeen(path string, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ext := strings.ToLower(filepath.Ext(path))
+	s.sizesByExt[ext] = append(s.sizesByExt[ext], size)
+}
+
+// addIncluded records an included file of the given size
+func (s *FilterStats) addIncluded(path string, size int64) {
+	atomic.AddInt64(&s.IncludedFiles, 1)
+	atomic.AddInt64(&s.TotalSizeIncluded, size)
+	
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Extensions[strings.ToLower(filepath.Ext(path))]++
+	if size > s.LargestIncludedSize {
+		s.LargestIncludedSize = size
+		s.LargestIncluded = path
+	}
 }
 
 func main() {
@@ -30,357 +74,145 @@ func main() {
 	maxFileSizeMB := flag.Int("max-size", 10, "Maximum file size in MB")
 	sampleOutput := flag.Bool("sample", false, "Show sample of included files")
 	sampleSize := flag.Int("sample-count", 20, "Number of sample files to show")
+	topExtensions := flag.Int("top-extensions", 20, "Number of extensions to show in the summary")
+	histogramPath := flag.String("export-histogram", "", "Write the count and average and median size of every extension seen to this CSV file")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of goroutines sizing included files")
+	jsonOutput := flag.Bool("json", false, "Print the statistics as JSON instead of the summary")
 	
 	flag.Parse()
 	
+	if *topExtensions < 0 {
+		fmt.Fprintf(os.Stderr, "--top-extensions must not be negative, got %d\n", *topExtensions)
+		os.Exit(1)
+	}
+	if *workers < 1 {
+		fmt.Fprintf(os.Stderr, "--workers must be at least 1, got %d\n", *workers)
+		os.Exit(1)
+	}
+	
 	// Convert max file size to bytes
 	maxFileSize := int64(*maxFileSizeMB * 1024 * 1024)
 	
 	// Setup statistics
-	stats := FilterStats{
-		LargestIncludedSize: 0,
-	}
-	
-	// Store sample of included files if requested
-	var includedSamples []string
-	
-	// Extensions to include - expanded list of code file extensions
-	extensions := map[string]bool{
-		// Programming languages
-		".go":    true,
-		".py":    true,
-		".js":    true,
-		".jsx":   true,
-		".ts":    true,
-		".tsx":   true,
-		".java":  true,
-		".c":     true,
-		".cpp":   true,
-		".cc":    true,
-		".cxx":   true,
-		".h":     true,
-		".hpp":   true,
-		".hxx":   true,
-		".cs":    true,
-		".php":   true,
-		".rb":    true,
-		".rs":    true,
-		".swift": true,
-		".kt":    true,
-		".scala": true,
-		".pl":    true,
-		".pm":    true,
-		".r":     true,
-		".lua":   true,
-		".groovy":true,
-		".dart":  true,
-		".elm":   true,
-		".ex":    true,
-		".exs":   true,
-		".erl":   true,
-		".hrl":   true,
-		".clj":   true,
-		".hs":    true,
-		".fs":    true,
-		".fsx":   true,
-		".ml":    true,
-		".mli":   true,
-		
-		// Shell scripts
-		".sh":    true,
-		".bash":  true,
-		".zsh":   true,
-		".fish":  true,
-		".ps1":   true,
-		".bat":   true,
-		".cmd":   true,
-		
-		// Web development
-		".html":  true,
-		".htm":   true,
-		".xhtml": true,
-		".css":   true,
-		".scss":  true,
-		".sass":  true,
-		".less":  true,
-		".vue":   true,
-		".svelte":true,
-		
-		// Data and config files
-		".json":  true,
-		".yaml":  true,
-		".yml":   true,
-		".xml":   true,
-		".toml":  true,
-		".ini":   true,
-		".sql":   true,
-		".graphql":true,
-		".proto": true,
-		
-		// Documentation
-		".md":    true,
-		".rst":   true,
-		".tex":   true,
-		".adoc":  true,
-	}
-	
-	// Directories to ignore - expanded with more common patterns
-	ignoreDirs := map[string]bool{
-		// Package managers and dependencies
-		"node_modules":    true,
-		"vendor":          true,
-		"bower_components":true,
-		"jspm_packages":   true,
-		"packages":        true,
-		
-		// Version control
-		".git":            true,
-		".svn":            true,
-		".hg":             true,
-		".bzr":            true,
-		
-		// Virtual environments
-		".venv":           true,
-		"venv":            true,
-		"env":             true,
-		".env":            true,
-		"virtualenv":      true,
-		"__pycache__":     true,
-		"site-packages":   true,
-		
-		// Build and distribution
-		"dist":            true,
-		"build":           true,
-		"out":             true,
-		"bin":             true,
-		"target":          true,
-		"output":          true,
-		"release":         true,
-		"debug":           true,
-		
-		// IDE and editor
-		".idea":           true,
-		".vscode":         true,
-		".vs":             true,
-		".eclipse":        true,
-		".settings":       true,
-		
-		// Temporary and cache
-		"tmp":             true,
-		"temp":            true,
-		"cache":           true,
-		".cache":          true,
-		".sass-cache":     true,
-		
-		// Documentation
-		"docs":            true,
-		"doc":             true,
-		
-		// Test coverage
-		"coverage":        true,
-		".nyc_output":     true,
-		".coverage":       true,
-		"htmlcov":         true,
-		
-		// Logs
-		"logs":            true,
-		"log":             true,
+	stats := &FilterStats{
+		Extensions: make(map[string]int64),
+		sizesByExt: make(map[string][]int64),
 	}
 	
-	// Files to ignore (by pattern)
-	ignoreFilePatterns := []string{
-		// Minified files
-		"*.min.js",
-		"*.min.css",
-		
-		// Generated files
-		"*.generated.*",
-		"*_generated.*",
-		"*.g.*",
-		"*.pb.*",
-		
-		// Compiled binaries
-		"*.exe",
-		"*.dll",
-		"*.so",
-		"*.dylib",
-		"*.class",
-		"*.o",
-		"*.obj",
-		"*.a",
-		"*.lib",
-		"*.pyc",
-		"*.pyo",
-		
-		// Archives
-		"*.zip",
-		"*.tar",
-		"*.gz",
-		"*.bz2",
-		"*.xz",
-		"*.rar",
-		"*.7z",
-		
-		// Media files
-		"*.jpg", "*.jpeg",
-		"*.png",
-		"*.gif",
-		"*.bmp",
-		"*.ico",
-		"*.svg",
-		"*.webp",
-		"*.mp3",
-		"*.mp4",
-		"*.wav",
-		"*.avi",
-		"*.mov",
-		"*.webm",
-		
-		// Lock files
-		"*.lock",
-		"package-lock.json",
-		"yarn.lock",
-		"Cargo.lock",
-		
-		// Backup files
-		"*~",
-		"*.bak",
-		"*.swp",
-		"*.swo",
-		
-		// Large data files
-		"*.csv",
-		"*.tsv",
-		"*.db",
-		"*.sqlite",
-		"*.sqlite3",
-		
-		// Logs
-		"*.log",
+	// Progress goes to stderr with --json so stdout stays parseable
+	var progress io.Writer = os.Stdout
+	if *jsonOutput {
+		progress = os.Stderr
 	}
 	
-	// Track extensions found
-	extensionsFound := make(map[string]int)
+	// Store sample of included files if requested
+	var includedSamples []string
+	var samplesMu sync.Mutex
 	
 	// Start time
 	startTime := time.Now()
-	fmt.Printf("Starting analysis of %s with max file size of %d MB\n", *rootDir, *maxFileSizeMB)
+	fmt.Fprintf(progress, "Starting analysis of %s with max file size of %d MB\n", *rootDir, *maxFileSizeMB)
 	
-	err := filepath.Walk(*rootDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			fmt.Printf("Error accessing path %s: %v\n", path, err)
-			return nil // Continue walking despite the error
-		}
-		
-		// Handle directories
-		if info.IsDir() {
-			// Check if we should skip this directory
-			baseName := filepath.Base(path)
-			
-			// Skip hidden directories (starting with .)
-			if strings.HasPrefix(baseName, ".") && baseName != "." && baseName != ".." {
-				return filepath.SkipDir
-			}
-			
-			// Check for direct matches with excluded directories
-			if ignoreDirs[baseName] {
-				stats.ExcludedByDir++
-				return filepath.SkipDir
-			}
-			
-			// Check for path components that should be skipped
-			pathParts := strings.Split(path, string(os.PathSeparator))
-			for _, part := range pathParts {
-				if ignoreDirs[part] {
-					stats.ExcludedByDir++
-					return filepath.SkipDir
-				}
-			}
-			
-			// Check for virtual environment paths
-			if (strings.Contains(path, "venv/lib/python") && strings.Contains(path, "site-packages")) ||
-			   (strings.Contains(path, "env/lib/python") && strings.Contains(path, "site-packages")) {
-				stats.ExcludedByDir++
-				return filepath.SkipDir
-			}
-			
-			return nil
-		}
-		
-		// Count total files
-		stats.TotalFiles++
+	// Count every file seen, included or not
+	countFile := func() {
+		total := atomic.AddInt64(&stats.TotalFiles, 1)
 		
 		// Progress indicator
-		if stats.TotalFiles%10000 == 0 {
-			fmt.Printf("Processed %d files...\n", stats.TotalFiles)
-		}
-		
-		// Handle files
-		fileName := filepath.Base(path)
-		fileSize := info.Size()
-		
-		// Skip hidden files
-		if strings.HasPrefix(fileName, ".") {
-			stats.ExcludedHidden++
-			stats.TotalSizeExcluded += fileSize
-			return nil
-		}
-		
-		// Skip if file is too large
-		if fileSize > maxFileSize {
-			stats.ExcludedBySize++
-			stats.TotalSizeExcluded += fileSize
-			return nil
+		if total%10000 == 0 {
+			fmt.Fprintf(progress, "Processed %d files...\n", total)
 		}
-		
-		// Skip files matching ignore patterns
-		for _, pattern := range ignoreFilePatterns {
-			matched, err := filepath.Match(pattern, fileName)
-			if err != nil {
-				continue
-			}
-			if matched {
-				stats.ExcludedByPattern++
-				stats.TotalSizeExcluded += fileSize
-				return nil
+	}
+	
+	// The walk produces included paths and the workers size and record
+	// them, so slow stat calls overlap with the directory traversal
+	paths := make(chan string, 1024)
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				var fileSize int64
+				if info, err := os.Stat(path); err == nil {
+					fileSize = info.Size()
+				}
+				stats.addIncluded(path, fileSize)
+				stats.addSeen(path, fileSize)
+				
+				// Add to samples if requested
+				if *sampleOutput {
+					samplesMu.Lock()
+					if len(includedSamples) < *sampleSize {
+						includedSamples = append(includedSamples, path)
+					}
+					samplesMu.Unlock()
+				}
 			}
-		}
-		
-		// Check if file extension is one we want to process
-		ext := strings.ToLower(filepath.Ext(path))
-		if extensions[ext] {
-			// Count by extension
-			extensionsFound[ext]++
-			
-			// Update stats
-			stats.IncludedFiles++
-			stats.TotalSizeIncluded += fileSize
-			
-			// Track largest file
-			if fileSize > stats.LargestIncludedSize {
-				stats.LargestIncludedSize = fileSize
-				stats.LargestIncluded = path
+		}()
+	}
+	
+	opts := filewalk.WalkOptions{
+		MaxFileSize: maxFileSize,
+		OnSkip: func(path string, info os.FileInfo, reason filewalk.SkipReason) {
+			if info.IsDir() {
+				if reason == filewalk.SkipIgnoredDir {
+					atomic.AddInt64(&stats.ExcludedByDir, 1)
+				}
+				return
 			}
 			
-			// Add to samples if requested
-			if *sampleOutput && len(includedSamples) < *sampleSize {
-				includedSamples = append(includedSamples, path)
+			countFile()
+			stats.addSeen(path, info.Size())
+			atomic.AddInt64(&stats.TotalSizeExcluded, info.Size())
+			switch reason {
+			case filewalk.SkipHidden:
+				atomic.AddInt64(&stats.ExcludedHidden, 1)
+			case filewalk.SkipTooLarge:
+				atomic.AddInt64(&stats.ExcludedBySize, 1)
+			case filewalk.SkipPattern:
+				atomic.AddInt64(&stats.ExcludedByPattern, 1)
+			case filewalk.SkipExtension:
+				atomic.AddInt64(&stats.ExcludedByExt, 1)
 			}
-		} else {
-			stats.ExcludedByExt++
-			stats.TotalSizeExcluded += fileSize
-		}
-		
-		return nil
+		},
+		OnError: func(path string, err error) {
+			fmt.Fprintf(os.Stderr, "Error accessing path %s: %v\n", path, err)
+		},
+	}
+	
+	err := filewalk.Walk(*rootDir, opts, func(path string) {
+		countFile()
+		paths <- path
 	})
+	close(paths)
+	wg.Wait()
 	
 	if err != nil {
-		fmt.Printf("Error during traversal: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error during traversal: %v\n", err)
 		os.Exit(1)
 	}
 	
 	// Calculate elapsed time
 	elapsed := time.Since(startTime)
+	stats.ElapsedSeconds = elapsed.Seconds()
+	
+	if *histogramPath != "" {
+		if err := writeHistogram(*histogramPath, stats); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing histogram: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(progress, "Wrote extension histogram to %s\n", *histogramPath)
+	}
+	
+	if *jsonOutput {
+		output, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding statistics: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(output))
+		return
+	}
 	
 	// Print statistics
 	fmt.Println("\n=== File Filtering Statistics ===")
@@ -400,30 +232,29 @@ func main() {
 	fmt.Printf("  - Largest included file: %s (%.2f MB)\n", stats.LargestIncluded, float64(stats.LargestIncludedSize)/(1024*1024))
 	
 	fmt.Println("\nExtension statistics:")
-	fmt.Println("  - Extensions found (top 20):")
+	fmt.Printf("  - Extensions found (top %d):\n", *topExtensions)
 	
 	// Sort extensions by count
 	type ExtCount struct {
 		Ext   string
-		Count int
+		Count int64
 	}
 	
 	var extCounts []ExtCount
-	for ext, count := range extensionsFound {
+	for ext, count := range stats.Extensions {
 		extCounts = append(extCounts, ExtCount{ext, count})
 	}
 	
-	// Sort by count (descending)
-	for i := 0; i < len(extCounts); i++ {
-		for j := i + 1; j < len(extCounts); j++ {
-			if extCounts[i].Count < extCounts[j].Count {
-				extCounts[i], extCounts[j] = extCounts[j], extCounts[i]
-			}
+	// Sort by count (descending), then by extension
+	sort.Slice(extCounts, func(i, j int) bool {
+		if extCounts[i].Count != extCounts[j].Count {
+			return extCounts[i].Count > extCounts[j].Count
 		}
-	}
+		return extCounts[i].Ext < extCounts[j].Ext
+	})
 	
 	// Print top extensions
-	maxExt := 20
+	maxExt := *topExtensions
 	if len(extCounts) < maxExt {
 		maxExt = len(extCounts)
 	}
@@ -434,6 +265,7 @@ func main() {
 	
 	// Print sample of included files if requested
 	if *sampleOutput && len(includedSamples) > 0 {
+		sort.Strings(includedSamples)
 		fmt.Printf("\nSample of included files (%d):\n", len(includedSamples))
 		for _, sample := range includedSamples {
 			fmt.Printf("  - %s\n", sample)
@@ -442,3 +274,55 @@ func main() {
 	
 	fmt.Printf("\nAnalysis completed in %v\n", elapsed)
 }
+
+// writeHistogram writes one CSV row per extension seen, included or not,
+// with its file count, included file count and average and median size in
+// bytes, sorted by descending file count
+func writeHistogram(path string, stats *FilterStats) error {
+	exts := make([]string, 0, len(stats.sizesByExt))
+	for ext := range stats.sizesByExt {
+		exts = append(exts, ext)
+	}
+	sort.Slice(exts, func(i, j int) bool {
+		ci, cj := len(stats.sizesByExt[exts[i]]), len(stats.sizesByExt[exts[j]])
+		if ci != cj {
+			return ci > cj
+		}
+		return exts[i] < exts[j]
+	})
+	
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	
+	w := csv.NewWriter(file)
+	w.Write([]string{"extension", "files", "included", "avg_size_bytes", "median_size_bytes"})
+	for _, ext := range exts {
+		sizes := stats.sizesByExt[ext]
+		sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+		
+		var total int64
+		for _, size := range sizes {
+			total += size
+		}
+		median := float64(sizes[len(sizes)/2])
+		if len(sizes)%2 == 0 {
+			median = float64(sizes[len(sizes)/2-1]+sizes[len(sizes)/2]) / 2
+		}
+		
+		w.Write([]string{
+			ext,
+			fmt.Sprint(len(sizes)),
+			fmt.Sprint(stats.Extensions[ext]),
+			fmt.Sprintf("%.1f", float64(total)/float64(len(sizes))),
+			fmt.Sprintf("%.1f", median),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
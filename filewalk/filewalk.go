@@ -0,0 +1,409 @@
+// Package filewalk holds the code file filtering shared by the indexer and the
+// standalone file filter tools: the default extension allowlist, the default
+// ignore lists and the filtering directory walk.
+package filewalk
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultExtensions returns the file extensions indexed by default. Each call
+// returns a new map, so callers may add to it.
+func DefaultExtensions() map[string]bool {
+	return map[string]bool{
+		// Programming languages
+		".go":     true,
+		".py":     true,
+		".js":     true,
+		".jsx":    true,
+		".ts":     true,
+		".tsx":    true,
+		".java":   true,
+		".c":      true,
+		".cpp":    true,
+		".cc":     true,
+		".cxx":    true,
+		".h":      true,
+		".hpp":    true,
+		".hxx":    true,
+		".cs":     true,
+		".php":    true,
+		".rb":     true,
+		".rs":     true,
+		".swift":  true,
+		".kt":     true,
+		".scala":  true,
+		".pl":     true,
+		".pm":     true,
+		".r":      true,
+		".lua":    true,
+		".groovy": true,
+		".dart":   true,
+		".elm":    true,
+		".ex":     true,
+		".exs":    true,
+		".erl":    true,
+		".hrl":    true,
+		".clj":    true,
+		".hs":     true,
+		".fs":     true,
+		".fsx":    true,
+		".ml":     true,
+		".mli":    true,
+
+		// Shell scripts
+		".sh":   true,
+		".bash": true,
+		".zsh":  true,
+		".fish": true,
+		".ps1":  true,
+		".bat":  true,
+		".cmd":  true,
+
+		// Web development
+		".html":   true,
+		".htm":    true,
+		".xhtml":  true,
+		".css":    true,
+		".scss":   true,
+		".sass":   true,
+		".less":   true,
+		".vue":    true,
+		".svelte": true,
+
+		// Data and config files
+		".json":    true,
+		".yaml":    true,
+		".yml":     true,
+		".xml":     true,
+		".toml":    true,
+		".ini":     true,
+		".sql":     true,
+		".graphql": true,
+		".proto":   true,
+
+		// Documentation
+		".md":   true,
+		".rst":  true,
+		".tex":  true,
+		".adoc": true,
+	}
+}
+
+// DefaultIgnoreDirs returns the directory names skipped by default: the hard
+// ignores plus the soft ones. Each call returns a new map, so callers may add
+// to or remove from it.
+func DefaultIgnoreDirs() map[string]bool {
+	dirs := HardIgnoreDirs()
+	for dir := range SoftIgnoreDirs() {
+		dirs[dir] = true
+	}
+	return dirs
+}
+
+// HardIgnoreDirs returns the directory names that never hold a project's own
+// source: version control metadata, installed dependencies, virtual
+// environments, editor settings and tool caches
+func HardIgnoreDirs() map[string]bool {
+	return map[string]bool{
+		// Package managers and dependencies
+		"node_modules":     true,
+		"vendor":           true,
+		"bower_components": true,
+		"jspm_packages":    true,
+
+		// Version control
+		".git": true,
+		".svn": true,
+		".hg":  true,
+		".bzr": true,
+
+		// Virtual environments
+		".venv":         true,
+		"venv":          true,
+		".env":          true,
+		"virtualenv":    true,
+		"__pycache__":   true,
+		"site-packages": true,
+
+		// IDE and editor
+		".idea":     true,
+		".vscode":   true,
+		".vs":       true,
+		".eclipse":  true,
+		".settings": true,
+
+		// Caches
+		".cache":      true,
+		".sass-cache": true,
+		".nyc_output": true,
+		".coverage":   true,
+	}
+}
+
+// SoftIgnoreDirs returns the directory names that usually hold build output,
+// documentation or scratch files but are also legitimate source package
+// names (a Go package called env, documentation-as-code in docs/). They are
+// skipped by default; leave them out of WalkOptions.IgnoreDirs to index them.
+func SoftIgnoreDirs() map[string]bool {
+	return map[string]bool{
+		// Package managers
+		"packages": true,
+		"env":      true,
+
+		// Build and distribution
+		"dist":    true,
+		"build":   true,
+		"out":     true,
+		"bin":     true,
+		"target":  true,
+		"output":  true,
+		"release": true,
+		"debug":   true,
+
+		// Temporary and cache
+		"tmp":   true,
+		"temp":  true,
+		"cache": true,
+
+		// Documentation
+		"docs": true,
+		"doc":  true,
+
+		// Test coverage
+		"coverage": true,
+		"htmlcov":  true,
+
+		// Logs
+		"logs": true,
+		"log":  true,
+	}
+}
+
+// DefaultIgnorePatterns returns the file name patterns, in filepath.Match
+// syntax, skipped by default
+func DefaultIgnorePatterns() []string {
+	return []string{
+		// Minified files
+		"*.min.js",
+		"*.min.css",
+
+		// Generated files
+		"*.generated.*",
+		"*_generated.*",
+		"*.g.*",
+		"*.pb.*",
+
+		// Compiled binaries
+		"*.exe",
+		"*.dll",
+		"*.so",
+		"*.dylib",
+		"*.class",
+		"*.o",
+		"*.obj",
+		"*.a",
+		"*.lib",
+		"*.pyc",
+		"*.pyo",
+
+		// Archives
+		"*.zip",
+		"*.tar",
+		"*.gz",
+		"*.bz2",
+		"*.xz",
+		"*.rar",
+		"*.7z",
+
+		// Media files
+		"*.jpg", "*.jpeg",
+		"*.png",
+		"*.gif",
+		"*.bmp",
+		"*.ico",
+		"*.svg",
+		"*.webp",
+		"*.mp3",
+		"*.mp4",
+		"*.wav",
+		"*.avi",
+		"*.mov",
+		"*.webm",
+
+		// Lock files
+		"*.lock",
+		"package-lock.json",
+		"yarn.lock",
+		"Cargo.lock",
+
+		// Backup files
+		"*~",
+		"*.bak",
+		"*.swp",
+		"*.swo",
+
+		// Large data files
+		"*.csv",
+		"*.tsv",
+		"*.db",
+		"*.sqlite",
+		"*.sqlite3",
+
+		// Logs
+		"*.log",
+	}
+}
+
+// SkipReason says why Walk left out a file or directory
+type SkipReason string
+
+const (
+	SkipBrokenSymlink SkipReason = "broken symlink"
+	SkipTooLarge      SkipReason = "too large"
+	SkipHidden        SkipReason = "hidden"
+	SkipIgnoredDir    SkipReason = "ignored directory"
+	SkipPattern       SkipReason = "ignore pattern"
+	SkipExtension     SkipReason = "extension not included"
+	SkipCaller        SkipReason = "excluded by caller"
+)
+
+// WalkOptions configures Walk. Nil lists use the defaults.
+type WalkOptions struct {
+	Extensions     map[string]bool // Lowercase extensions with the leading dot
+	AnyExtension   bool            // Include files regardless of extension
+	IgnoreDirs     map[string]bool // Directory names to skip, below the root
+	IgnorePatterns []string        // File name patterns to skip
+	MaxFileSize    int64           // Largest file to include in bytes; 0 means no limit
+
+	// Include reports whether a hidden file, or a file whose extension is
+	// not in Extensions, should be included anyway
+	Include func(path string) bool
+
+	// SkipDir and SkipFile let the caller exclude directories and files
+	// that pass the built-in rules. SkipDir is also called for the root.
+	SkipDir  func(path string) bool
+	SkipFile func(path string) bool
+
+	// OnSkip is called for every file and directory left out, and OnError
+	// for paths that could not be read; the walk continues after both
+	OnSkip  func(path string, info os.FileInfo, reason SkipReason)
+	OnError func(path string, err error)
+
+	// OnDir is called for every directory walked into, including the root
+	OnDir func(path string)
+}
+
+// Walk walks the tree under root and calls fn for every file that passes the
+// filters, in lexical order. Symlinks are followed to size the file they
+// point to, and directories are checked against the ignore lists, the
+// hidden-directory rule, a pyvenv.cfg virtual environment marker and
+// SkipDir, in that order. Files are checked against the size limit, the
+// hidden-file rule, the ignore patterns, SkipFile and the extension
+// allowlist.
+func Walk(root string, opts WalkOptions, fn func(path string)) error {
+	extensions := opts.Extensions
+	if extensions == nil {
+		extensions = DefaultExtensions()
+	}
+	ignoreDirs := opts.IgnoreDirs
+	if ignoreDirs == nil {
+		ignoreDirs = DefaultIgnoreDirs()
+	}
+	ignorePatterns := opts.IgnorePatterns
+	if ignorePatterns == nil {
+		ignorePatterns = DefaultIgnorePatterns()
+	}
+
+	skip := func(path string, info os.FileInfo, reason SkipReason) {
+		if opts.OnSkip != nil {
+			opts.OnSkip(path, info, reason)
+		}
+	}
+	include := func(path string) bool {
+		return opts.Include != nil && opts.Include(path)
+	}
+
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if opts.OnError != nil {
+				opts.OnError(path, err)
+			}
+			return nil // Continue walking despite the error
+		}
+
+		// Symlinks report their own size, so size the file they point to
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(path)
+			if err != nil {
+				skip(path, info, SkipBrokenSymlink)
+				return nil
+			}
+			info = target
+		}
+
+		if info.IsDir() {
+			// The root is always walked, even when its name is ignored
+			if path != root {
+				baseName := filepath.Base(path)
+				if ignoreDirs[baseName] {
+					skip(path, info, SkipIgnoredDir)
+					return filepath.SkipDir
+				}
+				if strings.HasPrefix(baseName, ".") {
+					skip(path, info, SkipHidden)
+					return filepath.SkipDir
+				}
+
+				// Virtual environments under any name, like env/ or .tox/py39
+				if _, err := os.Stat(filepath.Join(path, "pyvenv.cfg")); err == nil {
+					skip(path, info, SkipIgnoredDir)
+					return filepath.SkipDir
+				}
+			}
+			if opts.SkipDir != nil && opts.SkipDir(path) {
+				skip(path, info, SkipCaller)
+				return filepath.SkipDir
+			}
+			if opts.OnDir != nil {
+				opts.OnDir(path)
+			}
+			return nil
+		}
+
+		// Skip if file is too large, before anything reads it into memory
+		if opts.MaxFileSize > 0 && info.Size() > opts.MaxFileSize {
+			skip(path, info, SkipTooLarge)
+			return nil
+		}
+
+		fileName := filepath.Base(path)
+		if strings.HasPrefix(fileName, ".") && !include(path) {
+			skip(path, info, SkipHidden)
+			return nil
+		}
+
+		for _, pattern := range ignorePatterns {
+			if matched, _ := filepath.Match(pattern, fileName); matched {
+				skip(path, info, SkipPattern)
+				return nil
+			}
+		}
+
+		if opts.SkipFile != nil && opts.SkipFile(path) {
+			skip(path, info, SkipCaller)
+			return nil
+		}
+
+		ext := strings.ToLower(filepath.Ext(path))
+		if !opts.AnyExtension && !extensions[ext] && !include(path) {
+			skip(path, info, SkipExtension)
+			return nil
+		}
+
+		fn(path)
+		return nil
+	})
+}
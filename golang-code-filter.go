@@ -1,11 +1,14 @@
+//go:build ignore
+
 package main
 
 import (
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
+
+	"local-rag/filewalk"
 )
 
 // Configuration for the traversal
@@ -22,8 +25,8 @@ type Config struct {
 func main() {
 	// Define command line flags
 	rootDir := flag.String("dir", ".", "Root directory to start traversal")
-	excludeDirsStr := flag.String("exclude-dirs", "node_modules,target,build,dist,.git,vendor,bin,.idea,.vscode,venv,env,virtualenv,__pycache__,site-packages", "Comma-separated list of directories to exclude")
-	excludeFilesStr := flag.String("exclude-files", "*.class,*.jar,*.war,*.ear,*.zip,*.tar,*.gz,*.rar,*.min.js,*.min.css,*.png,*.jpg,*.jpeg,*.gif,*.bmp,*.ico,*.svg,*.webp,*.tiff,*.psd", "Comma-separated list of file patterns to exclude")
+	excludeDirsStr := flag.String("exclude-dirs", "", "Comma-separated list of directories to exclude (empty uses the indexer's default ignore list)")
+	excludeFilesStr := flag.String("exclude-files", "", "Comma-separated list of file patterns to exclude (empty uses the indexer's default patterns)")
 	includeExtsStr := flag.String("include-exts", "", "Comma-separated list of file extensions to include (empty means all)")
 	verbose := flag.Bool("verbose", false, "Enable verbose output")
 	listOnly := flag.Bool("list-only", false, "Only list files without any processing")
@@ -92,72 +95,36 @@ func main() {
 func traverseFiles(config Config, output *os.File) (int, error) {
 	count := 0
 
-	err := filepath.Walk(config.rootDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
+	opts := filewalk.WalkOptions{
+		AnyExtension: len(config.includeExts) == 0,
+		OnSkip: func(path string, info os.FileInfo, reason filewalk.SkipReason) {
 			if config.verbose {
-				fmt.Fprintf(os.Stderr, "Error accessing path %s: %v\n", path, err)
-			}
-			return nil // Continue walking despite the error
-		}
-
-		// Handle directories
-		if info.IsDir() {
-			// Check if we should skip this directory
-			baseName := filepath.Base(path)
-			
-			// Check for direct matches with excluded directories
-			for _, excludeDir := range config.excludeDirs {
-				if strings.EqualFold(baseName, excludeDir) {
-					if config.verbose {
-						fmt.Fprintf(os.Stderr, "Skipping directory: %s\n", path)
-					}
-					return filepath.SkipDir
-				}
-			}
-			
-			// Check for virtual environment paths (like venv/lib/python3.x/site-packages)
-			if strings.Contains(path, "venv/lib/python") && strings.Contains(path, "site-packages") {
-				if config.verbose {
-					fmt.Fprintf(os.Stderr, "Skipping Python virtual environment path: %s\n", path)
-				}
-				return filepath.SkipDir
-			}
-			return nil
-		}
-
-		// Handle files
-		// Skip excluded file patterns
-		for _, pattern := range config.excludeFiles {
-			matched, err := filepath.Match(pattern, filepath.Base(path))
-			if err != nil {
-				return err
+				fmt.Fprintf(os.Stderr, "Skipping %s (%s)\n", path, reason)
 			}
-			if matched {
-				if config.verbose {
-					fmt.Fprintf(os.Stderr, "Skipping file (pattern match): %s\n", path)
-				}
-				return nil
+		},
+		OnError: func(path string, err error) {
+			if config.verbose {
+				fmt.Fprintf(os.Stderr, "Error accessing path %s: %v\n", path, err)
 			}
+		},
+	}
+	if len(config.excludeDirs) > 0 {
+		opts.IgnoreDirs = map[string]bool{}
+		for _, dir := range config.excludeDirs {
+			opts.IgnoreDirs[dir] = true
 		}
-
-		// Check if we're filtering by extension
-		if len(config.includeExts) > 0 {
-			ext := strings.TrimPrefix(filepath.Ext(path), ".")
-			found := false
-			for _, includeExt := range config.includeExts {
-				if strings.EqualFold(ext, includeExt) {
-					found = true
-					break
-				}
-			}
-			if !found {
-				if config.verbose {
-					fmt.Fprintf(os.Stderr, "Skipping file (extension not included): %s\n", path)
-				}
-				return nil
-			}
+	}
+	if len(config.excludeFiles) > 0 {
+		opts.IgnorePatterns = config.excludeFiles
+	}
+	if len(config.includeExts) > 0 {
+		opts.Extensions = map[string]bool{}
+		for _, ext := range config.includeExts {
+			opts.Extensions["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = true
 		}
+	}
 
+	err := filewalk.Walk(config.rootDir, opts, func(path string) {
 		// Process or list the file
 		if config.listOnly {
 			fmt.Fprintln(output, path)
@@ -168,7 +135,6 @@ func traverseFiles(config Config, output *os.File) (int, error) {
 		}
 
 		count++
-		return nil
 	})
 
 	return count, err
@@ -3,38 +3,452 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"container/heap"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"hash/fnv"
+	"html"
+	"io"
 	"io/ioutil"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+
+	"local-rag/filewalk"
+	"local-rag/onnxembed"
 )
 
 // Config holds application configuration
 type Config struct {
-	Neo4jURI      string
-	Neo4jUser     string
-	Neo4jPassword string
-	ModelPath     string
-	EmbeddingURL  string
-	LLMServerURL  string
-	MaxChunkSize  int
-	ChunkOverlap  int
-	CodeDir       string
-	DbName        string
+	Neo4jURI       string
+	Neo4jUser      string
+	Neo4jPassword  string
+	ModelPath      string
+	EmbeddingURL   string
+	LLMServerURL   string
+	RerankURL      string // Optional cross-encoder reranking service; empty disables reranking
+	MaxChunkSize   int
+	ChunkOverlap   int
+	ChunkUnit      string   // Unit of MaxChunkSize and ChunkOverlap: "chars" (default) or "tokens"
+	MaxFileSize    int64    // Largest file to index, in bytes
+	CodeDirs       []string // Directories to index
+	DbName         string
+	KeywordWeight  float64 // Weight of the keyword score in hybrid search, --keyword-weight (0 = pure vector, the default; 1 = pure keyword)
+	ContextWindow  int     // Neighboring chunks to add before/after each match in QueryLLM (0 = off)
+	ForceReindex   bool    // Clear and rebuild projects indexed by a different chunker version
+	IncludeContext bool    // Return file/project context (language, project name, tags) with search results
+	Debug          bool    // Log search diagnostics
+
+	// ExtensionLanguageOverrides maps file extensions (e.g. ".tf") to the
+	// language they should be tagged and chunked as. Overridden extensions
+	// are indexed even when they are not in the built-in extension list.
+	ExtensionLanguageOverrides map[string]string
+	ExtraExtensions            []string // Additional file extensions to index
+
+	// IndexVendored indexes dependency directories (vendor/, node_modules/,
+	// site-packages/, ...) instead of skipping them. Their chunks are tagged
+	// is_vendored so searches can leave them out.
+	IndexVendored bool
+
+	// ProjectLayout decides which project each indexed file belongs to:
+	// "top-level" (default) makes every top-level directory of the indexed
+	// directory a project, with the files directly in it forming a project
+	// for the indexed directory itself; "root" makes the whole indexed
+	// directory one project; "manifest" makes the nearest directory above
+	// each file holding a go.mod, package.json, Cargo.toml, pyproject.toml
+	// or .git a project, falling back to the indexed directory. When several
+	// directories are indexed together each of them is always one project.
+	ProjectLayout string
+
+	// NoDefaultIgnores indexes the directories that are skipped by default
+	// but can hold real source, like env/, docs/, build/ and bin/ (see
+	// filewalk.SoftIgnoreDirs). Version control, dependency, virtual
+	// environment and editor directories are still skipped.
+	NoDefaultIgnores bool
+
+	// RespectGitignore skips paths matched by .gitignore files found while
+	// walking, each applying to its own directory and below. The built-in
+	// ignore lists and hidden-file rule are applied first: .gitignore rules
+	// can only exclude more, and a "!" negation re-includes only paths
+	// excluded by another .gitignore rule. Files above the indexed
+	// directory and .git/info/exclude are not read.
+	RespectGitignore bool
+
+	// LineIncremental uses git diff hunks since the commit a file was last
+	// indexed at to embed only the chunks touching changed lines; the other
+	// chunks keep their stored embeddings
+	LineIncremental bool
+
+	// DeferEmbeddings stores chunks without embeddings first so they are
+	// keyword-searchable right away, then backfills embeddings in a second
+	// phase (see EmbedPending)
+	DeferEmbeddings bool
+
+	// EnsembleEmbeddingURL is an optional second embedding service. When set,
+	// every chunk is embedded by both models (stored as embedding and
+	// embedding2) so searches can fuse the two rankings. This doubles
+	// embedding time and vector storage.
+	EnsembleEmbeddingURL string
+
+	// EmbeddingBackend selects how the primary embeddings are computed:
+	// "http" (default) calls EmbeddingURL, "onnx" runs the model in
+	// ONNXModelDir in process (see package onnxembed) and "mock" hashes
+	// words into vectors, for exercising indexing and search without a model
+	EmbeddingBackend   string
+	ONNXModelDir       string
+	ONNXRuntimeLibrary string // ONNX Runtime shared library; empty uses the platform default
+
+	// Embedder, when set, computes the primary embeddings instead of the
+	// backend named by EmbeddingBackend, e.g. another provider or a stub.
+	// It stays owned by the caller: Neo4jRAG.Close does not close it.
+	Embedder Embedder
+
+	// LLMProvider selects the API spoken at LLMServerURL: "completion"
+	// (default, the local completion server) or "openai" (chat completions,
+	// also served by Ollama and llama.cpp), or "mock" for canned answers
+	// without a server. LLM, when set, is used instead.
+	LLMProvider string
+	LLMModel    string // Model name sent to the openai provider
+	LLMAPIKey   string // Bearer token sent to the openai provider, if any
+	LLM         LLM
+
+	// ContextFormat selects how QueryLLM lays out code chunks in the prompt:
+	// "markdown" (default) fences each snippet, "delimited" wraps each one in
+	// ChunkMarker lines carrying a machine-parseable metadata header
+	ContextFormat string
+	ChunkMarker   string // Boundary marker for the delimited format (default "@@@")
+
+	// MaxPromptTokens caps the estimated size of the QueryLLM prompt (0 = no
+	// limit). Chunks are added in score order until the budget is reached;
+	// the chunk that crosses it is cut at a line boundary and the rest are
+	// dropped.
+	MaxPromptTokens int
+
+	// ContextChunks is how many retrieved chunks QueryLLM puts in the
+	// prompt (0 uses llmContextChunks), before ContextWindow neighbors
+	ContextChunks int
+
+	// RetryMinScore is the lower minimum score a search is retried with,
+	// once, when nothing scores above SearchOptions.MinScore; 0 disables
+	// the retry
+	RetryMinScore float64
+
+	// FullFile makes QueryLLM put the top result's whole file, read from
+	// disk, in the prompt instead of its chunks. Files larger than
+	// FullFileMaxSize bytes (0 uses defaultFullFileMaxSize) or no longer on
+	// disk fall back to the chunks.
+	FullFile        bool
+	FullFileMaxSize int64
+
+	// OmitContent leaves each chunk's content out of Neo4j
+	// (--store-content=false) and stores only the metadata and embeddings,
+	// which keeps the database much smaller. Results read their content
+	// from the indexed files by byte offset, resolved against the directory
+	// the project was indexed from, so the source must stay on disk at
+	// query time and a file edited since it was indexed shows shifted
+	// content until it is re-indexed. Keyword matching runs in Neo4j, so
+	// the keyword pre-filter lets such chunks through and their hybrid
+	// keyword score is 0.
+	OmitContent bool
+
+	// TokenCounter estimates the number of tokens in a text for
+	// MaxPromptTokens and for sizing chunks when ChunkUnit is "tokens"; nil
+	// uses estimateTokens
+	TokenCounter func(text string) int
+
+	// MinKeywordLength is the shortest query term the keyword pre-filter
+	// uses (0 uses defaultMinKeywordLength). Lower it to match short
+	// identifiers like id, os or ctx.
+	MinKeywordLength int
+
+	// SplitIdentifiers adds the words of camelCase and snake_case query
+	// terms (getUserById yields get, User, By and Id) to the keyword
+	// pre-filter, alongside the whole term
+	SplitIdentifiers bool
+
+	// StopWords are the lowercase query words left out of keyword matching;
+	// nil uses defaultStopWords
+	StopWords map[string]bool
+
+	// LogQueries records each query answered by the CLI, its results and
+	// the LLM answer as a (:Query) node for QueryStats
+	LogQueries bool
+
+	// BinaryThreshold is the fraction of non-printable bytes in a file's
+	// first binarySniffSize bytes above which it is skipped as binary
+	// (0 uses defaultBinaryThreshold). Files containing a null byte are
+	// always treated as binary.
+	BinaryThreshold float64
+
+	// SimilarityMetric compares query and chunk embeddings: "cosine"
+	// (default), "dot" or "euclidean". Scores are always larger-is-better;
+	// see similarityExpr for the ranges MinScore applies to.
+	SimilarityMetric string
+
+	// NormalizeEmbeddings scales every embedding, stored and query alike, to
+	// unit length, so dot-product scores equal cosine scores. Chunks indexed
+	// without it keep their raw vectors until they are re-indexed.
+	NormalizeEmbeddings bool
+
+	// Scoring holds the relevance boosts added to similarity scores; nil
+	// uses DefaultScoringConfig
+	Scoring *ScoringConfig
+
+	// FileProcessTimeout bounds embedding and storing a single file during
+	// indexing (0 = no limit). A file that runs over is logged and skipped
+	// and indexing continues with the next one.
+	FileProcessTimeout time.Duration
+
+	// Since and SinceCommit make indexing incremental: only files modified
+	// after Since, or changed since SinceCommit according to git (including
+	// untracked files), are processed. Indexed files under the directory
+	// that no longer exist are removed either way.
+	Since       time.Time
+	SinceCommit string
+
+	// EmbeddingTimeout bounds each embedding and reranking request, and
+	// LLMTimeout each LLM completion request, including reading the
+	// response. Zero uses defaultEmbeddingTimeout and defaultLLMTimeout.
+	EmbeddingTimeout time.Duration
+	LLMTimeout       time.Duration
+
+	// LogOutput receives log messages (default os.Stdout). Machine-readable
+	// output modes send logs to os.Stderr to keep stdout parseable.
+	LogOutput io.Writer
+
+	// LogLevel is the least severe level logged: "debug", "info" (default),
+	// "warn" or "error". Per-file filtering and per-chunk scoring messages
+	// are debug level. Debug forces "debug".
+	LogLevel string
+
+	// LogFormat is "text" (default) or "json", which writes each message as
+	// a JSON object with time, level and msg fields
+	LogFormat string
+
+	// Progress, when set, is called after each file IndexDirectory
+	// processes, including files that failed, instead of logging progress
+	// every 10 files. It runs synchronously on the indexing goroutine, so a
+	// slow callback slows indexing; hand the values off to a channel or
+	// another goroutine if they feed something that may block.
+	Progress ProgressFunc
+}
+
+// ProgressFunc reports indexing progress: done of total files have been
+// processed, the last of them being currentFile
+type ProgressFunc func(done, total int, currentFile string)
+
+// ScoringConfig holds the adjustments search adds to a chunk's base score
+type ScoringConfig struct {
+	EntityBoost         float64 // Added for function and method chunks (more focused)
+	SmallChunkBoost     float64 // Added for chunks shorter than SmallChunkThreshold (more precise)
+	SmallChunkThreshold int     // Content length in characters
+	LargeChunkPenalty   float64 // Subtracted for chunks longer than LargeChunkThreshold (too general)
+	LargeChunkThreshold int     // Content length in characters
+}
+
+// DefaultScoringConfig returns the built-in scoring adjustments
+func DefaultScoringConfig() ScoringConfig {
+	return ScoringConfig{
+		EntityBoost:         0.1,
+		SmallChunkBoost:     0.05,
+		SmallChunkThreshold: 500,
+		LargeChunkPenalty:   0.05,
+		LargeChunkThreshold: 2000,
+	}
+}
+
+// withParams adds the scoring parameters used by boostClause to params
+func (s ScoringConfig) withParams(params map[string]interface{}) map[string]interface{} {
+	params["entityBoost"] = s.EntityBoost
+	params["smallChunkBoost"] = s.SmallChunkBoost
+	params["smallChunkThreshold"] = s.SmallChunkThreshold
+	params["largeChunkPenalty"] = s.LargeChunkPenalty
+	params["largeChunkThreshold"] = s.LargeChunkThreshold
+	return params
+}
+
+// boostClause returns the Cypher that adds the ScoringConfig adjustments to
+// the base score variable baseVar, producing c and score. The adjustments
+// stay in scope as entityBoost, sizeBoost and sizePenalty, along with the
+// carry variables. The query needs the parameters from
+// ScoringConfig.withParams.
+func boostClause(baseVar string, carry ...string) string {
+	carried := ""
+	for _, name := range carry {
+		carried += name + ", "
+	}
+	return `// Calculate additional relevance factors
+		WITH c, ` + carried + baseVar + `,
+		     // Boost score for function/method chunks (more focused)
+		     CASE WHEN c.entity_type IN ['function', 'method'] THEN $entityBoost ELSE 0 END AS entityBoost,
+		     
+		     // Boost score for shorter chunks (more precise)
+		     CASE WHEN ` + chunkSizeExpr + ` < $smallChunkThreshold THEN $smallChunkBoost ELSE 0 END AS sizeBoost,
+		     
+		     // Penalize very large chunks (too general)
+		     CASE WHEN ` + chunkSizeExpr + ` > $largeChunkThreshold THEN -$largeChunkPenalty ELSE 0 END AS sizePenalty
+		
+		// Calculate final score with boosts
+		WITH c, ` + carried + baseVar + `, entityBoost, sizeBoost, sizePenalty,
+		     (` + baseVar + ` + entityBoost + sizeBoost + sizePenalty) AS score`
+}
+
+// Similarity metrics for Config.SimilarityMetric
+const (
+	similarityCosine    = "cosine"
+	similarityDot       = "dot"
+	similarityEuclidean = "euclidean"
+)
+
+// Context formats for QueryLLM prompts
+const (
+	contextFormatMarkdown  = "markdown"
+	contextFormatDelimited = "delimited"
+)
+
+// LLM providers for Config.LLMProvider
+const (
+	llmProviderCompletion = "completion"
+	llmProviderOpenAI     = "openai"
+	llmProviderMock       = "mock"
+)
+
+// Chunk size units for Config.ChunkUnit
+const (
+	chunkUnitChars  = "chars"
+	chunkUnitTokens = "tokens"
+)
+
+// Embedding backends for Config.EmbeddingBackend
+const (
+	embeddingBackendHTTP = "http"
+	embeddingBackendONNX = "onnx"
+	embeddingBackendMock = "mock"
+)
+
+// Project layouts for Config.ProjectLayout
+const (
+	projectLayoutTopLevel = "top-level"
+	projectLayoutRoot     = "root"
+	projectLayoutManifest = "manifest"
+)
+
+// defaultChunkMarker opens and closes each source in the delimited context format
+const defaultChunkMarker = "@@@"
+
+// defaultMaxFileSize is the largest file indexed when Config.MaxFileSize is unset
+const defaultMaxFileSize = 1 * 1024 * 1024
+
+// Request timeouts used when Config.EmbeddingTimeout or Config.LLMTimeout is
+// unset. Local models run one request at a time, so an LLM answer can queue
+// behind other work.
+const (
+	defaultEmbeddingTimeout = 2 * time.Minute
+	defaultLLMTimeout       = 3 * time.Minute
+)
+
+// binarySniffSize is how much of a file looksBinary inspects, like git's
+// binary detection
+const binarySniffSize = 8 * 1024
+
+// defaultBinaryThreshold is the non-printable byte ratio used when
+// Config.BinaryThreshold is unset
+const defaultBinaryThreshold = 0.3
+
+// chunkerVersion identifies the chunking algorithm. Bump it whenever chunk
+// boundaries change so chunks produced by an older chunker can be detected
+// instead of silently mixing with new ones.
+//
+// Version history:
+//
+//	1: initial chunkers
+//	2: size chunker overlap is capped so every chunk advances past the previous one
+//	3: "\r\n" and lone "\r" end lines, and chunk content uses "\n" line endings
+//	4: Go chunks end on their own last line and cover generic functions;
+//	   JavaScript and TypeScript are chunked by declaration
+//	5: Go chunks end at the function's closing brace and keep its doc
+//	   comment; code between functions is chunked by size
+//	6: C, C++ and Java are chunked by definition
+//	7: chunks record the byte offsets of their lines in the file
+//	8: size chunker overlap is measured like the chunk size instead of in lines
+//	9: Go method chunks are named after their receiver type, like (*T).Method
+//	10: with ChunkUnit "tokens", declarations over MaxChunkSize are split by size
+const chunkerVersion = 10
+
+// ScoreBand restricts search results to chunks whose vector similarity lies
+// within [Low, High], for exploring moderately related code
+type ScoreBand struct {
+	Low  float64
+	High float64
+}
+
+// parseScoreBand parses a band of the form "0.4-0.6". Bounds may be
+// negative, as similarities are under the dot and euclidean metrics, e.g.
+// "-0.5--0.2"; the separator is the first dash with a number on both sides.
+func parseScoreBand(value string) (*ScoreBand, error) {
+	var low, high float64
+	found := false
+	for i := 1; i < len(value) && !found; i++ {
+		if value[i] != '-' {
+			continue
+		}
+		var lowErr, highErr error
+		low, lowErr = strconv.ParseFloat(strings.TrimSpace(value[:i]), 64)
+		high, highErr = strconv.ParseFloat(strings.TrimSpace(value[i+1:]), 64)
+		found = lowErr == nil && highErr == nil
+	}
+	if !found || math.IsNaN(low) || math.IsNaN(high) {
+		return nil, fmt.Errorf("invalid score band %q, expected LOW-HIGH (e.g. 0.4-0.6)", value)
+	}
+	
+	if low > high {
+		return nil, fmt.Errorf("invalid score band %q, lower bound exceeds upper bound", value)
+	}
+	
+	return &ScoreBand{Low: low, High: high}, nil
 }
 
+// keywordSaturation is the BM25-style k1 constant used when turning keyword
+// term frequencies into a lexical score: tf / (tf + k1)
+const keywordSaturation = 1.2
+
+// Search adds exactNameBonus to chunks whose name is one of the query
+// terms, since the embedding of a bare name is a weak match for its
+// definition. Definition mode (SearchOptions.Definitions) also adds
+// definitionBoost to chunks of definitionEntityTypes, so definitions outrank
+// call sites.
+const (
+	definitionBoost = 0.15
+	exactNameBonus  = 0.25
+)
+
+// definitionEntityTypes are the entity types holding a definition's body
+var definitionEntityTypes = []string{"function", "method", "class"}
+
 // CodeChunk represents a chunk of code with metadata
 type CodeChunk struct {
 	ID          string   `json:"id"`
@@ -44,12 +458,48 @@ type CodeChunk struct {
 	Language    string   `json:"language"`
 	StartLine   int      `json:"start_line"`
 	EndLine     int      `json:"end_line"`
+	StartByte   int      `json:"start_byte"`  // Offset of StartLine in the file
+	EndByte     int      `json:"end_byte"`    // Offset just past EndLine, before its line ending
 	EntityType  string   `json:"entity_type"` // "function", "class", "method", "chunk"
 	Name        string   `json:"name"`        // function/class name if available
 	Signature   string   `json:"signature"`   // function signature if available
 	Embedding   []float32 `json:"-"`         // Vector embedding (not stored in JSON)
+	Embedding2  []float32 `json:"-"`         // Second model's embedding in ensemble mode
 	Hash        string   `json:"hash"`        // Content hash for change detection
 	Score       float64  `json:"score"`       // Similarity score from search
+	Breakdown   *ScoreBreakdown `json:"score_breakdown,omitempty"` // How Score was reached, with SearchOptions.Explain
+	IsVendored  bool     `json:"is_vendored"` // Chunk comes from third-party dependency code
+	Calls       []string `json:"-"`           // Functions called by Go chunks
+	Imports     []string `json:"-"`           // Import paths of the packages Go chunks use
+	Commit      string   `json:"commit,omitempty"` // Git commit the file was indexed at
+	Branch      string   `json:"branch,omitempty"` // Git branch the file was indexed on
+	
+	// Highlighting, populated with SearchOptions.Highlight: the query
+	// keywords that drove the match and, in JSON output, the content as
+	// HTML with them marked
+	MatchedKeywords []string `json:"-"`
+	Highlighted     string   `json:"highlighted,omitempty"`
+	
+	// Graph context, populated by search when Config.IncludeContext is set
+	FileLanguage string   `json:"file_language,omitempty"`
+	ProjectName  string   `json:"project_name,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+}
+
+// ScoreBreakdown shows how a search result's score was reached: the hybrid
+// base score blended from the vector and keyword scores, plus the
+// ScoringConfig adjustments. For fused searches it describes the primary
+// model's ranking; the result's Score is then the RRF score.
+type ScoreBreakdown struct {
+	VectorScore     float64  `json:"vector_score"`  // Raw similarity to the query
+	KeywordScore    float64  `json:"keyword_score"` // Hybrid keyword score
+	BaseScore       float64  `json:"base_score"`    // Blend of the two plus the bonuses below, before adjustments
+	NameBonus       float64  `json:"name_bonus"`    // Chunk is named after a query term
+	DefinitionBonus float64  `json:"definition_bonus"`
+	EntityBoost     float64  `json:"entity_boost"`
+	SizeBoost       float64  `json:"size_boost"`
+	SizePenalty     float64  `json:"size_penalty"`
+	MatchedKeywords []string `json:"matched_keywords,omitempty"` // Query keywords found in the content
 }
 
 // LLMRequest represents a request to the LLM
@@ -70,21 +520,182 @@ type EmbeddingRequest struct {
 	Texts []string `json:"texts"`
 }
 
-// EmbeddingResponse represents a response from the embedding service
+// EmbeddingResponse represents a response from the embedding service.
+// Each element is kept raw because servers disagree on the wire format:
+// some return plain vectors, others wrap each vector in an object.
 type EmbeddingResponse struct {
-	Embeddings [][]float32 `json:"embeddings"`
+	Embeddings []json.RawMessage `json:"embeddings"`
+}
+
+// embeddingObject is the object-wrapped form of a single embedding,
+// e.g. {"embedding": [...], "index": 0}
+type embeddingObject struct {
+	Embedding []float32 `json:"embedding"`
+	Index     *int      `json:"index"`
+}
+
+// RerankRequest represents a request to the reranking service
+type RerankRequest struct {
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+}
+
+// RerankResponse represents a response from the reranking service, with one
+// relevance score per document in request order
+type RerankResponse struct {
+	Scores []float64 `json:"scores"`
+}
+
+const (
+	// rerankCandidates is how many chunks QueryLLM retrieves before
+	// reranking, unless Config.ContextChunks asks for more
+	rerankCandidates = 20
+	
+	// llmContextChunks is how many chunks QueryLLM puts in the prompt when
+	// Config.ContextChunks is unset
+	llmContextChunks = 5
+)
+
+// Log levels, from most to least verbose
+const (
+	logLevelDebug = "debug"
+	logLevelInfo  = "info"
+	logLevelWarn  = "warn"
+	logLevelError = "error"
+)
+
+// Log formats
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var logLevelRanks = map[string]int{
+	logLevelDebug: 0,
+	logLevelInfo:  1,
+	logLevelWarn:  2,
+	logLevelError: 3,
+}
+
+// leveledLogger wraps a *log.Logger with debug/info/warn/error levels.
+// Printf and Println log at info level so plain progress messages read as
+// before; in the json format each message is written as one JSON object
+// with time, level and msg fields.
+type leveledLogger struct {
+	logger  *log.Logger
+	minRank int
+	json    bool
+}
+
+// newLeveledLogger builds the logger described by config. Config.Debug
+// lowers the level to debug regardless of Config.LogLevel.
+func newLeveledLogger(config Config) *leveledLogger {
+	out := config.LogOutput
+	if out == nil {
+		out = os.Stdout
+	}
+	level := config.LogLevel
+	if config.Debug {
+		level = logLevelDebug
+	}
+	rank, ok := logLevelRanks[level]
+	if !ok {
+		rank = logLevelRanks[logLevelInfo]
+	}
+	l := &leveledLogger{minRank: rank}
+	if config.LogFormat == logFormatJSON {
+		l.json = true
+		l.logger = log.New(out, "", 0)
+	} else {
+		l.logger = log.New(out, "NEO4J-RAG: ", log.LstdFlags)
+	}
+	return l
+}
+
+func (l *leveledLogger) logf(level string, format string, args ...interface{}) {
+	if logLevelRanks[level] < l.minRank {
+		return
+	}
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	if l.json {
+		line, err := json.Marshal(map[string]string{
+			"time":  time.Now().Format(time.RFC3339),
+			"level": level,
+			"msg":   msg,
+		})
+		if err != nil {
+			return
+		}
+		l.logger.Print(string(line))
+		return
+	}
+	switch level {
+	case logLevelDebug:
+		msg = "DEBUG: " + msg
+	case logLevelWarn:
+		msg = "WARNING: " + msg
+	case logLevelError:
+		msg = "ERROR: " + msg
+	}
+	l.logger.Print(msg)
+}
+
+func (l *leveledLogger) Debugf(format string, args ...interface{}) {
+	l.logf(logLevelDebug, format, args...)
+}
+
+func (l *leveledLogger) Infof(format string, args ...interface{}) {
+	l.logf(logLevelInfo, format, args...)
+}
+
+func (l *leveledLogger) Warnf(format string, args ...interface{}) {
+	l.logf(logLevelWarn, format, args...)
+}
+
+func (l *leveledLogger) Errorf(format string, args ...interface{}) {
+	l.logf(logLevelError, format, args...)
+}
+
+// Printf logs at info level
+func (l *leveledLogger) Printf(format string, args ...interface{}) {
+	l.logf(logLevelInfo, format, args...)
+}
+
+// Println logs its operands at info level, separated by spaces
+func (l *leveledLogger) Println(args ...interface{}) {
+	l.logf(logLevelInfo, "%s", fmt.Sprintln(args...))
 }
 
 // Neo4jRAG handles storing and retrieving code chunks from Neo4j
 type Neo4jRAG struct {
 	driver neo4j.Driver
 	config Config
-	logger *log.Logger
+	logger *leveledLogger
+
+	// embeddingClient sends embedding and reranking requests and llmClient
+	// LLM requests, each with its configured timeout. They share one pooled
+	// transport (see newHTTPTransport).
+	embeddingClient *http.Client
+	llmClient       *http.Client
+
+	// embedder computes the primary embeddings and ensembleEmbedder, when
+	// Config.EnsembleEmbeddingURL is set, the second model's. ownsEmbedder
+	// is set when embedder was created by NewNeo4jRAG rather than passed in
+	// Config.Embedder, so Close only closes embedders it created.
+	embedder         Embedder
+	ownsEmbedder     bool
+	ensembleEmbedder Embedder
+	llm              LLM
+}
+
+// Embedder turns texts into embedding vectors, one per text in order
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
 }
 
 // NewNeo4jRAG creates a new Neo4jRAG instance
 func NewNeo4jRAG(config Config) (*Neo4jRAG, error) {
-	logger := log.New(os.Stdout, "NEO4J-RAG: ", log.LstdFlags)
+	logger := newLeveledLogger(config)
 	
 	// Connect to Neo4j
 	logger.Println("Connecting to Neo4j at", config.Neo4jURI)
@@ -101,10 +712,43 @@ func NewNeo4jRAG(config Config) (*Neo4jRAG, error) {
 	
 	logger.Println("Successfully connected to Neo4j")
 	
+	embeddingTimeout := config.EmbeddingTimeout
+	if embeddingTimeout <= 0 {
+		embeddingTimeout = defaultEmbeddingTimeout
+	}
+	llmTimeout := config.LLMTimeout
+	if llmTimeout <= 0 {
+		llmTimeout = defaultLLMTimeout
+	}
+	
+	transport := newHTTPTransport()
 	rag := &Neo4jRAG{
-		driver: driver,
-		config: config,
-		logger: logger,
+		driver:          driver,
+		config:          config,
+		logger:          logger,
+		embeddingClient: &http.Client{Transport: transport, Timeout: embeddingTimeout},
+		llmClient:       &http.Client{Transport: transport, Timeout: llmTimeout},
+	}
+	
+	rag.embedder = config.Embedder
+	if rag.embedder == nil {
+		rag.embedder, err = rag.newEmbedder()
+		if err != nil {
+			driver.Close()
+			return nil, err
+		}
+		rag.ownsEmbedder = true
+	}
+	if config.EnsembleEmbeddingURL != "" {
+		rag.ensembleEmbedder = NewHTTPEmbedder(config.EnsembleEmbeddingURL, rag.embeddingClient, logger)
+	}
+	rag.llm = config.LLM
+	if rag.llm == nil {
+		rag.llm, err = rag.newLLM()
+		if err != nil {
+			driver.Close()
+			return nil, err
+		}
 	}
 	
 	// Initialize database
@@ -114,12 +758,227 @@ func NewNeo4jRAG(config Config) (*Neo4jRAG, error) {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
 	
+	// Warn early if the index was built by a different chunker
+	err = rag.checkChunkerVersion()
+	if err != nil {
+		logger.Warnf("could not check chunker version: %v\n", err)
+	}
+	
 	return rag, nil
 }
 
-// Close closes the Neo4j connection
+// httpMaxIdleConnsPerHost is how many idle connections are kept open to each
+// service. Indexing sends embedding batches back to back, so keeping a few
+// connections warm avoids reconnecting for every batch.
+const httpMaxIdleConnsPerHost = 16
+
+// newHTTPTransport returns the connection-pooling transport shared by the
+// service clients. Connecting is bounded separately from the request
+// timeouts so an unreachable service fails fast.
+func newHTTPTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = httpMaxIdleConnsPerHost
+	transport.IdleConnTimeout = 90 * time.Second
+	return transport
+}
+
+// Close closes the Neo4j connection, any idle service connections and the
+// embedder when NewNeo4jRAG created it
 func (r *Neo4jRAG) Close() {
 	r.driver.Close()
+	if r.embeddingClient != nil {
+		r.embeddingClient.CloseIdleConnections()
+	}
+	if closer, ok := r.embedder.(interface{ Close() error }); ok && r.ownsEmbedder {
+		closer.Close()
+	}
+}
+
+// newEmbedder returns the primary embedder selected by
+// Config.EmbeddingBackend
+func (r *Neo4jRAG) newEmbedder() (Embedder, error) {
+	switch r.config.EmbeddingBackend {
+	case "", embeddingBackendHTTP:
+		return NewHTTPEmbedder(r.config.EmbeddingURL, r.embeddingClient, r.logger), nil
+	case embeddingBackendONNX:
+		r.logger.Println("Loading ONNX embedding model from", r.config.ONNXModelDir)
+		embedder, err := onnxembed.New(onnxembed.Options{
+			ModelDir:       r.config.ONNXModelDir,
+			RuntimeLibrary: r.config.ONNXRuntimeLibrary,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ONNX embedding model: %w", err)
+		}
+		return embedder, nil
+	case embeddingBackendMock:
+		return &MockEmbedder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown embedding backend %q", r.config.EmbeddingBackend)
+	}
+}
+
+// newLLM returns the LLM client selected by Config.LLMProvider
+func (r *Neo4jRAG) newLLM() (LLM, error) {
+	switch r.config.LLMProvider {
+	case "", llmProviderCompletion:
+		return &CompletionLLM{URL: r.config.LLMServerURL, Client: r.llmClient}, nil
+	case llmProviderOpenAI:
+		return &OpenAIChatLLM{URL: r.config.LLMServerURL, Model: r.config.LLMModel, APIKey: r.config.LLMAPIKey, Client: r.llmClient}, nil
+	case llmProviderMock:
+		return &MockLLM{}, nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q", r.config.LLMProvider)
+	}
+}
+
+// DependencyStatus is the health of one external dependency
+type DependencyStatus struct {
+	Name  string `json:"name"`
+	URL   string `json:"url,omitempty"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// HealthReport is the result of checking every external dependency
+type HealthReport struct {
+	OK           bool               `json:"ok"`
+	Dependencies []DependencyStatus `json:"dependencies"`
+}
+
+// HealthCheck verifies that Neo4j, the embedding service and, when
+// configured, the LLM service are reachable. It returns an error naming every
+// failing dependency.
+func (r *Neo4jRAG) HealthCheck(ctx context.Context) error {
+	return r.CheckHealth(ctx).Err()
+}
+
+// CheckHealth reports the status of each external dependency (see HealthCheck)
+func (r *Neo4jRAG) CheckHealth(ctx context.Context) HealthReport {
+	return checkDependencies(ctx, r.config, r.embeddingClient, r.driver.VerifyConnectivity())
+}
+
+// Err summarizes the failing dependencies of the report, or returns nil
+func (h HealthReport) Err() error {
+	failures := []string{}
+	for _, dep := range h.Dependencies {
+		if !dep.OK {
+			failures = append(failures, fmt.Sprintf("%s: %s", dep.Name, dep.Error))
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	return fmt.Errorf("unhealthy dependencies: %s", strings.Join(failures, "; "))
+}
+
+// checkDependencies builds a health report from the Neo4j connectivity result
+// and probes of the embedding and LLM services, sent with client. It does not
+// need a working Neo4j connection, so the services can be checked even when
+// Neo4j is down.
+func checkDependencies(ctx context.Context, config Config, client *http.Client, neo4jErr error) HealthReport {
+	statuses := []DependencyStatus{
+		dependencyStatus("neo4j", config.Neo4jURI, neo4jErr),
+		embeddingStatus(ctx, config, client),
+	}
+	if config.LLMServerURL != "" && config.LLMProvider != llmProviderMock {
+		statuses = append(statuses, dependencyStatus("llm", config.LLMServerURL, probeLLMService(ctx, client, config.LLMServerURL)))
+	}
+	
+	report := HealthReport{OK: true, Dependencies: statuses}
+	for _, status := range statuses {
+		if !status.OK {
+			report.OK = false
+		}
+	}
+	return report
+}
+
+// embeddingStatus checks the configured embedding backend: the service at
+// EmbeddingURL, or for the onnx backend the build and the model files
+func embeddingStatus(ctx context.Context, config Config, client *http.Client) DependencyStatus {
+	switch config.EmbeddingBackend {
+	case embeddingBackendONNX:
+		return dependencyStatus("embedding", "onnx:"+config.ONNXModelDir, onnxembed.CheckModelDir(config.ONNXModelDir))
+	case embeddingBackendMock:
+		return dependencyStatus("embedding", embeddingBackendMock, nil)
+	}
+	return dependencyStatus("embedding", config.EmbeddingURL, probeEmbeddingService(ctx, client, config.EmbeddingURL))
+}
+
+// dependencyStatus converts a check result into a DependencyStatus
+func dependencyStatus(name, url string, err error) DependencyStatus {
+	status := DependencyStatus{Name: name, URL: url, OK: err == nil}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// probeEmbeddingService embeds a tiny text and checks that a non-empty
+// vector comes back, which catches services that answer with empty embeddings
+func probeEmbeddingService(ctx context.Context, client *http.Client, url string) error {
+	reqBody, err := json.Marshal(EmbeddingRequest{Texts: []string{"health check"}})
+	if err != nil {
+		return err
+	}
+	
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("embedding service returned status code %d", resp.StatusCode)
+	}
+	
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read embedding response: %w", err)
+	}
+	
+	embeddings, err := parseEmbeddingResponse(body)
+	if err != nil {
+		return err
+	}
+	if len(embeddings) != 1 || len(embeddings[0]) == 0 {
+		return fmt.Errorf("embedding service returned an empty embedding")
+	}
+	
+	return nil
+}
+
+// probeLLMService checks that the LLM server answers HTTP requests. Any
+// response below 500 counts as reachable; no completion is generated.
+func probeLLMService(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("LLM service returned status code %d", resp.StatusCode)
+	}
+	
+	return nil
 }
 
 // initDatabase sets up the Neo4j database schema
@@ -135,6 +994,10 @@ func (r *Neo4jRAG) initDatabase() error {
 		"CREATE INDEX chunk_hash IF NOT EXISTS FOR (c:Chunk) ON (c.hash)",
 		"CREATE INDEX chunk_language IF NOT EXISTS FOR (c:Chunk) ON (c.language)",
 		"CREATE INDEX chunk_entity_type IF NOT EXISTS FOR (c:Chunk) ON (c.entity_type)",
+		"CREATE INDEX chunk_embedded IF NOT EXISTS FOR (c:Chunk) ON (c.embedded)",
+		"CREATE INDEX chunk_embedding_dim IF NOT EXISTS FOR (c:Chunk) ON (c.embedding_dim)",
+		"CREATE INDEX symbol_name IF NOT EXISTS FOR (s:Symbol) ON (s.name, s.project_path)",
+		"CREATE CONSTRAINT package_path IF NOT EXISTS ON (p:Package) ASSERT p.path IS UNIQUE",
 	}
 	
 	for _, constraint := range constraints {
@@ -144,10 +1007,33 @@ func (r *Neo4jRAG) initDatabase() error {
 		}
 	}
 	
+	// Chunks stored updated_at as an RFC3339 string before it became a
+	// datetime like created_at; convert those so the two compare and sort
+	// alike and date filters work
+	for {
+		result, err := session.Run(
+			`MATCH (c:Chunk) WHERE c.updated_at STARTS WITH ''
+			 WITH c LIMIT $batchSize
+			 SET c.updated_at = datetime(c.updated_at)
+			 RETURN count(c) AS converted`,
+			map[string]interface{}{"batchSize": resetBatchSize},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to convert chunk timestamps: %w", err)
+		}
+		record, err := result.Single()
+		if err != nil {
+			return fmt.Errorf("failed to convert chunk timestamps: %w", err)
+		}
+		if converted, _ := record.Get("converted"); converted == int64(0) {
+			break
+		}
+	}
+	
 	// Check if GDS library is available
 	gdsResult, gdsErr := session.Run("CALL gds.list() YIELD name RETURN count(name) as count", nil)
 	if gdsErr != nil {
-		r.logger.Printf("Warning: Graph Data Science library might not be installed: %v\n", gdsErr)
+		r.logger.Warnf("Graph Data Science library might not be installed: %v\n", gdsErr)
 	} else {
 		if gdsResult.Next() {
 			count, _ := gdsResult.Record().Get("count")
@@ -158,1270 +1044,7400 @@ func (r *Neo4jRAG) initDatabase() error {
 	return nil
 }
 
-// IndexDirectory indexes a directory of code using sequential processing
-// optimized for LMStudio which doesn't handle multiple concurrent requests well
-func (r *Neo4jRAG) IndexDirectory(dir string) error {
-	r.logger.Printf("Indexing directory: %s\n", dir)
+// checkChunkerVersion compares the chunker version recorded in the index with
+// the current one and logs a warning on mismatch
+func (r *Neo4jRAG) checkChunkerVersion() error {
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
 	
-	// Get all code files recursively
-	files, err := r.findCodeFiles(dir)
+	result, err := session.Run(
+		`OPTIONAL MATCH (m:IndexMeta {name: 'default'})
+		 WITH m
+		 OPTIONAL MATCH (c:Chunk)
+		 WITH m, count(c) AS chunkCount
+		 RETURN m.chunker_version AS version, chunkCount`,
+		nil,
+	)
 	if err != nil {
-		return fmt.Errorf("failed to find code files: %w", err)
+		return err
 	}
 	
-	r.logger.Printf("Found %d files to index\n", len(files))
-	r.logger.Printf("Using single-threaded processing optimized for LMStudio\n")
+	record, err := result.Single()
+	if err != nil {
+		return err
+	}
 	
-	// Process files sequentially
-	processedCount := 0
-	errorCount := 0
+	chunkCount, _ := record.Get("chunkCount")
+	if count, ok := chunkCount.(int64); !ok || count == 0 {
+		return nil
+	}
 	
-	for _, file := range files {
-		// Process the file
-		err := r.processFile(file, dir)
+	// Indexes built before versioning have no recorded version
+	indexedVersion := int64(0)
+	if version, _ := record.Get("version"); version != nil {
+		indexedVersion, _ = version.(int64)
+	}
+	
+	if indexedVersion != chunkerVersion {
+		r.logger.Warnf("index was built with chunker version %d but this build uses version %d. "+
+			"Search results may mix incompatible chunks; re-run --index with --force-reindex to rebuild.\n",
+			indexedVersion, chunkerVersion)
+	}
+	
+	return nil
+}
+
+// findStaleProjects returns the paths of projects under dir that contain
+// chunks produced by a different chunker version
+func (r *Neo4jRAG) findStaleProjects(dir string) ([]string, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	result, err := session.Run(
+		`MATCH (c:Chunk)-[:PART_OF]->(:File)-[:BELONGS_TO]->(p:Project)
+		 WHERE coalesce(c.chunker_version, 0) <> $chunkerVersion
+		 RETURN DISTINCT p.path AS path`,
+		map[string]interface{}{"chunkerVersion": chunkerVersion},
+	)
+	if err != nil {
+		return nil, err
+	}
+	
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		absDir = dir
+	}
+	
+	stale := []string{}
+	for result.Next() {
+		path, _ := result.Record().Get("path")
+		projectPath, ok := path.(string)
+		if !ok {
+			continue
+		}
 		
-		// Update counters
-		processedCount++
+		absProject, err := filepath.Abs(projectPath)
 		if err != nil {
-			errorCount++
-			r.logger.Printf("Error processing file %s: %v\n", file, err)
+			absProject = projectPath
 		}
-		
-		// Log progress periodically
-		if processedCount%10 == 0 || processedCount == len(files) {
-			r.logger.Printf("Progress: %d/%d files processed (%.1f%%)\n", 
-				processedCount, len(files), float64(processedCount)/float64(len(files))*100)
+		if absProject == absDir || strings.HasPrefix(absProject, absDir+string(filepath.Separator)) {
+			stale = append(stale, projectPath)
 		}
 	}
 	
-	// Log final statistics
-	if errorCount > 0 {
-		r.logger.Printf("Indexing complete with %d errors. Successfully processed %d/%d files\n", 
-			errorCount, len(files)-errorCount, len(files))
-	} else {
-		r.logger.Printf("Indexing complete. Successfully processed all %d files\n", len(files))
-	}
+	return stale, result.Err()
+}
+
+// clearProjects removes the files and chunks of the given projects so they
+// can be rebuilt from scratch
+func (r *Neo4jRAG) clearProjects(projectPaths []string) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
 	
-	return nil
+	_, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		_, err := tx.Run(
+			`MATCH (f:File)-[:BELONGS_TO]->(p:Project)
+			 WHERE p.path IN $projectPaths
+			 OPTIONAL MATCH (c:Chunk)-[:PART_OF]->(f)
+			 DETACH DELETE c, f`,
+			map[string]interface{}{"projectPaths": projectPaths},
+		)
+		return nil, err
+	})
+	
+	return err
 }
 
-// findCodeFiles recursively finds all code files in a directory with comprehensive filtering
-func (r *Neo4jRAG) findCodeFiles(root string) ([]string, error) {
-	var files []string
+// recordChunkerVersion stores the current chunker version as the index version
+func (r *Neo4jRAG) recordChunkerVersion() error {
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
 	
-	// Extensions to include - expanded list of code file extensions
-	extensions := map[string]bool{
-		// Programming languages
-		".go":    true,
-		".py":    true,
-		".js":    true,
-		".jsx":   true,
-		".ts":    true,
-		".tsx":   true,
-		".java":  true,
-		".c":     true,
-		".cpp":   true,
-		".cc":    true,
-		".cxx":   true,
-		".h":     true,
-		".hpp":   true,
-		".hxx":   true,
-		".cs":    true,
-		".php":   true,
-		".rb":    true,
-		".rs":    true,
-		".swift": true,
-		".kt":    true,
-		".scala": true,
-		".pl":    true,
-		".pm":    true,
-		".r":     true,
-		".lua":   true,
-		".groovy":true,
-		".dart":  true,
-		".elm":   true,
-		".ex":    true,
-		".exs":   true,
-		".erl":   true,
-		".hrl":   true,
-		".clj":   true,
-		".hs":    true,
-		".fs":    true,
-		".fsx":   true,
-		".ml":    true,
-		".mli":   true,
-		
-		// Shell scripts
-		".sh":    true,
-		".bash":  true,
-		".zsh":   true,
-		".fish":  true,
-		".ps1":   true,
-		".bat":   true,
-		".cmd":   true,
-		
-		// Web development
-		".html":  true,
-		".htm":   true,
-		".xhtml": true,
-		".css":   true,
-		".scss":  true,
-		".sass":  true,
-		".less":  true,
-		".vue":   true,
-		".svelte":true,
-		
-		// Data and config files
-		".json":  true,
-		".yaml":  true,
-		".yml":   true,
-		".xml":   true,
-		".toml":  true,
-		".ini":   true,
-		".sql":   true,
-		".graphql":true,
-		".proto": true,
-		
-		// Documentation
-		".md":    true,
-		".rst":   true,
-		".tex":   true,
-		".adoc":  true,
-	}
-	
-	// Directories to ignore - expanded with more common patterns
-	ignoreDirs := map[string]bool{
-		// Package managers and dependencies
-		"node_modules":    true,
-		"vendor":          true,
-		"bower_components":true,
-		"jspm_packages":   true,
-		"packages":        true,
-		
-		// Version control
-		".git":            true,
-		".svn":            true,
-		".hg":             true,
-		".bzr":            true,
-		
-		// Virtual environments
-		".venv":           true,
-		"venv":            true,
-		"env":             true,
-		".env":            true,
-		"virtualenv":      true,
-		"__pycache__":     true,
-		"site-packages":   true,
-		
-		// Build and distribution
-		"dist":            true,
-		"build":           true,
-		"out":             true,
-		"bin":             true,
-		"target":          true,
-		"output":          true,
-		"release":         true,
-		"debug":           true,
-		
-		// IDE and editor
-		".idea":           true,
-		".vscode":         true,
-		".vs":             true,
-		".eclipse":        true,
-		".settings":       true,
-		
-		// Temporary and cache
-		"tmp":             true,
-		"temp":            true,
-		"cache":           true,
-		".cache":          true,
-		".sass-cache":     true,
-		
-		// Documentation
-		"docs":            true,
-		"doc":             true,
-		
-		// Test coverage
-		"coverage":        true,
-		".nyc_output":     true,
-		".coverage":       true,
-		"htmlcov":         true,
-		
-		// Logs
-		"logs":            true,
-		"log":             true,
-	}
-	
-	// Files to ignore (by pattern)
-	ignoreFilePatterns := []string{
-		// Minified files
-		"*.min.js",
-		"*.min.css",
-		
-		// Generated files
-		"*.generated.*",
-		"*_generated.*",
-		"*.g.*",
-		"*.pb.*",
-		
-		// Compiled binaries
-		"*.exe",
-		"*.dll",
-		"*.so",
-		"*.dylib",
-		"*.class",
-		"*.o",
-		"*.obj",
-		"*.a",
-		"*.lib",
-		"*.pyc",
-		"*.pyo",
-		
-		// Archives
-		"*.zip",
-		"*.tar",
-		"*.gz",
-		"*.bz2",
-		"*.xz",
-		"*.rar",
-		"*.7z",
-		
-		// Media files
-		"*.jpg", "*.jpeg",
-		"*.png",
-		"*.gif",
-		"*.bmp",
-		"*.ico",
-		"*.svg",
-		"*.webp",
-		"*.mp3",
-		"*.mp4",
-		"*.wav",
-		"*.avi",
-		"*.mov",
-		"*.webm",
-		
-		// Lock files
-		"*.lock",
-		"package-lock.json",
-		"yarn.lock",
-		"Cargo.lock",
-		
-		// Backup files
-		"*~",
-		"*.bak",
-		"*.swp",
-		"*.swo",
-		
-		// Large data files
-		"*.csv",
-		"*.tsv",
-		"*.db",
-		"*.sqlite",
-		"*.sqlite3",
-		
-		// Logs
-		"*.log",
-	}
-	
-	// Maximum file size to process (1MB)
-	maxFileSize := int64(1 * 1024 * 1024)
+	_, err := session.Run(
+		`MERGE (m:IndexMeta {name: 'default'})
+		 SET m.chunker_version = $chunkerVersion,
+		     m.updated_at = datetime()`,
+		map[string]interface{}{"chunkerVersion": chunkerVersion},
+	)
 	
-	r.logger.Printf("Starting file indexing with enhanced filtering from root: %s\n", root)
+	return err
+}
+
+// scoring returns the configured scoring adjustments
+func (r *Neo4jRAG) scoring() ScoringConfig {
+	if r.config.Scoring != nil {
+		return *r.config.Scoring
+	}
+	return DefaultScoringConfig()
+}
+
+// debugf logs diagnostic output at debug level, keeping the search and LLM
+// methods quiet by default
+func (r *Neo4jRAG) debugf(format string, args ...interface{}) {
+	r.logger.Debugf(format, args...)
+}
+
+// indexRoot is a directory to index and the project layout of the files
+// under it (see Config.ProjectLayout)
+type indexRoot struct {
+	dir    string
+	layout string
+}
+
+// projectLayout returns the configured project layout, or the default
+func (r *Neo4jRAG) projectLayout() string {
+	if r.config.ProjectLayout == "" {
+		return projectLayoutTopLevel
+	}
+	return r.config.ProjectLayout
+}
+
+// rootFor returns dir as a root with the configured project layout
+func (r *Neo4jRAG) rootFor(dir string) indexRoot {
+	return indexRoot{dir: dir, layout: r.projectLayout()}
+}
+
+// indexRoots returns the roots for indexing dirs. A single directory uses
+// the configured project layout. With several directories each one is a
+// project of its own, so sibling repositories indexed together can still be
+// told apart; their paths are made absolute so the project paths are too.
+func (r *Neo4jRAG) indexRoots(dirs []string) ([]indexRoot, error) {
+	if len(dirs) == 1 {
+		return []indexRoot{r.rootFor(dirs[0])}, nil
+	}
 	
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+	roots := make([]indexRoot, 0, len(dirs))
+	for _, dir := range dirs {
+		absDir, err := filepath.Abs(dir)
 		if err != nil {
-			r.logger.Printf("Error accessing path %s: %v\n", path, err)
-			return nil // Continue walking despite the error
+			return nil, fmt.Errorf("failed to resolve %s: %w", dir, err)
 		}
-		
-		// Skip if file is too large
-		if !info.IsDir() && info.Size() > maxFileSize {
-			r.logger.Printf("Skipping large file: %s (%.2f MB)\n", path, float64(info.Size())/(1024*1024))
-			return nil
+		roots = append(roots, indexRoot{dir: absDir, layout: projectLayoutRoot})
+	}
+	return roots, nil
+}
+
+// projectPathFor returns the path of the project filePath belongs to under
+// root. In the top-level layout that is the top-level directory containing
+// the file, or the root itself for files directly in it; in the root layout
+// it is always the root; in the manifest layout it is the nearest directory
+// with a project manifest (see manifestProjectPath).
+func projectPathFor(filePath string, root indexRoot) string {
+	switch root.layout {
+	case projectLayoutRoot:
+		return root.dir
+	case projectLayoutManifest:
+		return manifestProjectPath(filePath, root.dir)
+	}
+	
+	relPath, err := filepath.Rel(root.dir, filePath)
+	if err != nil {
+		return root.dir
+	}
+	pathParts := strings.Split(relPath, string(filepath.Separator))
+	if len(pathParts) > 1 {
+		return filepath.Join(root.dir, pathParts[0])
+	}
+	return root.dir
+}
+
+// projectManifests are the files marking a project directory, in the order
+// they are read for the project's name and version
+var projectManifests = []string{"go.mod", "package.json", "Cargo.toml", "pyproject.toml", ".git"}
+
+// projectDirCache remembers the project directory found for each directory,
+// and projectInfoCache the manifest details per project directory, for one
+// indexing run or watch scan; resetProjectCache clears them. Files may be
+// indexed concurrently, so both are guarded by projectCacheMu.
+var (
+	projectCacheMu   sync.Mutex
+	projectDirCache  = map[string]string{}
+	projectInfoCache = map[string]projectInfo{}
+)
+
+// resetProjectCache forgets detected projects so manifests added or edited
+// since the last run are picked up
+func resetProjectCache() {
+	projectCacheMu.Lock()
+	defer projectCacheMu.Unlock()
+	projectDirCache = map[string]string{}
+	projectInfoCache = map[string]projectInfo{}
+}
+
+// manifestProjectPath walks up from filePath's directory to rootDir and
+// returns the first directory holding one of projectManifests, or rootDir
+// when there is none
+func manifestProjectPath(filePath, rootDir string) string {
+	projectCacheMu.Lock()
+	defer projectCacheMu.Unlock()
+	
+	rootDir = filepath.Clean(rootDir)
+	project := rootDir
+	visited := []string{}
+	
+	for dir := filepath.Dir(filePath); ; {
+		if cached, ok := projectDirCache[dir]; ok {
+			project = cached
+			break
 		}
-		
-		// Handle directories
-		if info.IsDir() {
-			// Check if we should skip this directory
-			baseName := filepath.Base(path)
-			
-			// Skip hidden directories (starting with .)
-			if strings.HasPrefix(baseName, ".") && baseName != "." && baseName != ".." {
-				return filepath.SkipDir
-			}
-			
-			// Check for direct matches with excluded directories
-			if ignoreDirs[baseName] {
-				r.logger.Printf("Skipping directory: %s\n", path)
-				return filepath.SkipDir
-			}
-			
-			// Check for path components that should be skipped
-			pathParts := strings.Split(path, string(os.PathSeparator))
-			for _, part := range pathParts {
-				if ignoreDirs[part] {
-					r.logger.Printf("Skipping directory path containing %s: %s\n", part, path)
-					return filepath.SkipDir
-				}
-			}
-			
-			// Check for virtual environment paths
-			if (strings.Contains(path, "venv/lib/python") && strings.Contains(path, "site-packages")) ||
-			   (strings.Contains(path, "env/lib/python") && strings.Contains(path, "site-packages")) {
-				r.logger.Printf("Skipping Python virtual environment path: %s\n", path)
-				return filepath.SkipDir
-			}
-			
-			return nil
+		visited = append(visited, dir)
+		if hasProjectManifest(dir) {
+			project = dir
+			break
 		}
 		
-		// Handle files
-		fileName := filepath.Base(path)
-		
-		// Skip hidden files
-		if strings.HasPrefix(fileName, ".") {
-			return nil
+		// Stop at the root, or at the top of the file system for files
+		// that are not under it
+		parent := filepath.Dir(dir)
+		if dir == rootDir || parent == dir {
+			break
+		}
+		dir = parent
+	}
+	
+	for _, dir := range visited {
+		projectDirCache[dir] = project
+	}
+	return project
+}
+
+// hasProjectManifest reports whether dir holds one of projectManifests
+func hasProjectManifest(dir string) bool {
+	for _, name := range projectManifests {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// projectInfo is what a project's manifest says about it
+type projectInfo struct {
+	name     string // Declared name, e.g. the Go module path; empty if none
+	version  string // Declared version; empty if none
+	manifest string // File name of the manifest read; empty if none
+}
+
+// readProjectInfo returns the name and version declared by the first
+// manifest in projectManifests order that declares a name in dir
+func readProjectInfo(dir string) projectInfo {
+	projectCacheMu.Lock()
+	info, ok := projectInfoCache[dir]
+	projectCacheMu.Unlock()
+	if ok {
+		return info
+	}
+	
+	for _, manifest := range projectManifests {
+		content, err := ioutil.ReadFile(filepath.Join(dir, manifest))
+		if err != nil {
+			continue // Missing, or a directory like .git
 		}
 		
-		// Skip files matching ignore patterns
-		for _, pattern := range ignoreFilePatterns {
-			matched, err := filepath.Match(pattern, fileName)
-			if err != nil {
-				r.logger.Printf("Error matching pattern %s: %v\n", pattern, err)
-				continue
+		var name, version string
+		switch manifest {
+		case "go.mod":
+			name = goModulePath(string(content))
+		case "package.json":
+			var pkg struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
 			}
-			if matched {
-				return nil
+			if json.Unmarshal(content, &pkg) == nil {
+				name, version = pkg.Name, pkg.Version
 			}
+		case "Cargo.toml":
+			name, version = tomlNameVersion(string(content), "package")
+		case "pyproject.toml":
+			name, version = tomlNameVersion(string(content), "project", "tool.poetry")
+		}
+		if name != "" {
+			info = projectInfo{name: name, version: version, manifest: manifest}
+			break
+		}
+	}
+	
+	projectCacheMu.Lock()
+	projectInfoCache[dir] = info
+	projectCacheMu.Unlock()
+	return info
+}
+
+// goModulePath returns the module path declared in go.mod content
+func goModulePath(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
+
+// tomlNameVersion returns the name and version keys of the first of the
+// given TOML tables that sets a name. Only simple `key = "value"` lines are
+// understood, which is how manifests declare them.
+func tomlNameVersion(content string, tables ...string) (string, string) {
+	found := map[string][2]string{}
+	table := ""
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			table = strings.TrimSpace(strings.Trim(line, "[]"))
+			continue
 		}
 		
-		// Check if file extension is one we want to process
-		ext := strings.ToLower(filepath.Ext(path))
-		if extensions[ext] {
-			r.logger.Printf("Including file: %s\n", path)
-			files = append(files, path)
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value, err := strconv.Unquote(strings.TrimSpace(parts[1]))
+		if err != nil {
+			continue
 		}
 		
-		return nil
-	})
+		entry := found[table]
+		switch key {
+		case "name":
+			entry[0] = value
+		case "version":
+			entry[1] = value
+		}
+		found[table] = entry
+	}
 	
-	r.logger.Printf("File filtering complete. Found %d files to process\n", len(files))
-	return files, err
+	for _, table := range tables {
+		if entry := found[table]; entry[0] != "" {
+			return entry[0], entry[1]
+		}
+	}
+	return "", ""
+}
+
+// IndexDirectory indexes a directory of code using sequential processing
+// optimized for LMStudio which doesn't handle multiple concurrent requests well
+func (r *Neo4jRAG) IndexDirectory(dir string) error {
+	return r.indexDirectory(r.rootFor(dir))
 }
 
-// processFile processes a single code file
-func (r *Neo4jRAG) processFile(filePath, rootDir string) error {
-	// Read file
-	content, err := ioutil.ReadFile(filePath)
+// IndexDirectories indexes several directories into one index, one after
+// the other. Each directory becomes its own project (see indexRoots).
+func (r *Neo4jRAG) IndexDirectories(dirs []string) error {
+	roots, err := r.indexRoots(dirs)
 	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
+		return err
 	}
 	
-	// Skip if file is too large (>1MB)
-	if len(content) > 1024*1024 {
-		r.logger.Printf("Skipping large file: %s (%d bytes)\n", filePath, len(content))
-		return nil
+	for _, root := range roots {
+		if err := r.indexDirectory(root); err != nil {
+			return fmt.Errorf("failed to index %s: %w", root.dir, err)
+		}
 	}
+	return nil
+}
+
+// indexDirectory does the work of IndexDirectory for one root
+func (r *Neo4jRAG) indexDirectory(root indexRoot) error {
+	dir := root.dir
+	r.logger.Printf("Indexing directory: %s\n", dir)
+	resetGitCache()
+	resetProjectCache()
 	
-	// Get file info
-	relPath, err := filepath.Rel(rootDir, filePath)
+	// Detect projects chunked by an older chunker before adding new chunks
+	staleProjects, err := r.findStaleProjects(dir)
 	if err != nil {
-		relPath = filePath
+		return fmt.Errorf("failed to check chunker version: %w", err)
 	}
 	
-	ext := strings.ToLower(filepath.Ext(filePath))
-	language := getLanguageFromExt(ext)
-	
-	// Determine project path (typically the first directory in the relative path)
-	projectPath := rootDir
-	pathParts := strings.Split(relPath, string(filepath.Separator))
-	if len(pathParts) > 1 {
-		projectPath = filepath.Join(rootDir, pathParts[0])
+	if len(staleProjects) > 0 {
+		if r.config.ForceReindex {
+			r.logger.Printf("Clearing %d project(s) indexed with an older chunker: %v\n", len(staleProjects), staleProjects)
+			if err := r.clearProjects(staleProjects); err != nil {
+				return fmt.Errorf("failed to clear stale projects: %w", err)
+			}
+		} else {
+			r.logger.Warnf("%d project(s) contain chunks from a different chunker version (current: %d): %v. "+
+				"Use --force-reindex to clear and rebuild them.\n", len(staleProjects), chunkerVersion, staleProjects)
+		}
 	}
 	
-	// Chunk the file
-	chunks, err := r.chunkFile(string(content), filePath, projectPath, language)
+	// Get all code files recursively
+	files, err := r.findCodeFiles(dir)
 	if err != nil {
-		return fmt.Errorf("failed to chunk file: %w", err)
+		return fmt.Errorf("failed to find code files: %w", err)
 	}
 	
-	// Skip if no chunks were created
-	if len(chunks) == 0 {
-		return nil
+	// Incremental runs only process the files changed since the last one
+	if !r.config.Since.IsZero() || r.config.SinceCommit != "" {
+		files, err = r.changedFiles(dir, files)
+		if err != nil {
+			return fmt.Errorf("failed to find changed files: %w", err)
+		}
 	}
 	
-	// Generate embeddings for chunks
-	err = r.generateEmbeddings(chunks)
-	if err != nil {
-		return fmt.Errorf("failed to generate embeddings: %w", err)
+	r.logger.Printf("Found %d files to index\n", len(files))
+	r.logger.Printf("Using single-threaded processing optimized for LMStudio\n")
+	
+	// Process files sequentially
+	processedCount := 0
+	errorCount := 0
+	
+	for _, file := range files {
+		// Process the file
+		err := r.processFile(file, root)
+		
+		// Update counters
+		processedCount++
+		if errors.Is(err, context.DeadlineExceeded) {
+			errorCount++
+			r.logger.Errorf("skipping %s: processing exceeded the %v per-file timeout\n", file, r.config.FileProcessTimeout)
+		} else if err != nil {
+			errorCount++
+			r.logger.Errorf("failed to process file %s: %v\n", file, err)
+		}
+		
+		// Report progress to the caller, or log it periodically
+		if r.config.Progress != nil {
+			r.config.Progress(processedCount, len(files), file)
+		} else if processedCount%10 == 0 || processedCount == len(files) {
+			r.logger.Printf("Progress: %d/%d files processed (%.1f%%)\n", 
+				processedCount, len(files), float64(processedCount)/float64(len(files))*100)
+		}
 	}
 	
-	// Store chunks in Neo4j
-	err = r.storeChunks(chunks, filePath, projectPath)
-	if err != nil {
-		return fmt.Errorf("failed to store chunks: %w", err)
+	// Log final statistics
+	if errorCount > 0 {
+		r.logger.Printf("Indexing complete with %d errors. Successfully processed %d/%d files\n", 
+			errorCount, len(files)-errorCount, len(files))
+	} else {
+		r.logger.Printf("Indexing complete. Successfully processed all %d files\n", len(files))
+	}
+	
+	// Second phase: backfill the embeddings skipped while storing chunks
+	if r.config.DeferEmbeddings {
+		r.logger.Println("Chunks stored; generating pending embeddings")
+		embedded, err := r.EmbedPending()
+		if err != nil {
+			return fmt.Errorf("failed to backfill embeddings after %d chunks: %w", embedded, err)
+		}
+	}
+	
+	// Only mark the index as current once no stale chunks were left behind
+	if len(staleProjects) == 0 || r.config.ForceReindex {
+		if err := r.recordChunkerVersion(); err != nil {
+			r.logger.Warnf("failed to record chunker version: %v\n", err)
+		}
 	}
 	
 	return nil
 }
 
-// chunkFile splits a file into chunks
-func (r *Neo4jRAG) chunkFile(content, filePath, projectPath, language string) ([]CodeChunk, error) {
-	var chunks []CodeChunk
-	
-	// For Go files, try to split by functions/methods
-	if language == "Go" {
-		chunks = r.chunkGoCode(content, filePath, projectPath)
+// changedFiles narrows the files found under dir to those changed since
+// Config.Since or Config.SinceCommit, after removing indexed files under dir
+// that have been deleted
+func (r *Neo4jRAG) changedFiles(dir string, files []string) ([]string, error) {
+	removed, err := r.removeDeletedFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if removed > 0 {
+		r.logger.Printf("Removed %d deleted files from the index\n", removed)
 	}
 	
-	// For other languages or if function chunking produced too few chunks
-	if len(chunks) < 2 {
-		chunks = r.chunkBySize(content, filePath, projectPath, language)
+	var changed map[string]bool
+	if r.config.SinceCommit != "" {
+		changed, err = gitChangedFiles(dir, r.config.SinceCommit)
+		if err != nil {
+			return nil, err
+		}
 	}
 	
-	// Generate IDs and hashes for chunks
-	for i := range chunks {
-		// Generate a deterministic ID based on file path and chunk position
-		idStr := fmt.Sprintf("%s:%d:%d", filePath, chunks[i].StartLine, chunks[i].EndLine)
-		h := md5.Sum([]byte(idStr))
-		chunks[i].ID = hex.EncodeToString(h[:])
-		
-		// Generate content hash for change detection
-		contentHash := md5.Sum([]byte(chunks[i].Content))
-		chunks[i].Hash = hex.EncodeToString(contentHash[:])
+	kept := []string{}
+	for _, file := range files {
+		if changed != nil && !changed[filepath.Clean(file)] {
+			continue
+		}
+		if !r.config.Since.IsZero() {
+			info, err := os.Stat(file)
+			if err != nil || !info.ModTime().After(r.config.Since) {
+				continue
+			}
+		}
+		kept = append(kept, file)
 	}
 	
-	return chunks, nil
+	r.logger.Printf("%d of %d files changed since the last run\n", len(kept), len(files))
+	return kept, nil
 }
 
-// chunkGoCode splits Go code by functions and methods
-func (r *Neo4jRAG) chunkGoCode(content, filePath, projectPath string) []CodeChunk {
-	chunks := []CodeChunk{}
+// gitChangedFiles returns the paths under dir that differ from sinceCommit
+// in the working tree, including deleted and untracked files. The paths are
+// joined to dir like the paths of a walk.
+func gitChangedFiles(dir, sinceCommit string) (map[string]bool, error) {
+	diff, err := exec.Command("git", "-C", dir, "diff", "--name-only", "--relative", "--no-renames", sinceCommit, "--").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff against %s failed: %w", sinceCommit, err)
+	}
+	untracked, err := exec.Command("git", "-C", dir, "ls-files", "--others", "--exclude-standard").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files failed: %w", err)
+	}
 	
-	// Regex patterns for Go functions
-	funcPattern := regexp.MustCompile(`func\s+(\w+)\s*\((.*?)\)(?:\s+\w+)?\s*{`)
-	methodPattern := regexp.MustCompile(`func\s+\(\w+\s+\*?\w+\)\s+(\w+)\s*\((.*?)\)(?:\s+\w+)?\s*{`)
+	changed := map[string]bool{}
+	for _, line := range strings.Split(string(diff)+"\n"+string(untracked), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			changed[filepath.Join(dir, filepath.FromSlash(line))] = true
+		}
+	}
+	return changed, nil
+}
+
+// removeDeletedFiles removes indexed files under dir that no longer exist
+// and returns how many were removed
+func (r *Neo4jRAG) removeDeletedFiles(dir string) (int, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
 	
-	// Find all functions
-	funcMatches := funcPattern.FindAllStringSubmatchIndex(content, -1)
-	methodMatches := methodPattern.FindAllStringSubmatchIndex(content, -1)
+	result, err := session.Run(`MATCH (f:File) RETURN f.path AS path`, nil)
+	if err != nil {
+		return 0, err
+	}
 	
-	// Combine and sort all matches by their start position
-	type match struct {
-		start    int
-		end      int
-		name     string
-		sig      string
-		isMethod bool
-	}
-	
-	allMatches := []match{}
-	
-	// Process function matches
-	for _, m := range funcMatches {
-		if len(m) >= 4 {
-			funcName := content[m[2]:m[3]]
-			signature := ""
-			if len(m) >= 6 {
-				signature = content[m[4]:m[5]]
-			}
-			allMatches = append(allMatches, match{
-				start:    m[0],
-				end:      m[1],
-				name:     funcName,
-				sig:      signature,
-				isMethod: false,
-			})
+	deleted := []string{}
+	for result.Next() {
+		value, _ := result.Record().Get("path")
+		path, ok := value.(string)
+		if !ok {
+			continue
+		}
+		
+		// Only files under dir; stored paths share dir's relative or
+		// absolute form
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			deleted = append(deleted, path)
 		}
 	}
+	if err := result.Err(); err != nil {
+		return 0, err
+	}
 	
-	// Process method matches
-	for _, m := range methodMatches {
-		if len(m) >= 4 {
-			methodName := content[m[2]:m[3]]
-			signature := ""
-			if len(m) >= 6 {
-				signature = content[m[4]:m[5]]
-			}
-			allMatches = append(allMatches, match{
-				start:    m[0],
-				end:      m[1],
-				name:     methodName,
-				sig:      signature,
-				isMethod: true,
-			})
+	for _, path := range deleted {
+		if err := r.RemoveFile(path); err != nil {
+			return 0, fmt.Errorf("failed to remove %s: %w", path, err)
 		}
 	}
+	return len(deleted), nil
+}
+
+// Watch rescans once file system events have stopped for watchSettleDelay,
+// or watchMaxDelay after the first of a steady stream of them
+const (
+	watchSettleDelay = 500 * time.Millisecond
+	watchMaxDelay    = 10 * time.Second
+)
+
+// fileState is what Watch compares between scans to spot changed files
+type fileState struct {
+	modTime time.Time
+	size    int64
+	root    indexRoot // Root the file was found under
+}
+
+// Watch keeps the index of dir current until ctx is cancelled: files that
+// are created or modified are re-chunked and re-embedded, and deleted files
+// are removed from the index. Every directory findCodeFiles walks into is
+// watched for file system events; once they settle (see watchSettleDelay)
+// the tree is rescanned with the same filtering as findCodeFiles and the
+// files whose size or modification time changed are updated, so bursts of
+// editor writes trigger one update and new directories and ignore rules are
+// handled as when indexing.
+func (r *Neo4jRAG) Watch(ctx context.Context, dir string) error {
+	return r.watchRoots(ctx, []indexRoot{r.rootFor(dir)})
+}
+
+// WatchDirectories is Watch for the directories of an IndexDirectories run.
+// All of them are rescanned together once changes settle.
+func (r *Neo4jRAG) WatchDirectories(ctx context.Context, dirs []string) error {
+	roots, err := r.indexRoots(dirs)
+	if err != nil {
+		return err
+	}
+	return r.watchRoots(ctx, roots)
+}
+
+// watchRoots does the work of Watch for one or more roots
+func (r *Neo4jRAG) watchRoots(ctx context.Context, roots []indexRoot) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to start watching: %w", err)
+	}
+	defer watcher.Close()
 	
-	// Sort by start position
-	sort.Slice(allMatches, func(i, j int) bool {
-		return allMatches[i].start < allMatches[j].start
-	})
-	
-	// Create chunks from matches
-	lines := strings.Split(content, "\n")
-	linePositions := make([]int, len(lines)+1)
-	pos := 0
-	for i, line := range lines {
-		linePositions[i] = pos
-		pos += len(line) + 1 // +1 for newline
+	snapshot, dirs, err := r.scanFileStates(roots)
+	if err != nil {
+		return err
 	}
-	linePositions[len(lines)] = pos
+	watched := r.updateWatches(watcher, map[string]bool{}, dirs)
+	r.logger.Printf("Watching %d files in %d directories for changes\n", len(snapshot), len(watched))
 	
-	for i, m := range allMatches {
-		startPos := m.start
-		var endPos int
-		
-		// End position is either the start of next function or end of file
-		if i < len(allMatches)-1 {
-			endPos = allMatches[i+1].start
-		} else {
-			endPos = len(content)
+	// The settle timer only runs while events are coming in
+	settle := time.NewTimer(watchSettleDelay)
+	settle.Stop()
+	var firstEvent time.Time
+	
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			r.logger.Warnf("file watcher: %v\n", err)
+			continue
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			
+			// Wait for the burst to settle, but not forever
+			if firstEvent.IsZero() {
+				firstEvent = time.Now()
+			}
+			if time.Since(firstEvent) < watchMaxDelay {
+				if !settle.Stop() {
+					select {
+					case <-settle.C:
+					default:
+					}
+				}
+				settle.Reset(watchSettleDelay)
+			}
+			continue
+		case <-settle.C:
+			firstEvent = time.Time{}
 		}
 		
-		// Find start and end lines
-		startLine := sort.Search(len(linePositions), func(i int) bool {
-			return linePositions[i] > startPos
-		}) - 1
-		if startLine < 0 {
-			startLine = 0
+		current, dirs, err := r.scanFileStates(roots)
+		if err != nil {
+			r.logger.Warnf("%v\n", err)
+			continue
 		}
+		watched = r.updateWatches(watcher, watched, dirs)
+		resetGitCache()
+		resetProjectCache()
 		
-		endLine := sort.Search(len(linePositions), func(i int) bool {
-			return linePositions[i] > endPos
-		}) - 1
-		if endLine < 0 {
-			endLine = 0
+		for path := range snapshot {
+			if _, ok := current[path]; ok {
+				continue
+			}
+			if err := r.RemoveFile(path); err != nil {
+				r.logger.Errorf("failed to remove %s from the index: %v\n", path, err)
+				continue
+			}
+			r.logger.Printf("Removed deleted file from the index: %s\n", path)
 		}
 		
-		// Create chunk
-		entityType := "function"
-		if m.isMethod {
-			entityType = "method"
+		for path, state := range current {
+			if previous, ok := snapshot[path]; ok && previous == state {
+				continue
+			}
+			if err := r.reindexFile(path, state.root); err != nil {
+				r.logger.Errorf("failed to re-index %s: %v\n", path, err)
+				continue
+			}
+			r.logger.Printf("Re-indexed changed file: %s\n", path)
 		}
 		
-		chunks = append(chunks, CodeChunk{
-			FilePath:    filePath,
-			ProjectPath: projectPath,
-			Content:     content[startPos:endPos],
-			StartLine:   startLine + 1, // 1-based line numbers
-			EndLine:     endLine + 1,
-			EntityType:  entityType,
-			Name:        m.name,
-			Signature:   m.sig,
-			Language:    "Go",
-		})
+		snapshot = current
 	}
-	
-	return chunks
 }
 
-// chunkBySize splits content into chunks of approximately equal size
-func (r *Neo4jRAG) chunkBySize(content, filePath, projectPath, language string) []CodeChunk {
-	chunks := []CodeChunk{}
-	lines := strings.Split(content, "\n")
-	
-	// If file is small enough, return as single chunk
-	if len(content) <= r.config.MaxChunkSize {
-		return []CodeChunk{
-			{
-				FilePath:    filePath,
-				ProjectPath: projectPath,
-				Content:     content,
-				StartLine:   1,
-				EndLine:     len(lines),
-				EntityType:  "chunk",
-				Name:        fmt.Sprintf("chunk_1_%d", len(lines)),
-				Language:    language,
-			},
+// updateWatches makes watcher watch exactly dirs, given the directories in
+// watched it watches now, and returns the new set. Directories that cannot
+// be watched, e.g. past the inotify watch limit, are logged and skipped.
+func (r *Neo4jRAG) updateWatches(watcher *fsnotify.Watcher, watched map[string]bool, dirs []string) map[string]bool {
+	current := map[string]bool{}
+	for _, dir := range dirs {
+		current[dir] = true
+		if watched[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			r.logger.Warnf("cannot watch %s: %v\n", dir, err)
+			delete(current, dir)
 		}
 	}
 	
-	// Otherwise, split into multiple chunks
-	currentChunk := []string{}
-	currentSize := 0
-	startLine := 1
-	
-	for i, line := range lines {
-		lineLen := len(line) + 1 // +1 for newline
-		currentChunk = append(currentChunk, line)
-		currentSize += lineLen
+	// Watches of deleted directories are dropped by the watcher itself
+	for dir := range watched {
+		if !current[dir] {
+			_ = watcher.Remove(dir)
+		}
+	}
+	return current
+}
+
+// scanFileStates returns the modification time and size of every file
+// findCodeFiles would index under the roots, and the directories it walks
+// into
+func (r *Neo4jRAG) scanFileStates(roots []indexRoot) (map[string]fileState, []string, error) {
+	states := map[string]fileState{}
+	var dirs []string
+	for _, root := range roots {
+		files, err := r.walkCodeFiles(root.dir, func(dir string) {
+			dirs = append(dirs, dir)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to scan %s: %w", root.dir, err)
+		}
 		
-		// If chunk is big enough or we're at the end, save it
-		if currentSize >= r.config.MaxChunkSize || i == len(lines)-1 {
-			chunkContent := strings.Join(currentChunk, "\n")
-			endLine := startLine + len(currentChunk) - 1
-			
-			chunks = append(chunks, CodeChunk{
-				FilePath:    filePath,
-				ProjectPath: projectPath,
-				Content:     chunkContent,
-				StartLine:   startLine,
-				EndLine:     endLine,
-				EntityType:  "chunk",
-				Name:        fmt.Sprintf("chunk_%d_%d", startLine, endLine),
-				Language:    language,
-			})
-			
-			// Start new chunk with overlap
-			overlapLines := r.config.ChunkOverlap
-			if overlapLines > len(currentChunk) {
-				overlapLines = len(currentChunk)
-			}
-			
-			currentChunk = currentChunk[len(currentChunk)-overlapLines:]
-			startLine = endLine - overlapLines + 1
-			currentSize = 0
-			for _, line := range currentChunk {
-				currentSize += len(line) + 1
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err != nil {
+				continue // Deleted since the walk; the next scan removes it
 			}
+			states[file] = fileState{modTime: info.ModTime(), size: info.Size(), root: root}
 		}
 	}
-	
-	return chunks
+	return states, dirs, nil
 }
 
-// generateEmbeddings generates embeddings for chunks
-// optimized for LMStudio by processing in smaller batches
-func (r *Neo4jRAG) generateEmbeddings(chunks []CodeChunk) error {
+// reindexFile processes a changed file under root, removing it from the
+// index when it no longer produces any chunks
+func (r *Neo4jRAG) reindexFile(filePath string, root indexRoot) error {
+	chunks, projectPath, err := r.chunkSourceFile(filePath, root)
+	if err != nil {
+		return err
+	}
 	if len(chunks) == 0 {
-		return nil
+		return r.RemoveFile(filePath)
 	}
+	return r.indexChunks(chunks, filePath, projectPath)
+}
+
+// findCodeFiles recursively finds all code files in a directory with comprehensive filtering
+func (r *Neo4jRAG) findCodeFiles(root string) ([]string, error) {
+	r.logger.Printf("Starting file indexing with enhanced filtering from root: %s\n", root)
+	files, err := r.walkCodeFiles(root, nil)
+	r.logger.Printf("File filtering complete. Found %d files to process\n", len(files))
+	return files, err
+}
+
+// walkCodeFiles does the filtering walk behind findCodeFiles without
+// logging the start and end of the walk. onDir, when not nil, is called for
+// every directory walked into.
+func (r *Neo4jRAG) walkCodeFiles(root string, onDir func(dir string)) ([]string, error) {
+	var files []string
 	
-	// Process in smaller batches to avoid overwhelming LMStudio
-	batchSize := 5 // Small batch size to avoid overwhelming LMStudio
+	ignoreDirs := filewalk.DefaultIgnoreDirs()
+	if r.config.NoDefaultIgnores {
+		ignoreDirs = filewalk.HardIgnoreDirs()
+	}
 	
-	for i := 0; i < len(chunks); i += batchSize {
-		end := i + batchSize
-		if end > len(chunks) {
-			end = len(chunks)
-		}
-		
-		batch := chunks[i:end]
-		
-		// Prepare texts for embedding
-		texts := make([]string, len(batch))
-		for j, chunk := range batch {
-			texts[j] = chunk.Content
+	// Let dependency directories through when vendored code is indexed;
+	// their chunks are tagged as vendored in processFile instead
+	if r.config.IndexVendored {
+		for _, dir := range vendoredDirs {
+			delete(ignoreDirs, dir)
 		}
+	}
+	
+	// Add user-configured extensions to the allowlist
+	extensions := filewalk.DefaultExtensions()
+	for _, ext := range r.config.ExtraExtensions {
+		extensions[strings.ToLower(ext)] = true
+	}
+	
+	// .gitignore rules are loaded as the walk enters each directory
+	var gitignore *gitignoreMatcher
+	if r.config.RespectGitignore {
+		gitignore = newGitignoreMatcher()
+	}
+	
+	opts := filewalk.WalkOptions{
+		Extensions:  extensions,
+		IgnoreDirs:  ignoreDirs,
+		MaxFileSize: r.maxFileSize(),
 		
-		// Call embedding service
-		r.logger.Printf("Generating embeddings for batch %d/%d (size: %d)", 
-			(i/batchSize)+1, (len(chunks)+batchSize-1)/batchSize, len(batch))
-		
-		embeddings, err := r.getEmbeddings(texts)
-		if err != nil {
-			return fmt.Errorf("failed to generate embeddings for batch %d: %w", (i/batchSize)+1, err)
-		}
+		// Files without a listed extension are still code when they have an
+		// extension override, a well-known name (Dockerfile, Makefile,
+		// .bashrc) or an interpreter line
+		Include: func(path string) bool {
+			ext := strings.ToLower(filepath.Ext(path))
+			if r.config.ExtensionLanguageOverrides[ext] != "" || languageFromFilename(filepath.Base(path)) != "" {
+				return true
+			}
+			return ext == "" && languageFromShebang(readFileHead(path, shebangSniffSize)) != ""
+		},
 		
-		// Assign embeddings to chunks
-		for j, embedding := range embeddings {
-			batch[j].Embedding = embedding
+		OnSkip: func(path string, info os.FileInfo, reason filewalk.SkipReason) {
+			switch reason {
+			case filewalk.SkipBrokenSymlink:
+				r.logger.Warnf("Skipping broken symlink: %s\n", path)
+			case filewalk.SkipTooLarge:
+				r.logger.Debugf("Skipping large file: %s (%.2f MB)\n", path, float64(info.Size())/(1024*1024))
+			case filewalk.SkipIgnoredDir, filewalk.SkipCaller:
+				r.logger.Debugf("Skipping %s (%s)\n", path, reason)
+			}
+		},
+		OnError: func(path string, err error) {
+			r.logger.Errorf("cannot access path %s: %v\n", path, err)
+		},
+		OnDir: onDir,
+	}
+	
+	if gitignore != nil {
+		opts.SkipDir = func(path string) bool {
+			relDir := walkRelPath(root, path)
+			if relDir != "." && gitignore.ignored(relDir, true) {
+				return true
+			}
+			if err := gitignore.load(path, relDir); err != nil {
+				r.logger.Warnf("could not read .gitignore in %s: %v\n", path, err)
+			}
+			return false
 		}
-		
-		// Add a small delay between batches to avoid overwhelming LMStudio
-		if i+batchSize < len(chunks) {
-			time.Sleep(1 * time.Second)
+		opts.SkipFile = func(path string) bool {
+			return gitignore.ignored(walkRelPath(root, path), false)
 		}
 	}
 	
-	return nil
+	err := filewalk.Walk(root, opts, func(path string) {
+		r.logger.Debugf("Including file: %s\n", path)
+		files = append(files, path)
+	})
+	
+	return files, err
 }
 
-// getEmbeddings calls the embedding service with retry logic
-// optimized for LMStudio which may be slow with requests
-func (r *Neo4jRAG) getEmbeddings(texts []string) ([][]float32, error) {
-	// Prepare request
-	req := EmbeddingRequest{
-		Texts: texts,
+// walkRelPath returns path relative to the walk root, slash-separated
+func walkRelPath(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// gitignoreRule is one pattern line from a .gitignore file
+type gitignoreRule struct {
+	segments []string // Pattern split on "/"
+	negate   bool     // "!pattern" re-includes a path excluded by an earlier rule
+	dirOnly  bool     // "pattern/" only matches directories
+	anchored bool     // Patterns containing a slash match from the .gitignore's directory; others match a base name at any depth
+}
+
+// gitignoreMatcher holds the .gitignore rules loaded during a directory walk,
+// keyed by the slash-separated directory (relative to the walk root, "." for
+// the root itself) whose .gitignore they came from
+type gitignoreMatcher struct {
+	rules map[string][]gitignoreRule
+}
+
+func newGitignoreMatcher() *gitignoreMatcher {
+	return &gitignoreMatcher{rules: map[string][]gitignoreRule{}}
+}
+
+// load reads the .gitignore in dir, if there is one; relDir is dir relative
+// to the walk root
+func (m *gitignoreMatcher) load(dir, relDir string) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ".gitignore"))
+	if os.IsNotExist(err) {
+		return nil
 	}
-	
-	reqBody, err := json.Marshal(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	
-	// Add retry logic with backoff
-	maxRetries := 3
-	backoffDuration := 1 * time.Second
-	
-	var resp *http.Response
-	var lastErr error
-	
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		if attempt > 0 {
-			r.logger.Printf("Retrying embedding request (attempt %d/%d) after %v delay", 
-				attempt+1, maxRetries, backoffDuration)
-			time.Sleep(backoffDuration)
-			backoffDuration *= 2 // Exponential backoff
+	if rules := parseGitignore(string(data)); len(rules) > 0 {
+		m.rules[relDir] = rules
+	}
+	return nil
+}
+
+// parseGitignore parses the common .gitignore syntax: comments, negation,
+// trailing-slash directory patterns, leading-slash anchoring, "*", "?",
+// character classes and "**"
+func parseGitignore(content string) []gitignoreRule {
+	var rules []gitignoreRule
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		
+		// Trailing spaces are ignored unless escaped with a backslash
+		if !strings.HasSuffix(line, "\\ ") {
+			line = strings.TrimRight(line, " ")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
 		
-		// Call embedding service
-		resp, err = http.Post(r.config.EmbeddingURL, "application/json", bytes.NewBuffer(reqBody))
-		if err == nil && resp.StatusCode == http.StatusOK {
-			break // Success
+		var rule gitignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
 		}
 		
-		lastErr = err
-		if err == nil {
-			resp.Body.Close()
-			lastErr = fmt.Errorf("embedding service returned status code %d", resp.StatusCode)
+		rule.segments = strings.Split(line, "/")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// ignored reports whether relPath (slash-separated, relative to the walk
+// root) is excluded. Each ancestor directory's rules are checked from the
+// root down, so deeper .gitignore files override their parents, and the
+// last matching rule wins, as in git.
+func (m *gitignoreMatcher) ignored(relPath string, isDir bool) bool {
+	parts := strings.Split(relPath, "/")
+	ignored := false
+	for depth := range parts {
+		dir := "."
+		if depth > 0 {
+			dir = strings.Join(parts[:depth], "/")
+		}
+		for _, rule := range m.rules[dir] {
+			if rule.matches(parts[depth:], isDir) {
+				ignored = !rule.negate
+			}
 		}
 	}
+	return ignored
+}
+
+// matches reports whether the rule matches a path given as segments
+// relative to the rule's .gitignore directory
+func (rule gitignoreRule) matches(parts []string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+	if !rule.anchored {
+		return matchGlobSegments(rule.segments, parts[len(parts)-1:])
+	}
+	return matchGlobSegments(rule.segments, parts)
+}
+
+// matchGlobSegments matches path segments against pattern segments, where a
+// "**" segment matches zero or more path segments
+func matchGlobSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchGlobSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	matched, err := filepath.Match(pattern[0], parts[0])
+	if err != nil || !matched {
+		return false
+	}
+	return matchGlobSegments(pattern[1:], parts[1:])
+}
+
+// vendoredDirs are directory names that hold third-party dependencies
+var vendoredDirs = []string{"vendor", "node_modules", "bower_components", "jspm_packages", "site-packages", "dist-packages"}
+
+// vendorMarkerCache remembers which vendor/ directories have been identified
+// as dependency directories, so markers are checked once per directory. It is
+// guarded by vendorMarkerMu as files may be indexed concurrently.
+var (
+	vendorMarkerMu    sync.Mutex
+	vendorMarkerCache = map[string]bool{}
+)
+
+// isVendoredPath reports whether a file lives in third-party dependency code,
+// using language-idiomatic markers rather than directory names alone:
+// Go vendor/ with modules.txt, PHP vendor/ with composer metadata, Python
+// site-packages/dist-packages, Node node_modules and Rust crates under .cargo.
+func isVendoredPath(path string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		absPath = path
+	}
 	
-	if resp == nil || lastErr != nil {
-		return nil, fmt.Errorf("failed to get embeddings after %d attempts: %w", maxRetries, lastErr)
+	parts := strings.Split(filepath.ToSlash(absPath), "/")
+	for i, part := range parts {
+		switch part {
+		case "node_modules", "bower_components", "jspm_packages", "site-packages", "dist-packages":
+			return true
+		case ".cargo":
+			if i+1 < len(parts) && (parts[i+1] == "registry" || parts[i+1] == "git") {
+				return true
+			}
+		case "vendor":
+			vendorDir := filepath.FromSlash(strings.Join(parts[:i+1], "/"))
+			if isVendorDir(vendorDir) {
+				return true
+			}
+		}
 	}
-	defer resp.Body.Close()
 	
-	// Parse response
-	var embeddingResp EmbeddingResponse
-	err = json.NewDecoder(resp.Body).Decode(&embeddingResp)
+	return false
+}
+
+// isVendorDir checks a directory named vendor for dependency-manager markers
+func isVendorDir(dir string) bool {
+	vendorMarkerMu.Lock()
+	vendored, ok := vendorMarkerCache[dir]
+	vendorMarkerMu.Unlock()
+	if ok {
+		return vendored
+	}
+	
+	for _, marker := range []string{"modules.txt", "autoload.php", "composer"} {
+		if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+			vendored = true
+			break
+		}
+	}
+	
+	vendorMarkerMu.Lock()
+	vendorMarkerCache[dir] = vendored
+	vendorMarkerMu.Unlock()
+	return vendored
+}
+
+// maxFileSize returns the configured file size limit, falling back to the default
+func (r *Neo4jRAG) maxFileSize() int64 {
+	if r.config.MaxFileSize > 0 {
+		return r.config.MaxFileSize
+	}
+	return defaultMaxFileSize
+}
+
+// looksBinary reports whether the file at filePath appears to be binary: its
+// first binarySniffSize bytes contain a null byte or more than threshold
+// non-printable bytes as a fraction of those read
+func looksBinary(filePath string, threshold float64) (bool, error) {
+	file, err := os.Open(filePath)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
+	defer file.Close()
 	
-	// Add a small delay after successful embedding to avoid overwhelming LMStudio
-	time.Sleep(500 * time.Millisecond)
+	buf := make([]byte, binarySniffSize)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+	
+	nonPrintable := 0
+	for _, b := range buf[:n] {
+		switch {
+		case b == 0:
+			return true, nil
+		case b == '\t' || b == '\n' || b == '\r' || b == '\f' || b == '\b' || b == 0x1b:
+			// Whitespace and escape sequences are common in text files
+		case b < 0x20 || b == 0x7f:
+			nonPrintable++
+		}
+		// Bytes >= 0x80 count as printable so UTF-8 text is not flagged
+	}
 	
-	return embeddingResp.Embeddings, nil
+	return float64(nonPrintable)/float64(n) > threshold, nil
 }
 
-// storeChunks stores chunks in Neo4j
-func (r *Neo4jRAG) storeChunks(chunks []CodeChunk, filePath, projectPath string) error {
-	session := r.driver.NewSession(neo4j.SessionConfig{})
-	defer session.Close()
+// chunkSourceFile reads and chunks a single code file, returning its chunks
+// and project path. Files over the size limit and binary files yield no
+// chunks.
+func (r *Neo4jRAG) chunkSourceFile(filePath string, root indexRoot) ([]CodeChunk, string, error) {
+	// Re-check the size limit here since Go files are read into memory
+	// whole and callers may pass paths that did not come from the walk
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.Size() > r.maxFileSize() {
+		r.logger.Debugf("Skipping large file: %s (%.2f MB)\n", filePath, float64(info.Size())/(1024*1024))
+		return nil, "", nil
+	}
 	
-	// Create a transaction
-	_, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
-		// Create/merge project node
-		_, err := tx.Run(
-			`MERGE (p:Project {path: $projectPath}) 
-			 ON CREATE SET p.created_at = datetime(),
-			               p.name = $projectName
-			 ON MATCH SET p.updated_at = datetime()`,
-			map[string]interface{}{
-				"projectPath": projectPath,
-				"projectName": filepath.Base(projectPath),
-			},
-		)
+	// Skip binary files before spending any chunking or embedding work
+	threshold := r.config.BinaryThreshold
+	if threshold <= 0 {
+		threshold = defaultBinaryThreshold
+	}
+	binary, err := looksBinary(filePath, threshold)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read file: %w", err)
+	}
+	if binary {
+		r.logger.Debugf("Skipping binary file: %s\n", filePath)
+		return nil, "", nil
+	}
+	
+	language := r.languageForFile(filePath)
+	projectPath := projectPathFor(filePath, root)
+	
+	// Chunk the file. Go, JavaScript, TypeScript and C-family code is
+	// parsed as a whole; everything else is streamed through the size-based
+	// chunker.
+	var chunks []CodeChunk
+	if language == "Go" || isJSLanguage(language) || isCFamilyLanguage(language) {
+		content, err := ioutil.ReadFile(filePath)
 		if err != nil {
-			return nil, err
+			return nil, "", fmt.Errorf("failed to read file: %w", err)
 		}
-		
-		// Create/merge file node
-		_, err = tx.Run(
-			`MERGE (f:File {path: $filePath}) 
-			 ON CREATE SET f.created_at = datetime(),
-			               f.name = $fileName,
-			               f.language = $language
-			 ON MATCH SET f.updated_at = datetime()
-			 WITH f
-			 MATCH (p:Project {path: $projectPath})
-			 MERGE (f)-[:BELONGS_TO]->(p)`,
-			map[string]interface{}{
-				"filePath":    filePath,
-				"fileName":    filepath.Base(filePath),
-				"language":    getLanguageFromExt(filepath.Ext(filePath)),
-				"projectPath": projectPath,
-			},
-		)
+		chunks, err = r.chunkFile(string(content), filePath, projectPath, language)
 		if err != nil {
-			return nil, err
+			return nil, "", fmt.Errorf("failed to chunk file: %w", err)
 		}
-		
-		// Store each chunk
-		for _, chunk := range chunks {
-			// Check if chunk exists with same hash (unchanged)
-			result, err := tx.Run(
-				"MATCH (c:Chunk {id: $id}) RETURN c.hash",
-				map[string]interface{}{"id": chunk.ID},
-			)
-			if err != nil {
-				return nil, err
-			}
-			
-			record, err := result.Single()
-			if err == nil { // Chunk exists
-				storedHash, _ := record.Get("c.hash")
-				if storedHash.(string) == chunk.Hash {
-					// Skip if hash is the same (content unchanged)
-					continue
-				}
-			}
-			
-			// Create/update chunk node with embedding
-			params := map[string]interface{}{
-				"id":          chunk.ID,
-				"content":     chunk.Content,
-				"filePath":    chunk.FilePath,
-				"startLine":   chunk.StartLine,
-				"endLine":     chunk.EndLine,
-				"entityType":  chunk.EntityType,
-				"name":        chunk.Name,
-				"signature":   chunk.Signature,
-				"language":    chunk.Language,
-				"hash":        chunk.Hash,
-				"embedding":   chunk.Embedding,
-				"projectPath": chunk.ProjectPath,
-				"updated_at":  time.Now().Format(time.RFC3339),
-			}
-			
-			_, err = tx.Run(
-				`MERGE (c:Chunk {id: $id})
-				 ON CREATE SET c.created_at = datetime()
-				 SET c.content = $content,
-				     c.file_path = $filePath,
-				     c.start_line = $startLine,
-				     c.end_line = $endLine,
-				     c.entity_type = $entityType,
-				     c.name = $name,
-				     c.signature = $signature,
-				     c.language = $language,
-				     c.hash = $hash,
-				     c.embedding = $embedding,
-				     c.updated_at = $updated_at
-				 WITH c
-				 MATCH (f:File {path: $filePath})
-				 MERGE (c)-[:PART_OF]->(f)`,
-				params,
-			)
-			if err != nil {
-				return nil, err
-			}
+	} else {
+		chunks, err = r.chunkFileStream(filePath, projectPath, language)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to chunk file: %w", err)
 		}
-		
-		return nil, nil
-	})
+	}
 	
-	return err
+	// Tag dependency code so searches can exclude it
+	if isVendoredPath(filePath) {
+		for i := range chunks {
+			chunks[i].IsVendored = true
+		}
+	}
+	
+	return chunks, projectPath, nil
 }
 
-// SearchCode searches for code using vector similarity
-func (r *Neo4jRAG) SearchCode(query string, limit int) ([]CodeChunk, error) {
-	// Generate embedding for query
-	fmt.Println("Generating embedding for query...")
-	embeddings, err := r.getEmbeddings([]string{query})
+// DryRunFile is the chunking result for one file in a dry run
+type DryRunFile struct {
+	Path         string
+	Language     string
+	Chunks       int
+	AvgChunkSize float64 // Average content length in characters
+}
+
+// DryRunReport summarizes what indexing a directory would produce
+type DryRunReport struct {
+	Files             int
+	FilesWithErrors   int
+	TotalChunks       int
+	EmbeddingRequests int // Requests to the embedding service, counting the ensemble model
+	ByLanguage        map[string]int64
+	LargestFiles      []DryRunFile // Files producing the most chunks, most first
+}
+
+// dryRunTopFiles is the number of files listed in DryRunReport.LargestFiles
+const dryRunTopFiles = 10
+
+// DryRunIndex finds and chunks the files IndexDirectory would index, without
+// generating embeddings or touching the database, and reports chunk counts
+// and the embedding requests the run would make
+func (r *Neo4jRAG) DryRunIndex(dir string) (*DryRunReport, error) {
+	files, err := r.findCodeFiles(dir)
 	if err != nil {
-		fmt.Printf("Error generating embedding: %v\n", err)
-		return nil, fmt.Errorf("failed to generate query embedding: %w", err)
+		return nil, fmt.Errorf("failed to find code files: %w", err)
 	}
 	
-	if len(embeddings) == 0 || len(embeddings[0]) == 0 {
-		fmt.Println("Received empty embedding for query")
-		return nil, fmt.Errorf("received empty embedding for query")
+	// Each file is embedded separately, in batches of embeddingBatchSize
+	requestsPerBatch := 1
+	if r.config.EnsembleEmbeddingURL != "" {
+		requestsPerBatch = 2
 	}
 	
-	fmt.Printf("Embedding generated successfully, length: %d\n", len(embeddings[0]))
-	queryEmbedding := embeddings[0]
-	
-	// Search Neo4j
-	fmt.Println("Searching Neo4j with similarity threshold > 0.1...")
-	session := r.driver.NewSession(neo4j.SessionConfig{})
-	defer session.Close()
-	
-	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
-		// First check if the database has chunks
-			fmt.Println("Checking database content...")
-			testResult, testErr := tx.Run(
-				`MATCH (c:Chunk) RETURN count(c) as count`,
-				map[string]interface{}{},
-			)
-			
-			if testErr != nil {
-				fmt.Printf("Database check failed: %v\n", testErr)
-				return nil, testErr
-			}
-			
-			var chunkCount int64 = 0
-			if testResult.Next() {
-				count, _ := testResult.Record().Get("count")
-				chunkCount = count.(int64)
-				fmt.Printf("Database contains %v chunks\n", chunkCount)
-				
-				// If count is 0, no data was indexed
-				if chunkCount == 0 {
-					fmt.Println("No chunks found in database. Please run indexing first.")
-					return []CodeChunk{}, nil
-				}
-			} else {
-				fmt.Println("Could not get chunk count from database")
-			}
-			
-			// Check if GDS library is installed and the vector index exists
-			fmt.Println("Checking GDS library status...")
-			gdsResult, gdsErr := tx.Run(
-				`CALL gds.list() YIELD name RETURN count(name) as count`,
-				map[string]interface{}{},
-			)
-			
-			if gdsErr != nil {
-				fmt.Printf("GDS library check failed: %v\n", gdsErr)
-				fmt.Println("The Graph Data Science library might not be installed or configured properly.")
-			} else if gdsResult.Next() {
-				gdsCount, _ := gdsResult.Record().Get("count")
-				fmt.Printf("GDS library has %v procedures available\n", gdsCount)
-			}
-			
-			// Now try the vector similarity search with a very low threshold
-			fmt.Println("Performing vector similarity search with threshold 0.1...")
-			result, err := tx.Run(
-				`MATCH (c:Chunk)
-				 WITH c, gds.similarity.cosine(c.embedding, $embedding) AS vectorScore
-				 
-				 // Apply basic similarity threshold
-				 WHERE vectorScore > 0.1
-				 
-				 // Calculate additional relevance factors
-				 WITH c, vectorScore,
-					  // Boost score for function/method chunks (more focused)
-					  CASE WHEN c.entity_type IN ['function', 'method'] THEN 0.1 ELSE 0 END AS entityBoost,
-					  
-					  // Boost score for shorter chunks (more precise)
-					  CASE WHEN size(c.content) < 500 THEN 0.05 ELSE 0 END AS sizeBoost,
-					  
-					  // Penalize very large chunks (too general)
-					  CASE WHEN size(c.content) > 2000 THEN -0.05 ELSE 0 END AS sizePenalty
-				 
-				 // Calculate final score with boosts
-				 WITH c, (vectorScore + entityBoost + sizeBoost + sizePenalty) AS score
-				 
-				 // Ensure minimum threshold even after adjustments
-				 WHERE score > 0.1
-				 
-				 // Return results
-				 RETURN c.id, c.content, c.file_path, c.start_line, c.end_line, 
-						c.entity_type, c.name, c.signature, c.language, score
-				 
-				 // Order by final score and limit results
-				 ORDER BY score DESC
-				 LIMIT $limit`,
-				map[string]interface{}{
-					"embedding": queryEmbedding,
-					"limit":     limit,
-				},
-			)
-		
+	report := &DryRunReport{
+		Files:      len(files),
+		ByLanguage: map[string]int64{},
+	}
+	var perFile []DryRunFile
+	for _, file := range files {
+		chunks, _, err := r.chunkSourceFile(file, r.rootFor(dir))
 		if err != nil {
-			return nil, err
+			report.FilesWithErrors++
+			r.logger.Errorf("failed to chunk file %s: %v\n", file, err)
+			continue
 		}
-		
-		chunks := []CodeChunk{}
-		for result.Next() {
-			record := result.Record()
-			
-			id, _ := record.Get("c.id")
-			content, _ := record.Get("c.content")
-			filePath, _ := record.Get("c.file_path")
-			startLine, _ := record.Get("c.start_line")
-			endLine, _ := record.Get("c.end_line")
-			entityType, _ := record.Get("c.entity_type")
-			name, _ := record.Get("c.name")
-			signature, _ := record.Get("c.signature")
-			language, _ := record.Get("c.language")
-			score, _ := record.Get("score")
-			
-			chunk := CodeChunk{
-				ID:         id.(string),
-				Content:    content.(string),
-				FilePath:   filePath.(string),
-				StartLine:  int(startLine.(int64)),
-				EndLine:    int(endLine.(int64)),
-				EntityType: entityType.(string),
-				Name:       name.(string),
-				Language:   language.(string),
-			}
-			
-			if signature != nil {
-				chunk.Signature = signature.(string)
-			}
-			
-			// Save the score in the chunk
-			chunk.Score = score.(float64)
-			
-			r.logger.Printf("Found chunk with score %f: %s\n", score.(float64), chunk.Name)
-			chunks = append(chunks, chunk)
+		if len(chunks) == 0 {
+			continue
 		}
 		
-		return chunks, nil
-	})
+		totalSize := 0
+		for _, chunk := range chunks {
+			totalSize += len(chunk.Content)
+		}
+		language := chunks[0].Language
+		report.ByLanguage[language] += int64(len(chunks))
+		report.TotalChunks += len(chunks)
+		report.EmbeddingRequests += (len(chunks) + embeddingBatchSize - 1) / embeddingBatchSize * requestsPerBatch
+		perFile = append(perFile, DryRunFile{
+			Path:         file,
+			Language:     language,
+			Chunks:       len(chunks),
+			AvgChunkSize: float64(totalSize) / float64(len(chunks)),
+		})
+	}
 	
-	if err != nil {
-		fmt.Printf("Neo4j search failed: %v\n", err)
-		return nil, fmt.Errorf("search failed: %w", err)
+	sort.Slice(perFile, func(i, j int) bool {
+		if perFile[i].Chunks != perFile[j].Chunks {
+			return perFile[i].Chunks > perFile[j].Chunks
+		}
+		return perFile[i].Path < perFile[j].Path
+	})
+	if len(perFile) > dryRunTopFiles {
+		perFile = perFile[:dryRunTopFiles]
 	}
+	report.LargestFiles = perFile
 	
-	chunks := result.([]CodeChunk)
-	fmt.Printf("Search complete. Found %d matching chunks\n", len(chunks))
-	return chunks, nil
+	return report, nil
 }
 
-// SearchCodeAdvanced searches for code with advanced filtering options
-func (r *Neo4jRAG) SearchCodeAdvanced(query string, limit int, languages []string, pathFilters []string, minScore float64, useKeywords bool) ([]CodeChunk, error) {
-	// Generate embedding for query
-	fmt.Println("Generating embedding for query...")
-	embeddings, err := r.getEmbeddings([]string{query})
-	if err != nil {
-		fmt.Printf("Error generating embedding: %v\n", err)
-		return nil, fmt.Errorf("failed to generate query embedding: %w", err)
-	}
+// watchDirectories runs rag.WatchDirectories on dirs until the process is
+// interrupted
+func watchDirectories(rag *Neo4jRAG, dirs []string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 	
-	if len(embeddings) == 0 || len(embeddings[0]) == 0 {
-		fmt.Println("Received empty embedding for query")
-		return nil, fmt.Errorf("received empty embedding for query")
+	fmt.Printf("Watching %s for changes (Ctrl+C to stop)\n", strings.Join(dirs, ", "))
+	if err := rag.WatchDirectories(ctx, dirs); err != nil {
+		log.Fatalf("Failed to watch directory: %v", err)
 	}
+}
+
+// printDryRunReport prints a dry run summary in the --stats layout
+func printDryRunReport(report *DryRunReport) {
+	fmt.Println("Dry run (nothing was embedded or stored)")
+	fmt.Println("=======================================")
+	fmt.Printf("%-22s %d\n", "Files:", report.Files)
+	fmt.Printf("%-22s %d\n", "Files with errors:", report.FilesWithErrors)
+	fmt.Printf("%-22s %d\n", "Chunks:", report.TotalChunks)
+	fmt.Printf("%-22s %d (batches of %d)\n", "Embedding requests:", report.EmbeddingRequests, embeddingBatchSize)
 	
-	fmt.Printf("Embedding generated successfully, length: %d\n", len(embeddings[0]))
-	queryEmbedding := embeddings[0]
-	
-	// Extract keywords for potential keyword search
-	keywords := extractKeywords(query)
+	printCountTable("Chunks by language", report.ByLanguage)
 	
-	// Search Neo4j
-	fmt.Printf("Searching Neo4j with similarity threshold > %.2f...\n", minScore)
-	session := r.driver.NewSession(neo4j.SessionConfig{})
-	defer session.Close()
+	fmt.Println("\nFiles with the most chunks:")
+	if len(report.LargestFiles) == 0 {
+		fmt.Println("  (none)")
+	}
+	for _, file := range report.LargestFiles {
+		fmt.Printf("  %-60s %8d chunks (avg %.0f chars)\n", file.Path, file.Chunks, file.AvgChunkSize)
+	}
+}
+
+// chunkPreviewLines is how many lines of each chunk --show-chunks prints
+const chunkPreviewLines = 5
+
+// printChunks prints the chunks of one file for --show-chunks, as indented
+// JSON or as a readable listing with a short preview of each chunk
+func printChunks(filePath string, chunks []CodeChunk, jsonOutput bool) error {
+	if jsonOutput {
+		output, err := json.MarshalIndent(chunks, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(output))
+		return nil
+	}
 	
-	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
-		// First check if the database has chunks
-		fmt.Println("Checking database content...")
-		testResult, testErr := tx.Run(
-			`MATCH (c:Chunk) RETURN count(c) as count`,
-			map[string]interface{}{},
-		)
-		
-		if testErr != nil {
-			fmt.Printf("Database check failed: %v\n", testErr)
-			return nil, testErr
+	fmt.Printf("%s: %d chunks\n", filePath, len(chunks))
+	if len(chunks) == 0 {
+		fmt.Println("(the file is empty, binary or over the size limit)")
+	}
+	for i, chunk := range chunks {
+		fmt.Printf("\n--- Chunk %d: %s", i+1, chunk.EntityType)
+		if chunk.Name != "" {
+			fmt.Printf(" %s", chunk.Name)
 		}
-		
-		var chunkCount int64 = 0
-		if testResult.Next() {
-			count, _ := testResult.Record().Get("count")
-			chunkCount = count.(int64)
-			fmt.Printf("Database contains %v chunks\n", chunkCount)
-			
-			// If count is 0, no data was indexed
-			if chunkCount == 0 {
-				fmt.Println("No chunks found in database. Please run indexing first.")
-				return []CodeChunk{}, nil
-			}
-		} else {
-			fmt.Println("Could not get chunk count from database")
+		fmt.Printf(" (lines %d-%d, %d chars) ---\n", chunk.StartLine, chunk.EndLine, len(chunk.Content))
+		if chunk.Signature != "" {
+			fmt.Printf("Signature: %s\n", chunk.Signature)
 		}
 		
-		// Build the Cypher query with filters
-		cypherQuery := `MATCH (c:Chunk)`
-		
-		// Add language filter if specified
-		if len(languages) > 0 {
-			cypherQuery += ` WHERE c.language IN $languages`
+		lines := strings.Split(strings.TrimRight(chunk.Content, "\n"), "\n")
+		shown := lines
+		if len(shown) > chunkPreviewLines {
+			shown = shown[:chunkPreviewLines]
 		}
-		
-		// Add path filter if specified
-		if len(pathFilters) > 0 {
-			if len(languages) > 0 {
-				cypherQuery += ` AND`
-			} else {
-				cypherQuery += ` WHERE`
-			}
-			
-			pathConditions := []string{}
-			for i := range pathFilters {
-				// Use pattern index for parameter name
-				pathConditions = append(pathConditions, fmt.Sprintf(`c.file_path =~ $pathPattern%d`, i))
-			}
-			cypherQuery += ` (` + strings.Join(pathConditions, ` OR `) + `)`
+		for j, line := range shown {
+			fmt.Printf("%d: %s\n", chunk.StartLine+j, line)
 		}
-		
-		// Add keyword search if enabled
-		if useKeywords && len(keywords) > 0 {
-			keywordCondition := ``
-			if strings.Contains(cypherQuery, `WHERE`) {
-				keywordCondition += ` AND (`
-			} else {
-				keywordCondition += ` WHERE (`
-			}
-			
-			keywordPatterns := []string{}
-			for i, keyword := range keywords {
-				if len(keyword) > 3 { // Only use keywords with more than 3 characters
-					keywordPatterns = append(keywordPatterns, 
-						fmt.Sprintf(`c.content CONTAINS $keyword%d`, i))
-				}
-			}
-			
-			if len(keywordPatterns) > 0 {
-				keywordCondition += strings.Join(keywordPatterns, ` OR `) + `)`
-				cypherQuery += keywordCondition
-			}
+		if len(lines) > len(shown) {
+			fmt.Printf("... (%d more lines)\n", len(lines)-len(shown))
 		}
-		
-		// Add vector similarity calculation and improved scoring
-		cypherQuery += `
-		WITH c, gds.similarity.cosine(c.embedding, $embedding) AS vectorScore
-		
-		// Apply basic similarity threshold
-		WHERE vectorScore > $minScore
-		
-		// Calculate additional relevance factors
-		WITH c, vectorScore,
-		     // Boost score for function/method chunks (more focused)
-		     CASE WHEN c.entity_type IN ['function', 'method'] THEN 0.1 ELSE 0 END AS entityBoost,
-		     
-		     // Boost score for shorter chunks (more precise)
-		     CASE WHEN size(c.content) < 500 THEN 0.05 ELSE 0 END AS sizeBoost,
-		     
-		     // Penalize very large chunks (too general)
-		     CASE WHEN size(c.content) > 2000 THEN -0.05 ELSE 0 END AS sizePenalty
+	}
+	return nil
+}
 
-		// Calculate final score with boosts
-		WITH c, (vectorScore + entityBoost + sizeBoost + sizePenalty) AS score
-		
-		// Ensure minimum threshold even after adjustments
-		WHERE score > $minScore
-		
-		// Return results
-		RETURN c.id, c.content, c.file_path, c.project_path, c.start_line, c.end_line, 
-		       c.entity_type, c.name, c.signature, c.language, score
-		
-		// Order by final score and limit results
-		ORDER BY score DESC
-		LIMIT $limit`
-		
-		// Prepare parameters
-		parameters := map[string]interface{}{
-			"embedding": queryEmbedding,
-			"minScore":  minScore,
-			"limit":     limit,
+// processFile chunks, embeds and stores a single code file
+func (r *Neo4jRAG) processFile(filePath string, root indexRoot) error {
+	chunks, projectPath, err := r.chunkSourceFile(filePath, root)
+	if err != nil {
+		return err
+	}
+	
+	// Skip if no chunks were created
+	if len(chunks) == 0 {
+		return nil
+	}
+	return r.indexChunks(chunks, filePath, projectPath)
+}
+
+// indexChunks embeds and stores the chunks of a file in projectPath
+func (r *Neo4jRAG) indexChunks(chunks []CodeChunk, filePath, projectPath string) error {
+	// With line-level incremental indexing only chunks touching lines changed
+	// since the last indexed commit are embedded again
+	var err error
+	var headCommit string
+	needEmbedding := chunks
+	var needIndexes []int
+	if r.config.LineIncremental {
+		headCommit = gitHead(filepath.Dir(filePath))
+		needIndexes, err = r.reuseUnchangedEmbeddings(chunks, filePath)
+		if err != nil {
+			return fmt.Errorf("failed to reuse embeddings: %w", err)
+		}
+		needEmbedding = make([]CodeChunk, len(needIndexes))
+		for k, i := range needIndexes {
+			needEmbedding[k] = chunks[i]
+		}
+	}
+	
+	// Bound the embed and store step so one hanging request cannot stall
+	// the whole run
+	ctx := context.Background()
+	if r.config.FileProcessTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.config.FileProcessTimeout)
+		defer cancel()
+	}
+	
+	// Generate embeddings for chunks, unless they are backfilled later
+	if !r.config.DeferEmbeddings {
+		err = r.generateEmbeddings(ctx, needEmbedding)
+		if err != nil {
+			return fmt.Errorf("failed to generate embeddings: %w", err)
+		}
+		for k, i := range needIndexes {
+			chunks[i] = needEmbedding[k]
+		}
+	}
+	
+	// Store chunks in Neo4j
+	err = r.storeChunks(ctx, chunks, filePath, projectPath)
+	if err != nil {
+		return fmt.Errorf("failed to store chunks: %w", err)
+	}
+	
+	// Remember the commit so the next run can diff against it
+	if headCommit != "" {
+		err = r.recordIndexedCommit(filePath, headCommit)
+		if err != nil {
+			return fmt.Errorf("failed to record indexed commit: %w", err)
+		}
+	}
+	
+	return nil
+}
+
+// lineRange is an inclusive, 1-based range of lines
+type lineRange struct {
+	start int
+	end   int
+}
+
+// hunkHeaderPattern matches unified diff hunk headers, capturing the start and
+// optional length of the new-file side
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// gitRevision is the checked-out state of a git repository
+type gitRevision struct {
+	commit string
+	branch string // Empty on a detached HEAD
+}
+
+// gitRevisionCache remembers the revision per directory for one indexing run
+// or watch scan; resetGitCache clears it. It is guarded by gitRevisionMu as
+// files may be indexed concurrently.
+var (
+	gitRevisionMu    sync.Mutex
+	gitRevisionCache = map[string]gitRevision{}
+)
+
+// resetGitCache forgets cached revisions so commits and branch switches made
+// since the last run are picked up
+func resetGitCache() {
+	gitRevisionMu.Lock()
+	defer gitRevisionMu.Unlock()
+	gitRevisionCache = map[string]gitRevision{}
+}
+
+// gitRevisionOf returns the HEAD commit and branch of the git repository
+// containing dir, or a zero gitRevision when dir is not in a git repository
+// or git is not available
+func gitRevisionOf(dir string) gitRevision {
+	gitRevisionMu.Lock()
+	rev, ok := gitRevisionCache[dir]
+	gitRevisionMu.Unlock()
+	if ok {
+		return rev
+	}
+	
+	output, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD", "--abbrev-ref", "HEAD").Output()
+	if err == nil {
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		rev.commit = strings.TrimSpace(lines[0])
+		if len(lines) > 1 && strings.TrimSpace(lines[1]) != "HEAD" {
+			rev.branch = strings.TrimSpace(lines[1])
+		}
+	}
+	gitRevisionMu.Lock()
+	gitRevisionCache[dir] = rev
+	gitRevisionMu.Unlock()
+	return rev
+}
+
+// gitHead returns the HEAD commit of the git repository containing dir, or ""
+// when dir is not in a git repository or git is not available
+func gitHead(dir string) string {
+	return gitRevisionOf(dir).commit
+}
+
+// changedLineRanges returns the line ranges of filePath that differ from its
+// content at sinceCommit, in current line numbers. A pure deletion is
+// reported as the two lines on either side of it.
+func changedLineRanges(filePath, sinceCommit string) ([]lineRange, error) {
+	output, err := exec.Command("git", "-C", filepath.Dir(filePath), "diff", "-U0", "--no-color", "--no-ext-diff",
+		sinceCommit, "--", filepath.Base(filePath)).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff failed: %w", err)
+	}
+	
+	ranges := []lineRange{}
+	for _, line := range strings.Split(string(output), "\n") {
+		match := hunkHeaderPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
 		}
 		
-		// Add language parameters if specified
-		if len(languages) > 0 {
-			parameters["languages"] = languages
+		start, _ := strconv.Atoi(match[1])
+		length := 1
+		if match[2] != "" {
+			length, _ = strconv.Atoi(match[2])
 		}
 		
-		// Add path filter parameters if specified
-		for i, pattern := range pathFilters {
-			parameters[fmt.Sprintf("pathPattern%d", i)] = globToRegex(pattern)
+		if length == 0 {
+			// Pure deletion after line start
+			ranges = append(ranges, lineRange{start: start, end: start + 1})
+		} else {
+			ranges = append(ranges, lineRange{start: start, end: start + length - 1})
+		}
+	}
+	
+	return ranges, nil
+}
+
+// reuseUnchangedEmbeddings copies stored embeddings onto chunks that lie
+// outside the lines changed since the file was last indexed, and returns the
+// indexes of the chunks that still need embeddings. Embeddings are only
+// reused from stored chunks of the same file with identical content, so a
+// stale or missing diff can cost extra embedding calls but never attach the
+// wrong vector. Without diff information every chunk is returned.
+func (r *Neo4jRAG) reuseUnchangedEmbeddings(chunks []CodeChunk, filePath string) ([]int, error) {
+	all := make([]int, len(chunks))
+	for i := range chunks {
+		all[i] = i
+	}
+	
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	result, err := session.Run(
+		`MATCH (f:File {path: $filePath}) RETURN f.indexed_commit AS commit`,
+		map[string]interface{}{"filePath": filePath},
+	)
+	if err != nil {
+		return nil, err
+	}
+	
+	sinceCommit := ""
+	if result.Next() {
+		commit, _ := result.Record().Get("commit")
+		sinceCommit, _ = commit.(string)
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+	if sinceCommit == "" {
+		return all, nil
+	}
+	
+	changed, err := changedLineRanges(filePath, sinceCommit)
+	if err != nil {
+		r.debugf("No diff for %s, processing the whole file: %v\n", filePath, err)
+		return all, nil
+	}
+	
+	// Look up stored vectors for the content of untouched chunks
+	untouched := []int{}
+	hashes := []string{}
+	for i, chunk := range chunks {
+		touched := false
+		for _, lines := range changed {
+			if chunk.StartLine <= lines.end && lines.start <= chunk.EndLine {
+				touched = true
+				break
+			}
+		}
+		if !touched {
+			untouched = append(untouched, i)
+			hashes = append(hashes, chunk.Hash)
+		}
+	}
+	
+	if len(untouched) == 0 {
+		return all, nil
+	}
+	
+	result, err = session.Run(
+		`MATCH (c:Chunk {file_path: $filePath})
+		 WHERE c.hash IN $hashes AND c.embedding IS NOT NULL
+		 RETURN c.hash AS hash, c.embedding AS embedding, c.embedding2 AS embedding2`,
+		map[string]interface{}{"filePath": filePath, "hashes": hashes},
+	)
+	if err != nil {
+		return nil, err
+	}
+	
+	stored := map[string][2][]float32{}
+	for result.Next() {
+		record := result.Record()
+		hash, _ := record.Get("hash")
+		embedding, _ := record.Get("embedding")
+		embedding2, _ := record.Get("embedding2")
+		hashStr, _ := hash.(string)
+		stored[hashStr] = [2][]float32{toFloat32Slice(embedding), toFloat32Slice(embedding2)}
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+	
+	reused := map[int]bool{}
+	for _, i := range untouched {
+		vectors, ok := stored[chunks[i].Hash]
+		// Ensemble mode needs both vectors to skip embedding
+		if !ok || len(vectors[0]) == 0 || (r.config.EnsembleEmbeddingURL != "" && len(vectors[1]) == 0) {
+			continue
+		}
+		chunks[i].Embedding = vectors[0]
+		chunks[i].Embedding2 = vectors[1]
+		reused[i] = true
+	}
+	
+	needIndexes := []int{}
+	for i := range chunks {
+		if !reused[i] {
+			needIndexes = append(needIndexes, i)
+		}
+	}
+	
+	r.logger.Printf("Line-incremental: %s has %d changed range(s); reusing %d of %d embeddings\n",
+		filePath, len(changed), len(reused), len(chunks))
+	return needIndexes, nil
+}
+
+// recordIndexedCommit stores the git commit a file was indexed at
+func (r *Neo4jRAG) recordIndexedCommit(filePath, commit string) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	_, err := session.Run(
+		`MATCH (f:File {path: $filePath}) SET f.indexed_commit = $commit`,
+		map[string]interface{}{"filePath": filePath, "commit": commit},
+	)
+	return err
+}
+
+// chunkFile splits a file into chunks
+func (r *Neo4jRAG) chunkFile(content, filePath, projectPath, language string) ([]CodeChunk, error) {
+	var chunks []CodeChunk
+	
+	// Split Go, JavaScript, TypeScript and C-family files by declaration
+	switch {
+	case language == "Go":
+		chunks = r.chunkGoCode(content, filePath, projectPath)
+	case isJSLanguage(language):
+		chunks = r.chunkJSCode(content, filePath, projectPath, language)
+	case isCFamilyLanguage(language):
+		chunks = r.chunkCFamilyCode(content, filePath, projectPath, language)
+	}
+	
+	// For other languages or if function chunking produced too few chunks
+	if len(chunks) < 2 {
+		chunks = r.chunkBySize(content, filePath, projectPath, language)
+	}
+	
+	// Record the symbols Go chunks reference for the symbol graph
+	if language == "Go" {
+		annotateGoReferences(chunks, content)
+	}
+	
+	// The chunkers work on normalized line endings, so offsets are taken
+	// from the file as read
+	setByteOffsets(chunks, content)
+	
+	assignChunkIDs(chunks, filePath)
+	
+	return chunks, nil
+}
+
+// chunkFileStream chunks a file by size while reading it line by line, so
+// large files are never held in memory as a whole
+func (r *Neo4jRAG) chunkFileStream(filePath, projectPath, language string) ([]CodeChunk, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer file.Close()
+	
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	
+	chunks := []CodeChunk{}
+	err = r.chunkReaderBySize(file, info.Size(), filePath, projectPath, language, func(chunk CodeChunk) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	
+	assignChunkIDs(chunks, filePath)
+	
+	return chunks, nil
+}
+
+// goBuiltins are Go's predeclared functions and types, which are called but
+// never defined in indexed code
+var goBuiltins = map[string]bool{
+	"append": true, "cap": true, "clear": true, "close": true, "complex": true, "copy": true,
+	"delete": true, "imag": true, "len": true, "make": true, "max": true, "min": true,
+	"new": true, "panic": true, "print": true, "println": true, "real": true, "recover": true,
+	"any": true, "bool": true, "byte": true, "complex64": true, "complex128": true, "error": true,
+	"float32": true, "float64": true, "int": true, "int8": true, "int16": true, "int32": true,
+	"int64": true, "rune": true, "string": true, "uint": true, "uint8": true, "uint16": true,
+	"uint32": true, "uint64": true, "uintptr": true,
+}
+
+// annotateGoReferences sets Calls and Imports on chunks of the Go source in
+// content
+func annotateGoReferences(chunks []CodeChunk, content string) {
+	imports := goImports(content)
+	for i := range chunks {
+		chunks[i].Calls, chunks[i].Imports = goReferences(chunks[i].Content, imports)
+	}
+}
+
+// goImports returns a Go file's import paths keyed by the name code refers to
+// them by. Blank and dot imports are left out since code never names them.
+func goImports(content string) map[string]string {
+	file, err := parser.ParseFile(token.NewFileSet(), "", content, parser.ImportsOnly)
+	if err != nil {
+		return map[string]string{}
+	}
+	
+	imports := map[string]string{}
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := importPath[strings.LastIndex(importPath, "/")+1:]
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if name != "_" && name != "." {
+			imports[name] = importPath
+		}
+	}
+	return imports
+}
+
+// goReferences scans a fragment of Go source for the functions it calls and
+// the imported packages it uses, each sorted and deduplicated. A call is an
+// identifier followed by "(", so for pkg.Func() and x.Method() the selected
+// name is recorded; declarations, builtins and conversions to builtin types
+// are skipped. A package is used when its name is followed by ".".
+func goReferences(src string, imports map[string]string) ([]string, []string) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, []byte(src), nil, 0) // Chunks are fragments, so scan errors are ignored
+	
+	calls := map[string]bool{}
+	packages := map[string]bool{}
+	var prev, beforePrev token.Token
+	var prevLit string
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
 		}
 		
-		// Add keyword parameters if enabled
-		if useKeywords && len(keywords) > 0 {
-			for i, keyword := range keywords {
-				if len(keyword) > 3 {
-					parameters[fmt.Sprintf("keyword%d", i)] = keyword
+		if prev == token.IDENT {
+			switch tok {
+			case token.LPAREN:
+				// "func Name(" and "func (r *T) Name(" declare rather than call
+				if beforePrev != token.FUNC && beforePrev != token.RPAREN && !goBuiltins[prevLit] {
+					calls[prevLit] = true
+				}
+			case token.PERIOD:
+				if importPath, ok := imports[prevLit]; ok && beforePrev != token.PERIOD {
+					packages[importPath] = true
 				}
 			}
 		}
 		
-		// Execute the query
-		result, err := tx.Run(cypherQuery, parameters)
+		beforePrev, prev, prevLit = prev, tok, lit
+	}
+	
+	return sortedKeys(calls), sortedKeys(packages)
+}
+
+// sortedKeys returns the keys of set in sorted order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// assignChunkIDs generates IDs and content hashes for chunks
+func assignChunkIDs(chunks []CodeChunk, filePath string) {
+	for i := range chunks {
+		// Generate a deterministic ID based on file path and chunk position
+		idStr := fmt.Sprintf("%s:%d:%d", filePath, chunks[i].StartLine, chunks[i].EndLine)
+		h := md5.Sum([]byte(idStr))
+		chunks[i].ID = hex.EncodeToString(h[:])
 		
-		if err != nil {
-			return nil, err
+		// Generate content hash for change detection
+		chunks[i].Hash = contentHash(chunks[i].Content)
+	}
+}
+
+// contentHash returns the hash stored with a chunk of the given content
+func contentHash(content string) string {
+	h := md5.Sum([]byte(content))
+	return hex.EncodeToString(h[:])
+}
+
+// declaration is a function, method or class found by a declaration
+// chunker, spanning whole lines
+type declaration struct {
+	startLine  int // 1-based, inclusive
+	endLine    int
+	entityType string
+	name       string
+	signature  string
+}
+
+// chunkDeclarations turns declarations, sorted and not overlapping, into
+// chunks of the given lines. Comment lines directly above a declaration
+// are kept with it, blank lines at either end are dropped, and the code
+// between declarations is chunked by size. When chunks are sized in tokens,
+// declarations over MaxChunkSize are chunked by size too, each part keeping
+// the declaration's name, so no chunk exceeds the embedding model's input
+// limit.
+func (r *Neo4jRAG) chunkDeclarations(lines []string, declarations []declaration, filePath, projectPath, language string) []CodeChunk {
+	chunks := []CodeChunk{}
+	nextLine := 1
+	
+	// trim narrows a line range to its first and last non-blank lines
+	trim := func(startLine, endLine int) (int, int) {
+		for startLine <= endLine && strings.TrimSpace(lines[startLine-1]) == "" {
+			startLine++
+		}
+		for endLine >= startLine && strings.TrimSpace(lines[endLine-1]) == "" {
+			endLine--
+		}
+		return startLine, endLine
+	}
+	
+	// Declarations are only split when sized in tokens. declSize measures
+	// their lines like the size chunker does.
+	splitDecls := r.config.ChunkUnit == chunkUnitTokens
+	lineSize := r.chunkLineSize()
+	declSize := func(declLines []string) int {
+		size := 0
+		for _, line := range declLines {
+			size += lineSize(line)
+		}
+		return size
+	}
+	
+	// addGap chunks the lines before a declaration by size. Lines holding
+	// nothing but closing brackets, like the end of a class, are skipped.
+	addGap := func(startLine, endLine int) {
+		startLine, endLine = trim(startLine, endLine)
+		if endLine < startLine || strings.Trim(strings.Join(lines[startLine-1:endLine], ""), " \t});") == "" {
+			return
+		}
+		for _, chunk := range r.chunkBySize(strings.Join(lines[startLine-1:endLine], "\n"), filePath, projectPath, language) {
+			chunk.StartLine += startLine - 1
+			chunk.EndLine += startLine - 1
+			chunk.Name = fmt.Sprintf("chunk_%d_%d", chunk.StartLine, chunk.EndLine)
+			chunks = append(chunks, chunk)
 		}
+	}
+	
+	for _, decl := range declarations {
+		startLine := attachLeadingComments(lines, decl.startLine, nextLine)
+		addGap(nextLine, startLine-1)
+		nextLine = decl.endLine + 1
+		
+		startLine, endLine := trim(startLine, decl.endLine)
+		if endLine < startLine {
+			continue
+		}
+		content := strings.Join(lines[startLine-1:endLine], "\n")
+		if !splitDecls || declSize(lines[startLine-1:endLine]) <= r.config.MaxChunkSize {
+			chunks = append(chunks, CodeChunk{
+				FilePath:    filePath,
+				ProjectPath: projectPath,
+				Content:     content,
+				StartLine:   startLine,
+				EndLine:     endLine,
+				EntityType:  decl.entityType,
+				Name:        decl.name,
+				Signature:   decl.signature,
+				Language:    language,
+			})
+			continue
+		}
+		for _, chunk := range r.chunkBySize(content, filePath, projectPath, language) {
+			chunk.StartLine += startLine - 1
+			chunk.EndLine += startLine - 1
+			chunk.EntityType = decl.entityType
+			chunk.Name = decl.name
+			chunk.Signature = decl.signature
+			chunks = append(chunks, chunk)
+		}
+	}
+	addGap(nextLine, len(lines))
+	
+	return chunks
+}
+
+// attachLeadingComments moves startLine up over the comment and decorator
+// lines directly above it, but not above minLine
+func attachLeadingComments(lines []string, startLine, minLine int) int {
+	for startLine > minLine {
+		above := strings.TrimSpace(lines[startLine-2])
+		if !strings.HasPrefix(above, "//") && !strings.HasPrefix(above, "/*") &&
+			!strings.HasPrefix(above, "*") && !strings.HasPrefix(above, "@") {
+			break
+		}
+		startLine--
+	}
+	return startLine
+}
+
+// lineByteRanges returns the byte offsets at which each line of content
+// starts and ends, the end being before its line ending. Lines are split like
+// scanLinesExact splits them.
+func lineByteRanges(content string) (starts, ends []int) {
+	start := 0
+	for i := 0; i < len(content); i++ {
+		if content[i] != '\n' && content[i] != '\r' {
+			continue
+		}
+		starts = append(starts, start)
+		ends = append(ends, i)
+		if content[i] == '\r' && i+1 < len(content) && content[i+1] == '\n' {
+			i++
+		}
+		start = i + 1
+	}
+	starts = append(starts, start)
+	ends = append(ends, len(content))
+	return starts, ends
+}
+
+// setByteOffsets sets StartByte and EndByte on chunks of content from their
+// line numbers
+func setByteOffsets(chunks []CodeChunk, content string) {
+	starts, ends := lineByteRanges(content)
+	for i := range chunks {
+		if chunks[i].StartLine < 1 || chunks[i].EndLine > len(ends) {
+			continue
+		}
+		chunks[i].StartByte = starts[chunks[i].StartLine-1]
+		chunks[i].EndByte = ends[chunks[i].EndLine-1]
+	}
+}
+
+// lineIndex returns a function mapping a byte offset into the text split
+// into lines to its 1-based line number
+func lineIndex(lines []string) func(pos int) int {
+	lineStarts := make([]int, len(lines))
+	pos := 0
+	for i, line := range lines {
+		lineStarts[i] = pos
+		pos += len(line) + 1 // +1 for newline
+	}
+	return func(pos int) int {
+		return sort.Search(len(lineStarts), func(i int) bool { return lineStarts[i] > pos })
+	}
+}
+
+// findMatchingBrace returns the index of the '}' closing the '{' at
+// openIndex in C-family source like Go, Java or C, or -1 if it is never
+// closed. Braces in double-quoted strings, single-quoted runes and
+// characters, backquoted raw strings and comments are ignored. JavaScript
+// needs regular expression and template literal handling on top and uses
+// scanJS instead.
+func findMatchingBrace(content string, openIndex int) int {
+	depth := 0
+	for i := openIndex; i < len(content); i++ {
+		switch c := content[i]; {
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		case c == '"' || c == '\'':
+			i = skipQuotedString(content, i) - 1
+		case c == '`':
+			end := strings.IndexByte(content[i+1:], '`')
+			if end < 0 {
+				return -1
+			}
+			i += end + 1
+		case c == '/' && i+1 < len(content) && content[i+1] == '/':
+			end := strings.IndexByte(content[i:], '\n')
+			if end < 0 {
+				return -1
+			}
+			i += end
+		case c == '/' && i+1 < len(content) && content[i+1] == '*':
+			end := strings.Index(content[i+2:], "*/")
+			if end < 0 {
+				return -1
+			}
+			i += end + 3
+		}
+	}
+	return -1
+}
+
+// chunkGoCode splits Go code into a chunk per function and method, from its
+// doc comment to its closing brace as found by findMatchingBrace. Code
+// between them, like the package clause, imports and type declarations, is
+// chunked by size.
+func (r *Neo4jRAG) chunkGoCode(content, filePath, projectPath string) []CodeChunk {
+	// Line positions below assume "\n" separators
+	content = normalizeLineEndings(content)
+	
+	// Regex patterns for Go functions. Type parameters, on functions and
+	// on method receivers, are skipped, and any result list up to the
+	// opening brace is accepted: (int, error), []T, *Node. Methods capture
+	// their receiver type so they can be named like (*T).Method.
+	funcPattern := regexp.MustCompile(`func\s+(\w+)\s*(?:\[[^\]]*\])?\s*\(([^)]*)\)[^{\n]*{`)
+	methodPattern := regexp.MustCompile(`func\s+\((?:\w+\s+)?(\*?\w+)(?:\[[^\]]*\])?\)\s+(\w+)\s*\(([^)]*)\)[^{\n]*{`)
+	
+	// Find all functions
+	funcMatches := funcPattern.FindAllStringSubmatchIndex(content, -1)
+	methodMatches := methodPattern.FindAllStringSubmatchIndex(content, -1)
+	
+	// Combine and sort all matches by their start position
+	type match struct {
+		start    int
+		end      int
+		name     string
+		sig      string
+		isMethod bool
+	}
+	
+	allMatches := []match{}
+	
+	// Process function matches
+	for _, m := range funcMatches {
+		if len(m) >= 4 {
+			funcName := content[m[2]:m[3]]
+			signature := ""
+			if len(m) >= 6 {
+				signature = content[m[4]:m[5]]
+			}
+			allMatches = append(allMatches, match{
+				start:    m[0],
+				end:      m[1],
+				name:     funcName,
+				sig:      signature,
+				isMethod: false,
+			})
+		}
+	}
+	
+	// Process method matches, qualifying the name with the receiver type
+	// the way method expressions do: (*T).Method or T.Method
+	for _, m := range methodMatches {
+		if len(m) >= 6 {
+			receiver := content[m[2]:m[3]]
+			if strings.HasPrefix(receiver, "*") {
+				receiver = "(" + receiver + ")"
+			}
+			methodName := receiver + "." + content[m[4]:m[5]]
+			signature := ""
+			if len(m) >= 8 {
+				signature = content[m[6]:m[7]]
+			}
+			allMatches = append(allMatches, match{
+				start:    m[0],
+				end:      m[1],
+				name:     methodName,
+				sig:      signature,
+				isMethod: true,
+			})
+		}
+	}
+	
+	// Sort by start position
+	sort.Slice(allMatches, func(i, j int) bool {
+		return allMatches[i].start < allMatches[j].start
+	})
+	
+	lines := strings.Split(content, "\n")
+	lineOf := lineIndex(lines)
+	
+	declarations := []declaration{}
+	end := -1 // Byte offset of the last function's closing brace
+	for i, m := range allMatches {
+		// Skip matches in the strings and comments of the previous function
+		if m.start <= end {
+			continue
+		}
+		
+		// The body opens at the brace ending the match, unless that brace
+		// belongs to an interface{} or struct{} in the result type
+		open := m.end - 1
+		for open+1 < len(content) && content[open+1] == '}' {
+			next := strings.IndexAny(content[open+2:], "{\n")
+			if next < 0 || content[open+2+next] != '{' {
+				break
+			}
+			open += 2 + next
+		}
+		
+		// Unbalanced braces end the function where the next one starts
+		end = findMatchingBrace(content, open)
+		if end < 0 {
+			end = len(content) - 1
+			if i < len(allMatches)-1 {
+				end = allMatches[i+1].start - 1
+			}
+		}
+		
+		entityType := "function"
+		if m.isMethod {
+			entityType = "method"
+		}
+		declarations = append(declarations, declaration{
+			startLine:  lineOf(m.start),
+			endLine:    lineOf(end),
+			entityType: entityType,
+			name:       m.name,
+			signature:  m.sig,
+		})
+	}
+	
+	return r.chunkDeclarations(lines, declarations, filePath, projectPath, "Go")
+}
+
+// isCFamilyLanguage reports whether language is chunked by chunkCFamilyCode
+func isCFamilyLanguage(language string) bool {
+	switch language {
+	case "C", "C++", "C/C++ Header", "C++ Header", "Java":
+		return true
+	}
+	return false
+}
+
+// C, C++ and Java definition heads, anchored at the start of a line so
+// statements and expressions are not taken for them. cFunctionPattern
+// captures the possibly qualified name (Foo::bar, Foo::~Foo) and stops at
+// the opening parenthesis; modifiers, return types and a template<...>
+// line may come before it. cClassPattern captures the kind and name of a
+// class, struct, union, interface, enum or record, up to its brace, and
+// cAnonymousClassPattern finds Java anonymous classes up to theirs.
+// cReturnTypeLine matches a return type on a line of its own, as in GNU
+// style C.
+var (
+	cFunctionPattern       = regexp.MustCompile(`(?m)^[ \t]*(?:template[ \t]*<[^>\n]*>\s*)?(?:[\w$<>,.\[\]*&:~@?]+[ \t*&]+)*?(~?[A-Za-z_$][\w$]*(?:::~?[A-Za-z_$][\w$]*)*)[ \t]*\(`)
+	cClassPattern          = regexp.MustCompile(`(?m)^[ \t]*(?:template[ \t]*<[^>\n]*>\s*)?(?:[\w@]+[ \t]+)*?(class|struct|union|interface|enum|record)[ \t]+([A-Za-z_$][\w$]*)[^;{=()]*(?:\([^)]*\)[^;{=()]*)?\{`)
+	cAnonymousClassPattern = regexp.MustCompile(`\bnew[ \t]+[\w$.<>,\[\] \t]+\([^;{}]*\)\s*\{`)
+	cReturnTypeLine        = regexp.MustCompile(`^[ \t]*[A-Za-z_][\w \t*&:<>,]*[\w*&>][ \t]*$`)
+)
+
+// cControlKeywords are words cFunctionPattern can mistake for function names
+var cControlKeywords = map[string]bool{
+	"if": true, "for": true, "while": true, "switch": true, "catch": true,
+	"return": true, "sizeof": true, "synchronized": true, "else": true,
+	"do": true, "try": true, "new": true, "delete": true, "throw": true,
+	"case": true, "using": true, "static_assert": true, "decltype": true,
+}
+
+// cRange is a function or class definition found by chunkCFamilyCode, by
+// byte offsets of its first byte, its opening brace and its closing brace
+type cRange struct {
+	start, open, close int
+	name, signature    string
+}
+
+// chunkCFamilyCode splits C, C++ and Java code into a chunk per function
+// or method definition and per class head: the class line, fields and
+// comments up to its first method or nested class, or the whole class when
+// it has neither. Declarations without a body, like prototypes and
+// abstract methods, are left to the size chunks covering the code between
+// definitions. Bodies end at the brace found by findMatchingBrace. Methods
+// defined inside a class are named after it, as Class.method in Java and
+// Class::method in C++.
+func (r *Neo4jRAG) chunkCFamilyCode(content, filePath, projectPath, language string) []CodeChunk {
+	content = normalizeLineEndings(content)
+	lines := strings.Split(content, "\n")
+	lineOf := lineIndex(lines)
+	
+	// inComment reports whether a match starts on a comment line
+	inComment := func(pos int) bool {
+		line := strings.TrimSpace(lines[lineOf(pos)-1])
+		return strings.HasPrefix(line, "//") || strings.HasPrefix(line, "/*") || strings.HasPrefix(line, "*")
+	}
+	
+	classes := []cRange{}
+	for _, m := range cClassPattern.FindAllStringSubmatchIndex(content, -1) {
+		if inComment(m[0]) {
+			continue
+		}
+		open := m[1] - 1
+		close := findMatchingBrace(content, open)
+		if close < 0 {
+			continue
+		}
+		classes = append(classes, cRange{start: m[0], open: open, close: close, name: content[m[4]:m[5]]})
+	}
+	
+	// Methods of anonymous classes stay part of the expression creating them
+	anonymous := []cRange{}
+	for _, m := range cAnonymousClassPattern.FindAllStringIndex(content, -1) {
+		if close := findMatchingBrace(content, m[1]-1); close >= 0 {
+			anonymous = append(anonymous, cRange{start: m[0], open: m[1] - 1, close: close})
+		}
+	}
+	inAnonymous := func(pos int) bool {
+		for _, class := range anonymous {
+			if class.open < pos && pos < class.close {
+				return true
+			}
+		}
+		return false
+	}
+	
+	functions := []cRange{}
+	end := -1 // Closing brace of the last function; nested matches are skipped
+	for _, m := range cFunctionPattern.FindAllStringSubmatchIndex(content, -1) {
+		name := content[m[2]:m[3]]
+		if m[0] <= end || cControlKeywords[name] || inComment(m[0]) || inAnonymous(m[0]) {
+			continue
+		}
+		paramsEnd := matchingParen(content, m[1]-1)
+		if paramsEnd < 0 {
+			continue
+		}
+		open := cBodyStart(content, paramsEnd+1)
+		if open < 0 {
+			continue
+		}
+		close := findMatchingBrace(content, open)
+		if close < 0 {
+			continue
+		}
+		functions = append(functions, cRange{
+			start:     m[0],
+			open:      open,
+			close:     close,
+			name:      name,
+			signature: strings.Join(strings.Fields(content[m[1]:paramsEnd]), " "),
+		})
+		end = close
+	}
+	
+	// innermost returns the innermost class whose body holds pos
+	innermost := func(pos int) *cRange {
+		var found *cRange
+		for i := range classes {
+			class := &classes[i]
+			if class.open < pos && pos < class.close && (found == nil || class.open > found.open) {
+				found = class
+			}
+		}
+		return found
+	}
+	
+	separator := "::"
+	if language == "Java" {
+		separator = "."
+	}
+	
+	declarations := []declaration{}
+	for _, function := range functions {
+		entityType, name := "function", function.name
+		if class := innermost(function.start); class != nil {
+			entityType, name = "method", class.name+separator+function.name
+		} else if strings.Contains(function.name, "::") {
+			entityType = "method"
+		}
+		startLine := lineOf(function.start)
+		if startLine > 1 && cReturnTypeLine.MatchString(lines[startLine-2]) {
+			startLine--
+		}
+		declarations = append(declarations, declaration{
+			startLine:  startLine,
+			endLine:    lineOf(function.close),
+			entityType: entityType,
+			name:       name,
+			signature:  function.signature,
+		})
+	}
+	
+	for _, class := range classes {
+		// Local and anonymous classes stay part of their function
+		inFunction := false
+		for _, function := range functions {
+			if function.open < class.start && class.start < function.close {
+				inFunction = true
+				break
+			}
+		}
+		if inFunction {
+			continue
+		}
+		
+		// The head ends before the first method or nested class, and
+		// before the comments and annotations above it
+		startLine, endLine := lineOf(class.start), lineOf(class.close)
+		for _, member := range append(append([]cRange{}, functions...), classes...) {
+			if class.open < member.start && member.start < class.close {
+				memberLine := attachLeadingComments(lines, lineOf(member.start), startLine+1)
+				if memberLine-1 < endLine {
+					endLine = memberLine - 1
+				}
+			}
+		}
+		if endLine < startLine {
+			continue
+		}
+		declarations = append(declarations, declaration{
+			startLine:  startLine,
+			endLine:    endLine,
+			entityType: "class",
+			name:       class.name,
+		})
+	}
+	
+	sort.Slice(declarations, func(i, j int) bool { return declarations[i].startLine < declarations[j].startLine })
+	
+	// Drop declarations sharing lines with an earlier one, like a class
+	// and a method defined on its first line
+	kept := declarations[:0]
+	lastLine := 0
+	for _, decl := range declarations {
+		if decl.startLine > lastLine {
+			kept = append(kept, decl)
+			lastLine = decl.endLine
+		}
+	}
+	
+	return r.chunkDeclarations(lines, kept, filePath, projectPath, language)
+}
+
+// matchingParen returns the index of the ')' closing the '(' at openIndex,
+// skipping string and character literals, or -1 if it is never closed
+func matchingParen(content string, openIndex int) int {
+	depth := 0
+	for i := openIndex; i < len(content); i++ {
+		switch content[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		case '"', '\'':
+			i = skipQuotedString(content, i) - 1
+		case ';', '{', '}':
+			return -1
+		}
+	}
+	return -1
+}
+
+// cBodyStart returns the index of the '{' opening the body of a function
+// whose parameter list ends just before from, or -1 if the function is only
+// declared. Between the two may come qualifiers (const, noexcept,
+// override), a throws clause, a trailing return type or a constructor
+// initializer list, on the same line or across a line break.
+func cBodyStart(content string, from int) int {
+	for i := from; i < len(content); i++ {
+		switch c := content[i]; {
+		case c == '{':
+			return i
+		case c == '(':
+			end := matchingParen(content, i)
+			if end < 0 {
+				return -1
+			}
+			i = end
+		case c == '/' && i+1 < len(content) && content[i+1] == '/':
+			end := strings.IndexByte(content[i:], '\n')
+			if end < 0 {
+				return -1
+			}
+			i += end
+		case c == ';' || c == '=' || c == '}' || c == '"' || c == '#':
+			return -1
+		}
+	}
+	return -1
+}
+
+// normalizeLineEndings converts "\r\n" and lone "\r" line endings to "\n",
+// matching how scanLinesExact splits lines
+func normalizeLineEndings(content string) string {
+	if !strings.Contains(content, "\r") {
+		return content
+	}
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	return strings.ReplaceAll(content, "\r", "\n")
+}
+
+// isJSLanguage reports whether language is chunked by chunkJSCode
+func isJSLanguage(language string) bool {
+	return language == "JavaScript" || language == "TypeScript"
+}
+
+// JavaScript and TypeScript declaration heads. Heads stop at the opening
+// parenthesis of the parameters, or after the arrow of a single-parameter
+// arrow function, so chunkJSCode can find the arrows and bodies by bracket
+// matching. Type parameters and variable type annotations are skipped.
+var (
+	jsFunctionPattern   = regexp.MustCompile(`(?m)^[ \t]*(?:export[ \t]+(?:default[ \t]+)?)?(?:async[ \t]+)?function\b[ \t]*\*?[ \t]*([A-Za-z_$][\w$]*)?[ \t]*(?:<.*?>)?[ \t]*\(`)
+	jsClassPattern      = regexp.MustCompile(`(?m)^[ \t]*(?:export[ \t]+(?:default[ \t]+)?)?(?:abstract[ \t]+)?class\b[ \t]*([A-Za-z_$][\w$]*)?`)
+	jsArrowPattern      = regexp.MustCompile(`(?m)^[ \t]*(?:export[ \t]+)?(?:const|let|var)[ \t]+([A-Za-z_$][\w$]*)[ \t]*(?::[^=\n]+)?=[ \t]*(?:async\b[ \t]*)?(?:(function\b)[ \t]*\*?[ \t]*(?:[A-Za-z_$][\w$]*)?[ \t]*(?:<.*?>)?[ \t]*\(|(?:<.*?>)?[ \t]*\(|([A-Za-z_$][\w$]*)[ \t]*=>)`)
+	jsMethodPattern     = regexp.MustCompile(`(?m)^[ \t]*(?:(?:public|private|protected|static|async|readonly|override|abstract|get|set)[ \t]+)*\*?[ \t]*(#?[A-Za-z_$][\w$]*)[ \t]*\??[ \t]*(?:<.*?>)?[ \t]*\(`)
+	jsFieldArrowPattern = regexp.MustCompile(`(?m)^[ \t]*(?:(?:public|private|protected|static|readonly)[ \t]+)*(#?[A-Za-z_$][\w$]*)[ \t]*(?::[^=\n]+)?=[ \t]*(?:async\b[ \t]*)?(?:(?:<.*?>)?[ \t]*\(|([A-Za-z_$][\w$]*)[ \t]*=>)`)
+)
+
+// jsControlKeywords are words jsMethodPattern can mistake for method names
+var jsControlKeywords = map[string]bool{
+	"if": true, "for": true, "while": true, "switch": true, "catch": true,
+	"function": true, "return": true, "with": true, "super": true,
+}
+
+// chunkJSCode splits JavaScript and TypeScript code into one chunk per
+// top-level function, arrow function assignment and class. Classes with
+// methods are split further into a chunk for the class head and fields and
+// one per method. Bodies are found by matching braces outside strings,
+// template literals, comments and regular expressions, and comment and
+// decorator lines directly above a declaration are kept with it. Code
+// between declarations, like imports, is chunked by size.
+func (r *Neo4jRAG) chunkJSCode(content, filePath, projectPath, language string) []CodeChunk {
+	content = normalizeLineEndings(content)
+	layout := scanJS(content)
+	lines := strings.Split(content, "\n")
+	lineOf := lineIndex(lines)
+	
+	declarations := []declaration{}
+	end := -1 // Byte offset where the last declaration ends
+	for _, head := range findJSHeads(content, layout, 0, len(content), 0, false) {
+		if head.start <= end {
+			continue
+		}
+		decl := declaration{
+			startLine:  lineOf(head.start),
+			endLine:    lineOf(head.end),
+			entityType: head.entityType,
+			name:       head.name,
+			signature:  head.signature,
+		}
+		end = head.end
+		
+		if head.entityType != "class" || head.body < 0 {
+			declarations = append(declarations, decl)
+			continue
+		}
+		
+		// Split the class at its methods, each method taking the fields
+		// and comments above it and the last one the closing brace
+		methods := findJSHeads(content, layout, head.body+1, head.end, layout.depth[head.body]+1, true)
+		if len(methods) == 0 || lineOf(methods[0].start) <= decl.startLine {
+			declarations = append(declarations, decl)
+			continue
+		}
+		members := []declaration{}
+		for _, method := range methods {
+			members = append(members, declaration{
+				startLine:  lineOf(method.start),
+				endLine:    lineOf(method.end),
+				entityType: "method",
+				name:       method.name,
+				signature:  method.signature,
+			})
+		}
+		members[0].startLine = attachLeadingComments(lines, members[0].startLine, decl.startLine+1)
+		decl.endLine = members[0].startLine - 1
+		declarations = append(declarations, decl)
+		for i := range members {
+			if i > 0 {
+				members[i].startLine = members[i-1].endLine + 1
+			}
+			if i == len(members)-1 {
+				members[i].endLine = lineOf(head.end)
+			}
+			declarations = append(declarations, members[i])
+		}
+	}
+	
+	return r.chunkDeclarations(lines, declarations, filePath, projectPath, language)
+}
+
+// jsHead is a declaration head found by findJSHeads, with the byte offsets
+// of its start, its body's opening brace (-1 for an expression-bodied arrow
+// function) and its last byte
+type jsHead struct {
+	start      int
+	body       int
+	end        int
+	entityType string
+	name       string
+	signature  string
+}
+
+// findJSHeads finds the declarations starting at brace depth depth between
+// from and to, sorted by position: functions, arrow functions and classes,
+// or with members set class methods and arrow function fields. Heads
+// without a body, like overload signatures, are left out.
+func findJSHeads(content string, layout jsLayout, from, to int, depth int32, members bool) []jsHead {
+	patterns := []*regexp.Regexp{jsFunctionPattern, jsArrowPattern, jsClassPattern}
+	if members {
+		patterns = []*regexp.Regexp{jsMethodPattern, jsFieldArrowPattern}
+	}
+	
+	heads := []jsHead{}
+	for _, pattern := range patterns {
+		for _, m := range pattern.FindAllStringSubmatchIndex(content[from:to], -1) {
+			start, headEnd := from+m[0], from+m[1]
+			if layout.depth[start] != depth || layout.depth[headEnd-1] < 0 {
+				continue
+			}
+			group := func(i int) string {
+				if m[2*i] < 0 {
+					return ""
+				}
+				return content[from+m[2*i] : from+m[2*i+1]]
+			}
+			name := group(1)
+			
+			// The function keyword of a function expression, or the
+			// single parameter of an arrow function
+			keyword, param := "", ""
+			switch pattern {
+			case jsArrowPattern:
+				keyword, param = group(2), group(3)
+			case jsFieldArrowPattern:
+				param = group(2)
+			}
+			
+			head := jsHead{start: start, name: name, entityType: "function"}
+			arrow := -1
+			switch {
+			case pattern == jsClassPattern:
+				head.entityType = "class"
+				head.body = layout.bodyStart(content, headEnd)
+			case param != "":
+				head.signature = param
+				arrow = headEnd
+			default:
+				paramsEnd, ok := layout.close[headEnd-1]
+				if !ok {
+					continue
+				}
+				head.signature = strings.Join(strings.Fields(content[headEnd:paramsEnd]), " ")
+				if pattern == jsFunctionPattern || pattern == jsMethodPattern || keyword != "" {
+					head.body = layout.bodyStart(content, paramsEnd+1)
+					break
+				}
+				
+				// Without an arrow this is a parenthesized value, not a function
+				arrow = layout.arrowAfter(content, paramsEnd+1)
+				if arrow < 0 {
+					continue
+				}
+			}
+			if arrow >= 0 {
+				// An arrow function, with a block or an expression body
+				head.body = -1
+				bodyStart := arrow
+				for bodyStart < to && (content[bodyStart] == ' ' || content[bodyStart] == '\t' || content[bodyStart] == '\n') {
+					bodyStart++
+				}
+				if bodyStart < to && content[bodyStart] == '{' {
+					head.body = bodyStart
+				} else {
+					head.end = layout.expressionEnd(content, bodyStart)
+				}
+			}
+			if members && (jsControlKeywords[name] || pattern == jsMethodPattern && head.body < 0) {
+				continue
+			}
+			if head.body >= 0 {
+				bodyEnd, ok := layout.close[head.body]
+				if !ok {
+					continue
+				}
+				head.end = bodyEnd
+			} else if head.end == 0 {
+				continue
+			}
+			
+			if name == "" {
+				head.name = "default"
+			}
+			if members && head.entityType == "function" {
+				head.entityType = "method"
+			}
+			heads = append(heads, head)
+		}
+	}
+	
+	sort.Slice(heads, func(i, j int) bool { return heads[i].start < heads[j].start })
+	return heads
+}
+
+// jsLayout is the bracket structure of JavaScript or TypeScript source:
+// the brace depth at every byte, or -1 for bytes inside strings, template
+// text, comments and regular expression literals, and for every opening
+// bracket the position of the one closing it
+type jsLayout struct {
+	depth []int32
+	close map[int]int
+}
+
+// scanJS computes the bracket structure of JavaScript or TypeScript source.
+// A '/' starts a regular expression where an operand is expected, that is
+// after an operator, an opening bracket or at the start; '<' is left out so
+// JSX closing tags are not taken for one. Strings end at an unescaped
+// newline, so a stray quote in JSX text only hides the rest of its line.
+func scanJS(content string) jsLayout {
+	n := len(content)
+	layout := jsLayout{depth: make([]int32, n), close: map[int]int{}}
+	hide := func(from, to int) {
+		for i := from; i < to && i < n; i++ {
+			layout.depth[i] = -1
+		}
+	}
+	
+	// Open brackets, with '$' for the "${" of a template substitution,
+	// whose closing brace returns to the template text
+	type open struct {
+		char byte
+		pos  int
+	}
+	stack := []open{}
+	braces := int32(0)
+	
+	// scanTemplate hides template text from start up to and including the
+	// closing backtick, or up to a substitution, returning where code
+	// resumes
+	scanTemplate := func(start, i int) int {
+		for i < n {
+			switch content[i] {
+			case '\\':
+				i += 2
+				continue
+			case '`':
+				hide(start, i+1)
+				return i + 1
+			case '$':
+				if i+1 < n && content[i+1] == '{' {
+					hide(start, i+2)
+					stack = append(stack, open{'$', i + 1})
+					return i + 2
+				}
+			}
+			i++
+		}
+		hide(start, n)
+		return n
+	}
+	
+	// last is the last code character other than whitespace
+	last := byte(0)
+	for i := 0; i < n; {
+		c := content[i]
+		layout.depth[i] = braces
+		
+		switch {
+		case c == '/' && i+1 < n && content[i+1] == '/':
+			end := strings.IndexByte(content[i:], '\n')
+			if end < 0 {
+				end = n - i
+			}
+			hide(i, i+end)
+			i += end
+			continue
+		case c == '/' && i+1 < n && content[i+1] == '*':
+			end := strings.Index(content[i+2:], "*/")
+			if end < 0 {
+				end = n - i
+			} else {
+				end += 4
+			}
+			hide(i, i+end)
+			i += end
+			continue
+		case c == '"' || c == '\'':
+			end := skipQuotedString(content, i)
+			hide(i, end)
+			i = end
+			last = c
+			continue
+		case c == '`':
+			i = scanTemplate(i, i+1)
+			last = c
+			continue
+		case c == '/' && (last == 0 || strings.IndexByte("(,=:[!&|?{};+-*%~^", last) >= 0 || followsJSKeyword(content, i)):
+			if end := skipJSRegex(content, i); end > 0 {
+				hide(i, end)
+				i = end
+				last = '/'
+				continue
+			}
+		case c == '(' || c == '[' || c == '{':
+			stack = append(stack, open{c, i})
+			if c == '{' {
+				braces++
+			}
+		case c == ')' || c == ']' || c == '}':
+			if len(stack) == 0 {
+				break
+			}
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if top.char == '$' {
+				i = scanTemplate(i, i+1)
+				last = '`'
+				continue
+			}
+			layout.close[top.pos] = i
+			if top.char == '{' {
+				braces--
+			}
+		}
+		
+		if c != ' ' && c != '\t' && c != '\n' {
+			last = c
+		}
+		i++
+	}
+	
+	return layout
+}
+
+// jsOperandKeywords are the JavaScript keywords followed by an operand, so
+// a '/' after them starts a regular expression, as in "return /x/.test(s)"
+var jsOperandKeywords = map[string]bool{
+	"return": true, "typeof": true, "instanceof": true, "in": true, "of": true,
+	"new": true, "delete": true, "void": true, "throw": true, "case": true,
+	"do": true, "else": true, "yield": true, "await": true,
+}
+
+// followsJSKeyword reports whether the code before position i ends with one
+// of jsOperandKeywords, not used as a property name like x.return
+func followsJSKeyword(content string, i int) bool {
+	end := i
+	for end > 0 && strings.IndexByte(" \t\r\n", content[end-1]) >= 0 {
+		end--
+	}
+	start := end
+	for start > 0 {
+		c := content[start-1]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '$') {
+			break
+		}
+		start--
+	}
+	if start > 0 && content[start-1] == '.' {
+		return false
+	}
+	return jsOperandKeywords[content[start:end]]
+}
+
+// skipQuotedString returns the position after the string or character
+// literal starting with the quote at start, or of the newline ending an
+// unterminated one
+func skipQuotedString(content string, start int) int {
+	quote := content[start]
+	for i := start + 1; i < len(content); i++ {
+		switch content[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		case '\n':
+			return i
+		}
+	}
+	return len(content)
+}
+
+// skipJSRegex returns the position after the regular expression literal
+// starting with the slash at start, including its flags, or -1 if the line
+// ends first and the slash is not a regular expression after all
+func skipJSRegex(content string, start int) int {
+	inClass := false
+	for i := start + 1; i < len(content); i++ {
+		switch c := content[i]; {
+		case c == '\\':
+			i++
+		case c == '\n':
+			return -1
+		case c == '[':
+			inClass = true
+		case c == ']':
+			inClass = false
+		case c == '/' && !inClass:
+			i++
+			for i < len(content) && unicode.IsLetter(rune(content[i])) {
+				i++
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// bodyStart returns the position of the '{' opening the body of a
+// declaration whose head ends at from. Bracketed type annotations in
+// between, like the { id: string } of function f(): { id: string } {, are
+// skipped. It returns -1 if a ';' or a closing bracket comes first.
+func (l jsLayout) bodyStart(content string, from int) int {
+	prev := byte(0)
+	for i := from; i < len(content); i++ {
+		c := content[i]
+		if l.depth[i] < 0 || c == ' ' || c == '\t' || c == '\n' {
+			continue
+		}
+		switch c {
+		case '{':
+			if prev != ':' && prev != '|' && prev != '&' && prev != '<' && prev != ',' {
+				return i
+			}
+			fallthrough
+		case '(', '[':
+			end, ok := l.close[i]
+			if !ok {
+				return -1
+			}
+			i = end
+		case ';', ')', ']', '}':
+			return -1
+		}
+		prev = content[i]
+	}
+	return -1
+}
+
+// arrowAfter returns the position after the "=>" following the parameters
+// of an arrow function that end at from, skipping a return type annotation,
+// or -1 if the line or statement ends first
+func (l jsLayout) arrowAfter(content string, from int) int {
+	angles := 0
+	for i := from; i < len(content); i++ {
+		if l.depth[i] < 0 {
+			continue
+		}
+		switch content[i] {
+		case '(', '[', '{':
+			end, ok := l.close[i]
+			if !ok {
+				return -1
+			}
+			i = end
+		case '<':
+			angles++
+		case '>':
+			if angles > 0 {
+				angles--
+			}
+		case '=':
+			if i+1 < len(content) && content[i+1] == '>' {
+				if angles == 0 {
+					return i + 2
+				}
+				i++
+			}
+		case ';', '\n', ')', ']', '}':
+			return -1
+		}
+	}
+	return -1
+}
+
+// expressionEnd returns the position of the last byte of the expression
+// body of an arrow function starting at from: the end of its line, or of
+// the line where the brackets open on it close, or before a ';'
+func (l jsLayout) expressionEnd(content string, from int) int {
+	for i := from; i < len(content); i++ {
+		if l.depth[i] < 0 {
+			continue
+		}
+		switch content[i] {
+		case '(', '[', '{':
+			end, ok := l.close[i]
+			if !ok {
+				return len(content) - 1
+			}
+			i = end
+		case ';':
+			return i
+		case '\n', ')', ']', '}':
+			return i - 1
+		}
+	}
+	return len(content) - 1
+}
+
+// chunkBySize splits content into chunks of approximately equal size
+func (r *Neo4jRAG) chunkBySize(content, filePath, projectPath, language string) []CodeChunk {
+	chunks := []CodeChunk{}
+	// Reading from a string cannot fail and emit never returns an error
+	_ = r.chunkReaderBySize(strings.NewReader(content), int64(len(content)), filePath, projectPath, language, func(chunk CodeChunk) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	return chunks
+}
+
+// chunkReaderBySize is the streaming form of chunkBySize. It reads rd line by
+// line and passes each chunk to emit as soon as it is complete, so the whole
+// input never has to be held in memory. size is the total input size in
+// bytes; the chunks are identical to those chunkBySize produces for the same
+// content.
+func (r *Neo4jRAG) chunkReaderBySize(rd io.Reader, size int64, filePath, projectPath, language string, emit func(CodeChunk) error) error {
+	// A single line is at most the whole input, but the buffer only grows
+	// as long lines are actually seen
+	maxLine := int(size) + 1
+	initialBuffer := 64 * 1024
+	if maxLine < initialBuffer {
+		initialBuffer = maxLine
+	}
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(make([]byte, initialBuffer), maxLine)
+	
+	// Track where each line starts in the input, so chunks can record their
+	// byte offsets whatever the line endings
+	offset, tokenStart := 0, 0
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := scanLinesExact(data, atEOF)
+		if token != nil {
+			tokenStart = offset
+		}
+		offset += advance
+		return advance, token, err
+	})
+	
+	// If the input is small enough, it becomes a single chunk. A character
+	// or token is at least a byte, so this holds in either unit.
+	split := size > int64(r.config.MaxChunkSize)
+	lineSize := r.chunkLineSize()
+	
+	currentChunk := []string{}
+	lineStarts := []int{} // Byte offset of each line in currentChunk
+	currentSize := 0
+	startLine := 1
+	
+	// Look one line ahead so the final line always closes the last chunk
+	hasLine := scanner.Scan()
+	nextStart := tokenStart
+	for hasLine {
+		line := scanner.Text()
+		lineStart := nextStart
+		hasLine = scanner.Scan()
+		nextStart = tokenStart
+		isLast := !hasLine
+		
+		currentChunk = append(currentChunk, line)
+		lineStarts = append(lineStarts, lineStart)
+		currentSize += lineSize(line)
+		
+		// If chunk is big enough or we're at the end, save it
+		if (split && currentSize >= r.config.MaxChunkSize) || isLast {
+			endLine := startLine + len(currentChunk) - 1
+			
+			err := emit(CodeChunk{
+				FilePath:    filePath,
+				ProjectPath: projectPath,
+				Content:     strings.Join(currentChunk, "\n"),
+				StartLine:   startLine,
+				EndLine:     endLine,
+				StartByte:   lineStarts[0],
+				EndByte:     lineStart + len(line),
+				EntityType:  "chunk",
+				Name:        fmt.Sprintf("chunk_%d_%d", startLine, endLine),
+				Language:    language,
+			})
+			if err != nil {
+				return err
+			}
+			
+			// Start new chunk with overlap
+			overlapLines := overlapLineCount(currentChunk, r.config.ChunkOverlap, r.config.MaxChunkSize, lineSize)
+			
+			currentChunk = append([]string{}, currentChunk[len(currentChunk)-overlapLines:]...)
+			lineStarts = append([]int{}, lineStarts[len(lineStarts)-overlapLines:]...)
+			startLine = endLine - overlapLines + 1
+			currentSize = 0
+			for _, line := range currentChunk {
+				currentSize += lineSize(line)
+			}
+		}
+	}
+	
+	return scanner.Err()
+}
+
+// chunkLineSize returns the function measuring a line and its line ending in
+// Config.ChunkUnit. Tokens are counted with countTokens, plus one for the
+// line ending.
+func (r *Neo4jRAG) chunkLineSize() func(line string) int {
+	if r.config.ChunkUnit == chunkUnitTokens {
+		return func(line string) int {
+			return r.countTokens(line) + 1
+		}
+	}
+	return func(line string) int {
+		return len(line) + 1 // +1 for newline
+	}
+}
+
+// overlapLineCount returns how many trailing lines of the chunk just emitted
+// to carry into the next chunk: as many as fit in overlap, measured with
+// lineSize like maxChunkSize. It always leaves at least one line behind so
+// the next chunk starts after the previous one, and keeps the carried lines
+// under half of maxChunkSize so the next chunk can grow by more than a line
+// before it is emitted.
+func overlapLineCount(emitted []string, overlap, maxChunkSize int, lineSize func(line string) int) int {
+	size := 0
+	for i := 0; i < len(emitted)-1; i++ {
+		size += lineSize(emitted[len(emitted)-1-i])
+		if size > overlap || size >= maxChunkSize/2 {
+			return i
+		}
+	}
+	
+	return len(emitted) - 1
+}
+
+// scanLinesExact is a bufio.SplitFunc that splits lines like strings.Split
+// on normalizeLineEndings output: "\n", "\r\n" and lone "\r" each end a
+// line and are dropped, and a trailing line ending yields a final empty
+// line, so line numbers match the whole-file chunkers
+func scanLinesExact(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		switch {
+		case i+1 < len(data) && data[i+1] == '\n':
+			return i + 2, data[:i], nil
+		case i+1 < len(data) || atEOF:
+			return i + 1, data[:i], nil
+		}
+		// Need the next byte to tell "\r\n" from a lone "\r"
+		return 0, nil, nil
+	}
+	if atEOF {
+		// Always deliver the last line, even when it is empty
+		return len(data), append([]byte{}, data...), bufio.ErrFinalToken
+	}
+	return 0, nil, nil
+}
+
+// embeddingBatchSize is the number of chunks sent per embedding request, kept
+// small to avoid overwhelming LMStudio
+const embeddingBatchSize = 5
+
+// generateEmbeddings generates embeddings for chunks
+// optimized for LMStudio by processing in smaller batches
+func (r *Neo4jRAG) generateEmbeddings(ctx context.Context, chunks []CodeChunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	
+	// Process in smaller batches to avoid overwhelming LMStudio
+	batchSize := embeddingBatchSize
+	
+	for i := 0; i < len(chunks); i += batchSize {
+		end := i + batchSize
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+		
+		batch := chunks[i:end]
+		
+		// Prepare texts for embedding
+		texts := make([]string, len(batch))
+		for j, chunk := range batch {
+			texts[j] = chunk.Content
+		}
+		
+		// Call embedding service
+		r.logger.Debugf("Generating embeddings for batch %d/%d (size: %d)", 
+			(i/batchSize)+1, (len(chunks)+batchSize-1)/batchSize, len(batch))
+		
+		embeddings, err := r.getEmbeddings(ctx, texts)
+		if err != nil {
+			return fmt.Errorf("failed to generate embeddings for batch %d: %w", (i/batchSize)+1, err)
+		}
+		
+		// Assign embeddings to chunks
+		for j, embedding := range embeddings {
+			batch[j].Embedding = embedding
+		}
+		
+		// Ensemble mode embeds every chunk a second time with the other model
+		if r.ensembleEmbedder != nil {
+			embeddings2, err := r.embedWith(ctx, r.ensembleEmbedder, texts)
+			if err != nil {
+				return fmt.Errorf("failed to generate ensemble embeddings for batch %d: %w", (i/batchSize)+1, err)
+			}
+			for j, embedding := range embeddings2 {
+				batch[j].Embedding2 = embedding
+			}
+		}
+		
+		// Add a small delay between batches to avoid overwhelming LMStudio
+		if i+batchSize < len(chunks) {
+			if err := sleepContext(ctx, 1*time.Second); err != nil {
+				return err
+			}
+		}
+	}
+	
+	return nil
+}
+
+// getEmbeddings embeds texts with the primary embedder
+func (r *Neo4jRAG) getEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	return r.embedWith(ctx, r.embedder, texts)
+}
+
+// embedWith embeds texts with embedder, checks that one embedding came back
+// per text and applies Config.NormalizeEmbeddings
+func (r *Neo4jRAG) embedWith(ctx context.Context, embedder Embedder, texts []string) ([][]float32, error) {
+	embeddings, err := embedder.Embed(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+	
+	if len(embeddings) != len(texts) {
+		return nil, fmt.Errorf("embedding service returned %d embeddings for %d texts", len(embeddings), len(texts))
+	}
+	
+	// Normalizing here covers chunk, query and ensemble embeddings alike
+	if r.config.NormalizeEmbeddings {
+		for _, embedding := range embeddings {
+			normalizeEmbedding(embedding)
+		}
+	}
+	
+	return embeddings, nil
+}
+
+// Logger is the logging HTTPEmbedder does, satisfied by *log.Logger.
+// Loggers that also have a Warnf method, like Neo4jRAG's, log retries at
+// warning level.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// HTTPEmbedder calls an embedding service that takes an EmbeddingRequest
+// and answers with one of the shapes parseEmbeddingResponse accepts
+type HTTPEmbedder struct {
+	url    string
+	client *http.Client
+	logger Logger
+}
+
+// NewHTTPEmbedder returns an Embedder for the service at url. A nil client
+// gets the default embedding timeout and a nil logger the default logger.
+func NewHTTPEmbedder(url string, client *http.Client, logger Logger) *HTTPEmbedder {
+	if client == nil {
+		client = &http.Client{Timeout: defaultEmbeddingTimeout}
+	}
+	if logger == nil {
+		logger = newLeveledLogger(Config{})
+	}
+	return &HTTPEmbedder{url: url, client: client, logger: logger}
+}
+
+// warnf logs a warning through the embedder's logger
+func (e *HTTPEmbedder) warnf(format string, args ...interface{}) {
+	if leveled, ok := e.logger.(interface {
+		Warnf(format string, args ...interface{})
+	}); ok {
+		leveled.Warnf(format, args...)
+		return
+	}
+	e.logger.Printf("WARNING: "+format, args...)
+}
+
+// Embed calls the embedding service with retry logic optimized for LMStudio
+// which may be slow with requests. Requests, retries and backoff delays stop
+// when ctx is done.
+func (e *HTTPEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	// Prepare request
+	req := EmbeddingRequest{
+		Texts: texts,
+	}
+	
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Add retry logic with backoff
+	maxRetries := 3
+	backoffDuration := 1 * time.Second
+	
+	var resp *http.Response
+	var lastErr error
+	
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		if attempt > 0 {
+			e.warnf("Retrying embedding request (attempt %d/%d) after %v delay", 
+				attempt+1, maxRetries, backoffDuration)
+			if err := sleepContext(ctx, backoffDuration); err != nil {
+				return nil, err
+			}
+			backoffDuration *= 2 // Exponential backoff
+		}
+		
+		// Call embedding service
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(reqBody))
+		if err != nil {
+			return nil, err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		resp, err = e.client.Do(httpReq)
+		if ctx.Err() != nil {
+			if err == nil {
+				resp.Body.Close()
+			}
+			return nil, fmt.Errorf("embedding request interrupted: %w", ctx.Err())
+		}
+		if err == nil && resp.StatusCode == http.StatusOK {
+			break // Success
+		}
+		
+		lastErr = err
+		if err == nil {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("embedding service returned status code %d", resp.StatusCode)
+		}
+	}
+	
+	if resp == nil || lastErr != nil {
+		return nil, fmt.Errorf("failed to get embeddings after %d attempts: %w", maxRetries, lastErr)
+	}
+	defer resp.Body.Close()
+	
+	// Parse response
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedding response: %w", err)
+	}
+	
+	embeddings, err := parseEmbeddingResponse(body)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Add a small delay after successful embedding to avoid overwhelming LMStudio
+	if err := sleepContext(ctx, 500*time.Millisecond); err != nil {
+		return nil, err
+	}
+	
+	return embeddings, nil
+}
+
+// defaultMockDimension matches the all-MiniLM-L6-v2 model the embedding
+// service runs by default, so mock and real embeddings fit the same index
+const defaultMockDimension = 384
+
+// MockEmbedder embeds without a model by hashing each lowercased word of a
+// text into one of Dimension buckets and scaling the counts to unit length.
+// Texts that share words score higher than texts that don't, so rankings
+// are deterministic and predictable, which is what indexing and search runs
+// against a scratch Neo4j need.
+type MockEmbedder struct {
+	Dimension int // 0 uses defaultMockDimension
+}
+
+// Embed implements Embedder
+func (e *MockEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	dimension := e.Dimension
+	if dimension <= 0 {
+		dimension = defaultMockDimension
+	}
+	
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, dimension)
+		words := strings.FieldsFunc(strings.ToLower(text), func(c rune) bool {
+			return !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_'
+		})
+		for _, word := range words {
+			h := fnv.New32a()
+			h.Write([]byte(word))
+			vector[h.Sum32()%uint32(dimension)]++
+		}
+		normalizeEmbedding(vector)
+		embeddings[i] = vector
+	}
+	
+	return embeddings, nil
+}
+
+// normalizeEmbedding scales v in place to unit L2 length. Zero vectors are
+// left as they are.
+func normalizeEmbedding(v []float32) {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		return
+	}
+	
+	norm = math.Sqrt(norm)
+	for i := range v {
+		v[i] = float32(float64(v[i]) / norm)
+	}
+}
+
+// sleepContext waits for d or until ctx is done, returning ctx's error in
+// the latter case
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// vectorParam converts an embedding to a query parameter. The driver sends a
+// nil slice as an empty list, so missing embeddings are passed as nil to store
+// a null property that "IS NULL" checks recognize.
+func vectorParam(vector []float32) interface{} {
+	if len(vector) == 0 {
+		return nil
+	}
+	return vector
+}
+
+// stringParam converts a string to a query parameter, passing an empty string
+// as nil so that the property is removed rather than stored empty
+func stringParam(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// parseEmbeddingResponse decodes an embedding service response. Each element
+// of "embeddings" may be a raw vector or an object with an "embedding" field.
+// When objects carry an "index" field the result is reordered by it so that
+// embeddings line up with the input texts.
+func parseEmbeddingResponse(body []byte) ([][]float32, error) {
+	var embeddingResp EmbeddingResponse
+	if err := json.Unmarshal(body, &embeddingResp); err != nil {
+		return nil, fmt.Errorf("unexpected embedding response shape, expected {\"embeddings\": [...]}: %w", err)
+	}
+	
+	embeddings := make([][]float32, len(embeddingResp.Embeddings))
+	indices := make([]*int, len(embeddingResp.Embeddings))
+	indexed := 0
+	
+	for i, raw := range embeddingResp.Embeddings {
+		trimmed := bytes.TrimSpace(raw)
+		if len(trimmed) == 0 {
+			return nil, fmt.Errorf("unexpected embedding response shape: element %d is empty", i)
+		}
+		
+		switch trimmed[0] {
+		case '[':
+			if err := json.Unmarshal(trimmed, &embeddings[i]); err != nil {
+				return nil, fmt.Errorf("unexpected embedding response shape: element %d is not an array of numbers: %w", i, err)
+			}
+		case '{':
+			var obj embeddingObject
+			if err := json.Unmarshal(trimmed, &obj); err != nil {
+				return nil, fmt.Errorf("unexpected embedding response shape: element %d is not a valid embedding object: %w", i, err)
+			}
+			if obj.Embedding == nil {
+				return nil, fmt.Errorf("unexpected embedding response shape: element %d is an object without an \"embedding\" field", i)
+			}
+			embeddings[i] = obj.Embedding
+			indices[i] = obj.Index
+			if obj.Index != nil {
+				indexed++
+			}
+		default:
+			return nil, fmt.Errorf("unexpected embedding response shape: element %d is neither an array nor an object", i)
+		}
+	}
+	
+	// Without index fields the response order is the input order
+	if indexed == 0 {
+		return embeddings, nil
+	}
+	
+	if indexed != len(embeddings) {
+		return nil, fmt.Errorf("unexpected embedding response shape: only %d of %d elements have an \"index\" field", indexed, len(embeddings))
+	}
+	
+	ordered := make([][]float32, len(embeddings))
+	for i, idx := range indices {
+		if *idx < 0 || *idx >= len(ordered) {
+			return nil, fmt.Errorf("unexpected embedding response shape: element %d has out-of-range index %d", i, *idx)
+		}
+		if ordered[*idx] != nil {
+			return nil, fmt.Errorf("unexpected embedding response shape: duplicate index %d", *idx)
+		}
+		ordered[*idx] = embeddings[i]
+	}
+	
+	return ordered, nil
+}
+
+// pendingEmbeddingPageSize is the number of pending chunks EmbedPending loads
+// and writes back per round
+const pendingEmbeddingPageSize = 100
+
+// EmbedPending generates embeddings for chunks stored without them (see
+// Config.DeferEmbeddings) and returns how many chunks were embedded
+func (r *Neo4jRAG) EmbedPending() (int, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	pending, err := r.CountPendingEmbeddings()
+	if err != nil {
+		return 0, err
+	}
+	r.logger.Printf("%d chunks are waiting for embeddings\n", pending)
+	
+	embedded := 0
+	for {
+		result, err := session.Run(
+			`MATCH (c:Chunk)
+			 WHERE c.embedded = false
+			 RETURN c.id AS id, c.content AS content, c.file_path AS filePath,
+			        c.start_byte AS startByte, c.end_byte AS endByte
+			 LIMIT $limit`,
+			map[string]interface{}{"limit": pendingEmbeddingPageSize},
+		)
+		if err != nil {
+			return embedded, fmt.Errorf("failed to load pending chunks: %w", err)
+		}
+		
+		chunks := []CodeChunk{}
+		contents := newChunkContentLoader(r)
+		for result.Next() {
+			record := result.Record()
+			id, _ := record.Get("id")
+			content, _ := record.Get("content")
+			filePath, _ := record.Get("filePath")
+			startByte, _ := record.Get("startByte")
+			endByte, _ := record.Get("endByte")
+			chunk := CodeChunk{}
+			chunk.ID, _ = id.(string)
+			chunk.Content, _ = content.(string)
+			chunk.FilePath, _ = filePath.(string)
+			chunk.StartByte, _ = asInt(startByte)
+			chunk.EndByte, _ = asInt(endByte)
+			contents.load(&chunk)
+			chunks = append(chunks, chunk)
+		}
+		if err := result.Err(); err != nil {
+			return embedded, fmt.Errorf("failed to load pending chunks: %w", err)
+		}
+		
+		if len(chunks) == 0 {
+			break
+		}
+		
+		err = r.generateEmbeddings(context.Background(), chunks)
+		if err != nil {
+			return embedded, err
+		}
+		
+		rows := make([]map[string]interface{}, len(chunks))
+		for i, chunk := range chunks {
+			rows[i] = map[string]interface{}{
+				"id":         chunk.ID,
+				"embedding":  vectorParam(chunk.Embedding),
+				"embedding2": vectorParam(chunk.Embedding2),
+			}
+		}
+		
+		_, err = session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+			_, err := tx.Run(
+				`UNWIND $rows AS row
+				 MATCH (c:Chunk {id: row.id})
+				 SET c.embedding = row.embedding,
+				     c.embedding2 = row.embedding2,
+				     c.embedding_dim = size(row.embedding),
+				     c.embedded = true`,
+				map[string]interface{}{"rows": rows},
+			)
+			return nil, err
+		})
+		if err != nil {
+			return embedded, fmt.Errorf("failed to store embeddings: %w", err)
+		}
+		
+		embedded += len(chunks)
+		r.logger.Printf("Embedded %d/%d pending chunks\n", embedded, pending)
+	}
+	
+	return embedded, nil
+}
+
+// CountPendingEmbeddings returns the number of chunks stored without embeddings
+func (r *Neo4jRAG) CountPendingEmbeddings() (int64, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	result, err := session.Run(`MATCH (c:Chunk) WHERE c.embedded = false RETURN count(c) AS count`, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count pending embeddings: %w", err)
+	}
+	
+	record, err := result.Single()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count pending embeddings: %w", err)
+	}
+	
+	count, _ := record.Get("count")
+	pending, _ := count.(int64)
+	return pending, nil
+}
+
+// resetBatchSize is the number of nodes Reset deletes per transaction
+const resetBatchSize = 10000
+
+// Reset deletes indexed data and returns the number of nodes removed. With
+// an empty projectPath every Chunk, File, Project, Symbol and Package node is
+// deleted; otherwise only the given project and its files, chunks and
+// symbols are. Nodes are
+// deleted in batches of resetBatchSize to keep transactions small.
+func (r *Neo4jRAG) Reset(projectPath string) (int64, error) {
+	if projectPath == "" {
+		return r.deleteInBatches(
+			`MATCH (n) WHERE n:Chunk OR n:File OR n:Project OR n:Symbol OR n:Package
+			 WITH n LIMIT $batchSize
+			 DETACH DELETE n
+			 RETURN count(*) AS deleted`,
+			map[string]interface{}{},
+		)
+	}
+	
+	params := map[string]interface{}{"projectPaths": expandPathVariants([]string{projectPath})}
+	total := int64(0)
+	for _, query := range []string{
+		`MATCH (c:Chunk)-[:PART_OF]->(:File)-[:BELONGS_TO]->(p:Project)
+		 WHERE p.path IN $projectPaths
+		 WITH c LIMIT $batchSize
+		 DETACH DELETE c
+		 RETURN count(*) AS deleted`,
+		`MATCH (f:File)-[:BELONGS_TO]->(p:Project)
+		 WHERE p.path IN $projectPaths
+		 WITH f LIMIT $batchSize
+		 DETACH DELETE f
+		 RETURN count(*) AS deleted`,
+		`MATCH (s:Symbol)
+		 WHERE s.project_path IN $projectPaths
+		 WITH s LIMIT $batchSize
+		 DETACH DELETE s
+		 RETURN count(*) AS deleted`,
+		`MATCH (p:Project)
+		 WHERE p.path IN $projectPaths
+		 DETACH DELETE p
+		 RETURN count(*) AS deleted`,
+	} {
+		deleted, err := r.deleteInBatches(query, params)
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+	}
+	
+	return total, nil
+}
+
+// deleteInBatches runs a delete query returning a "deleted" count, one
+// transaction at a time, until a run deletes nothing
+func (r *Neo4jRAG) deleteInBatches(query string, params map[string]interface{}) (int64, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	params["batchSize"] = resetBatchSize
+	total := int64(0)
+	for {
+		result, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+			record, err := runSingleWithParams(tx, query, params)
+			if err != nil {
+				return nil, err
+			}
+			deleted, _ := record.Get("deleted")
+			return deleted, nil
+		})
+		if err != nil {
+			return total, fmt.Errorf("failed to delete nodes: %w", err)
+		}
+		
+		deleted, _ := result.(int64)
+		total += deleted
+		if deleted == 0 {
+			return total, nil
+		}
+		r.logger.Printf("Deleted %d nodes\n", total)
+	}
+}
+
+// exportPageSize is the number of chunks ExportChunks loads per query
+const exportPageSize = 500
+
+// ExportedChunk is the JSONL record written by ExportChunks. Embeddings are
+// only present when requested.
+type ExportedChunk struct {
+	CodeChunk
+	Embedding      []float32 `json:"embedding,omitempty"`
+	Embedding2     []float32 `json:"embedding2,omitempty"`
+	ChunkerVersion int       `json:"chunker_version,omitempty"`
+}
+
+// ExportChunks writes every chunk to w as newline-delimited JSON, paging
+// through the index so it is never loaded into memory at once. Each chunk's
+// project path is taken from the project its file belongs to.
+func (r *Neo4jRAG) ExportChunks(w io.Writer, includeEmbeddings bool) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	encoder := json.NewEncoder(w)
+	exported := 0
+	
+	for skip := 0; ; skip += exportPageSize {
+		result, err := session.Run(
+			`MATCH (c:Chunk)
+			 WITH c ORDER BY c.id SKIP $skip LIMIT $limit
+			 OPTIONAL MATCH (c)-[:PART_OF]->(:File)-[:BELONGS_TO]->(p:Project)
+			 RETURN c, p.path AS projectPath`,
+			map[string]interface{}{"skip": skip, "limit": exportPageSize},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to load chunks: %w", err)
+		}
+		
+		// Files are cached per page, so a large export does not hold them all
+		count := 0
+		contents := newChunkContentLoader(r)
+		for result.Next() {
+			count++
+			record := result.Record()
+			value, _ := record.Get("c")
+			node, ok := value.(neo4j.Node)
+			if !ok {
+				continue
+			}
+			
+			props := node.Props
+			chunk := ExportedChunk{}
+			chunk.ID, _ = props["id"].(string)
+			chunk.Content, _ = props["content"].(string)
+			chunk.FilePath, _ = props["file_path"].(string)
+			chunk.Language, _ = props["language"].(string)
+			chunk.EntityType, _ = props["entity_type"].(string)
+			chunk.Name, _ = props["name"].(string)
+			chunk.Signature, _ = props["signature"].(string)
+			chunk.Hash, _ = props["hash"].(string)
+			chunk.IsVendored, _ = props["is_vendored"].(bool)
+			if startLine, ok := props["start_line"].(int64); ok {
+				chunk.StartLine = int(startLine)
+			}
+			if endLine, ok := props["end_line"].(int64); ok {
+				chunk.EndLine = int(endLine)
+			}
+			if startByte, ok := props["start_byte"].(int64); ok {
+				chunk.StartByte = int(startByte)
+			}
+			if endByte, ok := props["end_byte"].(int64); ok {
+				chunk.EndByte = int(endByte)
+			}
+			if version, ok := props["chunker_version"].(int64); ok {
+				chunk.ChunkerVersion = int(version)
+			}
+			if projectPath, ok := record.Get("projectPath"); ok {
+				chunk.ProjectPath, _ = projectPath.(string)
+			}
+			contents.load(&chunk.CodeChunk)
+			
+			if includeEmbeddings {
+				chunk.Embedding = toFloat32Slice(props["embedding"])
+				chunk.Embedding2 = toFloat32Slice(props["embedding2"])
+			}
+			
+			if err := encoder.Encode(chunk); err != nil {
+				return fmt.Errorf("failed to write chunk %s: %w", chunk.ID, err)
+			}
+		}
+		if err := result.Err(); err != nil {
+			return fmt.Errorf("failed to load chunks: %w", err)
+		}
+		
+		exported += count
+		if count < exportPageSize {
+			break
+		}
+		r.logger.Printf("Exported %d chunks\n", exported)
+	}
+	
+	r.logger.Printf("Export complete: %d chunks\n", exported)
+	return nil
+}
+
+// defaultImportBatchSize is the number of chunks ImportChunks writes per
+// transaction when no batch size is given
+const defaultImportBatchSize = 500
+
+// ImportChunks reads chunks written by ExportChunks from rd and upserts them,
+// recreating their File and Project nodes, in transactions of batchSize
+// chunks. It returns the number of chunks imported. Imported embeddings must
+// have the dimension of the configured embedding service; a mismatch is an
+// error unless force is set, in which case it is only logged. Chunks without
+// embeddings are imported as pending (see EmbedPending). With
+// Config.OmitContent their content is left out, as when indexing.
+func (r *Neo4jRAG) ImportChunks(rd io.Reader, batchSize int, force bool) (int, error) {
+	if batchSize <= 0 {
+		batchSize = defaultImportBatchSize
+	}
+	
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	// The embedding service may be down when restoring offline, in which case
+	// the dimension cannot be checked
+	expectedDim := 0
+	probe, err := r.getEmbeddings(context.Background(), []string{"dimension probe"})
+	if err != nil || len(probe) == 0 {
+		r.logger.Warnf("could not reach the embedding service, skipping the dimension check: %v\n", err)
+	} else {
+		expectedDim = len(probe[0])
+	}
+	
+	decoder := json.NewDecoder(rd)
+	imported := 0
+	rows := []map[string]interface{}{}
+	
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		_, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+			_, err := tx.Run(
+				`UNWIND $rows AS row
+				 MERGE (p:Project {path: row.projectPath})
+				 ON CREATE SET p.created_at = datetime(),
+				               p.name = row.projectName
+				 MERGE (f:File {path: row.filePath})
+				 ON CREATE SET f.created_at = datetime(),
+				               f.name = row.fileName,
+				               f.language = row.language
+				 MERGE (f)-[:BELONGS_TO]->(p)
+				 MERGE (c:Chunk {id: row.id})
+				 ON CREATE SET c.created_at = datetime()
+				 SET c.content = row.content,
+				     c.content_size = row.contentSize,
+				     c.file_path = row.filePath,
+				     c.project_path = row.projectPath,
+				     c.start_line = row.startLine,
+				     c.end_line = row.endLine,
+				     c.start_byte = row.startByte,
+				     c.end_byte = row.endByte,
+				     c.entity_type = row.entityType,
+				     c.name = row.name,
+				     c.signature = row.signature,
+				     c.language = row.language,
+				     c.hash = row.hash,
+				     c.embedding = row.embedding,
+				     c.embedding2 = row.embedding2,
+				     c.embedding_dim = size(row.embedding),
+				     c.embedded = row.embedded,
+				     c.chunker_version = row.chunkerVersion,
+				     c.is_vendored = row.isVendored,
+				     c.updated_at = datetime()
+				 MERGE (c)-[:PART_OF]->(f)`,
+				map[string]interface{}{"rows": rows},
+			)
+			return nil, err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to store imported chunks: %w", err)
+		}
+		
+		imported += len(rows)
+		rows = rows[:0]
+		r.logger.Printf("Imported %d chunks\n", imported)
+		return nil
+	}
+	
+	for line := 1; ; line++ {
+		var chunk ExportedChunk
+		err := decoder.Decode(&chunk)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imported, fmt.Errorf("invalid chunk on line %d: %w", line, err)
+		}
+		if chunk.ID == "" || chunk.FilePath == "" {
+			return imported, fmt.Errorf("invalid chunk on line %d: id and file_path are required", line)
+		}
+		
+		if expectedDim > 0 && len(chunk.Embedding) > 0 && len(chunk.Embedding) != expectedDim {
+			if !force {
+				return imported, fmt.Errorf("chunk on line %d has a %d-dimensional embedding but the embedding service produces %d dimensions (use --force to import anyway)",
+					line, len(chunk.Embedding), expectedDim)
+			}
+			r.logger.Warnf("importing %d-dimensional embeddings; the embedding service produces %d dimensions\n",
+				len(chunk.Embedding), expectedDim)
+			expectedDim = 0 // Warn once
+		}
+		
+		// Chunks exported without a project fall back to their directory
+		projectPath := chunk.ProjectPath
+		if projectPath == "" {
+			projectPath = filepath.Dir(chunk.FilePath)
+		}
+		
+		var chunkerVersionParam interface{}
+		if chunk.ChunkerVersion > 0 {
+			chunkerVersionParam = chunk.ChunkerVersion
+		}
+		
+		var content interface{}
+		if !r.config.OmitContent {
+			content = chunk.Content
+		}
+		
+		rows = append(rows, map[string]interface{}{
+			"id":             chunk.ID,
+			"content":        content,
+			"contentSize":    len(chunk.Content),
+			"filePath":       chunk.FilePath,
+			"fileName":       filepath.Base(chunk.FilePath),
+			"projectPath":    projectPath,
+			"projectName":    filepath.Base(projectPath),
+			"startLine":      chunk.StartLine,
+			"endLine":        chunk.EndLine,
+			"startByte":      chunk.StartByte,
+			"endByte":        chunk.EndByte,
+			"entityType":     chunk.EntityType,
+			"name":           chunk.Name,
+			"signature":      chunk.Signature,
+			"language":       chunk.Language,
+			"hash":           chunk.Hash,
+			"embedding":      vectorParam(chunk.Embedding),
+			"embedding2":     vectorParam(chunk.Embedding2),
+			"embedded":       len(chunk.Embedding) > 0,
+			"chunkerVersion": chunkerVersionParam,
+			"isVendored":     chunk.IsVendored,
+		})
+		
+		if len(rows) >= batchSize {
+			if err := flush(); err != nil {
+				return imported, err
+			}
+		}
+	}
+	
+	if err := flush(); err != nil {
+		return imported, err
+	}
+	
+	return imported, nil
+}
+
+// IndexStats summarizes the contents of the index
+type IndexStats struct {
+	TotalChunks       int64            `json:"total_chunks"`
+	Files             int64            `json:"files"`
+	Projects          int64            `json:"projects"`
+	AvgChunkSize      float64          `json:"avg_chunk_size"` // Average chunk content length in characters
+	PendingEmbeddings int64            `json:"pending_embeddings"`
+	ByLanguage        map[string]int64 `json:"by_language"`
+	ByEntityType      map[string]int64 `json:"by_entity_type"`
+	ByProject         map[string]int64 `json:"by_project"`
+	LastIndexed       string           `json:"last_indexed,omitempty"` // Most recent file (re)index time, RFC 3339
+
+	// EmbeddingDimensions counts chunks per stored embedding length. More
+	// than one entry means chunks were embedded by different models.
+	EmbeddingDimensions map[int64]int64 `json:"embedding_dimensions"`
+}
+
+// IndexStats reports the composition of the index: chunk, file and project
+// counts, chunk counts per language, entity type and project, the average
+// chunk size and the embedding dimensions actually stored
+func (r *Neo4jRAG) IndexStats() (*IndexStats, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		stats := &IndexStats{
+			ByLanguage:          map[string]int64{},
+			ByEntityType:        map[string]int64{},
+			ByProject:           map[string]int64{},
+			EmbeddingDimensions: map[int64]int64{},
+		}
+		
+		record, err := runSingle(tx,
+			`MATCH (c:Chunk)
+			 RETURN count(c) AS chunks,
+			        coalesce(avg(`+chunkSizeExpr+`), 0.0) AS avgSize,
+			        count(CASE WHEN c.embedded = false THEN 1 END) AS pending`)
+		if err != nil {
+			return nil, err
+		}
+		chunks, _ := record.Get("chunks")
+		avgSize, _ := record.Get("avgSize")
+		pending, _ := record.Get("pending")
+		stats.TotalChunks, _ = chunks.(int64)
+		stats.AvgChunkSize, _ = avgSize.(float64)
+		stats.PendingEmbeddings, _ = pending.(int64)
+		
+		record, err = runSingle(tx,
+			`OPTIONAL MATCH (f:File)
+			 WITH count(f) AS files, max(coalesce(f.updated_at, f.created_at)) AS lastIndexed
+			 OPTIONAL MATCH (p:Project)
+			 RETURN files, toString(lastIndexed) AS lastIndexed, count(p) AS projects`)
+		if err != nil {
+			return nil, err
+		}
+		files, _ := record.Get("files")
+		projects, _ := record.Get("projects")
+		lastIndexed, _ := record.Get("lastIndexed")
+		stats.Files, _ = files.(int64)
+		stats.Projects, _ = projects.(int64)
+		stats.LastIndexed, _ = asString(lastIndexed)
+		
+		// Chunk counts grouped by a single key
+		groups := []struct {
+			query  string
+			counts map[string]int64
+		}{
+			{`MATCH (c:Chunk) RETURN coalesce(c.language, 'unknown') AS key, count(c) AS count`, stats.ByLanguage},
+			{`MATCH (c:Chunk) RETURN coalesce(c.entity_type, 'unknown') AS key, count(c) AS count`, stats.ByEntityType},
+			{`MATCH (c:Chunk)-[:PART_OF]->(:File)-[:BELONGS_TO]->(p:Project) RETURN p.path AS key, count(c) AS count`, stats.ByProject},
+		}
+		for _, group := range groups {
+			groupResult, err := tx.Run(group.query, nil)
+			if err != nil {
+				return nil, err
+			}
+			for groupResult.Next() {
+				key, _ := groupResult.Record().Get("key")
+				count, _ := groupResult.Record().Get("count")
+				keyStr, _ := key.(string)
+				group.counts[keyStr], _ = count.(int64)
+			}
+			if err := groupResult.Err(); err != nil {
+				return nil, err
+			}
+		}
+		
+		dimResult, err := tx.Run(
+			`MATCH (c:Chunk) WHERE c.embedding IS NOT NULL
+			 RETURN size(c.embedding) AS dimension, count(c) AS count`, nil)
+		if err != nil {
+			return nil, err
+		}
+		for dimResult.Next() {
+			dimension, _ := dimResult.Record().Get("dimension")
+			count, _ := dimResult.Record().Get("count")
+			dim, _ := dimension.(int64)
+			stats.EmbeddingDimensions[dim], _ = count.(int64)
+		}
+		if err := dimResult.Err(); err != nil {
+			return nil, err
+		}
+		
+		return stats, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect index stats: %w", err)
+	}
+	
+	return result.(*IndexStats), nil
+}
+
+// runSingle runs a query that returns exactly one record
+func runSingle(tx neo4j.Transaction, query string) (*neo4j.Record, error) {
+	return runSingleWithParams(tx, query, nil)
+}
+
+// runSingleWithParams runs a parameterized query that returns exactly one record
+func runSingleWithParams(tx neo4j.Transaction, query string, params map[string]interface{}) (*neo4j.Record, error) {
+	result, err := tx.Run(query, params)
+	if err != nil {
+		return nil, err
+	}
+	return result.Single()
+}
+
+// printIndexStats prints stats as a set of aligned tables
+func printIndexStats(stats *IndexStats) {
+	fmt.Println("Index statistics")
+	fmt.Println("================")
+	fmt.Printf("%-22s %d\n", "Chunks:", stats.TotalChunks)
+	fmt.Printf("%-22s %d\n", "Files:", stats.Files)
+	fmt.Printf("%-22s %d\n", "Projects:", stats.Projects)
+	fmt.Printf("%-22s %.1f chars\n", "Average chunk size:", stats.AvgChunkSize)
+	fmt.Printf("%-22s %d\n", "Pending embeddings:", stats.PendingEmbeddings)
+	if stats.LastIndexed != "" {
+		fmt.Printf("%-22s %s\n", "Last indexed:", stats.LastIndexed)
+	}
+	
+	fmt.Println("\nEmbedding dimensions:")
+	if len(stats.EmbeddingDimensions) == 0 {
+		fmt.Println("  (no embeddings stored)")
+	}
+	dims := make([]int64, 0, len(stats.EmbeddingDimensions))
+	for dim := range stats.EmbeddingDimensions {
+		dims = append(dims, dim)
+	}
+	sort.Slice(dims, func(i, j int) bool { return dims[i] < dims[j] })
+	for _, dim := range dims {
+		fmt.Printf("  %-40d %8d chunks\n", dim, stats.EmbeddingDimensions[dim])
+	}
+	if len(dims) > 1 {
+		fmt.Println("  WARNING: chunks have different embedding dimensions; the index mixes embedding models")
+	}
+	
+	printCountTable("Chunks by language", stats.ByLanguage)
+	printCountTable("Chunks by entity type", stats.ByEntityType)
+	printCountTable("Chunks by project", stats.ByProject)
+}
+
+// printCountTable prints counts sorted by descending count, then by key
+func printCountTable(title string, counts map[string]int64) {
+	fmt.Printf("\n%s:\n", title)
+	if len(counts) == 0 {
+		fmt.Println("  (none)")
+		return
+	}
+	
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	
+	for _, key := range keys {
+		fmt.Printf("  %-40s %8d\n", key, counts[key])
+	}
+}
+
+// VerifyOptions controls what VerifyIndex does about the problems it finds
+type VerifyOptions struct {
+	Fix bool // Delete the problems and mark bad embeddings pending
+
+	// Force fixes even when most indexed files are missing from disk, which
+	// more often means the index was built from another directory or
+	// machine than that the files were deleted
+	Force bool
+}
+
+// ErrMostFilesMissing is returned by VerifyIndex when it refuses to fix an
+// index because most of its files are missing from disk (see
+// VerifyOptions.Force)
+var ErrMostFilesMissing = errors.New("most indexed files are missing from disk")
+
+// VerifyReport lists the integrity problems VerifyIndex found, and with
+// VerifyOptions.Fix set what it did about them
+type VerifyReport struct {
+	Files             int64    `json:"files"`               // Indexed files checked
+	MissingFiles      []string `json:"missing_files"`       // Indexed files no longer on disk
+	MissingFileChunks int64    `json:"missing_file_chunks"` // Chunks of those files
+	OrphanedChunks    int64    `json:"orphaned_chunks"`     // Chunks not attached to any file
+	EmptyFiles        []string `json:"empty_files"`         // Files with no chunks
+	EmptyEmbeddings   int64    `json:"empty_embeddings"`    // Chunks marked embedded without an embedding
+	WrongDimension    int64    `json:"wrong_dimension"`     // Chunks whose embedding has another dimension
+	ExpectedDimension int64    `json:"expected_dimension"`  // 0 when neither the embedding service nor the index gave one
+
+	Fixed        bool  `json:"fixed"`
+	Deleted      int64 `json:"deleted"`        // Chunk and file nodes removed by the fix
+	ResetToEmbed int64 `json:"reset_to_embed"` // Chunks marked pending by the fix, for --embed-pending
+}
+
+// OK reports whether the index had no problems
+func (v *VerifyReport) OK() bool {
+	return len(v.MissingFiles) == 0 && v.OrphanedChunks == 0 && len(v.EmptyFiles) == 0 &&
+		v.EmptyEmbeddings == 0 && v.WrongDimension == 0
+}
+
+// mostFilesMissing reports whether more than half of the checked files are
+// missing from disk
+func (v *VerifyReport) mostFilesMissing() bool {
+	return int64(len(v.MissingFiles))*2 > v.Files
+}
+
+// VerifyIndex checks the index for chunks whose file is gone from disk or
+// from the graph, files without chunks, and chunks marked embedded whose
+// embedding is empty or has the wrong dimension. The expected dimension is
+// the embedding service's, or when the service cannot be reached the most
+// common one in the index. Relative file paths are resolved against the
+// directory their project was indexed from (see projectRoots), falling back
+// to the working directory for projects indexed before it was recorded.
+// Duplicate chunk IDs are not checked for, as the chunk_id constraint rules
+// them out.
+//
+// With opts.Fix set, missing and empty files and orphaned chunks are
+// deleted, and chunks with bad embeddings are marked pending so
+// EmbedPending regenerates them. When most files are missing nothing is
+// changed and ErrMostFilesMissing is returned with the report, unless
+// opts.Force is set.
+func (r *Neo4jRAG) VerifyIndex(opts VerifyOptions) (*VerifyReport, error) {
+	report := &VerifyReport{MissingFiles: []string{}, EmptyFiles: []string{}}
+	
+	if probe, err := r.getEmbeddings(context.Background(), []string{"dimension probe"}); err == nil && len(probe) > 0 {
+		report.ExpectedDimension = int64(len(probe[0]))
+	} else if err != nil {
+		r.logger.Warnf("could not reach the embedding service, using the most common stored dimension: %v\n", err)
+	}
+	
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	_, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		files, err := tx.Run(
+			`MATCH (f:File)
+			 OPTIONAL MATCH (f)-[:BELONGS_TO]->(p:Project)
+			 OPTIONAL MATCH (c:Chunk)-[:PART_OF]->(f)
+			 RETURN f.path AS path, p.path AS project, p.root AS root, count(c) AS chunks
+			 ORDER BY path`, nil)
+		if err != nil {
+			return nil, err
+		}
+		roots := map[string]string{}
+		for files.Next() {
+			path, _ := files.Record().Get("path")
+			project, _ := files.Record().Get("project")
+			root, _ := files.Record().Get("root")
+			chunks, _ := files.Record().Get("chunks")
+			pathStr, _ := path.(string)
+			count, _ := chunks.(int64)
+			projectPath, projectOK := asString(project)
+			projectRoot, rootOK := asString(root)
+			if projectOK && rootOK {
+				roots[projectPath] = projectRoot
+			}
+			
+			report.Files++
+			if _, err := os.Stat(resolveIndexedPath(pathStr, roots)); errors.Is(err, os.ErrNotExist) {
+				report.MissingFiles = append(report.MissingFiles, pathStr)
+				report.MissingFileChunks += count
+			} else if count == 0 {
+				report.EmptyFiles = append(report.EmptyFiles, pathStr)
+			}
+		}
+		if err := files.Err(); err != nil {
+			return nil, err
+		}
+		
+		record, err := runSingle(tx, `MATCH (c:Chunk) WHERE NOT (c)-[:PART_OF]->(:File) RETURN count(c) AS orphaned`)
+		if err != nil {
+			return nil, err
+		}
+		orphaned, _ := record.Get("orphaned")
+		report.OrphanedChunks, _ = orphaned.(int64)
+		
+		if report.ExpectedDimension == 0 {
+			record, err := tx.Run(
+				`MATCH (c:Chunk) WHERE size(coalesce(c.embedding, [])) > 0
+				 RETURN size(c.embedding) AS dimension, count(c) AS count
+				 ORDER BY count DESC LIMIT 1`, nil)
+			if err != nil {
+				return nil, err
+			}
+			if record.Next() {
+				dimension, _ := record.Record().Get("dimension")
+				report.ExpectedDimension, _ = dimension.(int64)
+			}
+			if err := record.Err(); err != nil {
+				return nil, err
+			}
+		}
+		
+		// Chunks stored with --defer-embeddings are pending, not broken
+		record, err = runSingleWithParams(tx,
+			`MATCH (c:Chunk) WHERE coalesce(c.embedded, true)
+			 WITH size(coalesce(c.embedding, [])) AS dimension
+			 RETURN count(CASE WHEN dimension = 0 THEN 1 END) AS empty,
+			        count(CASE WHEN dimension > 0 AND $expected > 0 AND dimension <> $expected THEN 1 END) AS wrong`,
+			map[string]interface{}{"expected": report.ExpectedDimension})
+		if err != nil {
+			return nil, err
+		}
+		empty, _ := record.Get("empty")
+		wrong, _ := record.Get("wrong")
+		report.EmptyEmbeddings, _ = empty.(int64)
+		report.WrongDimension, _ = wrong.(int64)
+		return nil, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify the index: %w", err)
+	}
+	
+	if !opts.Fix || report.OK() {
+		return report, nil
+	}
+	if report.mostFilesMissing() && !opts.Force {
+		return report, ErrMostFilesMissing
+	}
+	
+	report.Fixed = true
+	params := map[string]interface{}{
+		"missing":  report.MissingFiles,
+		"empty":    report.EmptyFiles,
+		"expected": report.ExpectedDimension,
+	}
+	for _, query := range []string{
+		`MATCH (c:Chunk)-[:PART_OF]->(f:File) WHERE f.path IN $missing
+		 WITH c LIMIT $batchSize
+		 DETACH DELETE c
+		 RETURN count(*) AS deleted`,
+		`MATCH (f:File) WHERE f.path IN $missing OR f.path IN $empty
+		 WITH f LIMIT $batchSize
+		 DETACH DELETE f
+		 RETURN count(*) AS deleted`,
+		`MATCH (c:Chunk) WHERE NOT (c)-[:PART_OF]->(:File)
+		 WITH c LIMIT $batchSize
+		 DETACH DELETE c
+		 RETURN count(*) AS deleted`,
+	} {
+		deleted, err := r.deleteInBatches(query, params)
+		report.Deleted += deleted
+		if err != nil {
+			return report, err
+		}
+	}
+	
+	result, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		record, err := runSingleWithParams(tx,
+			`MATCH (c:Chunk) WHERE coalesce(c.embedded, true)
+			 WITH c, size(coalesce(c.embedding, [])) AS dimension
+			 WHERE dimension = 0 OR ($expected > 0 AND dimension <> $expected)
+			 SET c.embedding = null, c.embedding_dim = null, c.embedded = false
+			 RETURN count(c) AS reset`,
+			map[string]interface{}{"expected": report.ExpectedDimension})
+		if err != nil {
+			return nil, err
+		}
+		reset, _ := record.Get("reset")
+		return reset, nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to reset bad embeddings: %w", err)
+	}
+	report.ResetToEmbed, _ = result.(int64)
+	
+	return report, nil
+}
+
+// printVerifyReport prints the problems VerifyIndex found, listing up to
+// limit paths per problem
+func printVerifyReport(report *VerifyReport, limit int) {
+	printList := func(title string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		fmt.Printf("\n%s (%d):\n", title, len(items))
+		for i, item := range items {
+			if i == limit {
+				fmt.Printf("  ... %d more\n", len(items)-limit)
+				break
+			}
+			fmt.Printf("  %s\n", item)
+		}
+	}
+	
+	fmt.Println("Index verification")
+	fmt.Println("==================")
+	fmt.Printf("%-28s %d\n", "Files checked:", report.Files)
+	fmt.Printf("%-28s %d files, %d chunks\n", "Files missing from disk:", len(report.MissingFiles), report.MissingFileChunks)
+	fmt.Printf("%-28s %d\n", "Orphaned chunks:", report.OrphanedChunks)
+	fmt.Printf("%-28s %d\n", "Files without chunks:", len(report.EmptyFiles))
+	fmt.Printf("%-28s %d\n", "Empty embeddings:", report.EmptyEmbeddings)
+	if report.ExpectedDimension > 0 {
+		fmt.Printf("%-28s %d (expected %d)\n", "Wrong-dimension embeddings:", report.WrongDimension, report.ExpectedDimension)
+	} else {
+		fmt.Printf("%-28s not checked (no expected dimension)\n", "Wrong-dimension embeddings:")
+	}
+	
+	printList("Files missing from disk", report.MissingFiles)
+	printList("Files without chunks", report.EmptyFiles)
+	
+	switch {
+	case report.OK():
+		fmt.Println("\nNo problems found")
+	case report.Fixed:
+		fmt.Printf("\nFixed: deleted %d nodes, marked %d chunks for re-embedding (run --embed-pending)\n", report.Deleted, report.ResetToEmbed)
+	default:
+		fmt.Println("\nRun with --fix to remove the problems")
+	}
+}
+
+// Defaults for FindDuplicates
+const (
+	defaultDuplicateThreshold = 0.95
+	defaultDuplicateMinLines  = 5
+	
+	// duplicateNeighbors is how many nearest neighbors GDS KNN finds per
+	// chunk; near copies beyond that are only found through other copies
+	duplicateNeighbors = 10
+	
+	// pairwiseDuplicateWarning is the chunk count above which the all-pairs
+	// fallback, used without GDS, warns that it will be slow
+	pairwiseDuplicateWarning = 20000
+)
+
+// DuplicateOptions configures FindDuplicates
+type DuplicateOptions struct {
+	Threshold float64 // Lowest embedding similarity of two chunks counted as copies
+	MinLines  int     // Shortest chunk considered, in lines
+}
+
+// DuplicateReport is the result of FindDuplicates
+type DuplicateReport struct {
+	Threshold       float64            `json:"threshold"`
+	MinLines        int                `json:"min_lines"`
+	Method          string             `json:"method"`           // "gds.knn" or "pairwise"
+	DuplicateChunks int                `json:"duplicate_chunks"` // Chunks in any cluster
+	Clusters        []DuplicateCluster `json:"clusters"`         // Largest first
+}
+
+// DuplicateCluster is a group of chunks that are copies or near copies of
+// each other
+type DuplicateCluster struct {
+	Size           int                 `json:"size"`
+	Identical      bool                `json:"identical"`  // All chunks have the same content
+	Similarity     float64             `json:"similarity"` // Lowest similarity of the pairs joining the cluster
+	Representative DuplicateLocation   `json:"representative"`
+	Chunks         []DuplicateLocation `json:"chunks"`
+}
+
+// DuplicateLocation is where a chunk of a DuplicateCluster is
+type DuplicateLocation struct {
+	ID         string `json:"id"`
+	FilePath   string `json:"file_path"`
+	StartLine  int    `json:"start_line"`
+	EndLine    int    `json:"end_line"`
+	Name       string `json:"name,omitempty"`
+	EntityType string `json:"entity_type,omitempty"`
+}
+
+// duplicatePair is two chunks found to be copies, by ID
+type duplicatePair struct {
+	a, b       string
+	similarity float64
+}
+
+// FindDuplicates clusters chunks that are copies of each other: chunks with
+// the same content hash, and chunks whose embeddings are at least
+// opts.Threshold similar under Config.SimilarityMetric. Clusters are the
+// connected groups of such pairs, so a cluster's ends may be less similar
+// than the threshold. Only embeddings of the most common dimension are
+// compared.
+//
+// With the Graph Data Science library the nearest neighbors of each chunk
+// come from gds.knn, which scales to large indexes. Without it every pair
+// of chunks is compared in Go, which is only practical for small ones.
+func (r *Neo4jRAG) FindDuplicates(opts DuplicateOptions) (*DuplicateReport, error) {
+	report := &DuplicateReport{Threshold: opts.Threshold, MinLines: opts.MinLines, Clusters: []DuplicateCluster{}}
+	
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	params := map[string]interface{}{"threshold": opts.Threshold, "minLines": opts.MinLines}
+	
+	// Identical chunks need no embeddings
+	pairs := []duplicatePair{}
+	result, err := session.Run(
+		`MATCH (c:Chunk) WHERE c.hash IS NOT NULL AND c.end_line - c.start_line + 1 >= $minLines
+		 WITH c.hash AS hash, collect(c.id) AS ids WHERE size(ids) > 1
+		 RETURN ids`, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find identical chunks: %w", err)
+	}
+	for result.Next() {
+		value, _ := result.Record().Get("ids")
+		ids, _ := value.([]interface{})
+		for _, id := range ids[1:] {
+			pair := duplicatePair{similarity: 1}
+			pair.a, _ = ids[0].(string)
+			pair.b, _ = id.(string)
+			pairs = append(pairs, pair)
+		}
+	}
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find identical chunks: %w", err)
+	}
+	
+	record, err := session.Run(
+		`MATCH (c:Chunk) WHERE size(coalesce(c.embedding, [])) > 0
+		 RETURN size(c.embedding) AS dimension, count(c) AS count
+		 ORDER BY count DESC LIMIT 1`, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedding dimensions: %w", err)
+	}
+	if record.Next() {
+		dimension, _ := record.Record().Get("dimension")
+		params["dimension"] = dimension
+	}
+	if err := record.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read embedding dimensions: %w", err)
+	}
+	
+	if params["dimension"] != nil {
+		var similar []duplicatePair
+		if _, gdsErr := session.Run("CALL gds.list() YIELD name RETURN count(name) AS count", nil); gdsErr == nil {
+			report.Method = "gds.knn"
+			similar, err = r.knnDuplicatePairs(session, params)
+		} else {
+			r.logger.Warnf("Graph Data Science library not available, comparing all pairs of chunks: %v", gdsErr)
+			report.Method = "pairwise"
+			similar, err = r.pairwiseDuplicatePairs(session, params)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to find similar chunks: %w", err)
+		}
+		pairs = append(pairs, similar...)
+	}
+	
+	clusters := clusterDuplicatePairs(pairs)
+	ids := []string{}
+	for _, cluster := range clusters {
+		ids = append(ids, cluster.ids...)
+	}
+	locations, hashes, err := r.duplicateLocations(session, ids)
+	if err != nil {
+		return nil, err
+	}
+	
+	for _, cluster := range clusters {
+		duplicate := DuplicateCluster{Size: len(cluster.ids), Identical: true, Similarity: cluster.similarity}
+		for _, id := range cluster.ids {
+			duplicate.Chunks = append(duplicate.Chunks, locations[id])
+			if hashes[id] != hashes[cluster.ids[0]] {
+				duplicate.Identical = false
+			}
+		}
+		duplicate.Representative = locations[cluster.representative]
+		sort.Slice(duplicate.Chunks, func(i, j int) bool {
+			a, b := duplicate.Chunks[i], duplicate.Chunks[j]
+			if a.FilePath != b.FilePath {
+				return a.FilePath < b.FilePath
+			}
+			return a.StartLine < b.StartLine
+		})
+		report.Clusters = append(report.Clusters, duplicate)
+		report.DuplicateChunks += duplicate.Size
+	}
+	sort.SliceStable(report.Clusters, func(i, j int) bool {
+		a, b := report.Clusters[i], report.Clusters[j]
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Similarity > b.Similarity
+	})
+	
+	return report, nil
+}
+
+// knnDuplicatePairs finds chunk pairs at least $threshold similar with
+// gds.knn on a temporary in-memory graph of the chunks with $dimension
+// embeddings. KNN's own similarity scale differs per metric, so candidate
+// pairs are scored again with similarityExpr.
+func (r *Neo4jRAG) knnDuplicatePairs(session neo4j.Session, params map[string]interface{}) ([]duplicatePair, error) {
+	graphName := fmt.Sprintf("local-rag-duplicates-%d", time.Now().UnixNano())
+	params["graph"] = graphName
+	params["topK"] = duplicateNeighbors
+	
+	_, err := session.Run(
+		`CALL gds.graph.project.cypher($graph,
+		   'MATCH (c:Chunk) WHERE size(coalesce(c.embedding, [])) = $dimension AND c.end_line - c.start_line + 1 >= $minLines
+		    RETURN id(c) AS id, c.embedding AS embedding',
+		   'MATCH (c:Chunk) WHERE false RETURN id(c) AS source, id(c) AS target',
+		   {parameters: {dimension: $dimension, minLines: $minLines}})
+		 YIELD nodeCount
+		 RETURN nodeCount`, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to project chunks: %w", err)
+	}
+	defer func() {
+		if _, err := session.Run(`CALL gds.graph.drop($graph, false) YIELD graphName RETURN graphName`, params); err != nil {
+			r.logger.Warnf("failed to drop in-memory graph %s: %v", graphName, err)
+		}
+	}()
+	
+	// A chunk can be among another's nearest neighbors without the reverse
+	// holding, so each pair is ordered by ID rather than kept in only one
+	// direction, and pairs found both ways are reported once
+	result, err := session.Run(
+		`CALL gds.knn.stream($graph, {nodeProperties: ['embedding'], topK: $topK})
+		 YIELD node1, node2
+		 WITH gds.util.asNode(node1) AS n1, gds.util.asNode(node2) AS n2
+		 WITH CASE WHEN n1.id < n2.id THEN n1 ELSE n2 END AS a,
+		      CASE WHEN n1.id < n2.id THEN n2 ELSE n1 END AS b
+		 WITH DISTINCT a, b
+		 WITH a, b, `+similarityExpr(r.config.SimilarityMetric, "a.embedding", "b.embedding")+` AS similarity
+		 WHERE similarity >= $threshold
+		 RETURN DISTINCT a.id AS a, b.id AS b, similarity`, params)
+	if err != nil {
+		return nil, err
+	}
+	
+	pairs := []duplicatePair{}
+	for result.Next() {
+		record := result.Record()
+		a, _ := record.Get("a")
+		b, _ := record.Get("b")
+		similarity, _ := record.Get("similarity")
+		pair := duplicatePair{}
+		pair.similarity, _ = asFloat(similarity)
+		pair.a, _ = a.(string)
+		pair.b, _ = b.(string)
+		pairs = append(pairs, pair)
+	}
+	return pairs, result.Err()
+}
+
+// pairwiseDuplicatePairs finds chunk pairs at least $threshold similar by
+// comparing every pair of chunks with $dimension embeddings in Go
+func (r *Neo4jRAG) pairwiseDuplicatePairs(session neo4j.Session, params map[string]interface{}) ([]duplicatePair, error) {
+	result, err := session.Run(
+		`MATCH (c:Chunk) WHERE size(coalesce(c.embedding, [])) = $dimension AND c.end_line - c.start_line + 1 >= $minLines
+		 RETURN c.id AS id, c.embedding AS embedding`, params)
+	if err != nil {
+		return nil, err
+	}
+	
+	ids := []string{}
+	embeddings := [][]float32{}
+	for result.Next() {
+		id, _ := result.Record().Get("id")
+		embedding, _ := result.Record().Get("embedding")
+		idStr, _ := id.(string)
+		ids = append(ids, idStr)
+		embeddings = append(embeddings, toFloat32Slice(embedding))
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) > pairwiseDuplicateWarning {
+		r.logger.Warnf("comparing all pairs of %d chunks; this may take a long time (install the Graph Data Science library to use KNN)", len(ids))
+	}
+	
+	threshold, _ := params["threshold"].(float64)
+	pairs := []duplicatePair{}
+	for i := range embeddings {
+		for j := i + 1; j < len(embeddings); j++ {
+			similarity, ok := vectorSimilarity(r.config.SimilarityMetric, embeddings[i], embeddings[j])
+			if ok && similarity >= threshold {
+				pairs = append(pairs, duplicatePair{a: ids[i], b: ids[j], similarity: similarity})
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// duplicateIDCluster is a connected group of duplicate pairs, by chunk ID
+type duplicateIDCluster struct {
+	ids            []string
+	representative string  // The chunk with the most pairs
+	similarity     float64 // Lowest similarity of the pairs
+}
+
+// clusterDuplicatePairs joins pairs sharing a chunk into clusters. Clusters
+// and their IDs are sorted for stable output.
+func clusterDuplicatePairs(pairs []duplicatePair) []duplicateIDCluster {
+	parent := map[string]string{}
+	var find func(id string) string
+	find = func(id string) string {
+		if parent[id] == "" || parent[id] == id {
+			parent[id] = id
+			return id
+		}
+		root := find(parent[id])
+		parent[id] = root
+		return root
+	}
+	
+	degree := map[string]int{}
+	for _, pair := range pairs {
+		if pair.a == pair.b {
+			continue
+		}
+		degree[pair.a]++
+		degree[pair.b]++
+		if rootA, rootB := find(pair.a), find(pair.b); rootA != rootB {
+			parent[rootB] = rootA
+		}
+	}
+	
+	byRoot := map[string]*duplicateIDCluster{}
+	roots := []string{}
+	for _, pair := range pairs {
+		if pair.a == pair.b {
+			continue
+		}
+		root := find(pair.a)
+		cluster, ok := byRoot[root]
+		if !ok {
+			cluster = &duplicateIDCluster{similarity: pair.similarity}
+			byRoot[root] = cluster
+			roots = append(roots, root)
+		}
+		if pair.similarity < cluster.similarity {
+			cluster.similarity = pair.similarity
+		}
+	}
+	for id := range degree {
+		cluster := byRoot[find(id)]
+		cluster.ids = append(cluster.ids, id)
+	}
+	
+	sort.Strings(roots)
+	clusters := make([]duplicateIDCluster, 0, len(roots))
+	for _, root := range roots {
+		cluster := byRoot[root]
+		sort.Strings(cluster.ids)
+		cluster.representative = cluster.ids[0]
+		for _, id := range cluster.ids {
+			if degree[id] > degree[cluster.representative] {
+				cluster.representative = id
+			}
+		}
+		clusters = append(clusters, *cluster)
+	}
+	return clusters
+}
+
+// duplicateLocations fetches the location and content hash of each chunk
+// ID
+func (r *Neo4jRAG) duplicateLocations(session neo4j.Session, ids []string) (map[string]DuplicateLocation, map[string]string, error) {
+	locations := map[string]DuplicateLocation{}
+	hashes := map[string]string{}
+	if len(ids) == 0 {
+		return locations, hashes, nil
+	}
+	
+	result, err := session.Run(
+		`MATCH (c:Chunk) WHERE c.id IN $ids
+		 RETURN c.id AS id, c.file_path AS filePath, c.start_line AS startLine, c.end_line AS endLine,
+		        c.name AS name, c.entity_type AS entityType, c.hash AS hash`,
+		map[string]interface{}{"ids": ids})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read duplicate chunk locations: %w", err)
+	}
+	for result.Next() {
+		record := result.Record()
+		var location DuplicateLocation
+		id, _ := record.Get("id")
+		filePath, _ := record.Get("filePath")
+		startLine, _ := record.Get("startLine")
+		endLine, _ := record.Get("endLine")
+		name, _ := record.Get("name")
+		entityType, _ := record.Get("entityType")
+		hash, _ := record.Get("hash")
+		location.ID, _ = id.(string)
+		location.FilePath, _ = filePath.(string)
+		location.StartLine, _ = asInt(startLine)
+		location.EndLine, _ = asInt(endLine)
+		location.Name, _ = name.(string)
+		location.EntityType, _ = entityType.(string)
+		locations[location.ID] = location
+		hashes[location.ID], _ = hash.(string)
+	}
+	if err := result.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read duplicate chunk locations: %w", err)
+	}
+	return locations, hashes, nil
+}
+
+// printDuplicateReport prints the clusters FindDuplicates found, up to
+// limit of them
+func printDuplicateReport(report *DuplicateReport, limit int) {
+	fmt.Println("Duplicate code")
+	fmt.Println("==============")
+	fmt.Printf("%-20s %.2f (%s)\n", "Similarity:", report.Threshold, report.Method)
+	fmt.Printf("%-20s %d\n", "Minimum lines:", report.MinLines)
+	fmt.Printf("%-20s %d\n", "Clusters:", len(report.Clusters))
+	fmt.Printf("%-20s %d\n", "Duplicate chunks:", report.DuplicateChunks)
+	
+	for i, cluster := range report.Clusters {
+		if i == limit {
+			fmt.Printf("\n... %d more clusters\n", len(report.Clusters)-limit)
+			break
+		}
+		kind := "identical"
+		if !cluster.Identical {
+			kind = fmt.Sprintf("similarity >= %.3f", cluster.Similarity)
+		}
+		fmt.Printf("\nCluster %d: %d chunks, %s\n", i+1, cluster.Size, kind)
+		for _, location := range cluster.Chunks {
+			marker := " "
+			if location.ID == cluster.Representative.ID {
+				marker = "*"
+			}
+			fmt.Printf(" %s %s:%d-%d", marker, location.FilePath, location.StartLine, location.EndLine)
+			if location.Name != "" {
+				fmt.Printf(" (%s %s)", location.EntityType, location.Name)
+			}
+			fmt.Println()
+		}
+	}
+}
+
+// LogQuery records a query with the chunks returned for it and the LLM
+// answer (empty when none was generated) as a (:Query) node. It does nothing
+// unless Config.LogQueries is set. Query nodes are kept by Reset, so the
+// history survives re-indexing.
+func (r *Neo4jRAG) LogQuery(query string, chunks []CodeChunk, answer string) error {
+	if !r.config.LogQueries {
+		return nil
+	}
+	
+	chunkIDs := make([]string, len(chunks))
+	scores := make([]float64, len(chunks))
+	for i, chunk := range chunks {
+		chunkIDs[i] = chunk.ID
+		scores[i] = chunk.Score
+	}
+	
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	_, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		_, err := tx.Run(
+			`CREATE (:Query {text: $text, normalized: $normalized, timestamp: datetime(),
+			                 chunk_ids: $chunkIDs, scores: $scores, answer: $answer})`,
+			map[string]interface{}{
+				"text":       query,
+				"normalized": normalizeQueryText(query),
+				"chunkIDs":   chunkIDs,
+				"scores":     scores,
+				"answer":     answer,
+			},
+		)
+		return nil, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to log query: %w", err)
+	}
+	return nil
+}
+
+// normalizeQueryText lowercases a query and collapses its whitespace, so
+// QueryStats counts trivially different spellings of a query together
+func normalizeQueryText(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// QueryStats summarizes the queries recorded by LogQuery
+type QueryStats struct {
+	TotalQueries    int64        `json:"total_queries"`
+	TopQueries      []QueryCount `json:"top_queries"`       // Most frequent queries
+	NoResultQueries []QueryCount `json:"no_result_queries"` // Most frequent queries that found nothing
+	TopChunks       []ChunkCount `json:"top_chunks"`        // Chunks returned most often
+}
+
+// QueryCount is how often a normalized query was asked
+type QueryCount struct {
+	Query     string `json:"query"`
+	Count     int64  `json:"count"`
+	LastAsked string `json:"last_asked"`
+}
+
+// ChunkCount is how often a chunk was returned. The location fields are
+// empty when the chunk has since been removed from the index.
+type ChunkCount struct {
+	ChunkID   string  `json:"chunk_id"`
+	Count     int64   `json:"count"`
+	AvgScore  float64 `json:"avg_score"`
+	FilePath  string  `json:"file_path,omitempty"`
+	StartLine int     `json:"start_line,omitempty"`
+	EndLine   int     `json:"end_line,omitempty"`
+}
+
+// QueryStats reports the limit most frequent queries, the most frequent
+// queries that returned no chunks (gaps in the index) and the chunks
+// returned most often
+func (r *Neo4jRAG) QueryStats(limit int) (*QueryStats, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		stats := &QueryStats{TopQueries: []QueryCount{}, NoResultQueries: []QueryCount{}, TopChunks: []ChunkCount{}}
+		params := map[string]interface{}{"limit": limit}
+		
+		record, err := runSingle(tx, `MATCH (q:Query) RETURN count(q) AS total`)
+		if err != nil {
+			return nil, err
+		}
+		total, _ := record.Get("total")
+		stats.TotalQueries, _ = total.(int64)
+		
+		queryGroups := []struct {
+			filter string
+			counts *[]QueryCount
+		}{
+			{``, &stats.TopQueries},
+			{`WHERE size(q.chunk_ids) = 0`, &stats.NoResultQueries},
+		}
+		for _, group := range queryGroups {
+			queryResult, err := tx.Run(
+				`MATCH (q:Query) `+group.filter+`
+				 RETURN q.normalized AS query, count(q) AS count, toString(max(q.timestamp)) AS lastAsked
+				 ORDER BY count DESC, lastAsked DESC
+				 LIMIT $limit`, params)
+			if err != nil {
+				return nil, err
+			}
+			for queryResult.Next() {
+				record := queryResult.Record()
+				query, _ := record.Get("query")
+				count, _ := record.Get("count")
+				lastAsked, _ := record.Get("lastAsked")
+				entry := QueryCount{}
+				entry.Query, _ = asString(query)
+				entry.Count, _ = count.(int64)
+				entry.LastAsked, _ = asString(lastAsked)
+				*group.counts = append(*group.counts, entry)
+			}
+			if err := queryResult.Err(); err != nil {
+				return nil, err
+			}
+		}
+		
+		chunkResult, err := tx.Run(
+			`MATCH (q:Query)
+			 UNWIND range(0, size(q.chunk_ids) - 1) AS i
+			 WITH q.chunk_ids[i] AS chunkID, q.scores[i] AS score
+			 WITH chunkID, count(*) AS count, avg(score) AS avgScore
+			 ORDER BY count DESC, chunkID
+			 LIMIT $limit
+			 OPTIONAL MATCH (c:Chunk {id: chunkID})
+			 RETURN chunkID, count, avgScore, c.file_path AS filePath,
+			        c.start_line AS startLine, c.end_line AS endLine
+			 ORDER BY count DESC, chunkID`, params)
+		if err != nil {
+			return nil, err
+		}
+		for chunkResult.Next() {
+			record := chunkResult.Record()
+			chunkID, _ := record.Get("chunkID")
+			count, _ := record.Get("count")
+			avgScore, _ := record.Get("avgScore")
+			filePath, _ := record.Get("filePath")
+			startLine, _ := record.Get("startLine")
+			endLine, _ := record.Get("endLine")
+			entry := ChunkCount{}
+			entry.ChunkID, _ = asString(chunkID)
+			entry.Count, _ = count.(int64)
+			entry.AvgScore, _ = asFloat(avgScore)
+			entry.FilePath, _ = asString(filePath)
+			entry.StartLine, _ = asInt(startLine)
+			entry.EndLine, _ = asInt(endLine)
+			stats.TopChunks = append(stats.TopChunks, entry)
+		}
+		if err := chunkResult.Err(); err != nil {
+			return nil, err
+		}
+		
+		return stats, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect query stats: %w", err)
+	}
+	
+	return result.(*QueryStats), nil
+}
+
+// printQueryStats prints query statistics as plain-text tables
+func printQueryStats(stats *QueryStats) {
+	fmt.Println("Query statistics")
+	fmt.Println("================")
+	fmt.Printf("%-22s %d\n", "Queries logged:", stats.TotalQueries)
+	
+	for _, group := range []struct {
+		title  string
+		counts []QueryCount
+	}{
+		{"Most frequent queries", stats.TopQueries},
+		{"Most frequent queries without results", stats.NoResultQueries},
+	} {
+		fmt.Printf("\n%s:\n", group.title)
+		if len(group.counts) == 0 {
+			fmt.Println("  (none)")
+		}
+		for _, entry := range group.counts {
+			fmt.Printf("  %-60s %8d  (last %s)\n", entry.Query, entry.Count, entry.LastAsked)
+		}
+	}
+	
+	fmt.Println("\nMost retrieved chunks:")
+	if len(stats.TopChunks) == 0 {
+		fmt.Println("  (none)")
+	}
+	for _, entry := range stats.TopChunks {
+		location := "(no longer indexed)"
+		if entry.FilePath != "" {
+			location = fmt.Sprintf("%s:%d-%d", entry.FilePath, entry.StartLine, entry.EndLine)
+		}
+		fmt.Printf("  %-60s %8d  (avg score %.3f)\n", location, entry.Count, entry.AvgScore)
+	}
+}
+
+// storeChunks stores chunks in Neo4j
+func (r *Neo4jRAG) storeChunks(ctx context.Context, chunks []CodeChunk, filePath, projectPath string) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	// The driver does not take a context, so a deadline becomes a server-side
+	// transaction timeout
+	var txConfig []func(*neo4j.TransactionConfig)
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return ctx.Err()
+		}
+		txConfig = append(txConfig, neo4j.WithTxTimeout(remaining))
+	}
+	
+	// Paths are stored as they were walked, so record where a relative
+	// project path was relative to for reading its files later
+	projectRoot, absErr := filepath.Abs(projectPath)
+	if absErr != nil {
+		projectRoot = projectPath
+	}
+	
+	// Name the project after its manifest when it declares a name
+	project := readProjectInfo(projectPath)
+	projectName := project.name
+	if projectName == "" {
+		projectName = filepath.Base(projectPath)
+	}
+	
+	// Create a transaction
+	start := time.Now()
+	stored := 0
+	_, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		// Create/merge project node
+		_, err := tx.Run(
+			`MERGE (p:Project {path: $projectPath}) 
+			 ON CREATE SET p.created_at = datetime()
+			 ON MATCH SET p.updated_at = datetime()
+			 SET p.name = $projectName,
+			     p.version = $projectVersion,
+			     p.manifest = $projectManifest,
+			     p.root = $projectRoot`,
+			map[string]interface{}{
+				"projectPath":     projectPath,
+				"projectRoot":     projectRoot,
+				"projectName":     projectName,
+				"projectVersion":  stringParam(project.version),
+				"projectManifest": stringParam(project.manifest),
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		
+		// Create/merge file node, recording the revision it was indexed at
+		// (null outside git repositories), and link it to its project only
+		rev := gitRevisionOf(filepath.Dir(filePath))
+		_, err = tx.Run(
+			`MERGE (f:File {path: $filePath}) 
+			 ON CREATE SET f.created_at = datetime(),
+			               f.name = $fileName,
+			               f.language = $language
+			 ON MATCH SET f.updated_at = datetime()
+			 SET f.commit = $commit,
+			     f.branch = $branch
+			 WITH f
+			 // A file moves when the project layout changes
+			 OPTIONAL MATCH (f)-[old:BELONGS_TO]->(other:Project)
+			 WHERE other.path <> $projectPath
+			 DELETE old
+			 WITH DISTINCT f
+			 MATCH (p:Project {path: $projectPath})
+			 MERGE (f)-[:BELONGS_TO]->(p)`,
+			map[string]interface{}{
+				"filePath":    filePath,
+				"fileName":    filepath.Base(filePath),
+				"language":    r.languageForFile(filePath),
+				"projectPath": projectPath,
+				"commit":      stringParam(rev.commit),
+				"branch":      stringParam(rev.branch),
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		
+		// Upsert all chunks in one round trip. A stored chunk is left alone
+		// when its content and chunker version are unchanged, unless it is
+		// pending and we now have its embedding, ensemble mode was turned
+		// on after it was indexed, or its content is now stored or omitted.
+		rows := make([]map[string]interface{}, len(chunks))
+		for i, chunk := range chunks {
+			var content interface{}
+			if !r.config.OmitContent {
+				content = chunk.Content
+			}
+			rows[i] = map[string]interface{}{
+				"id":             chunk.ID,
+				"content":        content,
+				"contentSize":    len(chunk.Content),
+				"filePath":       chunk.FilePath,
+				"startLine":      chunk.StartLine,
+				"endLine":        chunk.EndLine,
+				"startByte":      chunk.StartByte,
+				"endByte":        chunk.EndByte,
+				"entityType":     chunk.EntityType,
+				"name":           chunk.Name,
+				"signature":      chunk.Signature,
+				"language":       chunk.Language,
+				"hash":           chunk.Hash,
+				"embedding":      vectorParam(chunk.Embedding),
+				"embedding2":     vectorParam(chunk.Embedding2),
+				"projectPath":    chunk.ProjectPath,
+				"chunkerVersion": chunkerVersion,
+				"isVendored":     chunk.IsVendored,
+				"embedded":       len(chunk.Embedding) > 0,
+			}
+		}
+		
+		result, err := tx.Run(
+			`UNWIND $rows AS row
+			 MERGE (c:Chunk {id: row.id})
+			 ON CREATE SET c.created_at = datetime()
+			 WITH c, row
+			 WHERE coalesce(c.hash, '') <> row.hash
+			    OR coalesce(c.chunker_version, 0) <> row.chunkerVersion
+			    OR (c.embedded = false AND row.embedded)
+			    OR (c.embedding2 IS NULL AND row.embedding2 IS NOT NULL)
+			    OR (c.content IS NULL) <> (row.content IS NULL)
+			 SET c.content = row.content,
+			     c.content_size = row.contentSize,
+			     c.file_path = row.filePath,
+			     c.project_path = row.projectPath,
+			     c.start_line = row.startLine,
+			     c.end_line = row.endLine,
+			     c.start_byte = row.startByte,
+			     c.end_byte = row.endByte,
+			     c.entity_type = row.entityType,
+			     c.name = row.name,
+			     c.signature = row.signature,
+			     c.language = row.language,
+			     c.hash = row.hash,
+			     c.embedding = row.embedding,
+			     c.embedding2 = row.embedding2,
+			     c.embedding_dim = size(row.embedding),
+			     c.embedded = row.embedded,
+			     c.chunker_version = row.chunkerVersion,
+			     c.is_vendored = row.isVendored,
+			     c.updated_at = datetime()
+			 WITH c, row
+			 MATCH (f:File {path: row.filePath})
+			 MERGE (c)-[:PART_OF]->(f)
+			 RETURN c.id AS id`,
+			map[string]interface{}{"rows": rows},
+		)
+		if err != nil {
+			return nil, err
+		}
+		rewritten := map[string]bool{}
+		for result.Next() {
+			id, _ := result.Record().Get("id")
+			if idStr, ok := asString(id); ok {
+				rewritten[idStr] = true
+			}
+		}
+		if err := result.Err(); err != nil {
+			return nil, err
+		}
+		stored = len(rewritten)
+		
+		if err := storeChunkReferences(tx, chunks, rewritten); err != nil {
+			return nil, err
+		}
+		
+		// Drop chunks left over from an earlier, longer version of the file
+		ids := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			ids[i] = chunk.ID
+		}
+		_, err = tx.Run(
+			`MATCH (c:Chunk)-[:PART_OF]->(f:File {path: $filePath})
+			 WHERE NOT c.id IN $ids
+			 DETACH DELETE c`,
+			map[string]interface{}{"filePath": filePath, "ids": ids},
+		)
+		return nil, err
+	}, txConfig...)
+	if err != nil {
+		return err
+	}
+	
+	r.logger.Debugf("Stored %d of %d chunks of %s in %v", stored, len(chunks), filePath, time.Since(start))
+	return nil
+}
+
+// storeChunkReferences replaces the symbol graph edges of the Go chunks
+// among chunks: (:Chunk)-[:CALLS]->(:Symbol) for the functions a chunk
+// calls, (:Chunk)-[:IMPORTS]->(:Package) for the imported packages it uses
+// and (:Chunk)-[:DEFINES]->(:Symbol) for the function or method it
+// declares. Symbols are matched by name within a project, so same-named
+// functions in unrelated projects are not linked, while methods of
+// different types that share a name share a symbol. Chunks whose edges are
+// already stored are skipped unless their ID is in rewritten, so chunks
+// indexed before the symbol graph existed get edges without being
+// re-embedded.
+func storeChunkReferences(tx neo4j.Transaction, chunks []CodeChunk, rewritten map[string]bool) error {
+	rows := []map[string]interface{}{}
+	for _, chunk := range chunks {
+		if chunk.Language != "Go" {
+			continue
+		}
+		
+		// Calls only record the method name, so (*T).Method defines Method
+		defines := []string{}
+		if (chunk.EntityType == "function" || chunk.EntityType == "method") && chunk.Name != "" {
+			defines = append(defines, chunk.Name[strings.LastIndex(chunk.Name, ".")+1:])
+		}
+		calls := chunk.Calls
+		if calls == nil {
+			calls = []string{}
+		}
+		imports := chunk.Imports
+		if imports == nil {
+			imports = []string{}
+		}
+		rows = append(rows, map[string]interface{}{
+			"id":          chunk.ID,
+			"projectPath": chunk.ProjectPath,
+			"calls":       calls,
+			"imports":     imports,
+			"defines":     defines,
+			"rewritten":   rewritten[chunk.ID],
+		})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	
+	_, err := tx.Run(
+		`UNWIND $rows AS row
+		 MATCH (c:Chunk {id: row.id})
+		 WHERE row.rewritten OR coalesce(c.references_indexed, false) <> true
+		 OPTIONAL MATCH (c)-[old:CALLS|IMPORTS|DEFINES]->()
+		 DELETE old
+		 WITH DISTINCT c, row
+		 SET c.references_indexed = true
+		 FOREACH (name IN row.calls |
+		     MERGE (s:Symbol {name: name, project_path: row.projectPath})
+		     MERGE (c)-[:CALLS]->(s))
+		 FOREACH (importPath IN row.imports |
+		     MERGE (p:Package {path: importPath})
+		     MERGE (c)-[:IMPORTS]->(p))
+		 FOREACH (name IN row.defines |
+		     MERGE (s:Symbol {name: name, project_path: row.projectPath})
+		     MERGE (c)-[:DEFINES]->(s))`,
+		map[string]interface{}{"rows": rows},
+	)
+	return err
+}
+
+// RelatedChunks returns the chunks defining the functions the given chunk
+// calls, found through the symbol graph, ranked by how many of its calls
+// each one defines. Only Go chunks have symbol edges.
+func (r *Neo4jRAG) RelatedChunks(chunkID string) ([]CodeChunk, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	result, err := session.Run(
+		`MATCH (c:Chunk {id: $id})-[:CALLS]->(s:Symbol)<-[:DEFINES]-(d:Chunk)
+		 WHERE d.id <> c.id
+		 RETURN d.id AS id, count(DISTINCT s) AS shared
+		 ORDER BY shared DESC, id`,
+		map[string]interface{}{"id": chunkID},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find related chunks: %w", err)
+	}
+	
+	var ranked []scoredChunk
+	for result.Next() {
+		record := result.Record()
+		id, _ := record.Get("id")
+		shared, _ := record.Get("shared")
+		chunkID, idOK := asString(id)
+		count, _ := asFloat(shared)
+		if idOK {
+			ranked = append(ranked, scoredChunk{id: chunkID, score: count})
+		}
+	}
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find related chunks: %w", err)
+	}
+	
+	return r.fetchChunksByID(session, ranked)
+}
+
+// RemoveFile deletes a file and its chunks from the index
+func (r *Neo4jRAG) RemoveFile(filePath string) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	_, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		_, err := tx.Run(
+			`MATCH (f:File {path: $filePath})
+			 OPTIONAL MATCH (c:Chunk)-[:PART_OF]->(f)
+			 DETACH DELETE c, f`,
+			map[string]interface{}{"filePath": filePath},
+		)
+		return nil, err
+	})
+	
+	return err
+}
+
+// SearchCode searches for code using vector similarity with the default
+// search options: a 0.1 minimum score, no filters and no keyword pre-filter
+func (r *Neo4jRAG) SearchCode(query string, limit int) ([]CodeChunk, error) {
+	return r.SearchCodeWithOptions(query, SearchOptions{
+		Limit:    limit,
+		MinScore: 0.1,
+	})
+}
+
+// SearchOptions holds the filters and limits for SearchCodeWithOptions
+type SearchOptions struct {
+	Limit        int        // Maximum number of results
+	Offset       int        // Number of top-ranked results to skip, for paging
+	Languages    []string   // Only return chunks in these languages
+	PathFilters  []string   // Glob patterns; a chunk's file path must match one
+	MinScore     float64    // Minimum similarity score
+	UseKeywords  bool       // Pre-filter chunks by query keywords
+	Keywords     []string   // Extra pre-filter keywords, used regardless of length
+	ExcludeFiles []string   // Exact file paths to exclude
+	ScoreBand    *ScoreBand // Return chunks whose similarity lies in this band instead of thresholding
+	EntityTypes  []string   // Only return chunks of these entity types (e.g. "function", "method")
+
+	// ProjectPaths restricts results to chunks of these projects
+	ProjectPaths []string
+
+	// IncludeVendored includes chunks tagged as third-party dependency code,
+	// which are left out of results by default
+	IncludeVendored bool
+
+	// Fuse retrieves with both ensemble embedding models and merges the
+	// rankings with reciprocal rank fusion (requires Config.EnsembleEmbeddingURL)
+	Fuse bool
+
+	// Explain fills in each result's Breakdown
+	Explain bool
+
+	// Definitions favors function, method and class chunks, for questions
+	// about how something is implemented
+	Definitions bool
+
+	// UpdatedAfter and UpdatedBefore restrict results to chunks last
+	// indexed at or after, and before, the given times; zero times are
+	// ignored
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+
+	// Highlight fills in each result's MatchedKeywords so previews can
+	// highlight them
+	Highlight bool
+
+	// Similar treats the query as a code snippet to find similar code to.
+	// It is ranked by vector similarity alone, without query keywords, and
+	// chunks with exactly the snippet's content are left out.
+	Similar bool
+}
+
+// SearchCodeWithOptions searches for code with the filtering options in opts.
+//
+// Ranking is a hybrid of vector similarity and keyword matching:
+//
+//	keywordScore = avg over keywords k of tf(k) / (tf(k) + 1.2)
+//	baseScore    = alpha * similarity + (1 - alpha) * keywordScore
+//
+// where tf(k) is the number of case-insensitive occurrences of k in the chunk,
+// similarity is computed with Config.SimilarityMetric and alpha is
+// 1 - Config.KeywordWeight. The entity and size boosts are then added
+// to baseScore to produce the final score.
+func (r *Neo4jRAG) SearchCodeWithOptions(query string, opts SearchOptions) ([]CodeChunk, error) {
+	// Generate embedding for query
+	r.debugf("Generating embedding for query...\n")
+	embeddings, err := r.getEmbeddings(context.Background(), []string{query})
+	if err != nil {
+		r.debugf("Error generating embedding: %v\n", err)
+		return nil, fmt.Errorf("failed to generate query embedding: %w", err)
+	}
+	
+	if len(embeddings) == 0 || len(embeddings[0]) == 0 {
+		r.debugf("Received empty embedding for query\n")
+		return nil, fmt.Errorf("received empty embedding for query")
+	}
+	
+	r.debugf("Embedding generated successfully, length: %d\n", len(embeddings[0]))
+	queryEmbedding := embeddings[0]
+	
+	search := func(opts SearchOptions) ([]CodeChunk, error) {
+		if opts.Fuse {
+			return r.searchFused(query, queryEmbedding, opts)
+		}
+		return r.searchWithEmbedding(query, queryEmbedding, "embedding", opts)
+	}
+	chunks, err := search(opts)
+	
+	// Rather than come back empty, retry once with the lower threshold.
+	// Score bands set their own bounds, and later pages are empty because
+	// the results ran out.
+	floor := r.config.RetryMinScore
+	if err == nil && len(chunks) == 0 && floor > 0 && floor < opts.MinScore && opts.ScoreBand == nil && opts.Offset == 0 {
+		r.logger.Printf("No results above minimum score %.2f, retrying with %.2f\n", opts.MinScore, floor)
+		opts.MinScore = floor
+		chunks, err = search(opts)
+	}
+	return chunks, err
+}
+
+// CheckEmbeddingDimension compares the dimension of the configured embedding
+// service with the embedding dimensions stored in the index. It returns an
+// error explaining how to fix a mismatch, since searches skip chunks whose
+// dimension differs from the query embedding.
+func (r *Neo4jRAG) CheckEmbeddingDimension() error {
+	probe, err := r.getEmbeddings(context.Background(), []string{"dimension probe"})
+	if err != nil {
+		return fmt.Errorf("failed to get a probe embedding: %w", err)
+	}
+	if len(probe) == 0 || len(probe[0]) == 0 {
+		return fmt.Errorf("embedding service returned an empty probe embedding")
+	}
+	expected := int64(len(probe[0]))
+	
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	// Chunks stored before embedding_dim was recorded are sampled directly
+	result, err := session.Run(
+		`CALL {
+		   MATCH (c:Chunk) WHERE c.embedding_dim IS NOT NULL
+		   RETURN DISTINCT c.embedding_dim AS dimension
+		   UNION
+		   MATCH (c:Chunk) WHERE c.embedding_dim IS NULL AND c.embedding IS NOT NULL
+		   WITH c LIMIT 1
+		   RETURN size(c.embedding) AS dimension
+		 }
+		 RETURN dimension`,
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to read stored embedding dimensions: %w", err)
+	}
+	
+	mismatched := []int64{}
+	for result.Next() {
+		value, _ := result.Record().Get("dimension")
+		if dimension, ok := value.(int64); ok && dimension != expected {
+			mismatched = append(mismatched, dimension)
+		}
+	}
+	if err := result.Err(); err != nil {
+		return fmt.Errorf("failed to read stored embedding dimensions: %w", err)
+	}
+	
+	if len(mismatched) > 0 {
+		return fmt.Errorf("the index contains %v-dimensional embeddings but the embedding service produces %d dimensions; "+
+			"those chunks are skipped by search. Re-index them with the current model (e.g. --reset followed by --index)", mismatched, expected)
+	}
+	
+	return nil
+}
+
+// chunkCommitExpr and chunkBranchExpr are the Cypher expressions for the git
+// revision a chunk's file was indexed at
+const (
+	chunkCommitExpr = `head([(c)-[:PART_OF]->(src:File) | src.commit])`
+	chunkBranchExpr = `head([(c)-[:PART_OF]->(src:File) | src.branch])`
+)
+
+// chunkNameFormsExpr is the Cypher list of lowercased forms a chunk's name is
+// matched against query terms by: the name itself, a Go method name like
+// (*T).Method as T.Method, and a qualified name's part after the last "." or
+// "::", so Method finds methods of any type
+const chunkNameFormsExpr = `[toLower(coalesce(c.name, '')), toLower(replace(replace(coalesce(c.name, ''), '(*', ''), ')', '')), toLower(last(split(replace(coalesce(c.name, ''), '::', '.'), '.')))]`
+
+// chunkProjectPathExpr is the Cypher expression for a chunk's project path.
+// Chunks stored before project_path was recorded fall back to the project
+// their file belongs to.
+const chunkProjectPathExpr = `coalesce(c.project_path, head([(c)-[:PART_OF]->(:File)-[:BELONGS_TO]->(owner:Project) | owner.path]))`
+
+// chunkSizeExpr is the Cypher expression for a chunk's content size in
+// bytes. Chunks stored before content_size was recorded always have their
+// content.
+const chunkSizeExpr = `coalesce(c.content_size, size(c.content))`
+
+// projectRoots returns the absolute directory of each project, keyed by
+// project path. Projects indexed before the directory was recorded are
+// missing.
+func (r *Neo4jRAG) projectRoots() (map[string]string, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	result, err := session.Run(
+		`MATCH (p:Project)
+		 WHERE p.root IS NOT NULL
+		 RETURN p.path AS path, p.root AS root`,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	
+	roots := map[string]string{}
+	for result.Next() {
+		record := result.Record()
+		path, _ := record.Get("path")
+		root, _ := record.Get("root")
+		projectPath, pathOK := asString(path)
+		projectRoot, rootOK := asString(root)
+		if pathOK && rootOK {
+			roots[projectPath] = projectRoot
+		}
+	}
+	return roots, result.Err()
+}
+
+// resolveIndexedPath returns where the indexed file filePath is on disk.
+// Relative paths are relative to the directory indexing ran in, so they are
+// resolved through the innermost project containing them, using roots from
+// projectRoots; paths outside any known project are returned unchanged.
+func resolveIndexedPath(filePath string, roots map[string]string) string {
+	if filepath.IsAbs(filePath) {
+		return filePath
+	}
+	
+	resolved := filePath
+	best := ""
+	for projectPath, root := range roots {
+		rel, err := filepath.Rel(projectPath, filePath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if best == "" || len(rel) < len(best) {
+			best = rel
+			resolved = filepath.Join(root, rel)
+		}
+	}
+	return resolved
+}
+
+// chunkContentLoader fills in the content of chunks stored without it (see
+// Config.OmitContent) from their files, reading each file once
+type chunkContentLoader struct {
+	rag   *Neo4jRAG
+	roots map[string]string // From projectRoots; nil until first needed
+	files map[string][]byte // nil for files that could not be read
+}
+
+func newChunkContentLoader(r *Neo4jRAG) *chunkContentLoader {
+	return &chunkContentLoader{rag: r, files: map[string][]byte{}}
+}
+
+// load sets chunk.Content to the chunk's byte range of its file, with the
+// line endings normalized as the chunkers do, when the content was not
+// stored. Stored content is kept, and chunks whose file is gone or shorter
+// than the range keep their empty content.
+func (l *chunkContentLoader) load(chunk *CodeChunk) {
+	if chunk.Content != "" || chunk.FilePath == "" {
+		return
+	}
+	data, read := l.files[chunk.FilePath]
+	if !read {
+		if l.roots == nil {
+			roots, err := l.rag.projectRoots()
+			if err != nil {
+				l.rag.logger.Warnf("cannot look up project directories, reading files relative to the current directory: %v", err)
+				roots = map[string]string{}
+			}
+			l.roots = roots
+		}
+		
+		var err error
+		data, err = ioutil.ReadFile(resolveIndexedPath(chunk.FilePath, l.roots))
+		if err != nil {
+			l.rag.logger.Warnf("cannot read the content of chunks in %s: %v", chunk.FilePath, err)
+			data = nil
+		}
+		l.files[chunk.FilePath] = data
+	}
+	if chunk.StartByte < 0 || chunk.EndByte <= chunk.StartByte || chunk.EndByte > len(data) {
+		return
+	}
+	chunk.Content = normalizeLineEndings(string(data[chunk.StartByte:chunk.EndByte]))
+}
+
+// defaultMinKeywordLength is the shortest query term used by the keyword
+// pre-filter when Config.MinKeywordLength is unset
+const defaultMinKeywordLength = 4
+
+// keywordFilterParams returns the query parameters for the keyword
+// pre-filter, keyed by parameter name. Query terms shorter than minLength
+// are too unspecific to filter on and are left out, so the result may be
+// empty; explicit keywords are always used. Each keyword is matched both as
+// written and lowercased, so exact symbols like NewReader and lowercase
+// prose both match.
+func keywordFilterParams(terms []string, minLength int, explicit []string) map[string]string {
+	keywords := append([]string{}, explicit...)
+	for _, term := range terms {
+		if len(term) >= minLength {
+			keywords = append(keywords, term)
+		}
+	}
+	
+	params := map[string]string{}
+	seen := map[string]bool{}
+	for _, keyword := range keywords {
+		for _, variant := range []string{keyword, strings.ToLower(keyword)} {
+			if variant == "" || seen[variant] {
+				continue
+			}
+			seen[variant] = true
+			params[fmt.Sprintf("keyword%d", len(params))] = variant
+		}
+	}
+	return params
+}
+
+// stopWords returns the configured stop words, or the default ones
+func (r *Neo4jRAG) stopWords() map[string]bool {
+	if r.config.StopWords == nil {
+		return defaultStopWords
+	}
+	return r.config.StopWords
+}
+
+// minKeywordLength returns the configured minimum keyword length, or the
+// default
+func (r *Neo4jRAG) minKeywordLength() int {
+	if r.config.MinKeywordLength <= 0 {
+		return defaultMinKeywordLength
+	}
+	return r.config.MinKeywordLength
+}
+
+// snippetHashes returns the content hashes of the chunk a find-similar
+// snippet may have been copied from: the snippet as given and with
+// surrounding whitespace trimmed, since a copied file or selection usually
+// ends with a newline its chunk does not have
+func snippetHashes(snippet string) []string {
+	hashes := []string{contentHash(snippet)}
+	if trimmed := strings.TrimSpace(snippet); trimmed != snippet {
+		hashes = append(hashes, contentHash(trimmed))
+	}
+	return hashes
+}
+
+// queryKeywords returns the query terms for the keyword pre-filter, the
+// lowercased keywords for hybrid scoring, and the weight of the vector score.
+// Find-similar snippets are ranked by vector similarity alone.
+func (r *Neo4jRAG) queryKeywords(query string, opts SearchOptions) (terms, keywords []string, hybridAlpha float64) {
+	if opts.Similar {
+		return nil, nil, 1
+	}
+	stopWords := r.stopWords()
+	terms = queryTerms(query, stopWords)
+	if r.config.SplitIdentifiers {
+		terms = splitIdentifierTerms(terms)
+	}
+	return terms, extractKeywords(query, stopWords), 1 - r.config.KeywordWeight
+}
+
+// keywordScoreExpr returns the Cypher expression for a chunk's hybrid
+// keyword score (see SearchCodeWithOptions). It is only computed where it
+// counts: in hybrid search, and for chunks whose embeddingField is pending.
+func keywordScoreExpr(embeddingField string) string {
+	return `CASE WHEN size($hybridKeywords) = 0 OR c.content IS NULL OR ($hybridAlpha >= 1 AND ` + embeddingField + ` IS NOT NULL) THEN 0.0
+		          ELSE reduce(s = 0.0, kw IN $hybridKeywords |
+		               s + toFloat(size(split(toLower(c.content), kw)) - 1) /
+		                   (toFloat(size(split(toLower(c.content), kw)) - 1) + $keywordSaturation)
+		          ) / size($hybridKeywords)
+		     END`
+}
+
+// Cypher expressions for the exactNameBonus and, in definition mode, the
+// definitionBoost of a chunk. They need the parameters from rankingParams.
+const (
+	nameBonusExpr       = `CASE WHEN any(form IN ` + chunkNameFormsExpr + ` WHERE form IN $nameTerms) THEN $exactNameBonus ELSE 0.0 END`
+	definitionBonusExpr = `CASE WHEN $definitions AND c.entity_type IN $definitionTypes THEN $definitionBoost ELSE 0.0 END`
+)
+
+// rankingParams adds the parameters of keywordScoreExpr, nameBonusExpr and
+// definitionBonusExpr to params
+func rankingParams(params map[string]interface{}, keywords []string, hybridAlpha float64, opts SearchOptions) map[string]interface{} {
+	params["hybridAlpha"] = hybridAlpha
+	params["hybridKeywords"] = keywords
+	params["keywordSaturation"] = keywordSaturation
+	params["definitions"] = opts.Definitions
+	params["definitionTypes"] = definitionEntityTypes
+	params["definitionBoost"] = definitionBoost
+	params["nameTerms"] = nameTerms(keywords)
+	params["exactNameBonus"] = exactNameBonus
+	return params
+}
+
+// pathFilterCondition returns the Cypher condition matching a chunk's file
+// path against any of opts.PathFilters, adding the pattern parameters to
+// params, or "" when there are no path filters
+func pathFilterCondition(opts SearchOptions, params map[string]interface{}) string {
+	if len(opts.PathFilters) == 0 {
+		return ""
+	}
+	pathConditions := []string{}
+	for i, pattern := range opts.PathFilters {
+		name := fmt.Sprintf("pathPattern%d", i)
+		pathConditions = append(pathConditions, `c.file_path =~ $`+name)
+		params[name] = globToRegex(pattern)
+	}
+	return `(` + strings.Join(pathConditions, ` OR `) + `)`
+}
+
+// keywordFilterCondition returns the keyword pre-filter's Cypher condition
+// for keywordParams (see keywordFilterParams), adding them to params.
+// Chunks stored without content are let through (see Config.OmitContent).
+func keywordFilterCondition(keywordParams map[string]string, params map[string]interface{}) string {
+	keywordPatterns := []string{}
+	for name, keyword := range keywordParams {
+		keywordPatterns = append(keywordPatterns, `c.content CONTAINS $`+name)
+		params[name] = keyword
+	}
+	sort.Strings(keywordPatterns)
+	return `(c.content IS NULL OR ` + strings.Join(keywordPatterns, ` OR `) + `)`
+}
+
+// searchWithEmbedding runs the hybrid search of SearchCodeWithOptions against
+// the chunk vectors stored in embeddingProperty ("embedding" or "embedding2")
+func (r *Neo4jRAG) searchWithEmbedding(query string, queryEmbedding []float32, embeddingProperty string, opts SearchOptions) ([]CodeChunk, error) {
+	embeddingField := "c." + embeddingProperty
+	
+	// Extract keywords for potential keyword search. Hybrid scoring compares
+	// lowercased text; the pre-filter also matches the terms as written.
+	terms, keywords, hybridAlpha := r.queryKeywords(query, opts)
+	
+	// Search Neo4j
+	if opts.ScoreBand != nil {
+		r.debugf("Searching Neo4j for similarity in band %.2f-%.2f...\n", opts.ScoreBand.Low, opts.ScoreBand.High)
+	} else {
+		r.debugf("Searching Neo4j with similarity threshold > %.2f...\n", opts.MinScore)
+	}
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		// First check if the database has chunks
+		r.debugf("Checking database content...\n")
+		testResult, testErr := tx.Run(
+			`MATCH (c:Chunk) RETURN count(c) as count`,
+			map[string]interface{}{},
+		)
+		
+		if testErr != nil {
+			r.debugf("Database check failed: %v\n", testErr)
+			return nil, testErr
+		}
+		
+		var chunkCount int64 = 0
+		if testResult.Next() {
+			count, _ := testResult.Record().Get("count")
+			if n, ok := asInt(count); ok {
+				chunkCount = int64(n)
+			}
+			r.debugf("Database contains %v chunks\n", chunkCount)
+			
+			// If count is 0, no data was indexed
+			if chunkCount == 0 {
+				r.logger.Warnf("No chunks found in database. Please run indexing first.")
+				return []CodeChunk{}, nil
+			}
+		} else {
+			r.debugf("Could not get chunk count from database\n")
+		}
+		
+		// Build the Cypher query with filters, joining through the file to
+		// its project when searching within specific projects
+		parameters := map[string]interface{}{}
+		cypherQuery := `MATCH (c:Chunk)`
+		if len(opts.ProjectPaths) > 0 {
+			cypherQuery = `MATCH (c:Chunk)-[:PART_OF]->(:File)-[:BELONGS_TO]->(p:Project)`
+		}
+		
+		// Filter conditions, ANDed into a single WHERE clause. Chunks embedded
+		// by a model with a different dimension cannot be compared with the
+		// query and are left out (see CheckEmbeddingDimension).
+		conditions := []string{
+			`(` + embeddingField + ` IS NULL OR size(` + embeddingField + `) = size($embedding))`,
+		}
+		
+		// Add language filter if specified
+		if len(opts.Languages) > 0 {
+			conditions = append(conditions, `c.language IN $languages`)
+		}
+		
+		// Add path filter if specified
+		if condition := pathFilterCondition(opts, parameters); condition != "" {
+			conditions = append(conditions, condition)
+		}
+		
+		// Exclude exact files (e.g. the file a query snippet was copied from)
+		if len(opts.ExcludeFiles) > 0 {
+			conditions = append(conditions, `NOT c.file_path IN $excludeFiles`)
+		}
+		
+		// Exclude the chunk a find-similar snippet was copied from
+		if opts.Similar {
+			conditions = append(conditions, `NOT coalesce(c.hash, '') IN $snippetHashes`)
+		}
+		
+		// Restrict to the requested projects
+		if len(opts.ProjectPaths) > 0 {
+			conditions = append(conditions, `p.path IN $projects`)
+		}
+		
+		// Leave out dependency code unless requested
+		if !opts.IncludeVendored {
+			conditions = append(conditions, `coalesce(c.is_vendored, false) = false`)
+		}
+		
+		// Hard-exclude unwanted entity types before scoring
+		if len(opts.EntityTypes) > 0 {
+			conditions = append(conditions, `c.entity_type IN $entityTypes`)
+		}
+		
+		// Restrict to chunks indexed within the requested dates
+		if !opts.UpdatedAfter.IsZero() {
+			conditions = append(conditions, `c.updated_at >= $updatedAfter`)
+		}
+		if !opts.UpdatedBefore.IsZero() {
+			conditions = append(conditions, `c.updated_at < $updatedBefore`)
+		}
+		
+		// Add keyword search if enabled. Only terms of at least the minimum
+		// keyword length and explicit keywords are used; when none qualify no
+		// keyword condition is added.
+		keywordParams := keywordFilterParams(terms, r.minKeywordLength(), opts.Keywords)
+		if opts.UseKeywords && len(keywordParams) > 0 {
+			conditions = append(conditions, keywordFilterCondition(keywordParams, parameters))
+		}
+		
+		if len(conditions) > 0 {
+			cypherQuery += ` WHERE ` + strings.Join(conditions, ` AND `)
+		}
+		
+		// Return results ordered by final score. With IncludeContext the
+		// file/project context is fetched for the limited result set only, so
+		// the extra matches run once per returned chunk rather than per candidate.
+		// With Explain the score components are returned as well.
+		scoreColumns := `score`
+		if opts.Explain {
+			scoreColumns = `score, vectorScore, keywordScore, baseScore, nameBonus, definitionBonus, entityBoost, sizeBoost, sizePenalty`
+		}
+		returnClause := `
+		RETURN c.id, c.content, c.file_path, ` + chunkProjectPathExpr + ` AS project_path, c.start_line, c.end_line, 
+		       c.start_byte, c.end_byte, c.entity_type, c.name, c.signature, c.language, ` + scoreColumns + `,
+		       ` + chunkCommitExpr + ` AS commit, ` + chunkBranchExpr + ` AS branch
+		ORDER BY score DESC
+		SKIP $skip LIMIT $limit`
+		if r.config.IncludeContext {
+			returnClause = `
+		WITH c, ` + scoreColumns + `
+		ORDER BY score DESC
+		SKIP $skip LIMIT $limit
+		OPTIONAL MATCH (c)-[:PART_OF]->(f:File)
+		OPTIONAL MATCH (f)-[:BELONGS_TO]->(p:Project)
+		RETURN c.id, c.content, c.file_path, ` + chunkProjectPathExpr + ` AS project_path, c.start_line, c.end_line, 
+		       c.start_byte, c.end_byte, c.entity_type, c.name, c.signature, c.language, ` + scoreColumns + `,
+		       f.commit AS commit, f.branch AS branch,
+		       f.language AS file_language, p.name AS project_name,
+		       coalesce(f.tags, []) + coalesce(p.tags, []) AS tags
+		ORDER BY score DESC`
+		}
+		
+		// Score band mode keeps chunks whose raw similarity falls within the band
+		// instead of applying the usual minimum thresholds
+		thresholdClause := `WHERE baseScore > $minScore`
+		finalThresholdClause := `WHERE score > $minScore`
+		if opts.ScoreBand != nil {
+			thresholdClause = `WHERE vectorScore >= $bandLow AND vectorScore <= $bandHigh`
+			finalThresholdClause = ``
+		}
+		
+		// Add vector similarity and keyword scores, blended into a hybrid score
+		cypherQuery += `
+		WITH c, CASE WHEN ` + embeddingField + ` IS NULL THEN 0.0
+		             ELSE ` + similarityExpr(r.config.SimilarityMetric, embeddingField, "$embedding") + `
+		        END AS vectorScore,
+		     ` + keywordScoreExpr(embeddingField) + ` AS keywordScore
+		
+		// Favor chunks named after a query term and, in definition mode,
+		// definitions over call sites
+		WITH c, vectorScore, keywordScore,
+		     ` + nameBonusExpr + ` AS nameBonus,
+		     ` + definitionBonusExpr + ` AS definitionBonus
+		
+		// Chunks still waiting for embeddings are ranked by keywords alone
+		WITH c, vectorScore, keywordScore, nameBonus, definitionBonus,
+		     CASE WHEN ` + embeddingField + ` IS NULL THEN keywordScore
+		          ELSE $hybridAlpha * vectorScore + (1 - $hybridAlpha) * keywordScore
+		     END + nameBonus + definitionBonus AS baseScore
+		
+		// Apply basic similarity threshold
+		` + thresholdClause + `
+		
+		` + boostClause("baseScore", "vectorScore", "keywordScore", "nameBonus", "definitionBonus") + `
+		
+		// Ensure minimum threshold even after adjustments
+		` + finalThresholdClause + `
+		
+		` + returnClause
+		
+		// Keyword scores are only computed by the query for hybrid search and
+		// for chunks whose embeddings are still pending
+		hybridKeywords := keywords
+		
+		// Only keywords that drive the match are highlighted: the
+		// pre-filter's when it runs, and the hybrid score's unless the
+		// search is pure vector search
+		var highlightParams map[string]string
+		if opts.UseKeywords {
+			highlightParams = keywordParams
+		}
+		var highlightKeywords []string
+		if hybridAlpha < 1 {
+			highlightKeywords = hybridKeywords
+		}
+		
+		// Prepare parameters
+		parameters["embedding"] = queryEmbedding
+		parameters["minScore"] = opts.MinScore
+		parameters["skip"] = opts.Offset
+		parameters["limit"] = opts.Limit
+		rankingParams(r.scoring().withParams(parameters), hybridKeywords, hybridAlpha, opts)
+		
+		if opts.ScoreBand != nil {
+			parameters["bandLow"] = opts.ScoreBand.Low
+			parameters["bandHigh"] = opts.ScoreBand.High
+		}
+		
+		// Add language parameters if specified
+		if len(opts.Languages) > 0 {
+			parameters["languages"] = opts.Languages
+		}
+		
+		// Add excluded file parameters if specified
+		if len(opts.ExcludeFiles) > 0 {
+			parameters["excludeFiles"] = expandPathVariants(opts.ExcludeFiles)
+		}
+		if opts.Similar {
+			parameters["snippetHashes"] = snippetHashes(query)
+		}
+		
+		// Add entity type parameters if specified
+		if len(opts.EntityTypes) > 0 {
+			parameters["entityTypes"] = opts.EntityTypes
+		}
+		
+		// Add date parameters if specified
+		if !opts.UpdatedAfter.IsZero() {
+			parameters["updatedAfter"] = opts.UpdatedAfter
+		}
+		if !opts.UpdatedBefore.IsZero() {
+			parameters["updatedBefore"] = opts.UpdatedBefore
+		}
+		
+		// Add project parameters if specified
+		if len(opts.ProjectPaths) > 0 {
+			parameters["projects"] = expandPathVariants(opts.ProjectPaths)
+		}
+		
+		// Execute the query
+		result, err := tx.Run(cypherQuery, parameters)
+		
+		if err != nil {
+			return nil, err
+		}
+		
+		chunks := []CodeChunk{}
+		contents := newChunkContentLoader(r)
+		for result.Next() {
+			record := result.Record()
+			
+			id, _ := record.Get("c.id")
+			content, _ := record.Get("c.content")
+			filePath, _ := record.Get("c.file_path")
+			projectPath, _ := record.Get("project_path")
+			startLine, _ := record.Get("c.start_line")
+			endLine, _ := record.Get("c.end_line")
+			startByte, _ := record.Get("c.start_byte")
+			endByte, _ := record.Get("c.end_byte")
+			entityType, _ := record.Get("c.entity_type")
+			name, _ := record.Get("c.name")
+			signature, _ := record.Get("c.signature")
+			language, _ := record.Get("c.language")
+			score, _ := record.Get("score")
+			
+			// Skip records with missing or malformed required fields, e.g.
+			// chunks stored before a schema change, instead of failing the search
+			chunkID, idOK := asString(id)
+			chunkContent, contentOK := asString(content)
+			contentOK = contentOK || content == nil // Not stored, see Config.OmitContent
+			chunkFilePath, filePathOK := asString(filePath)
+			chunkStartLine, startLineOK := asInt(startLine)
+			chunkEndLine, endLineOK := asInt(endLine)
+			chunkScore, scoreOK := asFloat(score)
+			if !idOK || !contentOK || !filePathOK || !startLineOK || !endLineOK || !scoreOK {
+				r.logger.Warnf("Skipping malformed search result %v: missing or invalid required field\n", id)
+				continue
+			}
+			
+			chunk := CodeChunk{
+				ID:        chunkID,
+				Content:   chunkContent,
+				FilePath:  chunkFilePath,
+				StartLine: chunkStartLine,
+				EndLine:   chunkEndLine,
+				Score:     chunkScore,
+			}
+			
+			// Optional fields stay empty when missing
+			chunk.StartByte, _ = asInt(startByte)
+			chunk.EndByte, _ = asInt(endByte)
+			chunk.EntityType, _ = asString(entityType)
+			chunk.Name, _ = asString(name)
+			chunk.Language, _ = asString(language)
+			chunk.Signature, _ = asString(signature)
+			chunk.ProjectPath, _ = asString(projectPath)
+			if commit, ok := record.Get("commit"); ok {
+				chunk.Commit, _ = asString(commit)
+			}
+			if branch, ok := record.Get("branch"); ok {
+				chunk.Branch, _ = asString(branch)
+			}
+			
+			// Graph context is only returned with IncludeContext
+			if fileLanguage, ok := record.Get("file_language"); ok && fileLanguage != nil {
+				chunk.FileLanguage, _ = fileLanguage.(string)
+			}
+			if projectName, ok := record.Get("project_name"); ok && projectName != nil {
+				chunk.ProjectName, _ = projectName.(string)
+			}
+			if tags, ok := record.Get("tags"); ok {
+				if tagList, ok := tags.([]interface{}); ok {
+					for _, tag := range tagList {
+						if tagStr, ok := tag.(string); ok {
+							chunk.Tags = append(chunk.Tags, tagStr)
+						}
+					}
+				}
+			}
+			
+			contents.load(&chunk)
+			if opts.Explain {
+				chunk.Breakdown = scoreBreakdown(record, chunk.Content, keywordParams, hybridKeywords)
+			}
+			if opts.Highlight {
+				chunk.MatchedKeywords = matchedKeywords(chunk.Content, highlightParams, highlightKeywords)
+			}
+			
+			r.debugf("Found chunk with score %f: %s\n", chunk.Score, chunk.ID)
+			chunks = append(chunks, chunk)
+		}
+		
+		return chunks, nil
+	})
+	
+	if err != nil {
+		r.debugf("Neo4j search failed: %v\n", err)
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	
+	chunks := result.([]CodeChunk)
+	r.debugf("Search complete. Found %d matching chunks\n", len(chunks))
+	return chunks, nil
+}
+
+// nameTerms returns the lowercased query keywords that may name a chunk:
+// each keyword, and for qualified names like Type.Method or pkg.Func also
+// the part after the last dot
+func nameTerms(keywords []string) []string {
+	terms := []string{}
+	for _, keyword := range keywords {
+		terms = append(terms, keyword)
+		if i := strings.LastIndex(keyword, "."); i >= 0 && i < len(keyword)-1 {
+			terms = append(terms, keyword[i+1:])
+		}
+	}
+	return terms
+}
+
+// scoreBreakdown reads the score components returned with
+// SearchOptions.Explain and lists the keywords found in content: the
+// pre-filter keywords as written, and the hybrid keywords case-insensitively
+func scoreBreakdown(record *neo4j.Record, content string, keywordParams map[string]string, hybridKeywords []string) *ScoreBreakdown {
+	breakdown := &ScoreBreakdown{}
+	for name, field := range map[string]*float64{
+		"vectorScore":     &breakdown.VectorScore,
+		"keywordScore":    &breakdown.KeywordScore,
+		"baseScore":       &breakdown.BaseScore,
+		"nameBonus":       &breakdown.NameBonus,
+		"definitionBonus": &breakdown.DefinitionBonus,
+		"entityBoost":     &breakdown.EntityBoost,
+		"sizeBoost":       &breakdown.SizeBoost,
+		"sizePenalty":     &breakdown.SizePenalty,
+	} {
+		if value, ok := record.Get(name); ok {
+			*field, _ = asFloat(value)
+		}
+	}
+	
+	breakdown.MatchedKeywords = matchedKeywords(content, keywordParams, hybridKeywords)
+	return breakdown
+}
+
+// matchedKeywords returns the keywords found in content, sorted: the
+// pre-filter keywords as written, and the hybrid keywords case-insensitively
+func matchedKeywords(content string, keywordParams map[string]string, hybridKeywords []string) []string {
+	matched := map[string]bool{}
+	for _, keyword := range keywordParams {
+		if strings.Contains(content, keyword) {
+			matched[keyword] = true
+		}
+	}
+	lowerContent := strings.ToLower(content)
+	for _, keyword := range hybridKeywords {
+		if strings.Contains(lowerContent, keyword) {
+			matched[keyword] = true
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	return sortedKeys(matched)
+}
+
+// Terminal escapes wrapping highlighted keywords in CLI output
+const (
+	ansiHighlight = "\x1b[1;33m"
+	ansiReset     = "\x1b[0m"
+)
+
+// highlightKeywords returns text with every case-insensitive occurrence of
+// keywords wrapped in before and after, preferring the longest keyword where
+// several match at the same position. The text outside the markers is
+// passed through escape, when not nil.
+func highlightKeywords(text string, keywords []string, before, after string, escape func(string) string) string {
+	if escape == nil {
+		escape = func(s string) string { return s }
+	}
+	if len(keywords) == 0 {
+		return escape(text)
+	}
+	
+	sorted := append([]string{}, keywords...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	quoted := make([]string, len(sorted))
+	for i, keyword := range sorted {
+		quoted[i] = regexp.QuoteMeta(keyword)
+	}
+	pattern := regexp.MustCompile(`(?i)` + strings.Join(quoted, `|`))
+	
+	var b strings.Builder
+	last := 0
+	for _, m := range pattern.FindAllStringIndex(text, -1) {
+		b.WriteString(escape(text[last:m[0]]))
+		b.WriteString(before + escape(text[m[0]:m[1]]) + after)
+		last = m[1]
+	}
+	b.WriteString(escape(text[last:]))
+	return b.String()
+}
+
+// highlightChunksHTML sets Highlighted on chunks to their content as HTML with
+// their MatchedKeywords in <mark> elements
+func highlightChunksHTML(chunks []CodeChunk) {
+	for i := range chunks {
+		chunks[i].Highlighted = highlightKeywords(chunks[i].Content, chunks[i].MatchedKeywords, "<mark>", "</mark>", html.EscapeString)
+	}
+}
+
+// rrfK is the rank constant of reciprocal rank fusion; larger values flatten
+// the advantage of top-ranked results
+const rrfK = 60
+
+// fuseCandidateFactor is how many more candidates than the requested limit
+// each model retrieves before fusion, so chunks ranked highly by only one
+// model still have a chance to make the fused list
+const fuseCandidateFactor = 3
+
+// searchFused retrieves candidates with both ensemble embeddings and fuses
+// the two rankings with reciprocal rank fusion. queryEmbedding is the primary
+// model's embedding of query. The returned scores are RRF scores.
+func (r *Neo4jRAG) searchFused(query string, queryEmbedding []float32, opts SearchOptions) ([]CodeChunk, error) {
+	if r.config.EnsembleEmbeddingURL == "" {
+		return nil, fmt.Errorf("fused search requires a second embedding model (--ensemble-models)")
+	}
+	
+	embeddings, err := r.embedWith(context.Background(), r.ensembleEmbedder, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ensemble query embedding: %w", err)
+	}
+	if len(embeddings) == 0 || len(embeddings[0]) == 0 {
+		return nil, fmt.Errorf("received empty ensemble embedding for query")
+	}
+	
+	// The fused ranking differs from either model's, so each model's
+	// candidates start at the top and the page is cut from the fused list
+	candidateOpts := opts
+	candidateOpts.Offset = 0
+	candidateOpts.Limit = (opts.Offset + opts.Limit) * fuseCandidateFactor
+	
+	primary, err := r.searchWithEmbedding(query, queryEmbedding, "embedding", candidateOpts)
+	if err != nil {
+		return nil, err
+	}
+	
+	secondary, err := r.searchWithEmbedding(query, embeddings[0], "embedding2", candidateOpts)
+	if err != nil {
+		return nil, err
+	}
+	
+	r.debugf("Fusing %d primary and %d ensemble results\n", len(primary), len(secondary))
+	fused := reciprocalRankFusion(opts.Offset+opts.Limit, primary, secondary)
+	if opts.Offset >= len(fused) {
+		return []CodeChunk{}, nil
+	}
+	return fused[opts.Offset:], nil
+}
+
+// reciprocalRankFusion merges ranked result lists. Each chunk scores
+// sum(1 / (rrfK + rank)) over the lists it appears in, with 1-based ranks.
+// The top limit chunks are returned with Score set to their fused score.
+func reciprocalRankFusion(limit int, lists ...[]CodeChunk) []CodeChunk {
+	fused := map[string]*CodeChunk{}
+	order := []string{}
+	
+	for _, list := range lists {
+		for rank, chunk := range list {
+			contribution := 1.0 / float64(rrfK+rank+1)
+			if existing, ok := fused[chunk.ID]; ok {
+				existing.Score += contribution
+				continue
+			}
+			chunk.Score = contribution
+			c := chunk
+			fused[chunk.ID] = &c
+			order = append(order, chunk.ID)
+		}
+	}
+	
+	results := make([]CodeChunk, 0, len(order))
+	for _, id := range order {
+		results = append(results, *fused[id])
+	}
+	
+	// Stable sort keeps first-seen order (primary list first) for ties
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// SearchCodeAdvanced searches for code with advanced filtering options.
+//
+// Deprecated: use SearchCodeWithOptions, which supports all search filters.
+func (r *Neo4jRAG) SearchCodeAdvanced(query string, limit int, languages []string, pathFilters []string, minScore float64, useKeywords bool) ([]CodeChunk, error) {
+	return r.SearchCodeWithOptions(query, SearchOptions{
+		Limit:       limit,
+		Languages:   languages,
+		PathFilters: pathFilters,
+		MinScore:    minScore,
+		UseKeywords: useKeywords,
+	})
+}
+
+// SearchEvent is emitted by StreamSearch while a search is in progress
+type SearchEvent struct {
+	Type      string     `json:"type"`                // "progress", "result" or "done"
+	Scanned   int        `json:"scanned,omitempty"`   // Candidates scored so far
+	Total     int        `json:"total,omitempty"`     // Total candidates to score
+	Threshold float64    `json:"threshold,omitempty"` // Current K-th best score
+	Rank      int        `json:"rank,omitempty"`      // 1-based rank of a result
+	Chunk     *CodeChunk `json:"chunk,omitempty"`
+}
+
+// streamPageSize is the number of candidate embeddings StreamSearch scores per page
+const streamPageSize = 500
+
+// scoredChunk is a candidate tracked by the top-K heap in StreamSearch
+type scoredChunk struct {
+	id    string
+	score float64
+}
+
+// scoreHeap is a min-heap of candidates, so the worst of the current top-K is
+// always at the root and can be evicted in O(log K)
+type scoreHeap []scoredChunk
+
+func (h scoreHeap) Len() int            { return len(h) }
+func (h scoreHeap) Less(i, j int) bool  { return h[i].score < h[j].score }
+func (h scoreHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *scoreHeap) Push(x interface{}) { *h = append(*h, x.(scoredChunk)) }
+func (h *scoreHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// StreamSearch scores chunks in Go a page at a time, keeping the best
+// opts.Offset+opts.Limit candidates in a bounded heap and reporting progress through emit
+// after every page, so callers see activity long before a full scan of a large
+// index completes. Once all candidates are scored, the ranked results are
+// emitted followed by a "done" event.
+//
+// Filtering and ranking match SearchCodeWithOptions: the vector similarity
+// is computed in Go and blended with the keyword score, name and definition
+// bonuses and boosts computed as the Cypher scoring does. Score bands, fused
+// retrieval and explanations are not supported. Chunks still waiting for
+// embeddings are not candidates, and early termination is not possible
+// because candidates are not read in score order.
+func (r *Neo4jRAG) StreamSearch(query string, opts SearchOptions, emit func(SearchEvent)) error {
+	unsupported := []string{}
+	if opts.ScoreBand != nil {
+		unsupported = append(unsupported, "score bands")
+	}
+	if opts.Fuse {
+		unsupported = append(unsupported, "fused retrieval")
+	}
+	if opts.Explain {
+		unsupported = append(unsupported, "explanations")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("streaming search does not support %s", strings.Join(unsupported, " or "))
+	}
+	
+	embeddings, err := r.getEmbeddings(context.Background(), []string{query})
+	if err != nil {
+		return fmt.Errorf("failed to generate query embedding: %w", err)
+	}
+	if len(embeddings) == 0 || len(embeddings[0]) == 0 {
+		return fmt.Errorf("received empty embedding for query")
+	}
+	queryEmbedding := embeddings[0]
+	terms, keywords, hybridAlpha := r.queryKeywords(query, opts)
+	
+	// Build the candidate filter
+	conditions := []string{`c.embedding IS NOT NULL`}
+	parameters := map[string]interface{}{}
+	if condition := pathFilterCondition(opts, parameters); condition != "" {
+		conditions = append(conditions, condition)
+	}
+	keywordParams := keywordFilterParams(terms, r.minKeywordLength(), opts.Keywords)
+	if opts.UseKeywords && len(keywordParams) > 0 {
+		conditions = append(conditions, keywordFilterCondition(keywordParams, parameters))
+	}
+	if len(opts.Languages) > 0 {
+		conditions = append(conditions, `c.language IN $languages`)
+		parameters["languages"] = opts.Languages
+	}
+	if len(opts.EntityTypes) > 0 {
+		conditions = append(conditions, `c.entity_type IN $entityTypes`)
+		parameters["entityTypes"] = opts.EntityTypes
+	}
+	if !opts.UpdatedAfter.IsZero() {
+		conditions = append(conditions, `c.updated_at >= $updatedAfter`)
+		parameters["updatedAfter"] = opts.UpdatedAfter
+	}
+	if !opts.UpdatedBefore.IsZero() {
+		conditions = append(conditions, `c.updated_at < $updatedBefore`)
+		parameters["updatedBefore"] = opts.UpdatedBefore
+	}
+	if !opts.IncludeVendored {
+		conditions = append(conditions, `coalesce(c.is_vendored, false) = false`)
+	}
+	if len(opts.ProjectPaths) > 0 {
+		conditions = append(conditions, `any(path IN [(c)-[:PART_OF]->(:File)-[:BELONGS_TO]->(p:Project) | p.path] WHERE path IN $projects)`)
+		parameters["projects"] = expandPathVariants(opts.ProjectPaths)
+	}
+	if len(opts.ExcludeFiles) > 0 {
+		conditions = append(conditions, `NOT c.file_path IN $excludeFiles`)
+		parameters["excludeFiles"] = expandPathVariants(opts.ExcludeFiles)
+	}
+	if opts.Similar {
+		conditions = append(conditions, `NOT coalesce(c.hash, '') IN $snippetHashes`)
+		parameters["snippetHashes"] = snippetHashes(query)
+	}
+	filter := strings.Join(conditions, ` AND `)
+	
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	countResult, err := session.Run(`MATCH (c:Chunk) WHERE `+filter+` RETURN count(c) AS total`, parameters)
+	if err != nil {
+		return fmt.Errorf("failed to count candidates: %w", err)
+	}
+	countRecord, err := countResult.Single()
+	if err != nil {
+		return fmt.Errorf("failed to count candidates: %w", err)
+	}
+	totalValue, _ := countRecord.Get("total")
+	total64, _ := totalValue.(int64)
+	total := int(total64)
+	
+	scoring := r.scoring()
+	top := &scoreHeap{}
+	scanned := 0
+	lastID := ""
+	
+	// Page through candidates in id order using the unique id index. The
+	// score components other than vector similarity come from Cypher.
+	rankingParams(parameters, keywords, hybridAlpha, opts)
+	for {
+		parameters["lastId"] = lastID
+		parameters["pageSize"] = streamPageSize
+		
+		result, err := session.Run(
+			`MATCH (c:Chunk)
+			 WHERE `+filter+` AND c.id > $lastId
+			 RETURN c.id AS id, c.embedding AS embedding, c.entity_type AS entityType,
+			        `+chunkSizeExpr+` AS contentSize,
+			        `+keywordScoreExpr("c.embedding")+` AS keywordScore,
+			        `+nameBonusExpr+` AS nameBonus,
+			        `+definitionBonusExpr+` AS definitionBonus
+			 ORDER BY c.id
+			 LIMIT $pageSize`,
+			parameters,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to fetch candidates: %w", err)
+		}
+		
+		pageCount := 0
+		for result.Next() {
+			record := result.Record()
+			pageCount++
+			scanned++
+			
+			idValue, _ := record.Get("id")
+			id, _ := idValue.(string)
+			lastID = id
+			
+			embeddingValue, _ := record.Get("embedding")
+			similarity, ok := vectorSimilarity(r.config.SimilarityMetric, queryEmbedding, toFloat32Slice(embeddingValue))
+			if !ok {
+				continue
+			}
+			
+			// Mirror the hybrid base score and the boosts applied by boostClause
+			keywordScore, _ := record.Get("keywordScore")
+			nameBonus, _ := record.Get("nameBonus")
+			definitionBonus, _ := record.Get("definitionBonus")
+			keyword, _ := asFloat(keywordScore)
+			name, _ := asFloat(nameBonus)
+			definition, _ := asFloat(definitionBonus)
+			baseScore := hybridAlpha*similarity + (1-hybridAlpha)*keyword + name + definition
+			score := baseScore
+			entityType, _ := record.Get("entityType")
+			if entityType == "function" || entityType == "method" {
+				score += scoring.EntityBoost
+			}
+			contentSize, _ := record.Get("contentSize")
+			if size, ok := contentSize.(int64); ok {
+				if size < int64(scoring.SmallChunkThreshold) {
+					score += scoring.SmallChunkBoost
+				}
+				if size > int64(scoring.LargeChunkThreshold) {
+					score -= scoring.LargeChunkPenalty
+				}
+			}
+			
+			if baseScore <= opts.MinScore || score <= opts.MinScore {
+				continue
+			}
+			
+			heap.Push(top, scoredChunk{id: id, score: score})
+			if top.Len() > opts.Offset+opts.Limit {
+				heap.Pop(top)
+			}
+		}
+		if err := result.Err(); err != nil {
+			return fmt.Errorf("failed to fetch candidates: %w", err)
+		}
+		
+		progress := SearchEvent{Type: "progress", Scanned: scanned, Total: total}
+		if top.Len() > 0 {
+			progress.Threshold = (*top)[0].score
+		}
+		emit(progress)
+		
+		if pageCount < streamPageSize {
+			break
+		}
+	}
+	
+	// Drain the heap into descending score order
+	ranked := make([]scoredChunk, top.Len())
+	for i := len(ranked) - 1; i >= 0; i-- {
+		ranked[i] = heap.Pop(top).(scoredChunk)
+	}
+	
+	// The heap keeps Offset+Limit candidates; drop the pages before this one
+	if opts.Offset < len(ranked) {
+		ranked = ranked[opts.Offset:]
+	} else {
+		ranked = nil
+	}
+	
+	chunks, err := r.fetchChunksByID(session, ranked)
+	if err != nil {
+		return err
+	}
+	
+	// Highlight the keywords that drive the match, as searchWithEmbedding does
+	if opts.Highlight {
+		var highlightParams map[string]string
+		if opts.UseKeywords {
+			highlightParams = keywordParams
+		}
+		var highlightKeywords []string
+		if hybridAlpha < 1 {
+			highlightKeywords = keywords
+		}
+		for i := range chunks {
+			chunks[i].MatchedKeywords = matchedKeywords(chunks[i].Content, highlightParams, highlightKeywords)
+		}
+	}
+	
+	for i := range chunks {
+		emit(SearchEvent{Type: "result", Rank: opts.Offset + i + 1, Chunk: &chunks[i]})
+	}
+	emit(SearchEvent{Type: "done", Scanned: scanned, Total: total})
+	
+	return nil
+}
+
+// fetchChunksByID loads the full chunks for the ranked candidates, preserving
+// their order and scores
+func (r *Neo4jRAG) fetchChunksByID(session neo4j.Session, ranked []scoredChunk) ([]CodeChunk, error) {
+	if len(ranked) == 0 {
+		return []CodeChunk{}, nil
+	}
+	
+	ids := make([]string, len(ranked))
+	for i, candidate := range ranked {
+		ids[i] = candidate.id
+	}
+	
+	result, err := session.Run(
+		`MATCH (c:Chunk) WHERE c.id IN $ids
+		 RETURN c.id, c.content, c.file_path, `+chunkProjectPathExpr+` AS project_path, c.start_line, c.end_line,
+		        c.start_byte, c.end_byte, c.entity_type, c.name, c.signature, c.language,
+		        `+chunkCommitExpr+` AS commit, `+chunkBranchExpr+` AS branch`,
+		map[string]interface{}{"ids": ids},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch result chunks: %w", err)
+	}
+	
+	byID := map[string]CodeChunk{}
+	for result.Next() {
+		record := result.Record()
+		
+		id, _ := record.Get("c.id")
+		content, _ := record.Get("c.content")
+		filePath, _ := record.Get("c.file_path")
+		projectPath, _ := record.Get("project_path")
+		startLine, _ := record.Get("c.start_line")
+		endLine, _ := record.Get("c.end_line")
+		startByte, _ := record.Get("c.start_byte")
+		endByte, _ := record.Get("c.end_byte")
+		entityType, _ := record.Get("c.entity_type")
+		name, _ := record.Get("c.name")
+		signature, _ := record.Get("c.signature")
+		language, _ := record.Get("c.language")
+		commit, _ := record.Get("commit")
+		branch, _ := record.Get("branch")
+		
+		chunk := CodeChunk{}
+		chunk.ID, _ = id.(string)
+		chunk.Content, _ = content.(string)
+		chunk.FilePath, _ = filePath.(string)
+		chunk.ProjectPath, _ = projectPath.(string)
+		chunk.EntityType, _ = entityType.(string)
+		chunk.Name, _ = name.(string)
+		chunk.Signature, _ = signature.(string)
+		chunk.Language, _ = language.(string)
+		chunk.Commit, _ = commit.(string)
+		chunk.Branch, _ = branch.(string)
+		if v, ok := startLine.(int64); ok {
+			chunk.StartLine = int(v)
+		}
+		if v, ok := endLine.(int64); ok {
+			chunk.EndLine = int(v)
+		}
+		if v, ok := startByte.(int64); ok {
+			chunk.StartByte = int(v)
+		}
+		if v, ok := endByte.(int64); ok {
+			chunk.EndByte = int(v)
+		}
+		
+		byID[chunk.ID] = chunk
+	}
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch result chunks: %w", err)
+	}
+	
+	chunks := make([]CodeChunk, 0, len(ranked))
+	contents := newChunkContentLoader(r)
+	for _, candidate := range ranked {
+		if chunk, ok := byID[candidate.id]; ok {
+			chunk.Score = candidate.score
+			contents.load(&chunk)
+			chunks = append(chunks, chunk)
+		}
+	}
+	
+	return chunks, nil
+}
+
+// similarityExpr returns the Cypher expression comparing the vectors a and b
+// with metric. Every metric yields a score where larger means more similar:
+//
+//	cosine:    gds.similarity.cosine, in [-1, 1]
+//	dot:       the dot product; equal to cosine for normalized embeddings
+//	euclidean: gds.similarity.euclidean, 1 / (1 + distance), in (0, 1]
+func similarityExpr(metric, a, b string) string {
+	switch metric {
+	case similarityDot:
+		return `reduce(dot = 0.0, i IN range(0, size(` + a + `) - 1) | dot + ` + a + `[i] * ` + b + `[i])`
+	case similarityEuclidean:
+		return `gds.similarity.euclidean(` + a + `, ` + b + `)`
+	default:
+		return `gds.similarity.cosine(` + a + `, ` + b + `)`
+	}
+}
+
+// vectorSimilarity is the Go counterpart of similarityExpr. It reports false
+// when the vectors cannot be compared.
+func vectorSimilarity(metric string, a, b []float32) (float64, bool) {
+	if metric != similarityDot && metric != similarityEuclidean {
+		return cosineSimilarity(a, b)
+	}
+	if len(a) == 0 || len(a) != len(b) {
+		return 0, false
+	}
+	
+	var dot, distance float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		diff := float64(a[i]) - float64(b[i])
+		distance += diff * diff
+	}
+	
+	if metric == similarityDot {
+		return dot, true
+	}
+	return 1 / (1 + math.Sqrt(distance)), true
+}
+
+// cosineSimilarity computes the cosine similarity of two vectors. It reports
+// false when the vectors cannot be compared (empty, different dimensions or
+// zero length).
+func cosineSimilarity(a, b []float32) (float64, bool) {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0, false
+	}
+	
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	
+	if normA == 0 || normB == 0 {
+		return 0, false
+	}
+	
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB)), true
+}
+
+// asString converts a record value to a string. It reports false when the
+// value is nil or not a string.
+func asString(value interface{}) (string, bool) {
+	str, ok := value.(string)
+	return str, ok
+}
+
+// asInt converts a record value to an int. Neo4j returns integers as int64;
+// integral floats are accepted too. It reports false for nil and other types.
+func asInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int64:
+		return int(v), true
+	case int:
+		return v, true
+	case float64:
+		if v == math.Trunc(v) {
+			return int(v), true
+		}
+	}
+	return 0, false
+}
+
+// asFloat converts a record value to a float64, accepting integers. It
+// reports false for nil and other types.
+func asFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// toFloat32Slice converts a list property returned by the driver to []float32
+func toFloat32Slice(value interface{}) []float32 {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	
+	vector := make([]float32, 0, len(list))
+	for _, item := range list {
+		switch v := item.(type) {
+		case float64:
+			vector = append(vector, float32(v))
+		case int64:
+			vector = append(vector, float32(v))
+		default:
+			return nil
+		}
+	}
+	
+	return vector
+}
+
+// contextChunks returns the configured number of prompt chunks, or the
+// default
+func (r *Neo4jRAG) contextChunks() int {
+	if r.config.ContextChunks <= 0 {
+		return llmContextChunks
+	}
+	return r.config.ContextChunks
+}
+
+// LLMOptions are the generation settings passed to LLM.Complete
+type LLMOptions struct {
+	MaxTokens   int
+	Temperature float32
+}
+
+// defaultLLMOptions are QueryLLM's generation settings unless the caller
+// chooses others: room for a detailed answer at a low, mostly deterministic
+// temperature
+var defaultLLMOptions = LLMOptions{MaxTokens: 1000, Temperature: 0.2}
+
+// maxLLMTemperature is the highest temperature accepted, the upper end of
+// the range OpenAI-compatible servers allow
+const maxLLMTemperature = 2
+
+// Usage reports the tokens an LLM call consumed. Providers that only report
+// a total leave the prompt and completion counts at zero.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// LLM generates a completion for a prompt
+type LLM interface {
+	Complete(ctx context.Context, prompt string, opts LLMOptions) (string, Usage, error)
+}
+
+// CompletionLLM talks to the local completion server, which takes an
+// LLMRequest and answers with an LLMResponse
+type CompletionLLM struct {
+	URL    string
+	Client *http.Client // nil uses http.DefaultClient
+}
+
+// Complete implements LLM
+func (l *CompletionLLM) Complete(ctx context.Context, prompt string, opts LLMOptions) (string, Usage, error) {
+	reqBody, err := json.Marshal(LLMRequest{
+		Prompt:      prompt,
+		MaxTokens:   opts.MaxTokens,
+		Temperature: opts.Temperature,
+	})
+	if err != nil {
+		return "", Usage{}, err
+	}
+	
+	var llmResp LLMResponse
+	if err := postLLM(ctx, l.Client, l.URL, nil, reqBody, &llmResp); err != nil {
+		return "", Usage{}, err
+	}
+	
+	return llmResp.Text, Usage{TotalTokens: llmResp.TokensUsed}, nil
+}
+
+// openAIChatMessage is one message of an OpenAI chat completion
+type openAIChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// openAIChatRequest is the body of an OpenAI chat completion request
+type openAIChatRequest struct {
+	Model       string              `json:"model,omitempty"`
+	Messages    []openAIChatMessage `json:"messages"`
+	MaxTokens   int                 `json:"max_tokens,omitempty"`
+	Temperature float32             `json:"temperature"`
+}
+
+// openAIChatResponse is the part of an OpenAI chat completion response
+// QueryLLM uses
+type openAIChatResponse struct {
+	Choices []struct {
+		Message openAIChatMessage `json:"message"`
+	} `json:"choices"`
+	Usage Usage `json:"usage"`
+}
+
+// OpenAIChatLLM talks to an OpenAI-compatible chat completions endpoint,
+// such as https://api.openai.com/v1/chat/completions or the /v1 API of
+// Ollama and llama.cpp server. The prompt is sent as a single user message.
+type OpenAIChatLLM struct {
+	URL    string
+	Model  string
+	APIKey string       // Sent as a bearer token when set
+	Client *http.Client // nil uses http.DefaultClient
+}
+
+// Complete implements LLM
+func (l *OpenAIChatLLM) Complete(ctx context.Context, prompt string, opts LLMOptions) (string, Usage, error) {
+	reqBody, err := json.Marshal(openAIChatRequest{
+		Model:       l.Model,
+		Messages:    []openAIChatMessage{{Role: "user", Content: prompt}},
+		MaxTokens:   opts.MaxTokens,
+		Temperature: opts.Temperature,
+	})
+	if err != nil {
+		return "", Usage{}, err
+	}
+	
+	headers := map[string]string{}
+	if l.APIKey != "" {
+		headers["Authorization"] = "Bearer " + l.APIKey
+	}
+	
+	var chatResp openAIChatResponse
+	if err := postLLM(ctx, l.Client, l.URL, headers, reqBody, &chatResp); err != nil {
+		return "", Usage{}, err
+	}
+	if len(chatResp.Choices) == 0 {
+		return "", chatResp.Usage, fmt.Errorf("LLM service returned no choices")
+	}
+	
+	return chatResp.Choices[0].Message.Content, chatResp.Usage, nil
+}
+
+// postLLM posts a JSON body to an LLM service and decodes the JSON answer
+// into out
+func postLLM(ctx context.Context, client *http.Client, url string, headers map[string]string, body []byte, out interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("LLM service returned status code %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// MockLLM answers without a server, for tests and offline runs. It returns
+// Response, or when that is empty a fixed answer naming the prompt size, and
+// estimates usage at four characters per token.
+type MockLLM struct {
+	Response string
+}
+
+// Complete implements LLM
+func (l *MockLLM) Complete(ctx context.Context, prompt string, opts LLMOptions) (string, Usage, error) {
+	answer := l.Response
+	if answer == "" {
+		answer = fmt.Sprintf("Mock answer to a %d-token prompt.", estimateTokens(prompt))
+	}
+	usage := Usage{PromptTokens: estimateTokens(prompt), CompletionTokens: estimateTokens(answer)}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return answer, usage, nil
+}
+
+// noContextAnswer is QueryLLM's answer when there is no code to give the LLM
+// as context
+const noContextAnswer = "No relevant code was found in the index for this question, so there is no context to answer it from. " +
+	"Try rephrasing it, or index the code it is about."
+
+// QueryLLM sends a query to the LLM with retrieved context, generating the
+// answer with opts. When the search finds nothing, or none of it fits the
+// prompt budget, the LLM is not asked and noContextAnswer is returned
+// instead.
+func (r *Neo4jRAG) QueryLLM(query string, opts LLMOptions) (string, error) {
+	contextChunks := r.contextChunks()
+	
+	// Retrieve a wider candidate set when a reranker will narrow it down
+	searchLimit := contextChunks
+	if r.config.RerankURL != "" && searchLimit < rerankCandidates {
+		searchLimit = rerankCandidates
+	}
+	
+	// First search for relevant code chunks
+	chunks, err := r.SearchCode(query, searchLimit)
+	if err != nil {
+		return "", fmt.Errorf("failed to search for relevant chunks: %w", err)
+	}
+	
+	// Without context the model could only invent an answer
+	if len(chunks) == 0 {
+		r.logger.Println("No relevant code found, not asking the LLM")
+		return noContextAnswer, nil
+	}
+	
+	if r.config.RerankURL != "" && len(chunks) > 0 {
+		chunks, err = r.rerankChunks(query, chunks)
+		if err != nil {
+			return "", fmt.Errorf("failed to rerank chunks: %w", err)
+		}
+		if len(chunks) > contextChunks {
+			chunks = chunks[:contextChunks]
+		}
+	}
+	
+	// Pull in surrounding chunks so small matches come with their context
+	if r.config.ContextWindow > 0 && len(chunks) > 0 {
+		chunks, err = r.expandWithNeighbors(chunks, r.config.ContextWindow)
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch neighboring chunks: %w", err)
+		}
+	}
+	
+	return r.answerFromChunks(query, chunks, opts)
+}
+
+// answerFromChunks asks the LLM to answer query with chunks as context,
+// after applying the full-file and prompt budget settings. When the budget
+// leaves no context, the LLM is not asked and noContextAnswer is returned.
+func (r *Neo4jRAG) answerFromChunks(query string, chunks []CodeChunk, opts LLMOptions) (string, error) {
+	// Give the best match its whole file, for answers spanning functions
+	if r.config.FullFile && len(chunks) > 0 {
+		chunks = r.includeFullFile(chunks)
+	}
+	
+	// Keep the prompt within the model's context budget
+	if r.config.MaxPromptTokens > 0 {
+		chunks = r.fitPromptBudget(query, chunks, r.config.MaxPromptTokens)
+		if len(chunks) == 0 {
+			r.logger.Printf("Not even one line of context fits the %d token prompt budget, not asking the LLM\n", r.config.MaxPromptTokens)
+			return noContextAnswer, nil
+		}
+	}
+	
+	// Format prompt with context
+	prompt := r.buildPrompt(query, chunks)
+	
+	r.logger.Println("Sending query to LLM")
+	
+	answer, usage, err := r.llm.Complete(context.Background(), prompt, opts)
+	if err != nil {
+		return "", err
+	}
+	
+	r.logger.Printf("LLM response received, tokens used: %d\n", usage.TotalTokens)
+	
+	return answer, nil
+}
+
+// defaultFullFileMaxSize is the largest file Config.FullFile includes whole,
+// in bytes, when Config.FullFileMaxSize is not set
+const defaultFullFileMaxSize = 64 * 1024
+
+// includeFullFile replaces the chunks from the first chunk's file with a
+// single chunk holding the whole file as it is on disk now, found like
+// resolveIndexedPath finds it. The chunks are kept when the file cannot be
+// read or is larger than the full-file limit.
+func (r *Neo4jRAG) includeFullFile(chunks []CodeChunk) []CodeChunk {
+	top := chunks[0]
+	maxSize := r.config.FullFileMaxSize
+	if maxSize <= 0 {
+		maxSize = defaultFullFileMaxSize
+	}
+	
+	// Relative paths are relative to where the project was indexed from
+	path := top.FilePath
+	if !filepath.IsAbs(path) {
+		roots, err := r.projectRoots()
+		if err != nil {
+			r.logger.Warnf("cannot look up project directories, reading %s relative to the current directory: %v\n", path, err)
+			roots = map[string]string{}
+		}
+		path = resolveIndexedPath(path, roots)
+	}
+	
+	info, err := os.Stat(path)
+	if err != nil {
+		r.logger.Warnf("Sending chunks instead of the full file: %v\n", err)
+		return chunks
+	}
+	if info.Size() > maxSize {
+		r.logger.Printf("Sending chunks instead of the full file: %s is %d bytes, over the %d byte limit\n",
+			top.FilePath, info.Size(), maxSize)
+		return chunks
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		r.logger.Warnf("Sending chunks instead of the full file: %v\n", err)
+		return chunks
+	}
+	
+	text := normalizeLineEndings(string(content))
+	full := CodeChunk{
+		ID:          top.ID,
+		Content:     text,
+		FilePath:    top.FilePath,
+		ProjectPath: top.ProjectPath,
+		Language:    top.Language,
+		StartLine:   1,
+		EndLine:     strings.Count(text, "\n") + 1,
+		EndByte:     len(content),
+		EntityType:  "file",
+		Name:        filepath.Base(top.FilePath),
+		Score:       top.Score,
+		Commit:      top.Commit,
+		Branch:      top.Branch,
+	}
+	
+	result := []CodeChunk{full}
+	for _, chunk := range chunks[1:] {
+		if chunk.FilePath != top.FilePath {
+			result = append(result, chunk)
+		}
+	}
+	return result
+}
+
+// buildPrompt assembles the LLM prompt from the context chunks in the
+// configured context format. In the delimited format every chunk is enclosed
+// as
+//
+//	@@@ SOURCE 1 file="main.go" lines="10-42" language="Go" entity="function"
+//	...content...
+//	@@@ END SOURCE 1
+//
+// with header values quoted as Go string literals, so the model can cite
+// sources by number and the headers can be parsed back reliably.
+func (r *Neo4jRAG) buildPrompt(query string, chunks []CodeChunk) string {
+	var prompt strings.Builder
+	
+	if r.config.ContextFormat != contextFormatDelimited {
+		prompt.WriteString("Based on the following code snippets:\n\n")
+		for i, chunk := range chunks {
+			prompt.WriteString(fmt.Sprintf("SNIPPET %d (%s, %s):\n```%s\n%s\n```\n\n",
+				i+1, chunk.FilePath, chunk.EntityType, strings.ToLower(chunk.Language), chunk.Content))
+		}
+		prompt.WriteString(fmt.Sprintf("Answer the following question: %s", query))
+		return prompt.String()
+	}
+	
+	marker := r.config.ChunkMarker
+	if marker == "" {
+		marker = defaultChunkMarker
+	}
+	
+	prompt.WriteString(fmt.Sprintf("Based on the following code sources. Each source starts with a %q line "+
+		"giving its file, line range and language, and ends with a matching %q line.\n\n",
+		marker+" SOURCE n", marker+" END SOURCE n"))
+	for i, chunk := range chunks {
+		prompt.WriteString(fmt.Sprintf("%s SOURCE %d file=%s lines=%s language=%s entity=%s\n",
+			marker, i+1, strconv.Quote(chunk.FilePath),
+			strconv.Quote(fmt.Sprintf("%d-%d", chunk.StartLine, chunk.EndLine)),
+			strconv.Quote(chunk.Language), strconv.Quote(chunk.EntityType)))
+		prompt.WriteString(strings.TrimRight(chunk.Content, "\n"))
+		prompt.WriteString(fmt.Sprintf("\n%s END SOURCE %d\n\n", marker, i+1))
+	}
+	prompt.WriteString("Cite the sources you use by their SOURCE number.\n")
+	prompt.WriteString(fmt.Sprintf("Answer the following question: %s", query))
+	return prompt.String()
+}
+
+// estimateTokens approximates the token count of text at four characters per
+// token, which is close enough for budgeting English text and code
+func estimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// countTokens counts the tokens in text with Config.TokenCounter, falling
+// back to estimateTokens
+func (r *Neo4jRAG) countTokens(text string) int {
+	if r.config.TokenCounter != nil {
+		return r.config.TokenCounter(text)
+	}
+	return estimateTokens(text)
+}
+
+// fitPromptBudget returns the chunks, highest score first, whose prompt fits
+// in budget tokens. The first chunk that does not fit whole is cut to the
+// lines that do, and any chunks after it are dropped. The result is empty
+// when not even the first line of the best chunk fits.
+func (r *Neo4jRAG) fitPromptBudget(query string, chunks []CodeChunk, budget int) []CodeChunk {
+	ranked := make([]CodeChunk, len(chunks))
+	copy(ranked, chunks)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	
+	fits := func(selected []CodeChunk) bool {
+		return r.countTokens(r.buildPrompt(query, selected)) <= budget
+	}
+	
+	selected := []CodeChunk{}
+	for i, chunk := range ranked {
+		if fits(append(selected, chunk)) {
+			selected = append(selected, chunk)
+			continue
+		}
+		
+		// Keep as many whole leading lines of this chunk as fit
+		dropped := len(ranked) - i
+		lines := strings.Split(strings.TrimRight(chunk.Content, "\n"), "\n")
+		for keep := len(lines) - 1; keep > 0; keep-- {
+			truncated := chunk
+			truncated.Content = strings.Join(lines[:keep], "\n") + "\n"
+			truncated.EndLine = chunk.StartLine + keep - 1
+			// Content only maps byte for byte onto the file without "\r\n" endings
+			if chunk.EndByte-chunk.StartByte == len(chunk.Content) {
+				truncated.EndByte = chunk.StartByte + len(truncated.Content) - 1
+			}
+			if fits(append(selected, truncated)) {
+				selected = append(selected, truncated)
+				dropped--
+				r.logger.Printf("Truncated %s to %d of %d lines to fit the %d token prompt budget\n",
+					chunk.FilePath, keep, len(lines), budget)
+				break
+			}
+		}
+		
+		if dropped > 0 {
+			r.logger.Printf("Dropped %d of %d chunks to fit the %d token prompt budget\n", dropped, len(ranked), budget)
+		}
+		break
+	}
+	
+	return selected
+}
+
+// rerankChunks posts the query and candidate contents to the cross-encoder
+// reranking service and returns the chunks ordered by its relevance scores.
+// The returned chunks carry the reranker's score in Score.
+func (r *Neo4jRAG) rerankChunks(query string, chunks []CodeChunk) ([]CodeChunk, error) {
+	req := RerankRequest{
+		Query:     query,
+		Documents: make([]string, len(chunks)),
+	}
+	for i, chunk := range chunks {
+		req.Documents[i] = chunk.Content
+	}
+	
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	
+	resp, err := r.embeddingClient.Post(r.config.RerankURL, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rerank service returned status code %d", resp.StatusCode)
+	}
+	
+	var rerankResp RerankResponse
+	err = json.NewDecoder(resp.Body).Decode(&rerankResp)
+	if err != nil {
+		return nil, err
+	}
+	
+	if len(rerankResp.Scores) != len(chunks) {
+		return nil, fmt.Errorf("rerank service returned %d scores for %d documents", len(rerankResp.Scores), len(chunks))
+	}
+	
+	reranked := make([]CodeChunk, len(chunks))
+	copy(reranked, chunks)
+	for i := range reranked {
+		reranked[i].Score = rerankResp.Scores[i]
+	}
+	
+	sort.SliceStable(reranked, func(i, j int) bool {
+		return reranked[i].Score > reranked[j].Score
+	})
+	
+	r.logger.Printf("Reranked %d candidate chunks\n", len(reranked))
+	return reranked, nil
+}
+
+// expandWithNeighbors adds up to window chunks before and after each matched
+// chunk from the same file, then merges overlapping line ranges so the same
+// lines are not sent to the LLM twice
+func (r *Neo4jRAG) expandWithNeighbors(chunks []CodeChunk, window int) ([]CodeChunk, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	
+	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		expanded := []CodeChunk{}
+		contents := newChunkContentLoader(r)
 		
-		chunks := []CodeChunk{}
-		for result.Next() {
-			record := result.Record()
-			
-			id, _ := record.Get("c.id")
-			content, _ := record.Get("c.content")
-			filePath, _ := record.Get("c.file_path")
-			startLine, _ := record.Get("c.start_line")
-			endLine, _ := record.Get("c.end_line")
-			entityType, _ := record.Get("c.entity_type")
-			name, _ := record.Get("c.name")
-			signature, _ := record.Get("c.signature")
-			language, _ := record.Get("c.language")
-			score, _ := record.Get("score")
+		for _, chunk := range chunks {
+			expanded = append(expanded, chunk)
 			
-			chunk := CodeChunk{
-				ID:         id.(string),
-				Content:    content.(string),
-				FilePath:   filePath.(string),
-				StartLine:  int(startLine.(int64)),
-				EndLine:    int(endLine.(int64)),
-				EntityType: entityType.(string),
-				Name:       name.(string),
-				Language:   language.(string),
+			result, err := tx.Run(
+				`MATCH (c:Chunk {id: $id})-[:PART_OF]->(f:File)
+				 CALL {
+				     WITH c, f
+				     MATCH (n:Chunk)-[:PART_OF]->(f)
+				     WHERE n.start_line < c.start_line
+				     RETURN n ORDER BY n.start_line DESC LIMIT $window
+				     UNION
+				     WITH c, f
+				     MATCH (n:Chunk)-[:PART_OF]->(f)
+				     WHERE n.start_line > c.start_line
+				     RETURN n ORDER BY n.start_line ASC LIMIT $window
+				 }
+				 RETURN n.id, n.content, n.file_path, n.start_line, n.end_line,
+				        n.start_byte, n.end_byte, n.entity_type, n.name, n.language`,
+				map[string]interface{}{
+					"id":     chunk.ID,
+					"window": window,
+				},
+			)
+			if err != nil {
+				return nil, err
 			}
 			
-			if signature != nil {
-				chunk.Signature = signature.(string)
+			for result.Next() {
+				record := result.Record()
+				
+				id, _ := record.Get("n.id")
+				content, _ := record.Get("n.content")
+				filePath, _ := record.Get("n.file_path")
+				startLine, _ := record.Get("n.start_line")
+				endLine, _ := record.Get("n.end_line")
+				startByte, _ := record.Get("n.start_byte")
+				endByte, _ := record.Get("n.end_byte")
+				entityType, _ := record.Get("n.entity_type")
+				name, _ := record.Get("n.name")
+				language, _ := record.Get("n.language")
+				
+				neighbor := CodeChunk{}
+				neighbor.ID, _ = id.(string)
+				neighbor.Content, _ = content.(string)
+				neighbor.FilePath, _ = filePath.(string)
+				neighbor.ProjectPath = chunk.ProjectPath // Neighbors share the file
+				neighbor.EntityType, _ = entityType.(string)
+				neighbor.Name, _ = name.(string)
+				neighbor.Language, _ = language.(string)
+				if v, ok := startLine.(int64); ok {
+					neighbor.StartLine = int(v)
+				}
+				if v, ok := endLine.(int64); ok {
+					neighbor.EndLine = int(v)
+				}
+				if v, ok := startByte.(int64); ok {
+					neighbor.StartByte = int(v)
+				}
+				if v, ok := endByte.(int64); ok {
+					neighbor.EndByte = int(v)
+				}
+				contents.load(&neighbor)
+				
+				expanded = append(expanded, neighbor)
 			}
 			
-			// Save the score in the chunk
-			chunk.Score = score.(float64)
-			
-			r.logger.Printf("Found chunk with score %f: %s\n", score.(float64), chunk.ID)
-			chunks = append(chunks, chunk)
+			if err = result.Err(); err != nil {
+				return nil, err
+			}
 		}
 		
-		return chunks, nil
+		return expanded, nil
 	})
-	
 	if err != nil {
-		fmt.Printf("Neo4j search failed: %v\n", err)
-		return nil, fmt.Errorf("search failed: %w", err)
+		return nil, err
 	}
 	
-	chunks := result.([]CodeChunk)
-	fmt.Printf("Search complete. Found %d matching chunks\n", len(chunks))
-	return chunks, nil
+	merged := mergeChunkRanges(result.([]CodeChunk))
+	r.logger.Printf("Expanded %d matched chunks to %d context snippets\n", len(chunks), len(merged))
+	return merged, nil
 }
 
-// QueryLLM sends a query to the LLM with retrieved context
-func (r *Neo4jRAG) QueryLLM(query string, maxTokens int) (string, error) {
-	// First search for relevant code chunks
-	chunks, err := r.SearchCode(query, 5)
-	if err != nil {
-		return "", fmt.Errorf("failed to search for relevant chunks: %w", err)
+// mergeChunkRanges merges chunks of the same file whose line ranges overlap or
+// touch into single snippets. Files keep the order in which they first appear,
+// and each snippet keeps the highest score of the chunks it was built from.
+func mergeChunkRanges(chunks []CodeChunk) []CodeChunk {
+	type fileLines struct {
+		language string
+		lines    map[int]string
+		types    map[int]string
+		names    map[int]string
+		scores   map[int]float64
+		starts   map[int]int // Byte offsets of lines that start a chunk
+		ends     map[int]int // Byte offsets just past lines that end a chunk
 	}
 	
-	// Format prompt with context
-	prompt := "Based on the following code snippets:\n\n"
+	fileOrder := []string{}
+	files := map[string]*fileLines{}
 	
-	for i, chunk := range chunks {
-		prompt += fmt.Sprintf("SNIPPET %d (%s, %s):\n```%s\n%s\n```\n\n",
-			i+1, chunk.FilePath, chunk.EntityType, strings.ToLower(chunk.Language), chunk.Content)
+	for _, chunk := range chunks {
+		fl, ok := files[chunk.FilePath]
+		if !ok {
+			fl = &fileLines{
+				language: chunk.Language,
+				lines:    map[int]string{},
+				types:    map[int]string{},
+				names:    map[int]string{},
+				scores:   map[int]float64{},
+				starts:   map[int]int{},
+				ends:     map[int]int{},
+			}
+			files[chunk.FilePath] = fl
+			fileOrder = append(fileOrder, chunk.FilePath)
+		}
+		
+		lines := strings.Split(strings.TrimSuffix(chunk.Content, "\n"), "\n")
+		if chunk.EndByte > 0 {
+			fl.starts[chunk.StartLine] = chunk.StartByte
+			if chunk.StartLine+len(lines)-1 == chunk.EndLine {
+				fl.ends[chunk.EndLine] = chunk.EndByte
+			}
+		}
+		for i, line := range lines {
+			lineNum := chunk.StartLine + i
+			// Prefer non-empty text: chunk ends can carry an empty trailing line
+			if existing, seen := fl.lines[lineNum]; !seen || (existing == "" && line != "") {
+				fl.lines[lineNum] = line
+			}
+			if _, seen := fl.types[lineNum]; !seen {
+				fl.types[lineNum] = chunk.EntityType
+				fl.names[lineNum] = chunk.Name
+			}
+			if chunk.Score > fl.scores[lineNum] {
+				fl.scores[lineNum] = chunk.Score
+			}
+		}
 	}
 	
-	prompt += fmt.Sprintf("Answer the following question: %s", query)
-	
-	r.logger.Println("Sending query to LLM")
-	
-	// Send to LLM
-	req := LLMRequest{
-		Prompt:      prompt,
-		MaxTokens:   maxTokens,
-		Temperature: 0.2,
+	merged := []CodeChunk{}
+	for _, filePath := range fileOrder {
+		fl := files[filePath]
+		
+		lineNums := make([]int, 0, len(fl.lines))
+		for lineNum := range fl.lines {
+			lineNums = append(lineNums, lineNum)
+		}
+		sort.Ints(lineNums)
+		
+		for start := 0; start < len(lineNums); {
+			end := start
+			for end+1 < len(lineNums) && lineNums[end+1] == lineNums[end]+1 {
+				end++
+			}
+			
+			snippet := CodeChunk{
+				FilePath:   filePath,
+				Language:   fl.language,
+				StartLine:  lineNums[start],
+				EndLine:    lineNums[end],
+				EntityType: fl.types[lineNums[start]],
+				Name:       fl.names[lineNums[start]],
+			}
+			
+			text := make([]string, 0, end-start+1)
+			for _, lineNum := range lineNums[start : end+1] {
+				text = append(text, fl.lines[lineNum])
+				if fl.types[lineNum] != snippet.EntityType || fl.names[lineNum] != snippet.Name {
+					snippet.EntityType = "context"
+					snippet.Name = ""
+				}
+				if fl.scores[lineNum] > snippet.Score {
+					snippet.Score = fl.scores[lineNum]
+				}
+			}
+			snippet.Content = strings.Join(text, "\n")
+			
+			// Offsets are known when the snippet's edges are chunk edges
+			startByte, startOK := fl.starts[snippet.StartLine]
+			endByte, endOK := fl.ends[snippet.EndLine]
+			if startOK && endOK {
+				snippet.StartByte, snippet.EndByte = startByte, endByte
+			}
+			
+			merged = append(merged, snippet)
+			start = end + 1
+		}
 	}
 	
-	reqBody, err := json.Marshal(req)
-	if err != nil {
-		return "", err
+	return merged
+}
+
+// languageForFile gets a file's language, honoring the configured extension
+// overrides before detectLanguage
+func (r *Neo4jRAG) languageForFile(filePath string) string {
+	if lang, ok := r.config.ExtensionLanguageOverrides[strings.ToLower(filepath.Ext(filePath))]; ok {
+		return lang
 	}
 	
-	// Call LLM server
-	resp, err := http.Post(r.config.LLMServerURL, "application/json", bytes.NewBuffer(reqBody))
-	if err != nil {
-		return "", err
+	return detectLanguage(filePath, readFileHead(filePath, shebangSniffSize))
+}
+
+// parseExtensionOverrides parses overrides of the form ".tf=HCL,.gohtml=Go-HTML"
+// and validates each entry
+func parseExtensionOverrides(value string) (map[string]string, error) {
+	overrides := map[string]string{}
+	if strings.TrimSpace(value) == "" {
+		return overrides, nil
 	}
-	defer resp.Body.Close()
 	
-	// Parse response
-	var llmResp LLMResponse
-	err = json.NewDecoder(resp.Body).Decode(&llmResp)
-	if err != nil {
-		return "", err
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid override %q, expected .ext=Language", entry)
+		}
+		
+		ext := strings.ToLower(strings.TrimSpace(parts[0]))
+		lang := strings.TrimSpace(parts[1])
+		
+		if !strings.HasPrefix(ext, ".") || len(ext) < 2 || strings.ContainsAny(ext, `/\*?`) {
+			return nil, fmt.Errorf("invalid extension %q in override, expected a form like .tf", parts[0])
+		}
+		if lang == "" {
+			return nil, fmt.Errorf("empty language for extension %q", ext)
+		}
+		if existing, ok := overrides[ext]; ok && existing != lang {
+			return nil, fmt.Errorf("conflicting overrides for extension %q: %q and %q", ext, existing, lang)
+		}
+		
+		overrides[ext] = lang
 	}
 	
-	r.logger.Printf("LLM response received, tokens used: %d\n", llmResp.TokensUsed)
-	
-	return llmResp.Text, nil
+	return overrides, nil
 }
 
 // getLanguageFromExt gets the language name from file extension
@@ -1429,26 +8445,73 @@ func getLanguageFromExt(ext string) string {
 	ext = strings.ToLower(ext)
 	
 	langMap := map[string]string{
-		".go":   "Go",
-		".py":   "Python",
-		".js":   "JavaScript",
-		".ts":   "TypeScript",
-		".java": "Java",
-		".c":    "C",
-		".cpp":  "C++",
-		".h":    "C/C++ Header",
-		".hpp":  "C++ Header",
-		".cs":   "C#",
-		".php":  "PHP",
-		".rb":   "Ruby",
-		".rs":   "Rust",
-		".swift": "Swift",
-		".kt":   "Kotlin",
-		".sh":   "Shell",
-		".html": "HTML",
-		".css":  "CSS",
-		".sql":  "SQL",
-		".md":   "Markdown",
+		".go":      "Go",
+		".py":      "Python",
+		".js":      "JavaScript",
+		".jsx":     "JavaScript",
+		".ts":      "TypeScript",
+		".tsx":     "TypeScript",
+		".java":    "Java",
+		".c":       "C",
+		".cpp":     "C++",
+		".cc":      "C++",
+		".cxx":     "C++",
+		".h":       "C/C++ Header",
+		".hpp":     "C++ Header",
+		".hxx":     "C++ Header",
+		".cs":      "C#",
+		".php":     "PHP",
+		".rb":      "Ruby",
+		".rs":      "Rust",
+		".swift":   "Swift",
+		".kt":      "Kotlin",
+		".scala":   "Scala",
+		".pl":      "Perl",
+		".pm":      "Perl",
+		".r":       "R",
+		".lua":     "Lua",
+		".groovy":  "Groovy",
+		".dart":    "Dart",
+		".elm":     "Elm",
+		".ex":      "Elixir",
+		".exs":     "Elixir",
+		".erl":     "Erlang",
+		".hrl":     "Erlang",
+		".clj":     "Clojure",
+		".hs":      "Haskell",
+		".fs":      "F#",
+		".fsx":     "F#",
+		".ml":      "OCaml",
+		".mli":     "OCaml",
+		".sh":      "Shell",
+		".bash":    "Shell",
+		".zsh":     "Shell",
+		".fish":    "Shell",
+		".ps1":     "PowerShell",
+		".bat":     "Batch",
+		".cmd":     "Batch",
+		".html":    "HTML",
+		".htm":     "HTML",
+		".xhtml":   "HTML",
+		".css":     "CSS",
+		".scss":    "SCSS",
+		".sass":    "Sass",
+		".less":    "Less",
+		".vue":     "Vue",
+		".svelte":  "Svelte",
+		".json":    "JSON",
+		".yaml":    "YAML",
+		".yml":     "YAML",
+		".xml":     "XML",
+		".toml":    "TOML",
+		".ini":     "INI",
+		".sql":     "SQL",
+		".graphql": "GraphQL",
+		".proto":   "Protocol Buffers",
+		".md":      "Markdown",
+		".rst":     "reStructuredText",
+		".tex":     "TeX",
+		".adoc":    "AsciiDoc",
 	}
 	
 	if lang, ok := langMap[ext]; ok {
@@ -1458,13 +8521,140 @@ func getLanguageFromExt(ext string) string {
 	return "Unknown"
 }
 
-// processQuery handles processing a query and displaying results
-func processQuery(rag *Neo4jRAG, query string, jsonOutput bool, generateLLMResponse bool, limit int, explicitLanguages []string, explicitPathFilters []string, explicitMinScore float64, explicitUseKeywords bool) {
-	fmt.Println("\nQuery:", query)
-	fmt.Println("\nSearching for relevant code...")
+// filenameLanguages maps well-known file names without a telling extension
+// to their language
+var filenameLanguages = map[string]string{
+	"Dockerfile":     "Dockerfile",
+	"Containerfile":  "Dockerfile",
+	"Makefile":       "Makefile",
+	"makefile":       "Makefile",
+	"GNUmakefile":    "Makefile",
+	"CMakeLists.txt": "CMake",
+	"Jenkinsfile":    "Groovy",
+	"Rakefile":       "Ruby",
+	"Gemfile":        "Ruby",
+	"Vagrantfile":    "Ruby",
+	"Podfile":        "Ruby",
+	".bashrc":        "Shell",
+	".bash_profile":  "Shell",
+	".zshrc":         "Shell",
+	".profile":       "Shell",
+}
+
+// shebangLanguages maps "#!" interpreter names, without version suffixes, to
+// languages
+var shebangLanguages = map[string]string{
+	"python":  "Python",
+	"node":    "JavaScript",
+	"nodejs":  "JavaScript",
+	"deno":    "TypeScript",
+	"ts-node": "TypeScript",
+	"sh":      "Shell",
+	"bash":    "Shell",
+	"zsh":     "Shell",
+	"ksh":     "Shell",
+	"dash":    "Shell",
+	"fish":    "Shell",
+	"ruby":    "Ruby",
+	"perl":    "Perl",
+	"php":     "PHP",
+	"lua":     "Lua",
+	"Rscript": "R",
+	"pwsh":    "PowerShell",
+	"groovy":  "Groovy",
+	"elixir":  "Elixir",
+}
+
+// shebangSniffSize is how much of a file is read to find a "#!" line
+const shebangSniffSize = 256
+
+// detectLanguage gets a file's language from its extension, falling back to
+// well-known file names (Dockerfile, Makefile, ...) and then to the "#!"
+// interpreter line at the start of content
+func detectLanguage(path string, content []byte) string {
+	if lang := getLanguageFromExt(filepath.Ext(path)); lang != "Unknown" {
+		return lang
+	}
+	if lang := languageFromFilename(filepath.Base(path)); lang != "" {
+		return lang
+	}
+	if lang := languageFromShebang(content); lang != "" {
+		return lang
+	}
+	
+	return "Unknown"
+}
+
+// languageFromFilename returns the language of a well-known file name, or ""
+func languageFromFilename(name string) string {
+	if lang, ok := filenameLanguages[name]; ok {
+		return lang
+	}
+	if strings.HasPrefix(name, "Dockerfile.") || strings.HasSuffix(name, ".dockerfile") {
+		return "Dockerfile"
+	}
+	return ""
+}
+
+// languageFromShebang returns the language of the interpreter named on a
+// "#!" first line, such as "#!/usr/bin/env python3" or "#!/bin/bash -e",
+// or "" when content has no recognized shebang
+func languageFromShebang(content []byte) string {
+	if !bytes.HasPrefix(content, []byte("#!")) {
+		return ""
+	}
+	line := content[2:]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return ""
+	}
+	interpreter := filepath.Base(fields[0])
+	
+	// env runs the first argument that is not an option or variable assignment
+	if interpreter == "env" {
+		interpreter = ""
+		for _, field := range fields[1:] {
+			if strings.HasPrefix(field, "-") || strings.Contains(field, "=") {
+				continue
+			}
+			interpreter = filepath.Base(field)
+			break
+		}
+	}
+	
+	// python3.11 -> python
+	interpreter = strings.TrimRight(interpreter, "0123456789.")
+	return shebangLanguages[interpreter]
+}
+
+// readFileHead returns up to n bytes from the start of a file, or nil when it
+// cannot be read
+func readFileHead(filePath string, n int) []byte {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	
+	buf := make([]byte, n)
+	read, _ := io.ReadFull(file, buf)
+	return buf[:read]
+}
+
+// detectQueryFilters fills in language and path filters mentioned in the
+// query text ("python", "in directory foo") when opts has none
+func detectQueryFilters(query string, opts SearchOptions) SearchOptions {
+	// Code snippets mention languages and paths without asking for them
+	if opts.Similar {
+		return opts
+	}
 	
 	// Auto-detect language filters from query if not explicitly provided
-	languages := explicitLanguages
+	languages := opts.Languages
 	if len(languages) == 0 {
 		languages = []string{}
 		queryLower := strings.ToLower(query)
@@ -1503,7 +8693,7 @@ func processQuery(rag *Neo4jRAG, query string, jsonOutput bool, generateLLMRespo
 	}
 	
 	// Extract path filters from query if not explicitly provided
-	pathFilters := explicitPathFilters
+	pathFilters := opts.PathFilters
 	if len(pathFilters) == 0 {
 		pathFilters = []string{}
 		queryLower := strings.ToLower(query)
@@ -1539,40 +8729,192 @@ func processQuery(rag *Neo4jRAG, query string, jsonOutput bool, generateLLMRespo
 		}
 	}
 	
-	// Use provided parameters or defaults
-	minScore := explicitMinScore
-	useKeywords := explicitUseKeywords
+	opts.Languages = languages
+	opts.PathFilters = pathFilters
+	
+	// Questions about how something is implemented want its definition
+	if definitionQueryPattern.MatchString(query) {
+		opts.Definitions = true
+	}
+	
+	return opts
+}
+
+// definitionQueryPattern matches queries asking for a definition, like "how
+// is parseConfig implemented" or "where is Reset defined"
+var definitionQueryPattern = regexp.MustCompile(`(?i)\b(implemented|defined|declared|implementation of|definition of|declaration of)\b`)
+
+// QueryResult is the machine-readable result of a query printed with --json-output
+type QueryResult struct {
+	Query   string      `json:"query"`
+	Results []CodeChunk `json:"results"`
+	Answer  string      `json:"answer,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// maxQueryConcurrency caps --concurrency, so a batch cannot flood the
+// embedding and LLM servers with requests
+const maxQueryConcurrency = 16
+
+// runQuery runs a query and collects the ranked chunks, plus the LLM answer
+// when requested. Errors are reported in the result's error field and
+// returned.
+func runQuery(rag *Neo4jRAG, query string, generateLLMResponse bool, llmOpts LLMOptions, opts SearchOptions) (QueryResult, error) {
+	result := QueryResult{Query: query, Results: []CodeChunk{}}
+	
+	chunks, err := rag.SearchCodeWithOptions(query, detectQueryFilters(query, opts))
+	if err == nil {
+		if opts.Highlight {
+			highlightChunksHTML(chunks)
+		}
+		result.Results = chunks
+		if generateLLMResponse {
+			result.Answer, err = rag.QueryLLM(query, llmOpts)
+		}
+		if logErr := rag.LogQuery(query, chunks, result.Answer); logErr != nil {
+			rag.logger.Warnf("%v", logErr)
+		}
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	
+	return result, err
+}
+
+// printQueryJSON runs a query and prints the ranked chunks, plus the LLM
+// answer when requested, as a single JSON object. Errors are reported in the
+// object's error field and returned.
+func printQueryJSON(rag *Neo4jRAG, query string, generateLLMResponse bool, llmOpts LLMOptions, opts SearchOptions) error {
+	result, err := runQuery(rag, query, generateLLMResponse, llmOpts, opts)
+	
+	output, marshalErr := json.MarshalIndent(result, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+	fmt.Println(string(output))
+	
+	return err
+}
+
+// loadQueries reads a --queries-file: one query per line, blank lines
+// skipped
+func loadQueries(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	
+	var queries []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if query := strings.TrimSpace(scanner.Text()); query != "" {
+			queries = append(queries, query)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return queries, nil
+}
+
+// runQueries runs each query like --json-output does, up to concurrency of them at
+// a time, and writes one QueryResult per line to w in the order of queries.
+// Results are written as soon as every earlier query has finished. It
+// returns how many queries failed; the error is for writing to w.
+func runQueries(rag *Neo4jRAG, queries []string, concurrency int, w io.Writer, generateLLMResponse bool, llmOpts LLMOptions, opts SearchOptions) (int, error) {
+	results := make([]chan QueryResult, len(queries))
+	for i := range results {
+		results[i] = make(chan QueryResult, 1)
+	}
+	
+	// Start each query once one of the concurrency slots is free
+	slots := make(chan struct{}, concurrency)
+	go func() {
+		for i, query := range queries {
+			slots <- struct{}{}
+			go func(i int, query string) {
+				defer func() { <-slots }()
+				result, err := runQuery(rag, query, generateLLMResponse, llmOpts, opts)
+				if err != nil {
+					rag.logger.Warnf("Query %q failed: %v", query, err)
+				}
+				results[i] <- result
+			}(i, query)
+		}
+	}()
 	
-	// Log the search parameters if not in JSON mode
-	if !jsonOutput {
-		if len(languages) > 0 {
-			fmt.Printf("Language filters: %v\n", languages)
+	encoder := json.NewEncoder(w)
+	failed := 0
+	for i, result := range results {
+		queryResult := <-result
+		if queryResult.Error != "" {
+			failed++
 		}
-		if len(pathFilters) > 0 {
-			fmt.Printf("Path filters: %v\n", pathFilters)
+		if err := encoder.Encode(queryResult); err != nil {
+			return failed, err
 		}
+		rag.logger.Printf("Finished query %d/%d", i+1, len(queries))
+	}
+	return failed, nil
+}
+
+// processQuery handles processing a query and displaying results.
+// Language and path filters missing from opts are auto-detected from the query.
+func processQuery(rag *Neo4jRAG, query string, generateLLMResponse bool, llmOpts LLMOptions, opts SearchOptions) {
+	fmt.Println("\nQuery:", query)
+	fmt.Println("\nSearching for relevant code...")
+	
+	opts = detectQueryFilters(query, opts)
+	
+	// Log the search parameters
+	if len(opts.Languages) > 0 {
+		fmt.Printf("Language filters: %v\n", opts.Languages)
+	}
+	if len(opts.PathFilters) > 0 {
+		fmt.Printf("Path filters: %v\n", opts.PathFilters)
+	}
+	if len(opts.ExcludeFiles) > 0 {
+		fmt.Printf("Excluded files: %v\n", opts.ExcludeFiles)
+	}
+	if len(opts.EntityTypes) > 0 {
+		fmt.Printf("Entity types: %v\n", opts.EntityTypes)
+	}
+	if !opts.UpdatedAfter.IsZero() {
+		fmt.Printf("Updated after: %s\n", opts.UpdatedAfter.Format(time.RFC3339))
+	}
+	if !opts.UpdatedBefore.IsZero() {
+		fmt.Printf("Updated before: %s\n", opts.UpdatedBefore.Format(time.RFC3339))
+	}
+	if len(opts.ProjectPaths) > 0 {
+		fmt.Printf("Projects: %v\n", opts.ProjectPaths)
+	}
+	if opts.ScoreBand != nil {
+		fmt.Printf("Score band: %.2f-%.2f\n", opts.ScoreBand.Low, opts.ScoreBand.High)
+	}
+	if opts.Definitions {
+		fmt.Println("Favoring definitions")
+	}
+	if opts.Offset > 0 {
+		fmt.Printf("Skipping the first %d results\n", opts.Offset)
 	}
 	
 	// Use the advanced search
-	chunks, err := rag.SearchCodeAdvanced(query, limit, languages, pathFilters, minScore, useKeywords)
+	chunks, err := rag.SearchCodeWithOptions(query, opts)
 	if err != nil {
 		fmt.Printf("Error searching for code: %v\n", err)
 		return
 	}
 	
-	// Handle JSON output mode
-	if jsonOutput {
-		// Marshal chunks to JSON
-		jsonData, err := json.Marshal(chunks)
-		if err != nil {
-			fmt.Printf("Error marshaling to JSON: %v\n", err)
-			return
+	// Record the query once done, with the answer if one is generated
+	answer := ""
+	defer func() {
+		if err := rag.LogQuery(query, chunks, answer); err != nil {
+			rag.logger.Warnf("%v", err)
 		}
-		
-		// Print JSON output
-		fmt.Println(string(jsonData))
-		return
-	}
+	}()
 	
 	// Display results with more context in normal mode
 	if len(chunks) == 0 {
@@ -1580,7 +8922,7 @@ func processQuery(rag *Neo4jRAG, query string, jsonOutput bool, generateLLMRespo
 	} else {
 		fmt.Println("\nRelevant code chunks:")
 		for i, chunk := range chunks {
-			fmt.Printf("\n--- Chunk %d ---\n", i+1)
+			fmt.Printf("\n--- Chunk %d ---\n", opts.Offset+i+1)
 			
 			// Display detailed file information with absolute path
 			absPath, err := filepath.Abs(chunk.FilePath)
@@ -1615,6 +8957,23 @@ func processQuery(rag *Neo4jRAG, query string, jsonOutput bool, generateLLMRespo
 				fmt.Printf("\nSignature: %s", chunk.Signature)
 			}
 			
+			// Display graph context if requested
+			if chunk.ProjectName != "" {
+				fmt.Printf("\nProject: %s", chunk.ProjectName)
+			}
+			if len(chunk.Tags) > 0 {
+				fmt.Printf("\nTags: %s", strings.Join(chunk.Tags, ", "))
+			}
+			
+			// Display the score breakdown if requested
+			if b := chunk.Breakdown; b != nil {
+				fmt.Printf("\nScore: %.4f (vector %.4f, keyword %.4f, base %.4f, name bonus %+.2f, definition bonus %+.2f, entity boost %+.2f, size boost %+.2f, size penalty %+.2f)",
+					chunk.Score, b.VectorScore, b.KeywordScore, b.BaseScore, b.NameBonus, b.DefinitionBonus, b.EntityBoost, b.SizeBoost, b.SizePenalty)
+				if len(b.MatchedKeywords) > 0 {
+					fmt.Printf("\nMatched keywords: %s", strings.Join(b.MatchedKeywords, ", "))
+				}
+			}
+			
 			fmt.Println("\n\nContent Preview:")
 			
 			// Print snippet of code (show more lines for better context)
@@ -1624,7 +8983,11 @@ func processQuery(rag *Neo4jRAG, query string, jsonOutput bool, generateLLMRespo
 				maxLines = len(lines)
 			}
 			for j := 0; j < maxLines; j++ {
-				fmt.Printf("%d: %s\n", chunk.StartLine+j, lines[j])
+				line := lines[j]
+				if opts.Highlight {
+					line = highlightKeywords(line, chunk.MatchedKeywords, ansiHighlight, ansiReset, nil)
+				}
+				fmt.Printf("%d: %s\n", chunk.StartLine+j, line)
 			}
 			if len(lines) > maxLines {
 				fmt.Printf("... (%d more lines not shown)\n", len(lines) - maxLines)
@@ -1687,7 +9050,7 @@ func processQuery(rag *Neo4jRAG, query string, jsonOutput bool, generateLLMRespo
 	}
 	
 	// Get answer from LLM
-	answer, err := rag.QueryLLM(query, 1000)
+	answer, err = rag.QueryLLM(query, llmOpts)
 	if err != nil {
 		fmt.Printf("Error generating answer: %v\n", err)
 		return
@@ -1701,25 +9064,63 @@ func processQuery(rag *Neo4jRAG, query string, jsonOutput bool, generateLLMRespo
 		fmt.Println(answer)
 	}
 }
+
 // extractKeywords extracts important keywords from a query string
-func extractKeywords(query string) []string {
-	// Split the query into words
-	words := strings.Fields(strings.ToLower(query))
-	
-	// Filter out common stop words
-	stopWords := map[string]bool{
-		"a": true, "an": true, "the": true, "and": true, "or": true, "but": true,
-		"is": true, "are": true, "was": true, "were": true, "be": true, "been": true,
-		"being": true, "have": true, "has": true, "had": true, "do": true, "does": true,
-		"did": true, "to": true, "from": true, "in": true, "out": true, "on": true,
-		"off": true, "over": true, "under": true, "again": true, "further": true,
-		"then": true, "once": true, "here": true, "there": true, "when": true,
-		"where": true, "why": true, "how": true, "all": true, "any": true, "both": true,
-		"each": true, "few": true, "more": true, "most": true, "other": true, "some": true,
-		"such": true, "no": true, "nor": true, "not": true, "only": true, "own": true,
-		"same": true, "so": true, "than": true, "too": true, "very": true, "can": true,
-		"will": true, "just": true, "should": true, "now": true,
+func extractKeywords(query string, stopWords map[string]bool) []string {
+	terms := queryTerms(query, stopWords)
+	for i, term := range terms {
+		terms[i] = strings.ToLower(term)
+	}
+	return terms
+}
+
+// defaultStopWords are the common English words left out of query keywords
+var defaultStopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "and": true, "or": true, "but": true,
+	"is": true, "are": true, "was": true, "were": true, "be": true, "been": true,
+	"being": true, "have": true, "has": true, "had": true, "do": true, "does": true,
+	"did": true, "to": true, "from": true, "in": true, "out": true, "on": true,
+	"off": true, "over": true, "under": true, "again": true, "further": true,
+	"then": true, "once": true, "here": true, "there": true, "when": true,
+	"where": true, "why": true, "how": true, "all": true, "any": true, "both": true,
+	"each": true, "few": true, "more": true, "most": true, "other": true, "some": true,
+	"such": true, "no": true, "nor": true, "not": true, "only": true, "own": true,
+	"same": true, "so": true, "than": true, "too": true, "very": true, "can": true,
+	"will": true, "just": true, "should": true, "now": true,
+}
+
+// loadStopWords reads a stop word file: words separated by whitespace or
+// newlines, with "#" starting a comment that runs to the end of the line.
+// Words are lowercased and returned once each, in the order they first
+// appear.
+func loadStopWords(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	
+	var words []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		for _, word := range strings.Fields(line) {
+			word = strings.ToLower(word)
+			if !seen[word] {
+				seen[word] = true
+				words = append(words, word)
+			}
+		}
 	}
+	return words, nil
+}
+
+// queryTerms splits a query into words with their case kept, dropping
+// punctuation, the stop words and single characters
+func queryTerms(query string, stopWords map[string]bool) []string {
+	// Split the query into words
+	words := strings.Fields(query)
 	
 	keywords := []string{}
 	for _, word := range words {
@@ -1727,29 +9128,186 @@ func extractKeywords(query string) []string {
 		word = strings.Trim(word, ".,;:!?()[]{}-\"'`")
 		
 		// Skip empty words, stop words, and single characters
-		if word == "" || stopWords[word] || len(word) <= 1 {
+		if word == "" || stopWords[strings.ToLower(word)] || len(word) <= 1 {
+			continue
+		}
+		
+		keywords = append(keywords, word)
+	}
+	
+	return keywords
+}
+
+// maxIdentifierParts is the most words an identifier is split into; longer
+// identifiers are kept whole rather than flooding the keyword pre-filter
+// with parameters
+const maxIdentifierParts = 6
+
+// splitIdentifierTerms returns the terms followed by the words of each
+// camelCase or snake_case term, without duplicates
+func splitIdentifierTerms(terms []string) []string {
+	seen := map[string]bool{}
+	expanded := []string{}
+	add := func(term string) {
+		if !seen[term] {
+			seen[term] = true
+			expanded = append(expanded, term)
+		}
+	}
+	
+	for _, term := range terms {
+		add(term)
+	}
+	for _, term := range terms {
+		parts := splitIdentifier(term)
+		if len(parts) < 2 || len(parts) > maxIdentifierParts {
+			continue
+		}
+		for _, part := range parts {
+			add(part)
+		}
+	}
+	return expanded
+}
+
+// splitIdentifier splits an identifier into its words at underscores,
+// hyphens, dots, lower-to-upper case changes and the end of an acronym
+// (HTTPServer yields HTTP and Server). Digits stay with the word before.
+func splitIdentifier(identifier string) []string {
+	runes := []rune(identifier)
+	parts := []string{}
+	start := 0
+	flush := func(end int) {
+		if end > start {
+			parts = append(parts, string(runes[start:end]))
+		}
+	}
+	
+	for i, c := range runes {
+		switch {
+		case c == '_' || c == '-' || c == '.':
+			flush(i)
+			start = i + 1
+		case i > start && unicode.IsUpper(c):
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				flush(i)
+				start = i
+			}
+		}
+	}
+	flush(len(runes))
+	return parts
+}
+
+// expandPathVariants returns each path in both its cleaned and absolute form,
+// since chunks and projects store whichever form of the path was used at
+// index time
+func expandPathVariants(paths []string) []string {
+	seen := map[string]bool{}
+	expanded := []string{}
+	
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if path == "" {
 			continue
 		}
 		
-		keywords = append(keywords, word)
+		candidates := []string{path, filepath.Clean(path)}
+		if absPath, err := filepath.Abs(path); err == nil {
+			candidates = append(candidates, absPath)
+		}
+		
+		for _, candidate := range candidates {
+			if !seen[candidate] {
+				seen[candidate] = true
+				expanded = append(expanded, candidate)
+			}
+		}
 	}
 	
-	return keywords
+	return expanded
 }
 
-// globToRegex converts a glob pattern to a regex pattern
+// globToRegex converts a glob pattern to a regex pattern matching whole file
+// paths. "*" and "?" match any run of characters and any one character,
+// directory separators included, so "*handlers*" matches anywhere in a path.
+// "**/" matches zero or more whole directories, and "[abc]", "[a-z]" and
+// "[!abc]" match one character of a class. A "[" that does not open a
+// non-empty class is matched literally.
 func globToRegex(pattern string) string {
-	// Escape special regex characters
-	regex := regexp.QuoteMeta(pattern)
-	
-	// Convert glob wildcards to regex wildcards
-	regex = strings.ReplaceAll(regex, "\\*", ".*")
-	regex = strings.ReplaceAll(regex, "\\?", ".")
-	
-	// Add start and end anchors
-	regex = "^" + regex + "$"
+	var regex strings.Builder
+	regex.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**/") {
+				regex.WriteString("(?:.*/)?")
+				i += 2
+				continue
+			}
+			regex.WriteString(".*")
+			for i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+			}
+		case '?':
+			regex.WriteString(".")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 || strings.TrimLeft(pattern[i+1:i+1+end], "!^") == "" {
+				regex.WriteString(`\[`)
+				continue
+			}
+			regex.WriteString(globClassToRegex(pattern[i+1 : i+1+end]))
+			i += end + 1
+		default:
+			regex.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	regex.WriteString("$")
 	
-	return regex
+	return regex.String()
+}
+
+// globClassToRegex converts the inside of a glob character class, like "a-z"
+// or "!abc", to a regex character class. ASCII symbols other than ranges
+// are escaped, as Neo4j's Java regexes give "&&" and "[" meanings of their
+// own.
+func globClassToRegex(class string) string {
+	var regex strings.Builder
+	regex.WriteString("[")
+	if strings.HasPrefix(class, "!") || strings.HasPrefix(class, "^") {
+		regex.WriteString("^")
+		class = class[1:]
+	}
+	for i := 0; i < len(class); i++ {
+		c := class[i]
+		isRange := c == '-' && i > 0 && i < len(class)-1
+		if !isRange && c < utf8.RuneSelf && !unicode.IsLetter(rune(c)) && !unicode.IsDigit(rune(c)) {
+			regex.WriteString(`\`)
+		}
+		regex.WriteByte(c)
+	}
+	regex.WriteString("]")
+	return regex.String()
+}
+
+// dirListFlag collects directories from a flag that may be repeated, each
+// value holding one or more comma-separated directories
+type dirListFlag []string
+
+func (d *dirListFlag) String() string {
+	return strings.Join(*d, ",")
+}
+
+func (d *dirListFlag) Set(value string) error {
+	for _, dir := range strings.Split(value, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			*d = append(*d, dir)
+		}
+	}
+	return nil
 }
 
 func main() {
@@ -1759,39 +9317,432 @@ func main() {
 	neo4jPassword := flag.String("neo4j-password", "password", "Neo4j password")
 	embeddingURL := flag.String("embedding-url", "http://localhost:8080/embeddings", "URL for embedding service")
 	llmURL := flag.String("llm-url", "http://localhost:8081/completion", "URL for LLM service")
-	maxChunkSize := flag.Int("max-chunk-size", 1000, "Maximum chunk size in characters")
-	chunkOverlap := flag.Int("chunk-overlap", 100, "Chunk overlap in characters")
-	codeDir := flag.String("code-dir", "", "Directory to index")
+	llmProvider := flag.String("llm-provider", llmProviderCompletion, "API spoken at --llm-url: completion, openai (chat completions, also Ollama and llama.cpp server) or mock (canned answers, no server)")
+	llmModel := flag.String("llm-model", "", "Model name for --llm-provider=openai")
+	llmAPIKey := flag.String("llm-api-key", "", "API key for --llm-provider=openai (default: $OPENAI_API_KEY)")
+	rerankURL := flag.String("rerank-url", "", "URL for cross-encoder reranking service (empty disables reranking)")
+	embeddingBackend := flag.String("embedding-backend", embeddingBackendHTTP, "How embeddings are computed: http (the --embedding-url service), onnx (in process from --onnx-model-dir; needs a build with -tags onnx) or mock (hashed words, for trying indexing and search without a model)")
+	onnxModelDir := flag.String("onnx-model-dir", "", "Directory holding model.onnx and vocab.txt for --embedding-backend=onnx")
+	onnxRuntime := flag.String("onnx-runtime", "", "Path to the ONNX Runtime shared library (default: the platform's library name on the loader path)")
+	ensembleURL := flag.String("ensemble-models", "", "URL of a second embedding service; chunks are embedded by both models (doubles embedding cost and storage)")
+	maxChunkSize := flag.Int("max-chunk-size", 1000, "Maximum chunk size, in --chunk-unit")
+	chunkOverlap := flag.Int("chunk-overlap", 100, "Chunk overlap, in --chunk-unit")
+	chunkUnit := flag.String("chunk-unit", chunkUnitChars, "Unit of --max-chunk-size and --chunk-overlap: chars, or tokens (estimated at four characters per token) to stay within the embedding model's input limit")
+	maxFileSizeMB := flag.Float64("max-file-size", float64(defaultMaxFileSize)/(1024*1024), "Largest file to index, in MB")
+	var codeDirs dirListFlag
+	flag.Var(&codeDirs, "code-dir", "Directory to index; repeat the flag or separate directories with commas to index several into one index, each as its own project")
+	extraExtensions := flag.String("extensions", "", "Comma-separated list of additional file extensions to index (e.g. .tpl,.tf)")
+	extensionOverrides := flag.String("extension-language-overrides", "", "Comma-separated .ext=Language pairs to tag custom file types (e.g. .tf=HCL,.gohtml=Go-HTML)")
 	dbName := flag.String("db-name", "coderag", "Database name")
 	
 	indexCmd := flag.Bool("index", false, "Index code directory")
+	binaryThreshold := flag.Float64("binary-threshold", defaultBinaryThreshold, "Skip files whose first 8KB has more than this fraction of non-printable bytes (files with null bytes are always skipped)")
+	projectLayout := flag.String("project-layout", projectLayoutTopLevel, "How indexed files are grouped into projects: top-level (each top-level directory of --code-dir is a project), root (--code-dir is one project) or manifest (the nearest directory with a go.mod, package.json, Cargo.toml, pyproject.toml or .git)")
+	noDefaultIgnores := flag.Bool("no-default-ignores", false, "Index directories skipped by default that may hold source (env, docs, build, bin, ...); VCS, dependency and virtualenv directories are still skipped")
+	respectGitignore := flag.Bool("respect-gitignore", false, "Skip paths matched by .gitignore files in the indexed directory (applied after the built-in ignore list)")
+	indexVendored := flag.Bool("index-vendored", false, "Index dependency directories (vendor, node_modules, site-packages) and tag their chunks as vendored")
+	storeContent := flag.Bool("store-content", true, "Store chunk content in Neo4j; with --store-content=false only metadata and embeddings are stored and results read content from the indexed files, which must stay on disk")
+	deferEmbeddings := flag.Bool("defer-embeddings", false, "Store chunks first and backfill embeddings afterwards, so keyword search works immediately")
+	embedPending := flag.Bool("embed-pending", false, "Generate embeddings for chunks stored without them")
+	resetCmd := flag.Bool("reset", false, "Delete all indexed projects, files and chunks")
+	resetProject := flag.String("reset-project", "", "Delete one project's files and chunks from the index")
+	yes := flag.Bool("yes", false, "Do not ask for confirmation (used with --reset, --reset-project and --verify --fix)")
+	exportPath := flag.String("export", "", "Export all chunks as JSONL to this file")
+	exportEmbeddings := flag.Bool("export-embeddings", false, "Include embeddings in the export (used with --export)")
+	importPath := flag.String("import", "", "Import chunks from a JSONL file written by --export")
+	importBatchSize := flag.Int("import-batch-size", defaultImportBatchSize, "Chunks written per transaction (used with --import)")
+	force := flag.Bool("force", false, "Import even if embedding dimensions do not match the embedding service (used with --import)")
+	healthCmd := flag.Bool("health", false, "Check that Neo4j, the embedding service and the LLM service are reachable and print the status as JSON")
+	logQueries := flag.Bool("log-queries", false, "Record each query, its results and the LLM answer in Neo4j for --query-stats")
+	queryStatsCmd := flag.Bool("query-stats", false, "Print the most frequent logged queries, those without results and the most retrieved chunks (up to --limit each)")
+	verifyCmd := flag.Bool("verify", false, "Check the index for chunks of deleted files, files without chunks and bad embeddings (paths listed up to --limit)")
+	fixCmd := flag.Bool("fix", false, "With --verify, delete the problem files and chunks and mark chunks with bad embeddings for --embed-pending")
+	findDuplicates := flag.Bool("find-duplicates", false, "Report clusters of identical or near-identical chunks across the index (clusters listed up to --limit)")
+	duplicateThreshold := flag.Float64("duplicate-threshold", defaultDuplicateThreshold, "Lowest embedding similarity of two chunks reported as duplicates (used with --find-duplicates)")
+	duplicateMinLines := flag.Int("duplicate-min-lines", defaultDuplicateMinLines, "Shortest chunk, in lines, checked for duplicates (used with --find-duplicates)")
+	statsCmd := flag.Bool("stats", false, "Print index statistics (chunk counts per language, entity type and project, embedding dimensions)")
+	lineIncremental := flag.Bool("line-incremental", false, "Only re-embed chunks touching lines changed (per git diff) since a file was last indexed")
+	since := flag.String("since", "", "With --index, only index files modified after this RFC 3339 time (e.g. 2024-05-01T12:00:00Z)")
+	sinceCommit := flag.String("since-commit", "", "With --index, only index files changed since this git commit, including uncommitted and untracked files")
+	watch := flag.Bool("watch", false, "Keep the index of --code-dir current by re-indexing files as they change (after indexing, with --index)")
+	fileTimeout := flag.Duration("file-timeout", 15*time.Minute, "Longest time to spend embedding and storing one file before skipping it (0 = no limit)")
+	showChunks := flag.Bool("show-chunks", false, "Chunk the file given by --file and print the chunks, without embedding or storing anything (use --json-output for JSON)")
+	chunkTarget := flag.String("file", "", "File to chunk (used with --show-chunks)")
+	embeddingTimeout := flag.Duration("embedding-timeout", defaultEmbeddingTimeout, "Longest time to wait for one embedding or reranking request attempt")
+	llmTimeout := flag.Duration("llm-timeout", defaultLLMTimeout, "Longest time to wait for one LLM request")
+	dryRun := flag.Bool("dry-run", false, "With --index, chunk files and report chunk counts and embedding requests without embedding or storing anything")
+	forceReindex := flag.Bool("force-reindex", false, "Clear and rebuild projects indexed with a different chunker version")
 	queryCmd := flag.Bool("query", false, "Query the system")
 	queryString := flag.String("query-string", "", "Query string to search for (used with --query)")
+	queryFile := flag.String("query-file", "", "Read the query from a file; the file itself is excluded from results (used with --query)")
+	similarToFile := flag.String("similar-to-file", "", "Find indexed code similar to the code in this file, by embedding alone; the file itself and the chunk it was copied from are excluded")
+	similarToSnippet := flag.String("similar-to-snippet", "", "Find indexed code similar to this code snippet, like --similar-to-file")
+	queriesFile := flag.String("queries-file", "", "Run every query in a file, one per line, and write the results as JSON lines to --output (used with --query)")
+	queriesOutput := flag.String("output", "", "File to write --queries-file results to (default standard output)")
+	concurrency := flag.Int("concurrency", 1, fmt.Sprintf("Queries of --queries-file to run at once (at most %d)", maxQueryConcurrency))
 	
 	// Advanced search options
 	languages := flag.String("languages", "", "Comma-separated list of languages to filter by")
 	pathFilters := flag.String("path-filters", "", "Comma-separated list of path patterns to filter by")
+	projects := flag.String("project", "", "Comma-separated list of project paths to restrict the search to")
+	entityTypes := flag.String("entity-types", "", "Comma-separated list of entity types to return (e.g. function,method,class)")
+	updatedAfter := flag.String("updated-after", "", "Only return chunks indexed at or after this RFC3339 time (e.g. 2024-05-01T00:00:00Z)")
+	updatedBefore := flag.String("updated-before", "", "Only return chunks indexed before this RFC3339 time")
+	includeVendored := flag.Bool("include-vendored", false, "Include vendored dependency code in search results")
+	excludeVendored := flag.Bool("exclude-vendored", true, "Exclude vendored dependency code from search results (set to false to include it)")
+	excludeFile := flag.String("exclude-file", "", "Comma-separated list of exact file paths to exclude from results")
 	minScore := flag.Float64("min-score", 0.1, "Minimum similarity score (0.0-1.0)")
+	retryMinScore := flag.Float64("retry-min-score", 0.05, "Lower minimum score to retry a search with, once, when nothing scores above --min-score (0 disables the retry)")
 	useKeywords := flag.Bool("use-keywords", true, "Use keyword matching for better results")
+	minKeywordLength := flag.Int("min-keyword-length", defaultMinKeywordLength, "Shortest query term used for keyword matching")
+	splitIdentifiers := flag.Bool("split-identifiers", false, "Also match the words of camelCase and snake_case query terms, e.g. get, User, By and Id for getUserById")
+	stopWordsFile := flag.String("stopwords-file", "", "File of extra query stop words, separated by whitespace or newlines, with # comments (e.g. function, method, code)")
+	noDefaultStopWords := flag.Bool("no-default-stopwords", false, "Do not leave out the built-in English stop words, only those of --stopwords-file")
+	definitions := flag.Bool("definitions", false, "Favor function, method and class chunks over other matches (implied by queries like \"how is X implemented\")")
+	explain := flag.Bool("explain", false, "Show how each result's score was reached: vector and keyword scores, boosts and matched keywords")
+	highlight := flag.Bool("highlight", false, "Highlight the query keywords that drove each match: in color in content previews, and as <mark> in a highlighted field of JSON results")
+	keywords := flag.String("keywords", "", "Comma-separated list of extra keywords to match, used regardless of --min-keyword-length")
 	limit := flag.Int("limit", 5, "Maximum number of results to return")
+	offset := flag.Int("offset", 0, "Number of top-ranked results to skip, for paging past the first --limit results")
+	includeContext := flag.Bool("include-context", false, "Include file language, project name and tags with each result")
+	contextChunks := flag.Int("context-chunks", llmContextChunks, "Number of retrieved chunks to include in LLM prompts")
+	contextWindow := flag.Int("context-window", 0, "Number of neighboring chunks to include before/after each match in LLM prompts (0 = off)")
+	fullFile := flag.Bool("full-file", false, "Put the top result's whole file in LLM prompts instead of its chunks, falling back to the chunks for files over --full-file-max-size or no longer on disk")
+	fullFileMaxSize := flag.Int("full-file-max-size", defaultFullFileMaxSize/1024, "Largest file --full-file includes whole, in KB")
+	scoreBand := flag.String("score-band", "", "Only return chunks whose similarity lies in this band, e.g. 0.4-0.6 (capped by --limit)")
+	fuse := flag.Bool("fuse", false, "Retrieve with both embedding models and fuse the rankings with reciprocal rank fusion (requires --ensemble-models)")
+	normalizeEmbeddings := flag.Bool("normalize-embeddings", false, "Scale embeddings to unit length when indexing and searching, so dot-product scores equal cosine (re-index existing chunks after enabling)")
+	similarityMetric := flag.String("similarity-metric", similarityCosine, "Embedding similarity: cosine, dot or euclidean (scored as 1/(1+distance)); --min-score applies on this metric's scale")
+	defaultScoring := DefaultScoringConfig()
+	entityBoost := flag.Float64("entity-boost", defaultScoring.EntityBoost, "Score boost for function and method chunks")
+	smallChunkBoost := flag.Float64("small-chunk-boost", defaultScoring.SmallChunkBoost, "Score boost for chunks shorter than --small-chunk-threshold")
+	smallChunkThreshold := flag.Int("small-chunk-threshold", defaultScoring.SmallChunkThreshold, "Content length in characters below which --small-chunk-boost applies")
+	largeChunkPenalty := flag.Float64("large-chunk-penalty", defaultScoring.LargeChunkPenalty, "Score penalty for chunks longer than --large-chunk-threshold")
+	largeChunkThreshold := flag.Int("large-chunk-threshold", defaultScoring.LargeChunkThreshold, "Content length in characters above which --large-chunk-penalty applies")
+	keywordWeight := flag.Float64("keyword-weight", 0, "Weight of the keyword score vs vector similarity in hybrid search (0 = pure vector, 1 = pure keyword)")
 	
 	// Output options
-	jsonOutput := flag.Bool("json-output", false, "Output results in JSON format")
+	debug := flag.Bool("debug", false, "Log search diagnostics")
+	logLevel := flag.String("log-level", logLevelInfo, "Least severe log level to print: debug, info, warn or error (--debug implies debug)")
+	logFormat := flag.String("log-format", logFormatText, "Log output format: text or json")
+	jsonOutput := flag.Bool("json-output", false, "Output results in JSON format; a query prints the query, ranked chunks and LLM answer (with --llm-response) as a single object")
+	stream := flag.Bool("stream", false, "Stream search progress and results as newline-delimited JSON events (used with --query-string)")
 	llmResponse := flag.Bool("llm-response", false, "Generate LLM response for the query")
+	maxTokens := flag.Int("max-tokens", defaultLLMOptions.MaxTokens, "Most tokens the LLM may generate for an answer")
+	temperature := flag.Float64("temperature", float64(defaultLLMOptions.Temperature), "LLM sampling temperature, from 0 (most deterministic) to 2 (most varied)")
+	contextFormat := flag.String("context-format", contextFormatMarkdown, "How code is laid out in LLM prompts: markdown or delimited (explicit source markers with metadata headers)")
+	chunkMarker := flag.String("chunk-marker", defaultChunkMarker, "Boundary marker for --context-format=delimited")
+	maxPromptTokens := flag.Int("max-prompt-tokens", 0, "Estimated token budget for the LLM prompt; lower-scoring chunks are cut or dropped to fit (0 = no limit)")
 	
 	flag.Parse()
 	
+	if math.IsNaN(*keywordWeight) || *keywordWeight < 0 || *keywordWeight > 1 {
+		log.Fatalf("--keyword-weight must be between 0 and 1, got %v", *keywordWeight)
+	}
+	if *binaryThreshold <= 0 || *binaryThreshold > 1 {
+		log.Fatalf("--binary-threshold must be greater than 0 and at most 1, got %v", *binaryThreshold)
+	}
+	switch *similarityMetric {
+	case similarityCosine, similarityDot, similarityEuclidean:
+	default:
+		log.Fatalf("--similarity-metric must be %s, %s or %s, got %q", similarityCosine, similarityDot, similarityEuclidean, *similarityMetric)
+	}
+	if _, ok := logLevelRanks[*logLevel]; !ok {
+		log.Fatalf("--log-level must be %s, %s, %s or %s, got %q", logLevelDebug, logLevelInfo, logLevelWarn, logLevelError, *logLevel)
+	}
+	if *logFormat != logFormatText && *logFormat != logFormatJSON {
+		log.Fatalf("--log-format must be %s or %s, got %q", logFormatText, logFormatJSON, *logFormat)
+	}
+	if *maxFileSizeMB <= 0 {
+		log.Fatalf("--max-file-size must be positive, got %v", *maxFileSizeMB)
+	}
+	if *fileTimeout < 0 {
+		log.Fatalf("--file-timeout must not be negative, got %v", *fileTimeout)
+	}
+	if *embeddingTimeout <= 0 {
+		log.Fatalf("--embedding-timeout must be positive, got %v", *embeddingTimeout)
+	}
+	if *llmTimeout <= 0 {
+		log.Fatalf("--llm-timeout must be positive, got %v", *llmTimeout)
+	}
+	if *importBatchSize <= 0 {
+		log.Fatalf("--import-batch-size must be positive, got %d", *importBatchSize)
+	}
+	if *contextChunks < 1 {
+		log.Fatalf("--context-chunks must be at least 1, got %d", *contextChunks)
+	}
+	if *contextWindow < 0 {
+		log.Fatalf("--context-window must not be negative, got %d", *contextWindow)
+	}
+	if *maxPromptTokens < 0 {
+		log.Fatalf("--max-prompt-tokens must not be negative, got %d", *maxPromptTokens)
+	}
+	if *minKeywordLength < 1 {
+		log.Fatalf("--min-keyword-length must be at least 1, got %d", *minKeywordLength)
+	}
+	switch *projectLayout {
+	case projectLayoutTopLevel, projectLayoutRoot, projectLayoutManifest:
+	default:
+		log.Fatalf("--project-layout must be %s, %s or %s, got %q", projectLayoutTopLevel, projectLayoutRoot, projectLayoutManifest, *projectLayout)
+	}
+	var sinceTime time.Time
+	if *since != "" {
+		var err error
+		sinceTime, err = time.Parse(time.RFC3339, *since)
+		if err != nil {
+			log.Fatalf("--since must be an RFC 3339 time like 2024-05-01T12:00:00Z: %v", err)
+		}
+	}
+	if *since != "" && *sinceCommit != "" {
+		log.Fatal("--since and --since-commit cannot be used together")
+	}
+	if *limit <= 0 {
+		log.Fatalf("--limit must be positive, got %d", *limit)
+	}
+	if *similarToFile != "" || *similarToSnippet != "" {
+		if *similarToFile != "" && *similarToSnippet != "" {
+			log.Fatal("--similar-to-file and --similar-to-snippet cannot be used together")
+		}
+		if *queryString != "" || *queryFile != "" || *queriesFile != "" {
+			log.Fatal("--similar-to-file and --similar-to-snippet cannot be used with --query-string, --query-file or --queries-file")
+		}
+		if *llmResponse {
+			log.Fatal("--llm-response cannot be used to find similar code")
+		}
+		*queryCmd = true
+	}
+	if math.IsNaN(*duplicateThreshold) || *duplicateThreshold <= 0 || *duplicateThreshold > 1 {
+		log.Fatalf("--duplicate-threshold must be greater than 0 and at most 1, got %g", *duplicateThreshold)
+	}
+	if *duplicateMinLines <= 0 {
+		log.Fatalf("--duplicate-min-lines must be positive, got %d", *duplicateMinLines)
+	}
+	if *concurrency <= 0 || *concurrency > maxQueryConcurrency {
+		log.Fatalf("--concurrency must be between 1 and %d, got %d", maxQueryConcurrency, *concurrency)
+	}
+	if *maxTokens <= 0 {
+		log.Fatalf("--max-tokens must be positive, got %d", *maxTokens)
+	}
+	if math.IsNaN(*temperature) || *temperature < 0 || *temperature > maxLLMTemperature {
+		log.Fatalf("--temperature must be between 0 and %d, got %g", maxLLMTemperature, *temperature)
+	}
+	if *offset < 0 {
+		log.Fatalf("--offset must not be negative, got %d", *offset)
+	}
+	if *contextFormat != contextFormatMarkdown && *contextFormat != contextFormatDelimited {
+		log.Fatalf("--context-format must be %s or %s, got %q", contextFormatMarkdown, contextFormatDelimited, *contextFormat)
+	}
+	if strings.TrimSpace(*chunkMarker) == "" {
+		log.Fatalf("--chunk-marker must not be empty")
+	}
+	switch *llmProvider {
+	case llmProviderCompletion, llmProviderOpenAI, llmProviderMock:
+	default:
+		log.Fatalf("--llm-provider must be %s, %s or %s, got %q", llmProviderCompletion, llmProviderOpenAI, llmProviderMock, *llmProvider)
+	}
+	if *llmAPIKey == "" {
+		*llmAPIKey = os.Getenv("OPENAI_API_KEY")
+	}
+	switch *embeddingBackend {
+	case embeddingBackendHTTP, embeddingBackendMock:
+	case embeddingBackendONNX:
+		if *onnxModelDir == "" {
+			log.Fatalf("--embedding-backend=%s requires --onnx-model-dir", embeddingBackendONNX)
+		}
+	default:
+		log.Fatalf("--embedding-backend must be %s, %s or %s, got %q", embeddingBackendHTTP, embeddingBackendONNX, embeddingBackendMock, *embeddingBackend)
+	}
+	if *chunkUnit != chunkUnitChars && *chunkUnit != chunkUnitTokens {
+		log.Fatalf("--chunk-unit must be %s or %s, got %q", chunkUnitChars, chunkUnitTokens, *chunkUnit)
+	}
+	if *fixCmd && !*verifyCmd {
+		log.Fatalf("--fix requires --verify")
+	}
+	if *fuse && *ensembleURL == "" {
+		log.Fatalf("--fuse requires --ensemble-models")
+	}
+	if *fuse && *stream {
+		log.Fatalf("--fuse is not supported with --stream")
+	}
+	
+	overrides, err := parseExtensionOverrides(*extensionOverrides)
+	if err != nil {
+		log.Fatalf("Invalid --extension-language-overrides: %v", err)
+	}
+	
+	// A nil set keeps the built-in stop words
+	var stopWords map[string]bool
+	if *stopWordsFile != "" || *noDefaultStopWords {
+		stopWords = map[string]bool{}
+		if !*noDefaultStopWords {
+			for word := range defaultStopWords {
+				stopWords[word] = true
+			}
+		}
+		if *stopWordsFile != "" {
+			words, err := loadStopWords(*stopWordsFile)
+			if err != nil {
+				log.Fatalf("Failed to read --stopwords-file: %v", err)
+			}
+			for _, word := range words {
+				stopWords[word] = true
+			}
+		}
+	}
+	
+	var extList []string
+	for _, ext := range strings.Split(*extraExtensions, ",") {
+		ext = strings.TrimSpace(ext)
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		extList = append(extList, ext)
+	}
+	
 	// Configure the RAG system
 	config := Config{
-		Neo4jURI:      *neo4jURI,
-		Neo4jUser:     *neo4jUser,
-		Neo4jPassword: *neo4jPassword,
-		EmbeddingURL:  *embeddingURL,
-		LLMServerURL:  *llmURL,
-		MaxChunkSize:  *maxChunkSize,
-		ChunkOverlap:  *chunkOverlap,
-		CodeDir:       *codeDir,
-		DbName:        *dbName,
+		Neo4jURI:       *neo4jURI,
+		Neo4jUser:      *neo4jUser,
+		Neo4jPassword:  *neo4jPassword,
+		EmbeddingURL:   *embeddingURL,
+		LLMServerURL:   *llmURL,
+		RerankURL:      *rerankURL,
+		MaxChunkSize:   *maxChunkSize,
+		ChunkOverlap:   *chunkOverlap,
+		ChunkUnit:      *chunkUnit,
+		MaxFileSize:    int64(*maxFileSizeMB * 1024 * 1024),
+		CodeDirs:       codeDirs,
+		DbName:         *dbName,
+		KeywordWeight:  *keywordWeight,
+		ContextWindow:  *contextWindow,
+		ForceReindex:   *forceReindex,
+		IncludeContext: *includeContext,
+		Debug:          *debug,
+
+		ExtensionLanguageOverrides: overrides,
+		ExtraExtensions:            extList,
+		IndexVendored:              *indexVendored,
+		RespectGitignore:           *respectGitignore,
+		NoDefaultIgnores:           *noDefaultIgnores,
+		ProjectLayout:              *projectLayout,
+		DeferEmbeddings:            *deferEmbeddings,
+		OmitContent:                !*storeContent,
+		LineIncremental:            *lineIncremental,
+		EnsembleEmbeddingURL:       *ensembleURL,
+		EmbeddingBackend:           *embeddingBackend,
+		ONNXModelDir:               *onnxModelDir,
+		ONNXRuntimeLibrary:         *onnxRuntime,
+		LLMProvider:                *llmProvider,
+		LLMModel:                   *llmModel,
+		LLMAPIKey:                  *llmAPIKey,
+		ContextFormat:              *contextFormat,
+		ChunkMarker:                *chunkMarker,
+		MaxPromptTokens:            *maxPromptTokens,
+		ContextChunks:              *contextChunks,
+		RetryMinScore:              *retryMinScore,
+		FullFile:                   *fullFile,
+		FullFileMaxSize:            int64(*fullFileMaxSize) * 1024,
+		MinKeywordLength:           *minKeywordLength,
+		SplitIdentifiers:           *splitIdentifiers,
+		StopWords:                  stopWords,
+		LogQueries:                 *logQueries,
+		BinaryThreshold:            *binaryThreshold,
+		SimilarityMetric:           *similarityMetric,
+		NormalizeEmbeddings:        *normalizeEmbeddings,
+		FileProcessTimeout:         *fileTimeout,
+		Since:                      sinceTime,
+		SinceCommit:                *sinceCommit,
+		EmbeddingTimeout:           *embeddingTimeout,
+		LLMTimeout:                 *llmTimeout,
+		LogLevel:                   *logLevel,
+		LogFormat:                  *logFormat,
+		Scoring: &ScoringConfig{
+			EntityBoost:         *entityBoost,
+			SmallChunkBoost:     *smallChunkBoost,
+			SmallChunkThreshold: *smallChunkThreshold,
+			LargeChunkPenalty:   *largeChunkPenalty,
+			LargeChunkThreshold: *largeChunkThreshold,
+		},
+	}
+	
+	// Keep stdout parseable in machine-readable output modes
+	if *jsonOutput || *stream || *healthCmd || (*queriesFile != "" && *queriesOutput == "") {
+		config.LogOutput = os.Stderr
+	}
+	
+	// The health check reports on Neo4j itself, so it must not require a
+	// working connection
+	if *healthCmd {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		
+		var report HealthReport
+		rag, err := NewNeo4jRAG(config)
+		if err != nil {
+			report = checkDependencies(ctx, config, http.DefaultClient, err)
+		} else {
+			report = rag.CheckHealth(ctx)
+			rag.Close()
+		}
+		
+		output, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to encode health report: %v", err)
+		}
+		fmt.Println(string(output))
+		if !report.OK {
+			os.Exit(1)
+		}
+		return
+	}
+	
+	// Showing a file's chunks needs neither the database nor embeddings
+	if *showChunks {
+		if *chunkTarget == "" {
+			log.Fatal("Please specify a file to chunk with --file")
+		}
+		
+		// Project paths are derived from --code-dir when a single one is given
+		rag := &Neo4jRAG{config: config, logger: newLeveledLogger(config)}
+		root := rag.rootFor(filepath.Dir(*chunkTarget))
+		if len(codeDirs) == 1 {
+			root = rag.rootFor(codeDirs[0])
+		}
+		
+		chunks, _, err := rag.chunkSourceFile(*chunkTarget, root)
+		if err != nil {
+			log.Fatalf("Failed to chunk %s: %v", *chunkTarget, err)
+		}
+		if err := printChunks(*chunkTarget, chunks, *jsonOutput); err != nil {
+			log.Fatalf("Failed to print chunks: %v", err)
+		}
+		return
+	}
+	
+	// A dry run only walks and chunks files, so it needs no database
+	if *indexCmd && *dryRun {
+		if len(codeDirs) == 0 {
+			log.Fatal("Please specify a directory to index with --code-dir")
+		}
+		
+		rag := &Neo4jRAG{config: config, logger: newLeveledLogger(config)}
+		for i, dir := range codeDirs {
+			report, err := rag.DryRunIndex(dir)
+			if err != nil {
+				log.Fatalf("Dry run failed: %v", err)
+			}
+			if len(codeDirs) > 1 {
+				if i > 0 {
+					fmt.Println()
+				}
+				fmt.Printf("%s\n\n", dir)
+			}
+			printDryRunReport(report)
+		}
+		return
 	}
 	
 	// Create the Neo4j RAG instance
@@ -1803,37 +9754,359 @@ func main() {
 	
 	// Handle commands
 	if *indexCmd {
-		if *codeDir == "" {
+		if len(codeDirs) == 0 {
 			log.Fatal("Please specify a directory to index with --code-dir")
 		}
 		
-		fmt.Printf("Indexing directory: %s\n", *codeDir)
-		err := rag.IndexDirectory(*codeDir)
+		if err := rag.CheckEmbeddingDimension(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		
+		fmt.Printf("Indexing directory: %s\n", strings.Join(codeDirs, ", "))
+		err := rag.IndexDirectories(codeDirs)
 		if err != nil {
 			log.Fatalf("Failed to index directory: %v", err)
 		}
 		
 		fmt.Println("Indexing complete")
+		
+		if *watch {
+			watchDirectories(rag, codeDirs)
+		}
+	} else if *watch {
+		if len(codeDirs) == 0 {
+			log.Fatal("Please specify a directory to watch with --code-dir")
+		}
+		
+		watchDirectories(rag, codeDirs)
+	} else if *embedPending {
+		embedded, err := rag.EmbedPending()
+		if err != nil {
+			log.Fatalf("Failed to embed pending chunks: %v", err)
+		}
+		
+		fmt.Printf("Embedded %d pending chunks\n", embedded)
+	} else if *resetCmd || *resetProject != "" {
+		target := "the entire index"
+		if *resetProject != "" {
+			target = fmt.Sprintf("project %s", *resetProject)
+		}
+		
+		if !*yes {
+			fmt.Printf("This will delete %s. Continue? [y/N]: ", target)
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			if answer != "y" && answer != "yes" {
+				fmt.Println("Aborted")
+				return
+			}
+		}
+		
+		deleted, err := rag.Reset(*resetProject)
+		if err != nil {
+			log.Fatalf("Failed to reset %s: %v", target, err)
+		}
+		
+		fmt.Printf("Deleted %d nodes from %s\n", deleted, target)
+	} else if *exportPath != "" {
+		file, err := os.Create(*exportPath)
+		if err != nil {
+			log.Fatalf("Failed to create export file: %v", err)
+		}
+		
+		writer := bufio.NewWriter(file)
+		err = rag.ExportChunks(writer, *exportEmbeddings)
+		if err == nil {
+			err = writer.Flush()
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Fatalf("Failed to export chunks: %v", err)
+		}
+		
+		fmt.Printf("Exported chunks to %s\n", *exportPath)
+	} else if *importPath != "" {
+		file, err := os.Open(*importPath)
+		if err != nil {
+			log.Fatalf("Failed to open import file: %v", err)
+		}
+		defer file.Close()
+		
+		imported, err := rag.ImportChunks(bufio.NewReader(file), *importBatchSize, *force)
+		if err != nil {
+			log.Fatalf("Failed to import chunks after %d chunks: %v", imported, err)
+		}
+		
+		fmt.Printf("Imported %d chunks from %s\n", imported, *importPath)
+	} else if *statsCmd {
+		stats, err := rag.IndexStats()
+		if err != nil {
+			log.Fatalf("Failed to get index stats: %v", err)
+		}
+		
+		if *jsonOutput {
+			output, err := json.MarshalIndent(stats, "", "  ")
+			if err != nil {
+				log.Fatalf("Failed to encode index stats: %v", err)
+			}
+			fmt.Println(string(output))
+		} else {
+			printIndexStats(stats)
+		}
+	} else if *verifyCmd {
+		opts := VerifyOptions{Fix: *fixCmd, Force: *yes}
+		report, err := rag.VerifyIndex(opts)
+		if errors.Is(err, ErrMostFilesMissing) {
+			// The prompt goes to stderr as the report may be JSON
+			fmt.Fprintf(os.Stderr, "%d of %d indexed files are missing from disk; the index may have been built from another directory. Delete them anyway? [y/N]: ", len(report.MissingFiles), report.Files)
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			if answer == "y" || answer == "yes" {
+				opts.Force = true
+				report, err = rag.VerifyIndex(opts)
+			} else {
+				fmt.Fprintln(os.Stderr, "Not fixing the index")
+				err = nil
+			}
+		}
+		if err != nil {
+			log.Fatalf("Failed to verify the index: %v", err)
+		}
+		
+		if *jsonOutput {
+			output, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				log.Fatalf("Failed to encode the verification report: %v", err)
+			}
+			fmt.Println(string(output))
+		} else {
+			printVerifyReport(report, *limit)
+		}
+	} else if *findDuplicates {
+		report, err := rag.FindDuplicates(DuplicateOptions{Threshold: *duplicateThreshold, MinLines: *duplicateMinLines})
+		if err != nil {
+			log.Fatalf("Failed to find duplicates: %v", err)
+		}
+		
+		if *jsonOutput {
+			output, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				log.Fatalf("Failed to encode the duplicate report: %v", err)
+			}
+			fmt.Println(string(output))
+		} else {
+			printDuplicateReport(report, *limit)
+		}
+	} else if *queryStatsCmd {
+		stats, err := rag.QueryStats(*limit)
+		if err != nil {
+			log.Fatalf("Failed to get query stats: %v", err)
+		}
+		
+		if *jsonOutput {
+			output, err := json.MarshalIndent(stats, "", "  ")
+			if err != nil {
+				log.Fatalf("Failed to encode query stats: %v", err)
+			}
+			fmt.Println(string(output))
+		} else {
+			printQueryStats(stats)
+		}
 	} else if *queryCmd {
+		if err := rag.CheckEmbeddingDimension(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		
+		var band *ScoreBand
+		if *scoreBand != "" {
+			band, err = parseScoreBand(*scoreBand)
+			if err != nil {
+				log.Fatalf("Invalid --score-band: %v", err)
+			}
+		}
+		
+		var after, before time.Time
+		if *updatedAfter != "" {
+			after, err = time.Parse(time.RFC3339, *updatedAfter)
+			if err != nil {
+				log.Fatalf("Invalid --updated-after: %v", err)
+			}
+		}
+		if *updatedBefore != "" {
+			before, err = time.Parse(time.RFC3339, *updatedBefore)
+			if err != nil {
+				log.Fatalf("Invalid --updated-before: %v", err)
+			}
+		}
+		
+		var excludeList []string
+		if *excludeFile != "" {
+			excludeList = strings.Split(*excludeFile, ",")
+		}
+		
+		// A query read from a file should not match the file itself
+		if *queryFile != "" {
+			content, err := ioutil.ReadFile(*queryFile)
+			if err != nil {
+				log.Fatalf("Failed to read query file: %v", err)
+			}
+			*queryString = string(content)
+			excludeList = append(excludeList, *queryFile)
+		}
+		
+		// Code to find similar code to is used as the query, and like a
+		// query file it should not match itself
+		if *similarToFile != "" {
+			content, err := ioutil.ReadFile(*similarToFile)
+			if err != nil {
+				log.Fatalf("Failed to read --similar-to-file: %v", err)
+			}
+			*similarToSnippet = string(content)
+			excludeList = append(excludeList, *similarToFile)
+		}
+		if *similarToSnippet != "" {
+			if strings.TrimSpace(*similarToSnippet) == "" {
+				log.Fatal("The code to find similar code to is empty")
+			}
+			*queryString = *similarToSnippet
+		}
+		
+		llmOpts := LLMOptions{MaxTokens: *maxTokens, Temperature: float32(*temperature)}
+		
+		searchOpts := SearchOptions{
+			Limit:           *limit,
+			Offset:          *offset,
+			MinScore:        *minScore,
+			UseKeywords:     *useKeywords,
+			ExcludeFiles:    excludeList,
+			ScoreBand:       band,
+			IncludeVendored: *includeVendored || !*excludeVendored,
+			Fuse:            *fuse,
+			Explain:         *explain,
+			Definitions:     *definitions,
+			UpdatedAfter:    after,
+			UpdatedBefore:   before,
+			Highlight:       *highlight,
+			Similar:         *similarToSnippet != "",
+		}
+		
+		if *keywords != "" {
+			for _, keyword := range strings.Split(*keywords, ",") {
+				if keyword = strings.TrimSpace(keyword); keyword != "" {
+					searchOpts.Keywords = append(searchOpts.Keywords, keyword)
+				}
+			}
+		}
+		
+		if *projects != "" {
+			for _, project := range strings.Split(*projects, ",") {
+				if project = strings.TrimSpace(project); project != "" {
+					searchOpts.ProjectPaths = append(searchOpts.ProjectPaths, project)
+				}
+			}
+		}
+		
+		if *entityTypes != "" {
+			for _, entityType := range strings.Split(*entityTypes, ",") {
+				if entityType = strings.TrimSpace(entityType); entityType != "" {
+					searchOpts.EntityTypes = append(searchOpts.EntityTypes, entityType)
+				}
+			}
+		}
+		
+		// Run a batch of queries from a file, one JSON line per query
+		if *queriesFile != "" {
+			queries, err := loadQueries(*queriesFile)
+			if err != nil {
+				log.Fatalf("Failed to read queries file: %v", err)
+			}
+			if *languages != "" {
+				searchOpts.Languages = strings.Split(*languages, ",")
+			}
+			if *pathFilters != "" {
+				searchOpts.PathFilters = strings.Split(*pathFilters, ",")
+			}
+			
+			file := os.Stdout
+			if *queriesOutput != "" {
+				file, err = os.Create(*queriesOutput)
+				if err != nil {
+					log.Fatalf("Failed to create output file: %v", err)
+				}
+			}
+			
+			writer := bufio.NewWriter(file)
+			failed, err := runQueries(rag, queries, *concurrency, writer, *llmResponse, llmOpts, searchOpts)
+			if err == nil {
+				err = writer.Flush()
+			}
+			if *queriesOutput != "" {
+				if closeErr := file.Close(); err == nil {
+					err = closeErr
+				}
+			}
+			if err != nil {
+				log.Fatalf("Failed to write query results: %v", err)
+			}
+			
+			log.Printf("Ran %d queries, %d failed", len(queries), failed)
+			if failed > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+		
 		// Check if query string was provided as argument
 		if *queryString != "" {
 			// Use the provided query string directly
 			query := *queryString
-			fmt.Printf("\nQuery: %s\n", query)
+			if !*stream && !*jsonOutput {
+				fmt.Printf("\nQuery: %s\n", query)
+			}
 			
 			// Parse advanced search options
-			var langList []string
 			if *languages != "" {
-				langList = strings.Split(*languages, ",")
+				searchOpts.Languages = strings.Split(*languages, ",")
 			}
 			
-			var pathList []string
 			if *pathFilters != "" {
-				pathList = strings.Split(*pathFilters, ",")
+				searchOpts.PathFilters = strings.Split(*pathFilters, ",")
+			}
+			
+			// Stream events as NDJSON instead of the formatted output
+			if *stream {
+				var results []CodeChunk
+				err := rag.StreamSearch(query, searchOpts, func(event SearchEvent) {
+					if event.Type == "result" && event.Chunk != nil {
+						results = append(results, *event.Chunk)
+					}
+					data, err := json.Marshal(event)
+					if err != nil {
+						log.Printf("Error marshaling search event: %v", err)
+						return
+					}
+					fmt.Println(string(data))
+				})
+				if err != nil {
+					log.Fatalf("Streaming search failed: %v", err)
+				}
+				if err := rag.LogQuery(query, results, ""); err != nil {
+					log.Printf("Warning: %v", err)
+				}
+				return
+			}
+			
+			if *jsonOutput {
+				if err := printQueryJSON(rag, query, *llmResponse, llmOpts, searchOpts); err != nil {
+					os.Exit(1)
+				}
+				return
 			}
 			
 			// Process the query
-			processQuery(rag, query, *jsonOutput, *llmResponse, *limit, langList, pathList, *minScore, *useKeywords)
+			processQuery(rag, query, *llmResponse, llmOpts, searchOpts)
 		} else {
 			// Start interactive query mode
 			reader := bufio.NewReader(os.Stdin)
@@ -1852,7 +10125,11 @@ func main() {
 				}
 				
 				// Process the query
-				processQuery(rag, query, *jsonOutput, *llmResponse, *limit, []string{}, []string{}, *minScore, *useKeywords)
+				if *jsonOutput {
+					printQueryJSON(rag, query, *llmResponse, llmOpts, searchOpts)
+					continue
+				}
+				processQuery(rag, query, *llmResponse, llmOpts, searchOpts)
 			}
 		}
 	} else {
@@ -1860,8 +10137,23 @@ func main() {
 		fmt.Println("Local RAG System with Neo4j and LMStudio")
 		fmt.Println("\nUsage:")
 		fmt.Println("  To index code:   go run main.go --index --code-dir=/path/to/code")
+		fmt.Println("  To preview indexing: go run main.go --index --dry-run --code-dir=/path/to/code")
+		fmt.Println("  To inspect chunking: go run main.go --show-chunks --file=/path/to/file.go [--json-output]")
+		fmt.Println("  To keep the index current: go run main.go --watch --code-dir=/path/to/code")
+		fmt.Println("  To embed pending: go run main.go --embed-pending")
+		fmt.Println("  To show index stats: go run main.go --stats")
+		fmt.Println("  To find duplicate code: go run main.go --find-duplicates [--duplicate-threshold=0.95] [--json-output]")
+		fmt.Println("  To show query stats: go run main.go --query-stats [--limit=20]")
+		fmt.Println("  To check services: go run main.go --health")
+		fmt.Println("  To export chunks: go run main.go --export=chunks.jsonl [--export-embeddings]")
+		fmt.Println("  To import chunks: go run main.go --import=chunks.jsonl [--force]")
+		fmt.Println("  To reset the index: go run main.go --reset [--yes]")
+		fmt.Println("  To reset a project: go run main.go --reset-project=/path/to/project [--yes]")
 		fmt.Println("  To query:        go run main.go --query")
 		fmt.Println("  To query directly: go run main.go --query --query-string=\"your query here\"")
+		fmt.Println("  To query with a file: go run main.go --query --query-file=/path/to/snippet.go")
+		fmt.Println("  To find similar code: go run main.go --similar-to-file=/path/to/snippet.go")
+		fmt.Println("  To run a batch of queries: go run main.go --query --queries-file=queries.txt --output=results.jsonl [--concurrency=4]")
 		fmt.Println("\nOptions:")
 		flag.PrintDefaults()
 	}
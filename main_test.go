@@ -0,0 +1,1158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseEmbeddingResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    [][]float32
+		wantErr string
+	}{
+		{
+			name: "raw arrays",
+			body: `{"embeddings": [[1, 2], [3, 4]]}`,
+			want: [][]float32{{1, 2}, {3, 4}},
+		},
+		{
+			name: "objects without indices keep the response order",
+			body: `{"embeddings": [{"embedding": [1, 2]}, {"embedding": [3, 4]}]}`,
+			want: [][]float32{{1, 2}, {3, 4}},
+		},
+		{
+			name: "objects are reordered by index",
+			body: `{"embeddings": [{"embedding": [5, 6], "index": 2}, {"embedding": [1, 2], "index": 0}, {"embedding": [3, 4], "index": 1}]}`,
+			want: [][]float32{{1, 2}, {3, 4}, {5, 6}},
+		},
+		{
+			name: "extra fields are ignored",
+			body: `{"model": "m", "embeddings": [{"embedding": [1], "index": 0, "object": "embedding"}], "usage": {}}`,
+			want: [][]float32{{1}},
+		},
+		{
+			name: "empty list",
+			body: `{"embeddings": []}`,
+			want: [][]float32{},
+		},
+		{
+			name:    "not an embeddings object",
+			body:    `[[1, 2]]`,
+			wantErr: `expected {"embeddings": [...]}`,
+		},
+		{
+			name:    "element of the wrong type",
+			body:    `{"embeddings": ["a"]}`,
+			wantErr: "element 0 is neither an array nor an object",
+		},
+		{
+			name:    "array of non-numbers",
+			body:    `{"embeddings": [["a"]]}`,
+			wantErr: "element 0 is not an array of numbers",
+		},
+		{
+			name:    "object without an embedding",
+			body:    `{"embeddings": [{"vector": [1]}]}`,
+			wantErr: `element 0 is an object without an "embedding" field`,
+		},
+		{
+			name:    "some indices missing",
+			body:    `{"embeddings": [{"embedding": [1], "index": 0}, {"embedding": [2]}]}`,
+			wantErr: `only 1 of 2 elements have an "index" field`,
+		},
+		{
+			name:    "index out of range",
+			body:    `{"embeddings": [{"embedding": [1], "index": 1}]}`,
+			wantErr: "out-of-range index 1",
+		},
+		{
+			name:    "duplicate index",
+			body:    `{"embeddings": [{"embedding": [1], "index": 0}, {"embedding": [2], "index": 0}]}`,
+			wantErr: "duplicate index 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEmbeddingResponse([]byte(tt.body))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseEmbeddingResponse() error = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseEmbeddingResponse() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseEmbeddingResponse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPEmbedderObjectResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var request EmbeddingRequest
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil || len(request.Texts) != 2 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"embeddings": [{"embedding": [0, 1], "index": 1}, {"embedding": [1, 0], "index": 0}]}`))
+	}))
+	defer server.Close()
+
+	embedder := NewHTTPEmbedder(server.URL, server.Client(), newLeveledLogger(Config{LogOutput: ioutil.Discard}))
+	got, err := embedder.Embed(context.Background(), []string{"first", "second"})
+	if err != nil {
+		t.Fatalf("Embed() error = %v", err)
+	}
+	if want := [][]float32{{1, 0}, {0, 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Embed() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildPrompt(t *testing.T) {
+	chunks := []CodeChunk{
+		{FilePath: "/repo/main.go", StartLine: 10, EndLine: 12, Language: "Go", EntityType: "function", Content: "func A() {\n}\n"},
+		{FilePath: "/repo/web/app.js", StartLine: 1, EndLine: 1, Language: "JavaScript", EntityType: "chunk", Content: "export {}"},
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+		want   string
+	}{
+		{
+			name:   "markdown",
+			config: Config{},
+			want: "Based on the following code snippets:\n\n" +
+				"SNIPPET 1 (/repo/main.go, function):\n```go\nfunc A() {\n}\n\n```\n\n" +
+				"SNIPPET 2 (/repo/web/app.js, chunk):\n```javascript\nexport {}\n```\n\n" +
+				"Answer the following question: what does A do?",
+		},
+		{
+			name:   "delimited",
+			config: Config{ContextFormat: contextFormatDelimited},
+			want: "Based on the following code sources. Each source starts with a \"@@@ SOURCE n\" line " +
+				"giving its file, line range and language, and ends with a matching \"@@@ END SOURCE n\" line.\n\n" +
+				"@@@ SOURCE 1 file=\"/repo/main.go\" lines=\"10-12\" language=\"Go\" entity=\"function\"\n" +
+				"func A() {\n}\n" +
+				"@@@ END SOURCE 1\n\n" +
+				"@@@ SOURCE 2 file=\"/repo/web/app.js\" lines=\"1-1\" language=\"JavaScript\" entity=\"chunk\"\n" +
+				"export {}\n" +
+				"@@@ END SOURCE 2\n\n" +
+				"Cite the sources you use by their SOURCE number.\n" +
+				"Answer the following question: what does A do?",
+		},
+		{
+			name:   "delimited with a custom marker",
+			config: Config{ContextFormat: contextFormatDelimited, ChunkMarker: "###"},
+			want: "Based on the following code sources. Each source starts with a \"### SOURCE n\" line " +
+				"giving its file, line range and language, and ends with a matching \"### END SOURCE n\" line.\n\n" +
+				"### SOURCE 1 file=\"/repo/main.go\" lines=\"10-12\" language=\"Go\" entity=\"function\"\n" +
+				"func A() {\n}\n" +
+				"### END SOURCE 1\n\n" +
+				"### SOURCE 2 file=\"/repo/web/app.js\" lines=\"1-1\" language=\"JavaScript\" entity=\"chunk\"\n" +
+				"export {}\n" +
+				"### END SOURCE 2\n\n" +
+				"Cite the sources you use by their SOURCE number.\n" +
+				"Answer the following question: what does A do?",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Neo4jRAG{config: tt.config}
+			if got := r.buildPrompt("what does A do?", chunks); got != tt.want {
+				t.Errorf("buildPrompt() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildPromptHeadersParseBack(t *testing.T) {
+	chunk := CodeChunk{
+		FilePath:   `/repo/odd "name" dir/file=x.go`,
+		StartLine:  3,
+		EndLine:    4,
+		Language:   "Go",
+		EntityType: "method",
+		Content:    "@@@ SOURCE 9 in content\nreturn",
+	}
+	r := &Neo4jRAG{config: Config{ContextFormat: contextFormatDelimited}}
+	prompt := r.buildPrompt("q", []CodeChunk{chunk})
+
+	header := regexp.MustCompile(`(?m)^@@@ SOURCE 1 file=("(?:[^"\\]|\\.)*") lines=("[^"]*") language=("[^"]*") entity=("[^"]*")$`)
+	m := header.FindStringSubmatch(prompt)
+	if m == nil {
+		t.Fatalf("no parseable header for source 1 in\n%s", prompt)
+	}
+	got := []string{}
+	for _, quoted := range m[1:] {
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			t.Fatalf("header value %s does not unquote: %v", quoted, err)
+		}
+		got = append(got, value)
+	}
+	if want := []string{chunk.FilePath, "3-4", "Go", "method"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("header values = %q, want %q", got, want)
+	}
+
+	// The source ends at its own end marker, after all of its content
+	start := strings.Index(prompt, m[0]) + len(m[0]) + 1
+	end := strings.Index(prompt, "\n@@@ END SOURCE 1\n")
+	if end < start || prompt[start:end] != chunk.Content {
+		t.Errorf("source 1 does not hold %q between its markers in\n%s", chunk.Content, prompt)
+	}
+}
+
+// countingLLM answers every prompt with a fixed answer and counts the calls
+type countingLLM struct {
+	calls int
+}
+
+func (l *countingLLM) Complete(ctx context.Context, prompt string, opts LLMOptions) (string, Usage, error) {
+	l.calls++
+	return "answer", Usage{}, nil
+}
+
+func TestAnswerFromChunksPromptBudget(t *testing.T) {
+	chunks := []CodeChunk{{
+		FilePath:   "/p/x.go",
+		Language:   "Go",
+		StartLine:  1,
+		EndLine:    3,
+		EntityType: "function",
+		Name:       "Add",
+		Content:    "func Add(a, b int) int {\n\treturn a + b\n}",
+		Score:      0.9,
+	}}
+
+	tests := []struct {
+		name      string
+		budget    int
+		want      string
+		wantCalls int
+	}{
+		{"no budget", 0, "answer", 1},
+		{"chunk fits", 10000, "answer", 1},
+		{"budget below the bare prompt", 1, noContextAnswer, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{MaxPromptTokens: tt.budget, LogOutput: ioutil.Discard}
+			llm := &countingLLM{}
+			r := &Neo4jRAG{config: config, llm: llm, logger: newLeveledLogger(config)}
+
+			got, err := r.answerFromChunks("how are numbers added", chunks, LLMOptions{})
+			if err != nil {
+				t.Fatalf("answerFromChunks() error = %v", err)
+			}
+			if got != tt.want || llm.calls != tt.wantCalls {
+				t.Errorf("answerFromChunks() = %q after %d LLM calls, want %q after %d", got, llm.calls, tt.want, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestKeywordFilterCondition(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		keywords []string // Pre-filter keywords expected, sorted; none means no condition
+	}{
+		{"all stop words", "how is it the", nil},
+		{"all short words", "ctx id os fmt", nil},
+		{"stop words and short words", "where is the ctx", nil},
+		{"mixed", "how is ctx passed to NewReader", []string{"NewReader", "newreader", "passed"}},
+	}
+
+	r := &Neo4jRAG{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := SearchOptions{UseKeywords: true, PathFilters: []string{"*/internal/*"}}
+			terms, _, _ := r.queryKeywords(tt.query, opts)
+			keywordParams := keywordFilterParams(terms, r.minKeywordLength(), opts.Keywords)
+
+			// Assemble the filter the way searchWithEmbedding does
+			params := map[string]interface{}{}
+			conditions := []string{pathFilterCondition(opts, params)}
+			if len(keywordParams) > 0 {
+				conditions = append(conditions, keywordFilterCondition(keywordParams, params))
+			}
+			where := ` WHERE ` + strings.Join(conditions, ` AND `)
+
+			if len(tt.keywords) == 0 {
+				if len(keywordParams) != 0 || strings.Contains(where, "CONTAINS") {
+					t.Errorf("query %q filters on keywords: %s %v", tt.query, where, params)
+				}
+			} else if got := paramValues(keywordParams); !reflect.DeepEqual(got, tt.keywords) {
+				t.Errorf("query %q pre-filters on %q, want %q", tt.query, got, tt.keywords)
+			}
+
+			// Every condition is complete and every parameter it names is set
+			if strings.HasSuffix(strings.TrimSpace(where), "AND") || strings.HasSuffix(strings.TrimSpace(where), "WHERE") ||
+				strings.Count(where, "(") != strings.Count(where, ")") {
+				t.Errorf("malformed filter for %q: %s", tt.query, where)
+			}
+			for _, name := range regexp.MustCompile(`\$(\w+)`).FindAllStringSubmatch(where, -1) {
+				if _, ok := params[name[1]]; !ok {
+					t.Errorf("filter for %q uses $%s without setting it: %s", tt.query, name[1], where)
+				}
+			}
+		})
+	}
+}
+
+// numberedLines returns n lines "line01", "line02", ... joined by "\n"
+func numberedLines(n int) string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = "line" + string(rune('0'+(i+1)/10)) + string(rune('0'+(i+1)%10))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestChunkBySizeOverlapInvariants(t *testing.T) {
+	long := strings.Repeat("x", 50)
+	tests := []struct {
+		name         string
+		content      string
+		maxChunkSize int
+		overlap      int
+	}{
+		{"last chunk smaller than the overlap", numberedLines(10), 21, 20},
+		{"overlap larger than a chunk", numberedLines(10), 21, 1000},
+		{"overlap equal to a chunk", numberedLines(10), 21, 21},
+		{"lines longer than a chunk", strings.Join([]string{long, long, "a", long, "b"}, "\n"), 20, 30},
+		{"single trailing line", numberedLines(4) + "\n", 14, 14},
+		{"empty lines", "\n\n\n\n\n\n\n\n", 2, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Neo4jRAG{config: Config{MaxChunkSize: tt.maxChunkSize, ChunkOverlap: tt.overlap}}
+			lines := strings.Split(tt.content, "\n")
+			chunks := r.chunkBySize(tt.content, "/p/x.txt", "/p", "Text")
+			if len(chunks) == 0 {
+				t.Fatal("chunkBySize() returned no chunks")
+			}
+
+			for i, chunk := range chunks {
+				if chunk.StartLine < 1 || chunk.StartLine > chunk.EndLine || chunk.EndLine > len(lines) {
+					t.Fatalf("chunk %d has lines %d-%d of %d", i, chunk.StartLine, chunk.EndLine, len(lines))
+				}
+				if chunk.Content != strings.Join(lines[chunk.StartLine-1:chunk.EndLine], "\n") {
+					t.Errorf("chunk %d content = %q does not match lines %d-%d", i, chunk.Content, chunk.StartLine, chunk.EndLine)
+				}
+				if i == 0 {
+					if chunk.StartLine != 1 {
+						t.Errorf("first chunk starts at line %d", chunk.StartLine)
+					}
+					continue
+				}
+
+				// Each chunk starts after the previous one, without a gap,
+				// and overlaps less than the whole previous chunk
+				prev := chunks[i-1]
+				if chunk.StartLine <= prev.StartLine || chunk.StartLine > prev.EndLine+1 || chunk.EndLine <= prev.EndLine {
+					t.Errorf("chunk %d (lines %d-%d) does not follow chunk %d (lines %d-%d)",
+						i, chunk.StartLine, chunk.EndLine, i-1, prev.StartLine, prev.EndLine)
+				}
+			}
+			if last := chunks[len(chunks)-1]; last.EndLine != len(lines) {
+				t.Errorf("last chunk ends at line %d, want %d", last.EndLine, len(lines))
+			}
+		})
+	}
+}
+
+func TestOverlapLineCount(t *testing.T) {
+	lineSize := func(line string) int { return len(line) + 1 }
+	emitted := []string{"aaaa", "bbbb", "cccc"} // 5 characters each
+
+	tests := []struct {
+		name         string
+		emitted      []string
+		overlap      int
+		maxChunkSize int
+		want         int
+	}{
+		{"no overlap", emitted, 0, 100, 0},
+		{"one line fits", emitted, 5, 100, 1},
+		{"two lines fit", emitted, 10, 100, 2},
+		{"at least one line is left behind", emitted, 1000, 1000, 2},
+		{"kept under half a chunk", emitted, 1000, 20, 1},
+		{"single line chunk", []string{"aaaa"}, 1000, 1000, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := overlapLineCount(tt.emitted, tt.overlap, tt.maxChunkSize, lineSize); got != tt.want {
+				t.Errorf("overlapLineCount() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// vectorLength returns the L2 length of v
+func vectorLength(v []float32) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	return math.Sqrt(sum)
+}
+
+func TestNormalizeEmbedding(t *testing.T) {
+	tests := []struct {
+		name   string
+		vector []float32
+		want   float64
+	}{
+		{"unnormalized", []float32{3, 4}, 1},
+		{"already unit length", []float32{0, 1, 0}, 1},
+		{"tiny values", []float32{1e-20, 2e-20, 2e-20}, 1},
+		{"large values", []float32{1e10, -1e10, 1e10, -1e10}, 1},
+		{"zero vector is left alone", []float32{0, 0, 0}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := append([]float32{}, tt.vector...)
+			normalizeEmbedding(v)
+			if got := vectorLength(v); math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("normalizeEmbedding(%v) has length %v, want %v", tt.vector, got, tt.want)
+			}
+		})
+	}
+}
+
+// staticEmbedder returns copies of its vectors, one per text in turn
+type staticEmbedder struct {
+	vectors [][]float32
+}
+
+func (e *staticEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i := range texts {
+		embeddings[i] = append([]float32{}, e.vectors[i%len(e.vectors)]...)
+	}
+	return embeddings, nil
+}
+
+func TestEmbedWithNormalizesEmbeddings(t *testing.T) {
+	embedder := &staticEmbedder{vectors: [][]float32{{3, 4}, {1, 2, 2}}}
+	texts := []string{"chunk", "query"}
+
+	r := &Neo4jRAG{config: Config{NormalizeEmbeddings: true}}
+	embeddings, err := r.embedWith(context.Background(), embedder, texts)
+	if err != nil {
+		t.Fatalf("embedWith() error = %v", err)
+	}
+	for i, embedding := range embeddings {
+		if length := vectorLength(embedding); math.Abs(length-1) > 1e-6 {
+			t.Errorf("embedding %d has length %v with NormalizeEmbeddings, want 1", i, length)
+		}
+	}
+
+	// Dot product equals cosine similarity once both sides are normalized
+	a, b := embeddings[0], []float32{0.6, 0.8}
+	if dot := float64(a[0]*b[0] + a[1]*b[1]); math.Abs(dot-1) > 1e-6 {
+		t.Errorf("dot product of parallel unit vectors = %v, want 1", dot)
+	}
+
+	r = &Neo4jRAG{config: Config{}}
+	embeddings, err = r.embedWith(context.Background(), embedder, texts)
+	if err != nil {
+		t.Fatalf("embedWith() error = %v", err)
+	}
+	if !reflect.DeepEqual(embeddings, [][]float32{{3, 4}, {1, 2, 2}}) {
+		t.Errorf("embedWith() without NormalizeEmbeddings = %v, want the vectors unchanged", embeddings)
+	}
+}
+
+// chunkSpan is the part of a chunk the chunker tests compare
+type chunkSpan struct {
+	entityType string
+	name       string
+	startLine  int
+	endLine    int
+}
+
+func spansOf(chunks []CodeChunk) []chunkSpan {
+	spans := []chunkSpan{}
+	for _, chunk := range chunks {
+		spans = append(spans, chunkSpan{chunk.EntityType, chunk.Name, chunk.StartLine, chunk.EndLine})
+	}
+	return spans
+}
+
+func TestChunkFileCRLF(t *testing.T) {
+	lf := "package p\n\n// A is first\nfunc A() {\n\treturn\n}\n\nfunc B() {\n}\n"
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"CRLF", strings.ReplaceAll(lf, "\n", "\r\n")},
+		{"lone CR", strings.ReplaceAll(lf, "\n", "\r")},
+		{"mixed", "package p\r\n\n// A is first\rfunc A() {\r\n\treturn\n}\r\n\nfunc B() {\r}\n"},
+	}
+
+	r := &Neo4jRAG{config: Config{MaxChunkSize: 1000, ChunkOverlap: 100}}
+	want, _ := r.chunkFile(lf, "/p/x.go", "/p", "Go")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := r.chunkFile(tt.content, "/p/x.go", "/p", "Go")
+			if !reflect.DeepEqual(spansOf(got), spansOf(want)) {
+				t.Fatalf("chunkFile() = %v, want the line ranges of the LF file %v", spansOf(got), spansOf(want))
+			}
+			for _, chunk := range got {
+				// Content uses "\n" and the byte offsets point into the file as read
+				if raw := normalizeLineEndings(tt.content[chunk.StartByte:chunk.EndByte]); raw != chunk.Content {
+					t.Errorf("chunk %s bytes %d-%d hold %q, want %q", chunk.Name, chunk.StartByte, chunk.EndByte, raw, chunk.Content)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkBySizeCRLF(t *testing.T) {
+	lf := numberedLines(9)
+	crlf := strings.ReplaceAll(lf, "\n", "\r\n")
+	r := &Neo4jRAG{config: Config{MaxChunkSize: 21, ChunkOverlap: 7}}
+
+	want := r.chunkBySize(lf, "/p/x.txt", "/p", "Text")
+	got := r.chunkBySize(crlf, "/p/x.txt", "/p", "Text")
+	if len(got) != len(want) {
+		t.Fatalf("chunkBySize() made %d chunks of the CRLF file, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i].StartLine != want[i].StartLine || got[i].EndLine != want[i].EndLine || got[i].Content != want[i].Content {
+			t.Errorf("chunk %d = lines %d-%d %q, want lines %d-%d %q", i,
+				got[i].StartLine, got[i].EndLine, got[i].Content, want[i].StartLine, want[i].EndLine, want[i].Content)
+		}
+		if raw := strings.ReplaceAll(crlf[got[i].StartByte:got[i].EndByte], "\r\n", "\n"); raw != got[i].Content {
+			t.Errorf("chunk %d bytes %d-%d hold %q, want %q", i, got[i].StartByte, got[i].EndByte, raw, got[i].Content)
+		}
+	}
+}
+
+// writeFiles creates each file, and its directories, under dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProjectPathFor(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"main.go":          "package main\n",
+		"svc/api/h.go":     "package api\n",
+		"lib/go.mod":       "module example.com/lib\n",
+		"lib/pkg/x.go":     "package pkg\n",
+		"web/package.json": "{\"name\": \"web\"}\n",
+		"web/src/app.js":   "export {}\n",
+	})
+	resetProjectCache()
+	t.Cleanup(resetProjectCache)
+
+	tests := []struct {
+		layout string
+		file   string
+		want   string
+	}{
+		// Top-level: the top-level directory, or the root for files in it
+		{projectLayoutTopLevel, "main.go", "."},
+		{projectLayoutTopLevel, "svc/api/h.go", "svc"},
+		{projectLayoutTopLevel, "lib/pkg/x.go", "lib"},
+
+		// Root: always the root
+		{projectLayoutRoot, "main.go", "."},
+		{projectLayoutRoot, "svc/api/h.go", "."},
+
+		// Manifest: the nearest directory with a manifest, else the root
+		{projectLayoutManifest, "main.go", "."},
+		{projectLayoutManifest, "svc/api/h.go", "."},
+		{projectLayoutManifest, "lib/go.mod", "lib"},
+		{projectLayoutManifest, "lib/pkg/x.go", "lib"},
+		{projectLayoutManifest, "web/src/app.js", "web"},
+	}
+
+	for _, tt := range tests {
+		file := filepath.Join(root, filepath.FromSlash(tt.file))
+		want := filepath.Join(root, filepath.FromSlash(tt.want))
+		if got := projectPathFor(file, indexRoot{dir: root, layout: tt.layout}); got != want {
+			t.Errorf("projectPathFor(%s) in the %s layout = %s, want %s", tt.file, tt.layout, got, want)
+		}
+	}
+}
+
+func TestIndexRootsSeveralDirectories(t *testing.T) {
+	r := &Neo4jRAG{config: Config{ProjectLayout: projectLayoutManifest}}
+
+	roots, err := r.indexRoots([]string{"repo"})
+	if err != nil || len(roots) != 1 || roots[0] != (indexRoot{dir: "repo", layout: projectLayoutManifest}) {
+		t.Errorf("indexRoots(one directory) = %v, %v, want it with the configured layout", roots, err)
+	}
+
+	// Each of several directories is a project of its own
+	roots, err = r.indexRoots([]string{"a", "b"})
+	if err != nil || len(roots) != 2 {
+		t.Fatalf("indexRoots(two directories) = %v, %v", roots, err)
+	}
+	for i, name := range []string{"a", "b"} {
+		if !filepath.IsAbs(roots[i].dir) || filepath.Base(roots[i].dir) != name || roots[i].layout != projectLayoutRoot {
+			t.Errorf("root %d = %+v, want %s made absolute with the root layout", i, roots[i], name)
+		}
+	}
+}
+
+// paramValues returns the values of params, sorted
+func paramValues(params map[string]string) []string {
+	values := []string{}
+	for _, value := range params {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func TestKeywordFilterParams(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		minLength int
+		explicit  []string
+		want      []string
+	}{
+		{
+			name:      "short identifiers are dropped by default",
+			query:     "ctx id in fmt os handler",
+			minLength: defaultMinKeywordLength,
+			want:      []string{"handler"},
+		},
+		{
+			name:      "short identifiers with a lower minimum",
+			query:     "ctx id in fmt os handler",
+			minLength: 2,
+			want:      []string{"ctx", "fmt", "handler", "id", "os"},
+		},
+		{
+			name:      "explicit keywords bypass the minimum",
+			query:     "where is the handler",
+			minLength: defaultMinKeywordLength,
+			explicit:  []string{"os", "ID"},
+			want:      []string{"ID", "handler", "id", "os"},
+		},
+		{
+			name:      "keywords are matched as written and lowercased",
+			query:     "NewReader newreader",
+			minLength: defaultMinKeywordLength,
+			want:      []string{"NewReader", "newreader"},
+		},
+		{
+			name:      "only short terms leave no filter",
+			query:     "ctx id",
+			minLength: defaultMinKeywordLength,
+			want:      []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			terms := queryTerms(tt.query, defaultStopWords)
+			got := paramValues(keywordFilterParams(terms, tt.minLength, tt.explicit))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keywordFilterParams(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMinKeywordLength(t *testing.T) {
+	if got := (&Neo4jRAG{}).minKeywordLength(); got != defaultMinKeywordLength {
+		t.Errorf("minKeywordLength() unset = %d, want %d", got, defaultMinKeywordLength)
+	}
+	if got := (&Neo4jRAG{config: Config{MinKeywordLength: 2}}).minKeywordLength(); got != 2 {
+		t.Errorf("minKeywordLength() = %d, want 2", got)
+	}
+}
+
+func TestNameTerms(t *testing.T) {
+	tests := []struct {
+		keywords []string
+		want     []string
+	}{
+		{[]string{"parseconfig"}, []string{"parseconfig"}},
+		{[]string{"config.load"}, []string{"config.load", "load"}},
+		{[]string{"(*config).load", "reader"}, []string{"(*config).load", "load", "reader"}},
+		{[]string{"trailing."}, []string{"trailing."}},
+		{[]string{}, []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := nameTerms(tt.keywords); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("nameTerms(%q) = %q, want %q", tt.keywords, got, tt.want)
+		}
+	}
+}
+
+func TestChunkGoCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []chunkSpan
+	}{
+		{
+			name: "doc comments and nested braces",
+			content: `package store
+
+import "fmt"
+
+// Get returns the value for key.
+// It never panics.
+func (s *Store) Get(key string) (string, error) {
+	if v, ok := s.m[key]; ok {
+		for i := 0; i < 1; i++ {
+			fmt.Println("}")
+		}
+		return v, nil
+	}
+	return "", nil
+}
+
+type T struct{}
+
+// String implements fmt.Stringer
+func (T) String() string { return "{" }
+`,
+			want: []chunkSpan{
+				{"chunk", "chunk_1_3", 1, 3},
+				{"method", "(*Store).Get", 5, 15},
+				{"chunk", "chunk_17_17", 17, 17},
+				{"method", "T.String", 19, 20},
+			},
+		},
+		{
+			name: "generic functions and interface results",
+			content: `package p
+
+func Map[K comparable, V any](m map[K]V) []K {
+	return nil
+}
+
+func Any() interface{} {
+	return struct{}{}
+}
+`,
+			want: []chunkSpan{
+				{"chunk", "chunk_1_1", 1, 1},
+				{"function", "Map", 3, 5},
+				{"function", "Any", 7, 9},
+			},
+		},
+		{
+			name: "braces in comments and raw strings",
+			content: `package p
+
+// Open { is not a brace that counts
+func Open() string {
+	/* } */
+	return ` + "`}}`" + `
+}
+
+func Next() {}
+`,
+			want: []chunkSpan{
+				{"chunk", "chunk_1_1", 1, 1},
+				{"function", "Open", 3, 7},
+				{"function", "Next", 9, 9},
+			},
+		},
+	}
+
+	r := &Neo4jRAG{config: Config{MaxChunkSize: 1000, ChunkOverlap: 100}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := spansOf(r.chunkGoCode(tt.content, "/p/x.go", "/p"))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunkGoCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkGoCodeKeepsDocComment(t *testing.T) {
+	content := "package p\n\n// Add adds.\n// It is exported.\nfunc Add(a, b int) int {\n\treturn a + b\n}\n"
+	r := &Neo4jRAG{config: Config{MaxChunkSize: 1000, ChunkOverlap: 100}}
+
+	chunks := r.chunkGoCode(content, "/p/x.go", "/p")
+	want := "// Add adds.\n// It is exported.\nfunc Add(a, b int) int {\n\treturn a + b\n}"
+	if len(chunks) != 2 || chunks[1].Content != want || chunks[1].Signature != "a, b int" {
+		t.Fatalf("chunkGoCode() = %+v, want the function chunk %q", chunks, want)
+	}
+}
+
+func TestChunkDeclarationsSplitsByTokens(t *testing.T) {
+	var content strings.Builder
+	content.WriteString("package p\n\n// Big is big\nfunc Big() {\n")
+	for i := 0; i < 40; i++ {
+		content.WriteString("\tx := \"a line of about ten tokens\"\n")
+	}
+	content.WriteString("}\n\nfunc Small() {}\n")
+
+	// In characters declarations are never split
+	r := &Neo4jRAG{config: Config{MaxChunkSize: 100, ChunkOverlap: 0}}
+	chunks, _ := r.chunkFile(content.String(), "/p/x.go", "/p", "Go")
+	if len(chunks) != 3 || chunks[1].StartLine != 3 || chunks[1].EndLine != 45 {
+		t.Fatalf("chunkFile() in chars = %v, want Big whole", spansOf(chunks))
+	}
+
+	r = &Neo4jRAG{config: Config{MaxChunkSize: 100, ChunkOverlap: 0, ChunkUnit: chunkUnitTokens}}
+	chunks, _ = r.chunkFile(content.String(), "/p/x.go", "/p", "Go")
+	nextLine := 3
+	for _, chunk := range chunks[1 : len(chunks)-1] {
+		if chunk.Name != "Big" || chunk.EntityType != "function" || chunk.StartLine != nextLine {
+			t.Fatalf("chunkFile() in tokens = %v, want Big split into consecutive parts", spansOf(chunks))
+		}
+		if tokens := estimateTokens(chunk.Content); tokens > 100 {
+			t.Errorf("part %d-%d is %d tokens, over the budget of 100", chunk.StartLine, chunk.EndLine, tokens)
+		}
+		nextLine = chunk.EndLine + 1
+	}
+	if len(chunks) < 4 || nextLine != 46 || chunks[len(chunks)-1].Name != "Small" {
+		t.Errorf("chunkFile() in tokens = %v, want Big split and Small whole", spansOf(chunks))
+	}
+}
+
+func TestChunkBySize(t *testing.T) {
+	// Every line of numberedLines is 7 characters with its line ending
+	tests := []struct {
+		name         string
+		lines        int
+		maxChunkSize int
+		overlap      int
+		want         [][2]int // Line range of each chunk
+	}{
+		{
+			name:         "small input is one chunk",
+			lines:        3,
+			maxChunkSize: 100,
+			overlap:      10,
+			want:         [][2]int{{1, 3}},
+		},
+		{
+			name:         "no overlap",
+			lines:        9,
+			maxChunkSize: 21,
+			overlap:      0,
+			want:         [][2]int{{1, 3}, {4, 6}, {7, 9}},
+		},
+		{
+			name:         "overlap of one line",
+			lines:        9,
+			maxChunkSize: 21,
+			overlap:      7,
+			want:         [][2]int{{1, 3}, {3, 5}, {5, 7}, {7, 9}},
+		},
+		{
+			name:         "overlap is capped below half a chunk",
+			lines:        9,
+			maxChunkSize: 21,
+			overlap:      100,
+			want:         [][2]int{{1, 3}, {3, 5}, {5, 7}, {7, 9}},
+		},
+		{
+			name:         "overlap of two lines",
+			lines:        10,
+			maxChunkSize: 35,
+			overlap:      14,
+			want:         [][2]int{{1, 5}, {4, 8}, {7, 10}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Neo4jRAG{config: Config{MaxChunkSize: tt.maxChunkSize, ChunkOverlap: tt.overlap}}
+			chunks := r.chunkBySize(numberedLines(tt.lines), "/p/x.txt", "/p", "Text")
+
+			got := [][2]int{}
+			for _, chunk := range chunks {
+				got = append(got, [2]int{chunk.StartLine, chunk.EndLine})
+
+				want := strings.Split(numberedLines(tt.lines), "\n")[chunk.StartLine-1 : chunk.EndLine]
+				if chunk.Content != strings.Join(want, "\n") {
+					t.Errorf("chunk %d-%d content = %q", chunk.StartLine, chunk.EndLine, chunk.Content)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunkBySize() line ranges = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkBySizeInTokens(t *testing.T) {
+	// Each line is 2 estimated tokens plus 1 for its line ending
+	r := &Neo4jRAG{config: Config{MaxChunkSize: 9, ChunkOverlap: 3, ChunkUnit: chunkUnitTokens}}
+	chunks := r.chunkBySize(numberedLines(9), "/p/x.txt", "/p", "Text")
+
+	got := [][2]int{}
+	for _, chunk := range chunks {
+		got = append(got, [2]int{chunk.StartLine, chunk.EndLine})
+	}
+	want := [][2]int{{1, 3}, {3, 5}, {5, 7}, {7, 9}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("chunkBySize() line ranges = %v, want %v", got, want)
+	}
+}
+
+func TestExtractKeywords(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		stopWords map[string]bool
+		want      []string
+	}{
+		{
+			name:      "stop words are dropped",
+			query:     "how is the connection pool closed",
+			stopWords: defaultStopWords,
+			want:      []string{"connection", "pool", "closed"},
+		},
+		{
+			name:      "stop words match case-insensitively",
+			query:     "Where The Handler Is",
+			stopWords: defaultStopWords,
+			want:      []string{"handler"},
+		},
+		{
+			name:      "punctuation is trimmed and keywords lowercased",
+			query:     "\"ParseConfig\", (retry)?",
+			stopWords: defaultStopWords,
+			want:      []string{"parseconfig", "retry"},
+		},
+		{
+			name:      "single characters are dropped",
+			query:     "x y index",
+			stopWords: defaultStopWords,
+			want:      []string{"index"},
+		},
+		{
+			name:      "custom stop words replace the defaults",
+			query:     "the func returns",
+			stopWords: map[string]bool{"func": true},
+			want:      []string{"the", "returns"},
+		},
+		{
+			name:      "only stop words",
+			query:     "how is it",
+			stopWords: map[string]bool{"how": true, "is": true, "it": true},
+			want:      []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractKeywords(tt.query, tt.stopWords)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractKeywords(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGlobToRegex(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.go", "/repo/main.go", true},
+		{"*.go", "/repo/main.gox", false},
+		{"*handlers*", "/repo/api/handlers/user.go", true},
+		{"main.go", "mainxgo", false},
+
+		// "**/" matches zero or more directories
+		{"**/handlers/*.go", "/repo/handlers/user.go", true},
+		{"**/handlers/*.go", "handlers/user.go", true},
+		{"/repo/**/util.go", "/repo/util.go", true},
+		{"/repo/**/util.go", "/repo/a/b/util.go", true},
+		{"/repo/**/util.go", "/repo/a/b/util.go.bak", false},
+		{"/repo/**", "/repo/a/b.go", true},
+
+		// "?" matches exactly one character
+		{"*/file?.go", "/repo/file1.go", true},
+		{"*/file?.go", "/repo/file.go", false},
+		{"*/file?.go", "/repo/file12.go", false},
+
+		// Character classes
+		{"*/[abc].go", "/repo/b.go", true},
+		{"*/[abc].go", "/repo/d.go", false},
+		{"*/v[0-9].go", "/repo/v7.go", true},
+		{"*/v[0-9].go", "/repo/vx.go", false},
+		{"/repo/[!_]*.go", "/repo/main.go", true},
+		{"/repo/[!_]*.go", "/repo/_test.go", false},
+		{"/repo/[^_]*.go", "/repo/_test.go", false},
+		{"*/[.&]x", "/repo/&x", true},
+		{"*/[.&]x", "/repo/ax", false},
+
+		// A "[" that opens no class is literal, as are other regex metacharacters
+		{"*/a[b", "/repo/a[b", true},
+		{"*/a[]", "/repo/a[]", true},
+		{"*/a+b.go", "/repo/a+b.go", true},
+		{"*/a+b.go", "/repo/aab.go", false},
+	}
+
+	for _, tt := range tests {
+		regex := globToRegex(tt.pattern)
+		re, err := regexp.Compile(regex)
+		if err != nil {
+			t.Errorf("globToRegex(%q) = %q, which does not compile: %v", tt.pattern, regex, err)
+			continue
+		}
+		if got := re.MatchString(tt.path); got != tt.want {
+			t.Errorf("globToRegex(%q) = %q matching %q: got %v, want %v", tt.pattern, regex, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFindMatchingBrace(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		closed  bool // The brace is closed by the content's last byte
+	}{
+		{"nested braces", "{ if x { y() } }", true},
+		{"double-quoted string", `{ s := "}" }`, true},
+		{"escaped quote in a string", `{ s := "\"}" }`, true},
+		{"rune", `{ c := '}' }`, true},
+		{"escaped rune", `{ c := '\'' ; d := '}' }`, true},
+		{"raw string", "{ s := `}\n}` }", true},
+		{"line comment", "{ // }\n}", true},
+		{"block comment", "{ /* } */ }", true},
+		{"unclosed", `{ s := "}"`, false},
+		{"unterminated raw string", "{ s := `}", false},
+		{"unterminated block comment", "{ /* }", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := -1
+			if tt.closed {
+				want = len(tt.content) - 1
+			}
+			if got := findMatchingBrace(tt.content, 0); got != want {
+				t.Errorf("findMatchingBrace(%q) = %d, want %d", tt.content, got, want)
+			}
+		})
+	}
+}
+
+func TestScanJSBraces(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"nested braces", "{ if (x) { y() } }"},
+		{"strings", `{ a = "}"; b = '}'; c = "\"}" }`},
+		{"template literal", "{ s = `}` }"},
+		{"template substitution with braces", "{ s = `} ${ {k: '}'}.k } }` }"},
+		{"nested template literals", "{ s = `${ `}${ x }}` }}` }"},
+		{"line comment", "{ // }\n}"},
+		{"block comment", "{ /* } */ }"},
+		{"regular expression", "{ ok = /}/.test(s) }"},
+		{"regular expression after return", "{ return /}/.test(s) }"},
+		{"regular expression after typeof", "{ t = typeof /}/ }"},
+		{"division", "{ x = a / b / c }"},
+		{"division by a property named like a keyword", "{ x = a.return / b; y = '/}' }"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layout := scanJS(tt.content)
+			if got, ok := layout.close[0]; !ok || got != len(tt.content)-1 {
+				t.Errorf("scanJS(%q) closes the first brace at %d (%v), want %d", tt.content, got, ok, len(tt.content)-1)
+			}
+		})
+	}
+}
+
+func TestDeclarationChunkersIgnoreQuotedBraces(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+		content  string
+		want     []chunkSpan
+	}{
+		{
+			name:     "Go",
+			language: "Go",
+			content:  "package p\n\nfunc A() string {\n\ts := \"}\" + `}`\n\t// }\n\t/* } */\n\treturn s + string('}')\n}\n\nfunc B() {}\n",
+			want: []chunkSpan{
+				{"chunk", "chunk_1_1", 1, 1},
+				{"function", "A", 3, 8},
+				{"function", "B", 10, 10},
+			},
+		},
+		{
+			name:     "JavaScript",
+			language: "JavaScript",
+			content:  "import x from 'y'\n\nfunction a() {\n  const s = `}${ {k: '}'}.k }`\n  // }\n  return /}/.test(s) && \"}\"\n}\n\nconst b = () => {\n  /* } */\n}\n",
+			want: []chunkSpan{
+				{"chunk", "chunk_1_1", 1, 1},
+				{"function", "a", 3, 7},
+				{"function", "b", 9, 11},
+			},
+		},
+		{
+			name:     "C",
+			language: "C",
+			content:  "#include <stdio.h>\n\nint a(void) {\n  char c = '}';\n  printf(\"}\\n\"); // }\n  /* } */\n  return 0;\n}\n\nint b(void) {\n  return 1;\n}\n",
+			want: []chunkSpan{
+				{"chunk", "chunk_1_1", 1, 1},
+				{"function", "a", 3, 8},
+				{"function", "b", 10, 12},
+			},
+		},
+	}
+
+	r := &Neo4jRAG{config: Config{MaxChunkSize: 1000, ChunkOverlap: 100}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := r.chunkFile(tt.content, "/p/x", "/p", tt.language)
+			if err != nil {
+				t.Fatalf("chunkFile() error = %v", err)
+			}
+			if got := spansOf(chunks); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunkFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
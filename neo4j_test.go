@@ -0,0 +1,870 @@
+//go:build integration
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// The tests in this file index and search a real Neo4j 4.4 with the Graph
+// Data Science plugin, which testcontainers-go starts in Docker:
+//
+//	go test -tags integration ./...
+//	go test -tags integration -run '^$' -bench Neo4j .
+//
+// To run against a server of your own instead, point NEO4J_TEST_URI at it,
+// with NEO4J_TEST_USER and NEO4J_TEST_PASSWORD (default neo4j/password).
+// Use a scratch database: each test indexes a project of its own and
+// removes it when done, but searches without a project filter also see
+// whatever else is indexed. With -short no container is started and the
+// tests are skipped.
+
+// neo4jTestURI is the Bolt URI of the server the tests run against
+var neo4jTestURI string
+
+func TestMain(m *testing.M) {
+	os.Exit(runWithNeo4j(m))
+}
+
+// runWithNeo4j runs the tests against NEO4J_TEST_URI, or against a Neo4j
+// container started for the run and removed after it
+func runWithNeo4j(m *testing.M) int {
+	flag.Parse()
+	neo4jTestURI = os.Getenv("NEO4J_TEST_URI")
+	if neo4jTestURI == "" && !testing.Short() {
+		ctx := context.Background()
+		container, uri, err := startNeo4j(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to start Neo4j: %v\n", err)
+			return 1
+		}
+		defer container.Terminate(ctx)
+		neo4jTestURI = uri
+	}
+	return m.Run()
+}
+
+// startNeo4j starts the Neo4j image and plugins docker-compose.yml runs and
+// returns the container and its Bolt URI
+func startNeo4j(ctx context.Context) (testcontainers.Container, string, error) {
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "neo4j:4.4",
+			ExposedPorts: []string{"7687/tcp"},
+			Env: map[string]string{
+				"NEO4J_AUTH":        "neo4j/password",
+				"NEO4JLABS_PLUGINS": `["graph-data-science"]`,
+			},
+			// The plugin is downloaded when the container starts
+			WaitingFor: wait.ForLog("Started.").WithStartupTimeout(5 * time.Minute),
+		},
+		Started: true,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	uri, err := container.PortEndpoint(ctx, "7687/tcp", "bolt")
+	if err != nil {
+		container.Terminate(ctx)
+		return nil, "", err
+	}
+	return container, uri, nil
+}
+
+// testLogWriter sends the RAG's log output to the test log
+type testLogWriter struct {
+	t *testing.T
+}
+
+func (w testLogWriter) Write(p []byte) (int, error) {
+	w.t.Log(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+// envOr returns the environment variable key, or fallback when it is unset
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// newTestRAG connects to the test Neo4j with config. Chunks are embedded
+// with MockEmbedder, so no embedding service is needed. It returns a fresh
+// directory to index as the test's project, which is removed from the index
+// when the test ends.
+func newTestRAG(t testing.TB, config Config) (*Neo4jRAG, string) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping Neo4j integration test in short mode")
+	}
+
+	config.Neo4jURI = neo4jTestURI
+	config.Neo4jUser = envOr("NEO4J_TEST_USER", "neo4j")
+	config.Neo4jPassword = envOr("NEO4J_TEST_PASSWORD", "password")
+	config.Embedder = &MockEmbedder{}
+	config.LLMProvider = llmProviderMock
+	config.ProjectLayout = projectLayoutRoot
+	if config.MaxChunkSize == 0 {
+		config.MaxChunkSize = 1000
+		config.ChunkOverlap = 100
+	}
+	if tt, ok := t.(*testing.T); ok {
+		config.LogOutput = testLogWriter{tt}
+	} else {
+		config.LogLevel = logLevelWarn
+	}
+
+	rag, err := NewNeo4jRAG(config)
+	if err != nil {
+		t.Fatalf("NewNeo4jRAG() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	t.Cleanup(func() {
+		if _, err := rag.Reset(dir); err != nil {
+			t.Errorf("Reset(%s) error = %v", dir, err)
+		}
+		rag.Close()
+	})
+	return rag, dir
+}
+
+// storeTestChunks embeds chunks and stores them as the chunks of filePath
+// in projectPath, the way indexing does
+func storeTestChunks(t testing.TB, rag *Neo4jRAG, filePath, projectPath string, chunks []CodeChunk) []CodeChunk {
+	t.Helper()
+	chunks = prepareTestChunks(t, rag, filePath, projectPath, chunks)
+	if err := rag.storeChunks(context.Background(), chunks, filePath, projectPath); err != nil {
+		t.Fatalf("storeChunks() error = %v", err)
+	}
+	return chunks
+}
+
+// prepareTestChunks fills in the fields indexing sets on chunks of filePath
+// in projectPath and embeds them, without storing them
+func prepareTestChunks(t testing.TB, rag *Neo4jRAG, filePath, projectPath string, chunks []CodeChunk) []CodeChunk {
+	t.Helper()
+	for i := range chunks {
+		chunks[i].FilePath = filePath
+		chunks[i].ProjectPath = projectPath
+		if chunks[i].Language == "" {
+			chunks[i].Language = "Go"
+		}
+		if chunks[i].EndLine == 0 {
+			chunks[i].EndLine = chunks[i].StartLine + strings.Count(chunks[i].Content, "\n")
+		}
+	}
+	assignChunkIDs(chunks, filePath)
+
+	if err := rag.generateEmbeddings(context.Background(), chunks); err != nil {
+		t.Fatalf("generateEmbeddings() error = %v", err)
+	}
+	return chunks
+}
+
+// resultNames returns the names of search results, in rank order
+func resultNames(chunks []CodeChunk) []string {
+	names := []string{}
+	for _, chunk := range chunks {
+		names = append(names, chunk.Name)
+	}
+	return names
+}
+
+// rankOf returns the position of the result named name, or -1
+func rankOf(chunks []CodeChunk, name string) int {
+	for i, chunk := range chunks {
+		if chunk.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestNeo4jStoreAndSearchCode(t *testing.T) {
+	for _, metric := range []string{similarityCosine, similarityDot, similarityEuclidean} {
+		t.Run(metric, func(t *testing.T) {
+			testStoreAndSearchCode(t, metric)
+		})
+	}
+}
+
+func testStoreAndSearchCode(t *testing.T, metric string) {
+	rag, dir := newTestRAG(t, Config{SimilarityMetric: metric})
+	file := filepath.Join(dir, "widgets.go")
+
+	storeTestChunks(t, rag, file, dir, []CodeChunk{
+		{StartLine: 1, EntityType: "function", Name: "Frobnicate", Signature: "widget string",
+			Content: "func Frobnicate(widget string) {\n\tquuxify(widget)\n}"},
+		{StartLine: 5, EntityType: "function", Name: "Zorble", Signature: "gadget int",
+			Content: "func Zorble(gadget int) {\n\tblargify(gadget)\n}"},
+		{StartLine: 9, EntityType: "function", Name: "FrobnicateAll", Signature: "widgets []string",
+			Content: "func FrobnicateAll(widgets []string) {\n\tfor _, w := range widgets {\n\t\tFrobnicate(w)\n\t}\n}"},
+	})
+
+	results, err := rag.SearchCode("frobnicate widget", 10)
+	if err != nil {
+		t.Fatalf("SearchCode() error = %v", err)
+	}
+	if len(results) == 0 || results[0].Name != "Frobnicate" {
+		t.Fatalf("SearchCode() ranked %v, want Frobnicate first", resultNames(results))
+	}
+	if all := rankOf(results, "FrobnicateAll"); all < 0 {
+		t.Errorf("SearchCode() ranked %v, want FrobnicateAll among the results", resultNames(results))
+	}
+	if zorble := rankOf(results, "Zorble"); zorble >= 0 && zorble < rankOf(results, "FrobnicateAll") {
+		t.Errorf("SearchCode() ranked %v, want the unrelated Zorble after FrobnicateAll", resultNames(results))
+	}
+
+	results, err = rag.SearchCode("zorble gadget", 10)
+	if err != nil {
+		t.Fatalf("SearchCode() error = %v", err)
+	}
+	if len(results) == 0 || results[0].Name != "Zorble" {
+		t.Errorf("SearchCode() ranked %v, want Zorble first", resultNames(results))
+	}
+}
+
+func TestNeo4jStoreChunksUpdatesChangedContent(t *testing.T) {
+	rag, dir := newTestRAG(t, Config{})
+	file := filepath.Join(dir, "widgets.go")
+	opts := SearchOptions{Limit: 10, MinScore: 0.1, ProjectPaths: []string{dir}}
+
+	storeTestChunks(t, rag, file, dir, []CodeChunk{
+		{StartLine: 1, EntityType: "function", Name: "Frobnicate", Content: "func Frobnicate() {\n\tquuxify()\n}"},
+	})
+	storeTestChunks(t, rag, file, dir, []CodeChunk{
+		{StartLine: 1, EntityType: "function", Name: "Frobnicate", Content: "func Frobnicate() {\n\tsprocket()\n}"},
+	})
+
+	results, err := rag.SearchCodeWithOptions("sprocket", opts)
+	if err != nil {
+		t.Fatalf("SearchCodeWithOptions() error = %v", err)
+	}
+	if len(results) != 1 || !strings.Contains(results[0].Content, "sprocket") {
+		t.Fatalf("search for the new content found %v, want the updated chunk", resultNames(results))
+	}
+
+	results, err = rag.SearchCodeWithOptions("quuxify", opts)
+	if err != nil {
+		t.Fatalf("SearchCodeWithOptions() error = %v", err)
+	}
+	for _, result := range results {
+		if strings.Contains(result.Content, "quuxify") {
+			t.Errorf("search still finds the replaced content %q", result.Content)
+		}
+	}
+}
+
+func TestNeo4jIndexDirectoryAndSearch(t *testing.T) {
+	rag, dir := newTestRAG(t, Config{})
+	writeFiles(t, dir, map[string]string{
+		"auth/password.go": `package auth
+
+// ValidatePassword checks a password against its stored hash
+func ValidatePassword(password, hash string) bool {
+	return compareHash(hash, password)
+}
+
+func compareHash(hash, password string) bool {
+	return hash == password
+}
+`,
+		"store/evict.go": `package store
+
+// EvictExpired removes entries past their expiry time
+func EvictExpired(entries map[string]int64, now int64) {
+	for key, expiry := range entries {
+		if now > expiry {
+			delete(entries, key)
+		}
+	}
+}
+`,
+	})
+
+	if err := rag.IndexDirectory(dir); err != nil {
+		t.Fatalf("IndexDirectory() error = %v", err)
+	}
+
+	tests := []struct {
+		query string
+		want  string
+		file  string
+	}{
+		{"validate password", "ValidatePassword", "auth/password.go"},
+		{"evict expired entries", "EvictExpired", "store/evict.go"},
+	}
+	for _, tt := range tests {
+		results, err := rag.SearchCodeWithOptions(tt.query, SearchOptions{Limit: 5, MinScore: 0.1, ProjectPaths: []string{dir}})
+		if err != nil {
+			t.Fatalf("SearchCodeWithOptions(%q) error = %v", tt.query, err)
+		}
+		if len(results) == 0 || results[0].Name != tt.want {
+			t.Errorf("SearchCodeWithOptions(%q) ranked %v, want %s first", tt.query, resultNames(results), tt.want)
+			continue
+		}
+		if want := filepath.Join(dir, filepath.FromSlash(tt.file)); results[0].FilePath != want {
+			t.Errorf("SearchCodeWithOptions(%q) found %s in %s, want %s", tt.query, tt.want, results[0].FilePath, want)
+		}
+	}
+
+	// Resetting the project removes everything indexed for it
+	if _, err := rag.Reset(dir); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	results, err := rag.SearchCodeWithOptions("validate password", SearchOptions{Limit: 5, ProjectPaths: []string{dir}})
+	if err != nil {
+		t.Fatalf("SearchCodeWithOptions() error = %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("search after Reset found %v, want nothing", resultNames(results))
+	}
+}
+
+func TestNeo4jSearchEntrypointsAgree(t *testing.T) {
+	rag, dir := newTestRAG(t, Config{})
+	storeTestChunks(t, rag, filepath.Join(dir, "widgets.go"), dir, []CodeChunk{
+		{StartLine: 1, EntityType: "function", Name: "Frobnicate", Content: "func Frobnicate(widget string) {\n\tquuxify(widget)\n}"},
+		{StartLine: 5, EntityType: "function", Name: "FrobnicateAll", Content: "func FrobnicateAll(widgets []string) {\n\tfor _, w := range widgets {\n\t\tFrobnicate(w)\n\t}\n}"},
+		{StartLine: 11, EntityType: "chunk", Name: "chunk_11_12", Content: "// widget frobnication\nvar frobnicated = map[string]bool{}"},
+	})
+
+	for _, query := range []string{"frobnicate widget", "frobnicated widgets map"} {
+		simple, err := rag.SearchCode(query, 10)
+		if err != nil {
+			t.Fatalf("SearchCode(%q) error = %v", query, err)
+		}
+		advanced, err := rag.SearchCodeAdvanced(query, 10, nil, nil, 0.1, false)
+		if err != nil {
+			t.Fatalf("SearchCodeAdvanced(%q) error = %v", query, err)
+		}
+		if len(simple) == 0 {
+			t.Fatalf("SearchCode(%q) found nothing", query)
+		}
+		if !reflect.DeepEqual(simple, advanced) {
+			t.Errorf("SearchCode(%q) = %v, SearchCodeAdvanced = %v, want the same results", query, resultNames(simple), resultNames(advanced))
+		}
+	}
+}
+
+func TestNeo4jSearchReturnsStoredFields(t *testing.T) {
+	rag, dir := newTestRAG(t, Config{})
+	file := filepath.Join(dir, "widgets.go")
+	stored := storeTestChunks(t, rag, file, dir, []CodeChunk{{
+		StartLine:  3,
+		EndLine:    5,
+		StartByte:  14,
+		EndByte:    62,
+		EntityType: "method",
+		Name:       "(*Widget).Frobnicate",
+		Signature:  "times int",
+		Language:   "Go",
+		Content:    "func (w *Widget) Frobnicate(times int) {\n\tw.quuxify(times)\n}",
+	}})[0]
+
+	search := map[string]func() ([]CodeChunk, error){
+		"SearchCode": func() ([]CodeChunk, error) {
+			return rag.SearchCode("frobnicate widget times", 10)
+		},
+		"SearchCodeAdvanced": func() ([]CodeChunk, error) {
+			return rag.SearchCodeAdvanced("frobnicate widget times", 10, []string{"Go"}, nil, 0.1, true)
+		},
+		"SearchCodeWithOptions": func() ([]CodeChunk, error) {
+			return rag.SearchCodeWithOptions("frobnicate widget times", SearchOptions{Limit: 10, MinScore: 0.1, ProjectPaths: []string{dir}})
+		},
+	}
+	for name, run := range search {
+		results, err := run()
+		if err != nil {
+			t.Fatalf("%s() error = %v", name, err)
+		}
+		if len(results) == 0 || results[0].ID != stored.ID {
+			t.Errorf("%s() ranked %v, want the stored chunk first", name, resultNames(results))
+			continue
+		}
+
+		got := results[0]
+		got.Score = 0
+		want := stored
+		want.Embedding = nil
+		want.Hash = ""
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s() returned\n%+v\nwant the stored fields\n%+v", name, got, want)
+		}
+	}
+}
+
+func TestNeo4jExactNameRanksFirst(t *testing.T) {
+	rag, dir := newTestRAG(t, Config{})
+	file := filepath.Join(dir, "config.go")
+
+	// Both chunks hold the same words, so their vector and keyword scores
+	// tie and only the exact-name bonus can tell them apart
+	storeTestChunks(t, rag, file, dir, []CodeChunk{
+		{StartLine: 1, EntityType: "function", Name: "ParseConfig", Signature: "path string",
+			Content: "func ParseConfig(path string) error {\n\treturn LoadSettings(path)\n}"},
+		{StartLine: 5, EntityType: "function", Name: "LoadSettings", Signature: "path string",
+			Content: "func LoadSettings(path string) error {\n\treturn ParseConfig(path)\n}"},
+	})
+
+	for _, name := range []string{"ParseConfig", "LoadSettings"} {
+		results, err := rag.SearchCodeWithOptions(name, SearchOptions{Limit: 5, MinScore: 0.1, ProjectPaths: []string{dir}, Explain: true})
+		if err != nil {
+			t.Fatalf("SearchCodeWithOptions(%q) error = %v", name, err)
+		}
+		if len(results) != 2 || results[0].Name != name {
+			t.Errorf("SearchCodeWithOptions(%q) ranked %v, want %s first", name, resultNames(results), name)
+			continue
+		}
+		if results[0].Breakdown == nil || results[1].Breakdown == nil {
+			t.Errorf("SearchCodeWithOptions(%q) with Explain returned no score breakdowns", name)
+			continue
+		}
+		if bonus := results[0].Breakdown.NameBonus; bonus != exactNameBonus {
+			t.Errorf("SearchCodeWithOptions(%q) gave %s a name bonus of %v, want %v", name, name, bonus, exactNameBonus)
+		}
+		if bonus := results[1].Breakdown.NameBonus; bonus != 0 {
+			t.Errorf("SearchCodeWithOptions(%q) gave %s a name bonus of %v, want 0", name, results[1].Name, bonus)
+		}
+	}
+}
+
+// chunkTimestamps returns the created_at and updated_at properties of the
+// chunk with the given id, as the driver returns them
+func chunkTimestamps(t *testing.T, rag *Neo4jRAG, id string) (createdAt, updatedAt interface{}) {
+	t.Helper()
+	session := rag.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+
+	result, err := session.Run(`MATCH (c:Chunk {id: $id}) RETURN c.created_at AS created, c.updated_at AS updated`,
+		map[string]interface{}{"id": id})
+	if err != nil {
+		t.Fatalf("reading chunk timestamps: %v", err)
+	}
+	record, err := result.Single()
+	if err != nil {
+		t.Fatalf("reading chunk timestamps: %v", err)
+	}
+	createdAt, _ = record.Get("created")
+	updatedAt, _ = record.Get("updated")
+	return createdAt, updatedAt
+}
+
+func TestNeo4jChunkTimestampsAreTemporal(t *testing.T) {
+	rag, dir := newTestRAG(t, Config{})
+	file := filepath.Join(dir, "clock.go")
+	start := time.Now().Add(-time.Second)
+
+	chunks := storeTestChunks(t, rag, file, dir, []CodeChunk{
+		{StartLine: 1, EntityType: "function", Name: "Tick", Content: "func Tick() {\n\tadvanceClock()\n}"},
+	})
+	id := chunks[0].ID
+
+	created, updated := chunkTimestamps(t, rag, id)
+	createdAt, createdOK := created.(time.Time)
+	updatedAt, updatedOK := updated.(time.Time)
+	if !createdOK || !updatedOK {
+		t.Fatalf("created_at is %T and updated_at is %T, want both datetimes", created, updated)
+	}
+	if createdAt.Before(start) || updatedAt.Before(createdAt) {
+		t.Errorf("created_at %v and updated_at %v, want both after %v, in that order", createdAt, updatedAt, start)
+	}
+
+	// Updated_at values stored as strings by older versions are converted
+	// when the database is initialized
+	session := rag.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+	if _, err := session.Run(`MATCH (c:Chunk {id: $id}) SET c.updated_at = toString(c.updated_at)`,
+		map[string]interface{}{"id": id}); err != nil {
+		t.Fatalf("storing a string timestamp: %v", err)
+	}
+	if _, updated := chunkTimestamps(t, rag, id); fmt.Sprintf("%T", updated) != "string" {
+		t.Fatalf("updated_at is %T after storing a string", updated)
+	}
+	if err := rag.initDatabase(); err != nil {
+		t.Fatalf("initDatabase() error = %v", err)
+	}
+	if _, updated := chunkTimestamps(t, rag, id); !updatedAt.Equal(asTime(updated)) {
+		t.Errorf("updated_at is %v (%T) after initDatabase, want the datetime %v", updated, updated, updatedAt)
+	}
+
+	// Both fields compare as temporals in Cypher
+	result, err := session.Run(`MATCH (c:Chunk {id: $id}) RETURN c.created_at <= c.updated_at AS ordered`,
+		map[string]interface{}{"id": id})
+	if err != nil {
+		t.Fatalf("comparing timestamps: %v", err)
+	}
+	record, err := result.Single()
+	if err != nil {
+		t.Fatalf("comparing timestamps: %v", err)
+	}
+	if ordered, _ := record.Get("ordered"); ordered != true {
+		t.Errorf("created_at <= updated_at is %v, want true", ordered)
+	}
+}
+
+// asTime returns v as a time.Time, or the zero time when it is not one
+func asTime(v interface{}) time.Time {
+	t, _ := v.(time.Time)
+	return t
+}
+
+func TestNeo4jUpdatedFilters(t *testing.T) {
+	rag, dir := newTestRAG(t, Config{})
+	file := filepath.Join(dir, "clock.go")
+	before := time.Now().Add(-time.Minute)
+
+	storeTestChunks(t, rag, file, dir, []CodeChunk{
+		{StartLine: 1, EntityType: "function", Name: "Tick", Content: "func Tick() {\n\tadvanceClock()\n}"},
+	})
+	after := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		name          string
+		updatedAfter  time.Time
+		updatedBefore time.Time
+		found         bool
+	}{
+		{"no filter", time.Time{}, time.Time{}, true},
+		{"updated after an earlier time", before, time.Time{}, true},
+		{"updated after a later time", after, time.Time{}, false},
+		{"updated before a later time", time.Time{}, after, true},
+		{"updated before an earlier time", time.Time{}, before, false},
+		{"updated within the window", before, after, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := rag.SearchCodeWithOptions("tick advance clock", SearchOptions{
+				Limit:         5,
+				MinScore:      0.1,
+				ProjectPaths:  []string{dir},
+				UpdatedAfter:  tt.updatedAfter,
+				UpdatedBefore: tt.updatedBefore,
+			})
+			if err != nil {
+				t.Fatalf("SearchCodeWithOptions() error = %v", err)
+			}
+			if found := rankOf(results, "Tick") >= 0; found != tt.found {
+				t.Errorf("SearchCodeWithOptions() found %v, want Tick found: %v", resultNames(results), tt.found)
+			}
+		})
+	}
+}
+
+func TestNeo4jFindDuplicates(t *testing.T) {
+	rag, dir := newTestRAG(t, Config{})
+	chunks := storeTestChunks(t, rag, filepath.Join(dir, "sums.go"), dir, []CodeChunk{
+		{StartLine: 1, EntityType: "function", Name: "SumInts",
+			Content: "func SumInts(xs []int) int {\n\ttotal := 0\n\tfor _, x := range xs {\n\t\ttotal += x\n\t}\n\treturn total\n}"},
+		{StartLine: 9, EntityType: "function", Name: "AddInts",
+			Content: "func AddInts(xs []int) int {\n\ttotal := 0\n\tfor _, x := range xs {\n\t\ttotal += x\n\t}\n\treturn total\n}"},
+		{StartLine: 17, EntityType: "function", Name: "OpenSocket",
+			Content: "func OpenSocket(addr string) (net.Conn, error) {\n\treturn net.Dial(\"tcp\", addr)\n}"},
+	})
+	sum, add, open := chunks[0].ID, chunks[1].ID, chunks[2].ID
+
+	report, err := rag.FindDuplicates(DuplicateOptions{Threshold: 0.8, MinLines: 1})
+	if err != nil {
+		t.Fatalf("FindDuplicates() error = %v", err)
+	}
+	if report.Method != "gds.knn" {
+		t.Errorf("FindDuplicates() used %q, want gds.knn", report.Method)
+	}
+
+	// Other projects in the database may have duplicates of their own
+	var found *DuplicateCluster
+	for i, cluster := range report.Clusters {
+		for _, chunk := range cluster.Chunks {
+			if chunk.ID == open {
+				t.Errorf("FindDuplicates() put the unrelated OpenSocket in a cluster of %d", cluster.Size)
+			}
+			if chunk.ID == sum {
+				found = &report.Clusters[i]
+			}
+		}
+	}
+	if found == nil {
+		t.Fatalf("FindDuplicates() found no cluster holding SumInts")
+	}
+	ids := map[string]bool{}
+	for _, chunk := range found.Chunks {
+		ids[chunk.ID] = true
+	}
+	if !ids[add] || found.Identical {
+		t.Errorf("FindDuplicates() clustered SumInts as %+v, want it with AddInts as near copies", found)
+	}
+}
+
+func TestNeo4jImportChunksOmitContent(t *testing.T) {
+	for _, omit := range []bool{false, true} {
+		t.Run(fmt.Sprintf("OmitContent=%v", omit), func(t *testing.T) {
+			rag, dir := newTestRAG(t, Config{OmitContent: omit})
+			content := "func Tick() {\n\tadvanceClock()\n}"
+			chunk := ExportedChunk{CodeChunk: CodeChunk{
+				ID:          "import-" + dir,
+				Content:     content,
+				FilePath:    filepath.Join(dir, "clock.go"),
+				ProjectPath: dir,
+				Language:    "Go",
+				StartLine:   1,
+				EndLine:     3,
+				EndByte:     len(content),
+				EntityType:  "function",
+				Name:        "Tick",
+			}}
+			line, err := json.Marshal(chunk)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := rag.ImportChunks(bytes.NewReader(line), 0, false); err != nil {
+				t.Fatalf("ImportChunks() error = %v", err)
+			}
+
+			session := rag.driver.NewSession(neo4j.SessionConfig{})
+			defer session.Close()
+			result, err := session.Run(`MATCH (c:Chunk {id: $id}) RETURN c.content AS content, c.content_size AS size`,
+				map[string]interface{}{"id": chunk.ID})
+			if err != nil {
+				t.Fatalf("reading the imported chunk: %v", err)
+			}
+			record, err := result.Single()
+			if err != nil {
+				t.Fatalf("reading the imported chunk: %v", err)
+			}
+			stored, _ := record.Get("content")
+			size, _ := record.Get("size")
+
+			var want interface{} = content
+			if omit {
+				want = nil
+			}
+			if stored != want || size != int64(len(content)) {
+				t.Errorf("imported content = %q with content_size %v, want %q with %d", stored, size, want, len(content))
+			}
+		})
+	}
+}
+
+// benchmarkChunks returns n Go function chunks of one file, each calling the
+// next. The version is part of every chunk's content, so chunks of another
+// version have the same IDs but different hashes.
+func benchmarkChunks(n, version int) []CodeChunk {
+	chunks := make([]CodeChunk, n)
+	for i := range chunks {
+		name := fmt.Sprintf("Step%d", i)
+		chunks[i] = CodeChunk{
+			StartLine:  i*4 + 1,
+			EntityType: "function",
+			Name:       name,
+			Content:    fmt.Sprintf("func %s(n int) int {\n\treturn Step%d(n + %d)\n}", name, i+1, version),
+			Calls:      []string{fmt.Sprintf("Step%d", i+1)},
+			Imports:    []string{},
+		}
+	}
+	return chunks
+}
+
+// storeChunksOneByOne stores chunks the way storeChunks did before it
+// batched them: a read and, unless the chunk is unchanged, a MERGE for each
+// chunk, plus one statement per Go chunk for its symbol edges. It is the
+// baseline for BenchmarkNeo4jStoreChunks. It leaves out the project and
+// file MERGEs, which both versions run once per file, so the file must
+// already be stored.
+func storeChunksOneByOne(rag *Neo4jRAG, chunks []CodeChunk, filePath string) error {
+	session := rag.driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+
+	storeReferences := func(tx neo4j.Transaction, chunk CodeChunk) error {
+		_, err := tx.Run(
+			`MATCH (c:Chunk {id: $id})
+			 OPTIONAL MATCH (c)-[old:CALLS|IMPORTS|DEFINES]->()
+			 DELETE old
+			 WITH DISTINCT c
+			 SET c.references_indexed = true
+			 FOREACH (name IN $calls |
+			     MERGE (s:Symbol {name: name, project_path: $projectPath})
+			     MERGE (c)-[:CALLS]->(s))
+			 FOREACH (importPath IN $imports |
+			     MERGE (p:Package {path: importPath})
+			     MERGE (c)-[:IMPORTS]->(p))
+			 FOREACH (name IN $defines |
+			     MERGE (s:Symbol {name: name, project_path: $projectPath})
+			     MERGE (c)-[:DEFINES]->(s))`,
+			map[string]interface{}{
+				"id":          chunk.ID,
+				"projectPath": chunk.ProjectPath,
+				"calls":       chunk.Calls,
+				"imports":     chunk.Imports,
+				"defines":     []string{chunk.Name},
+			},
+		)
+		return err
+	}
+
+	_, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		for _, chunk := range chunks {
+			result, err := tx.Run(
+				"MATCH (c:Chunk {id: $id}) RETURN c.hash, c.chunker_version, c.embedded, c.embedding2 IS NOT NULL AS hasEmbedding2, c.references_indexed",
+				map[string]interface{}{"id": chunk.ID},
+			)
+			if err != nil {
+				return nil, err
+			}
+			if record, err := result.Single(); err == nil {
+				storedHash, _ := record.Get("c.hash")
+				storedVersion, _ := record.Get("c.chunker_version")
+				hash, _ := asString(storedHash)
+				version, _ := asInt(storedVersion)
+				if hash == chunk.Hash && version == chunkerVersion {
+					referencesIndexed, _ := record.Get("c.references_indexed")
+					if referencesIndexed != true {
+						if err := storeReferences(tx, chunk); err != nil {
+							return nil, err
+						}
+					}
+					continue
+				}
+			}
+
+			_, err = tx.Run(
+				`MERGE (c:Chunk {id: $id})
+				 ON CREATE SET c.created_at = datetime()
+				 SET c.content = $content,
+				     c.file_path = $filePath,
+				     c.project_path = $projectPath,
+				     c.start_line = $startLine,
+				     c.end_line = $endLine,
+				     c.start_byte = $startByte,
+				     c.end_byte = $endByte,
+				     c.entity_type = $entityType,
+				     c.name = $name,
+				     c.signature = $signature,
+				     c.language = $language,
+				     c.hash = $hash,
+				     c.embedding = $embedding,
+				     c.embedding2 = $embedding2,
+				     c.embedding_dim = size($embedding),
+				     c.embedded = $embedded,
+				     c.chunker_version = $chunkerVersion,
+				     c.is_vendored = $isVendored,
+				     c.updated_at = datetime()
+				 WITH c
+				 MATCH (f:File {path: $filePath})
+				 MERGE (c)-[:PART_OF]->(f)`,
+				map[string]interface{}{
+					"id":             chunk.ID,
+					"content":        chunk.Content,
+					"filePath":       chunk.FilePath,
+					"startLine":      chunk.StartLine,
+					"endLine":        chunk.EndLine,
+					"startByte":      chunk.StartByte,
+					"endByte":        chunk.EndByte,
+					"entityType":     chunk.EntityType,
+					"name":           chunk.Name,
+					"signature":      chunk.Signature,
+					"language":       chunk.Language,
+					"hash":           chunk.Hash,
+					"embedding":      vectorParam(chunk.Embedding),
+					"embedding2":     vectorParam(chunk.Embedding2),
+					"projectPath":    chunk.ProjectPath,
+					"chunkerVersion": chunkerVersion,
+					"isVendored":     chunk.IsVendored,
+					"embedded":       len(chunk.Embedding) > 0,
+				},
+			)
+			if err != nil {
+				return nil, err
+			}
+			if err := storeReferences(tx, chunk); err != nil {
+				return nil, err
+			}
+		}
+
+		ids := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			ids[i] = chunk.ID
+		}
+		_, err := tx.Run(
+			`MATCH (c:Chunk)-[:PART_OF]->(f:File {path: $filePath})
+			 WHERE NOT c.id IN $ids
+			 DETACH DELETE c`,
+			map[string]interface{}{"filePath": filePath, "ids": ids},
+		)
+		return nil, err
+	})
+	return err
+}
+
+// BenchmarkNeo4jStoreChunks compares storing a file of many chunks with one
+// UNWIND statement against the statement-per-chunk baseline, both when
+// every chunk changed and when none did. Each iteration stores the whole
+// file; chunks/s is the number to compare.
+func BenchmarkNeo4jStoreChunks(b *testing.B) {
+	const numChunks = 500
+	stores := []struct {
+		name  string
+		store func(rag *Neo4jRAG, chunks []CodeChunk, filePath, projectPath string) error
+	}{
+		{"per-chunk", func(rag *Neo4jRAG, chunks []CodeChunk, filePath, projectPath string) error {
+			return storeChunksOneByOne(rag, chunks, filePath)
+		}},
+		{"batched", func(rag *Neo4jRAG, chunks []CodeChunk, filePath, projectPath string) error {
+			return rag.storeChunks(context.Background(), chunks, filePath, projectPath)
+		}},
+	}
+
+	for _, s := range stores {
+		for _, changed := range []bool{true, false} {
+			name := s.name + "/unchanged"
+			if changed {
+				name = s.name + "/changed"
+			}
+			store := s.store
+			b.Run(name, func(b *testing.B) {
+				rag, dir := newTestRAG(b, Config{})
+				file := filepath.Join(dir, "steps.go")
+
+				// Storing one version first creates the project and file
+				// nodes, which the baseline needs; changed runs then
+				// alternate between the two versions
+				versions := [][]CodeChunk{
+					storeTestChunks(b, rag, file, dir, benchmarkChunks(numChunks, 0)),
+					prepareTestChunks(b, rag, file, dir, benchmarkChunks(numChunks, 1)),
+				}
+
+				b.ResetTimer()
+				start := time.Now()
+				for i := 0; i < b.N; i++ {
+					chunks := versions[0]
+					if changed {
+						chunks = versions[(i+1)%2]
+					}
+					if err := store(rag, chunks, file, dir); err != nil {
+						b.Fatalf("storing %d chunks: %v", len(chunks), err)
+					}
+				}
+				b.StopTimer()
+				b.ReportMetric(float64(numChunks*b.N)/time.Since(start).Seconds(), "chunks/s")
+			})
+		}
+	}
+}
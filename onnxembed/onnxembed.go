@@ -0,0 +1,189 @@
+// Package onnxembed computes sentence embeddings in process with a
+// sentence-transformers model exported to ONNX, such as all-MiniLM-L6-v2, so
+// the indexer works without a separate embedding service. A model directory
+// holds the exported model.onnx and the vocab.txt of its WordPiece tokenizer.
+//
+// Running the model needs ONNX Runtime through github.com/yalue/onnxruntime_go,
+// which is only compiled in with the onnx build tag:
+//
+//	go get github.com/yalue/onnxruntime_go
+//	go build -tags onnx main.go
+//
+// Without the tag, New and CheckModelDir return ErrNotBuilt.
+package onnxembed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Defaults for Options, matching all-MiniLM-L6-v2
+const (
+	DefaultMaxLength = 256
+	DefaultDimension = 384
+)
+
+// File names expected in a model directory
+const (
+	ModelFile = "model.onnx"
+	VocabFile = "vocab.txt"
+)
+
+// ErrNotBuilt is returned when the package was built without the onnx tag
+var ErrNotBuilt = errors.New("in-process embeddings need ONNX Runtime support; rebuild with -tags onnx")
+
+// Options configures New
+type Options struct {
+	ModelDir       string // Directory holding ModelFile and VocabFile
+	RuntimeLibrary string // Path of the ONNX Runtime shared library; empty uses the platform default
+	MaxLength      int    // Longest token sequence, including [CLS] and [SEP]; 0 uses DefaultMaxLength
+	Dimension      int    // Hidden size of the model output; 0 uses DefaultDimension
+}
+
+// runner runs the model on a padded batch of token sequences and returns
+// the flattened last hidden state, batch x seqLen x dimension
+type runner interface {
+	run(ids, mask, types []int64, batch, seqLen int) ([]float32, error)
+	close() error
+}
+
+// Embedder embeds texts with an ONNX model. It is safe for concurrent use.
+type Embedder struct {
+	tokenizer *Tokenizer
+	runner    runner
+	maxLength int
+	dimension int
+}
+
+// New loads the tokenizer and model from opts.ModelDir
+func New(opts Options) (*Embedder, error) {
+	if opts.MaxLength <= 0 {
+		opts.MaxLength = DefaultMaxLength
+	}
+	if opts.Dimension <= 0 {
+		opts.Dimension = DefaultDimension
+	}
+	if err := CheckModelDir(opts.ModelDir); err != nil {
+		return nil, err
+	}
+
+	tokenizer, err := LoadVocab(filepath.Join(opts.ModelDir, VocabFile))
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newRunner(filepath.Join(opts.ModelDir, ModelFile), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", ModelFile, err)
+	}
+
+	return &Embedder{
+		tokenizer: tokenizer,
+		runner:    r,
+		maxLength: opts.MaxLength,
+		dimension: opts.Dimension,
+	}, nil
+}
+
+// CheckModelDir reports whether in-process embedding is available: the
+// package was built with ONNX Runtime support and dir holds the model files
+func CheckModelDir(dir string) error {
+	if !builtWithRuntime {
+		return ErrNotBuilt
+	}
+	if dir == "" {
+		return errors.New("no ONNX model directory configured")
+	}
+	for _, name := range []string{ModelFile, VocabFile} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("model directory %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// Embed returns one unit-length embedding per text, in order. The token
+// embeddings of each text are mean-pooled over its attention mask, as
+// sentence-transformers does.
+func (e *Embedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return [][]float32{}, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Pad every sequence in the batch to the longest one
+	sequences := make([][]int64, len(texts))
+	seqLen := 0
+	for i, text := range texts {
+		sequences[i] = e.tokenizer.Encode(text, e.maxLength)
+		if len(sequences[i]) > seqLen {
+			seqLen = len(sequences[i])
+		}
+	}
+
+	ids := make([]int64, len(texts)*seqLen)
+	mask := make([]int64, len(texts)*seqLen)
+	types := make([]int64, len(texts)*seqLen)
+	for i, sequence := range sequences {
+		for j := range ids[i*seqLen : (i+1)*seqLen] {
+			ids[i*seqLen+j] = e.tokenizer.pad
+		}
+		copy(ids[i*seqLen:], sequence)
+		for j := range sequence {
+			mask[i*seqLen+j] = 1
+		}
+	}
+
+	hidden, err := e.runner.run(ids, mask, types, len(texts), seqLen)
+	if err != nil {
+		return nil, err
+	}
+	if len(hidden) != len(texts)*seqLen*e.dimension {
+		return nil, fmt.Errorf("model returned %d values, expected %d (is the dimension %d right?)",
+			len(hidden), len(texts)*seqLen*e.dimension, e.dimension)
+	}
+
+	embeddings := make([][]float32, len(texts))
+	for i, sequence := range sequences {
+		embeddings[i] = meanPool(hidden[i*seqLen*e.dimension:(i+1)*seqLen*e.dimension], len(sequence), e.dimension)
+	}
+	return embeddings, nil
+}
+
+// Close releases the model session
+func (e *Embedder) Close() error {
+	return e.runner.close()
+}
+
+// meanPool averages the first tokens token vectors of hidden, which holds
+// dimension values per token, and scales the result to unit length
+func meanPool(hidden []float32, tokens, dimension int) []float32 {
+	sum := make([]float64, dimension)
+	for t := 0; t < tokens; t++ {
+		for d, x := range hidden[t*dimension : (t+1)*dimension] {
+			sum[d] += float64(x)
+		}
+	}
+
+	var norm float64
+	for d := range sum {
+		sum[d] /= float64(tokens)
+		norm += sum[d] * sum[d]
+	}
+	norm = math.Sqrt(norm)
+
+	pooled := make([]float32, dimension)
+	for d, x := range sum {
+		if norm > 0 {
+			x /= norm
+		}
+		pooled[d] = float32(x)
+	}
+	return pooled
+}
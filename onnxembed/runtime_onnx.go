@@ -0,0 +1,78 @@
+//go:build onnx
+
+package onnxembed
+
+import (
+	"sync"
+
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+// builtWithRuntime reports whether ONNX Runtime support is compiled in
+const builtWithRuntime = true
+
+// initOnce initializes the process-wide ONNX Runtime environment
+var (
+	initOnce sync.Once
+	initErr  error
+)
+
+// ortRunner runs a model through ONNX Runtime. The model takes the BERT
+// inputs input_ids, attention_mask and token_type_ids and produces
+// last_hidden_state.
+type ortRunner struct {
+	session   *ort.DynamicAdvancedSession
+	dimension int
+}
+
+func newRunner(modelPath string, opts Options) (runner, error) {
+	initOnce.Do(func() {
+		if opts.RuntimeLibrary != "" {
+			ort.SetSharedLibraryPath(opts.RuntimeLibrary)
+		}
+		initErr = ort.InitializeEnvironment()
+	})
+	if initErr != nil {
+		return nil, initErr
+	}
+
+	session, err := ort.NewDynamicAdvancedSession(modelPath,
+		[]string{"input_ids", "attention_mask", "token_type_ids"},
+		[]string{"last_hidden_state"}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ortRunner{session: session, dimension: opts.Dimension}, nil
+}
+
+func (o *ortRunner) run(ids, mask, types []int64, batch, seqLen int) ([]float32, error) {
+	shape := ort.NewShape(int64(batch), int64(seqLen))
+	inputs := make([]ort.Value, 0, 3)
+	defer func() {
+		for _, input := range inputs {
+			input.Destroy()
+		}
+	}()
+	for _, data := range [][]int64{ids, mask, types} {
+		tensor, err := ort.NewTensor(shape, data)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, tensor)
+	}
+
+	output, err := ort.NewEmptyTensor[float32](ort.NewShape(int64(batch), int64(seqLen), int64(o.dimension)))
+	if err != nil {
+		return nil, err
+	}
+	defer output.Destroy()
+
+	if err := o.session.Run(inputs, []ort.Value{output}); err != nil {
+		return nil, err
+	}
+	return append([]float32(nil), output.GetData()...), nil
+}
+
+func (o *ortRunner) close() error {
+	return o.session.Destroy()
+}
@@ -0,0 +1,10 @@
+//go:build !onnx
+
+package onnxembed
+
+// builtWithRuntime reports whether ONNX Runtime support is compiled in
+const builtWithRuntime = false
+
+func newRunner(modelPath string, opts Options) (runner, error) {
+	return nil, ErrNotBuilt
+}
@@ -0,0 +1,145 @@
+package onnxembed
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// maxWordRunes is the longest word WordPiece splits; longer words become
+// [UNK], as in BERT
+const maxWordRunes = 100
+
+// Tokenizer is an uncased BERT WordPiece tokenizer. Unlike the reference
+// implementation it does not strip accents, which matters little for code.
+type Tokenizer struct {
+	vocab map[string]int64
+	unk   int64
+	cls   int64
+	sep   int64
+	pad   int64
+}
+
+// LoadVocab reads a vocab.txt with one token per line, the line number
+// being the token ID
+func LoadVocab(path string) (*Tokenizer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	vocab := map[string]int64{}
+	scanner := bufio.NewScanner(file)
+	for id := int64(0); scanner.Scan(); id++ {
+		vocab[strings.TrimRight(scanner.Text(), "\r")] = id
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	t := &Tokenizer{vocab: vocab}
+	for token, id := range map[string]*int64{"[UNK]": &t.unk, "[CLS]": &t.cls, "[SEP]": &t.sep, "[PAD]": &t.pad} {
+		var ok bool
+		if *id, ok = vocab[token]; !ok {
+			return nil, fmt.Errorf("%s has no %s token", path, token)
+		}
+	}
+	return t, nil
+}
+
+// Encode returns the token IDs of text between [CLS] and [SEP], truncated
+// to maxLength IDs in total
+func (t *Tokenizer) Encode(text string, maxLength int) []int64 {
+	ids := []int64{t.cls}
+	for _, word := range basicTokens(text) {
+		for _, id := range t.wordPiece(word) {
+			if len(ids) >= maxLength-1 {
+				return append(ids, t.sep)
+			}
+			ids = append(ids, id)
+		}
+	}
+	return append(ids, t.sep)
+}
+
+// wordPiece splits a word into the longest vocabulary pieces from the left,
+// continuation pieces carrying a "##" prefix. A word that cannot be split
+// becomes [UNK].
+func (t *Tokenizer) wordPiece(word string) []int64 {
+	runes := []rune(word)
+	if len(runes) > maxWordRunes {
+		return []int64{t.unk}
+	}
+
+	ids := []int64{}
+	for start := 0; start < len(runes); {
+		end := len(runes)
+		found := false
+		for ; end > start; end-- {
+			piece := string(runes[start:end])
+			if start > 0 {
+				piece = "##" + piece
+			}
+			if id, ok := t.vocab[piece]; ok {
+				ids = append(ids, id)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return []int64{t.unk}
+		}
+		start = end
+	}
+	return ids
+}
+
+// basicTokens lowercases text, drops control characters and splits it into
+// words at whitespace, with each punctuation mark and CJK character as a word
+// of its own
+func basicTokens(text string) []string {
+	words := []string{}
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case r == 0 || r == unicode.ReplacementChar || unicode.IsControl(r):
+			// Dropped
+		case isPunctuation(r) || isCJK(r):
+			flush()
+			words = append(words, string(r))
+		default:
+			word.WriteRune(unicode.ToLower(r))
+		}
+	}
+	flush()
+	return words
+}
+
+// isPunctuation reports whether BERT treats r as punctuation: every
+// non-alphanumeric ASCII symbol, plus Unicode punctuation
+func isPunctuation(r rune) bool {
+	if (r >= 33 && r <= 47) || (r >= 58 && r <= 64) || (r >= 91 && r <= 96) || (r >= 123 && r <= 126) {
+		return true
+	}
+	return unicode.IsPunct(r)
+}
+
+// isCJK reports whether r is in a CJK ideograph block
+func isCJK(r rune) bool {
+	return (r >= 0x4E00 && r <= 0x9FFF) || (r >= 0x3400 && r <= 0x4DBF) ||
+		(r >= 0x20000 && r <= 0x2A6DF) || (r >= 0x2A700 && r <= 0x2B73F) ||
+		(r >= 0x2B740 && r <= 0x2B81F) || (r >= 0x2B820 && r <= 0x2CEAF) ||
+		(r >= 0xF900 && r <= 0xFAFF) || (r >= 0x2F800 && r <= 0x2FA1F)
+}
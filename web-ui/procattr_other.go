@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// detachFromTerminal does nothing where processes have no process groups;
+// console interrupts may then stop in-flight queries
+func detachFromTerminal(cmd *exec.Cmd) {}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detachFromTerminal starts cmd in its own process group, so a Ctrl-C in the
+// server's terminal, which signals the whole foreground group, reaches the
+// server but not the queries it is still waiting on during shutdown
+func detachFromTerminal(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
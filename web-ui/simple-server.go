@@ -1,21 +1,51 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode"
 )
 
+// embeddedAssets holds the UI assets in static/, built into the server so
+// it runs as a single binary from any directory. --static-dir serves them
+// from disk instead, so edits show up without a rebuild.
+//
+//go:embed static
+var embeddedAssets embed.FS
+
+// indexPage is served for the root and, as the SPA fallback, for any path
+// that is not a static file
+const indexPage = "simple.html"
+
 func main() {
 	// Parse command line flags
 	port := flag.Int("port", 8000, "Port to listen on")
 	mainBinary := flag.String("main", "../main", "Path to the main binary")
+	corsOrigins := flag.String("cors-origins", "*", "Comma-separated list of origins allowed to call the API, or * for any")
+	statsCacheTTL := flag.Duration("stats-cache", 5*time.Second, "How long /api/stats reuses the last index statistics")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 3*time.Minute, "How long to let in-flight requests, such as LLM queries, finish on SIGINT or SIGTERM")
+	staticDir := flag.String("static-dir", "", "Directory to serve the UI from, e.g. static for development (default: the assets built into the server)")
 	flag.Parse()
 
 	// Print current working directory for debugging
@@ -37,86 +67,326 @@ func main() {
 		log.Fatalf("Main binary not found at %s", absMainBinary)
 	}
 
+	// Serve static assets from an explicit root, never the working directory
+	static, err := fs.Sub(embeddedAssets, "static")
+	if err != nil {
+		log.Fatalf("Error loading embedded assets: %v", err)
+	}
+	if *staticDir != "" {
+		absStaticDir, err := filepath.Abs(*staticDir)
+		if err != nil {
+			log.Fatalf("Error resolving static directory: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(absStaticDir, indexPage)); err != nil {
+			log.Fatalf("Static directory %s has no %s: %v", absStaticDir, indexPage, err)
+		}
+		static = os.DirFS(absStaticDir)
+	}
+
 	// Create server
 	logger := log.New(os.Stdout, "SIMPLE-SERVER: ", log.LstdFlags)
 	server := &SimpleServer{
 		mainBinary: absMainBinary,
 		logger:     logger,
+		static:     static,
+		files:      http.FileServer(http.FS(static)),
+		cors:       parseOrigins(*corsOrigins),
+		stats:      &statsCache{ttl: *statsCacheTTL},
 	}
 
 	// Set up routes
 	http.HandleFunc("/", server.handleRoot)
-	http.HandleFunc("/api/test-search", server.handleTestSearch)
-	http.HandleFunc("/api/llm-query", server.handleLLMQuery)
+	http.HandleFunc("/api/test-search", server.withCORS("GET, POST", server.handleTestSearch))
+	http.HandleFunc("/api/llm-query", server.withCORS("GET, POST", server.handleLLMQuery))
+	http.HandleFunc("/api/stream-search", server.withCORS("GET, POST", server.handleStreamSearch))
+	http.HandleFunc("/api/health", server.withCORS("GET", server.handleHealth))
+	http.HandleFunc("/api/stats", server.withCORS("GET", server.handleStats))
 
 	// Start server
 	addr := fmt.Sprintf(":%d", *port)
-	logger.Printf("Starting server on %s", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	httpServer := &http.Server{Addr: addr}
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Printf("Starting server on %s", addr)
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	// Stop accepting connections on SIGINT or SIGTERM and let in-flight
+	// requests finish, up to the shutdown timeout
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	stop() // A second signal now stops the server immediately
+
+	logger.Printf("Shutting down, waiting up to %v for in-flight requests", *shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Printf("Graceful shutdown failed: %v; closing remaining connections", err)
+		httpServer.Close()
+	}
+	logger.Printf("Server stopped")
 }
 
 // SimpleServer handles HTTP requests
 type SimpleServer struct {
 	mainBinary string
 	logger     *log.Logger
+	static     fs.FS        // UI assets
+	files      http.Handler // File server over static
+	cors       corsOrigins  // Origins allowed to call the API
+	stats      *statsCache
 }
 
-// handleRoot serves the simple.html file
-func (s *SimpleServer) handleRoot(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.ServeFile(w, r, filepath.Join(".", r.URL.Path))
-		return
+// statsCache keeps the last index statistics for ttl, so page loads do not
+// each run a set of Neo4j aggregations
+type statsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	body    []byte
+	fetched time.Time
+}
+
+// corsOrigins is the set of origins allowed to make cross-origin API
+// requests; the "*" entry allows any origin
+type corsOrigins map[string]bool
+
+// parseOrigins parses a comma-separated origin list
+func parseOrigins(value string) corsOrigins {
+	origins := corsOrigins{}
+	for _, origin := range strings.Split(value, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins[strings.TrimSuffix(origin, "/")] = true
+		}
 	}
-	http.ServeFile(w, r, "simple.html")
+	return origins
 }
 
-// handleTestSearch executes the main binary with search arguments
-func (s *SimpleServer) handleTestSearch(w http.ResponseWriter, r *http.Request) {
-	// Set CORS headers
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	// Handle OPTIONS request
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
+// withCORS sets the CORS headers for requests from allowed origins and
+// answers preflight OPTIONS requests, so handlers only see the methods they
+// serve. methods lists those methods for Access-Control-Allow-Methods.
+func (s *SimpleServer) withCORS(methods string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		switch {
+		case s.cors["*"]:
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		case origin != "" && s.cors[origin]:
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		// Caches must key on Origin when the allowed origin is echoed
+		if !s.cors["*"] {
+			w.Header().Add("Vary", "Origin")
+		}
+		w.Header().Set("Access-Control-Allow-Methods", methods+", OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+		// Browsers refuse the preflight response of a disallowed origin,
+		// as it carries no Access-Control-Allow-Origin
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleRoot serves static assets, falling back to simple.html for the root
+// and any other path that is not a file, so client-side routes load the app
+func (s *SimpleServer) handleRoot(w http.ResponseWriter, r *http.Request) {
+	// Clean the path so it cannot climb out of the static root
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+
+	// Unknown API routes are errors, not pages
+	if name == "api" || strings.HasPrefix(name, "api/") {
+		http.NotFound(w, r)
 		return
 	}
 
-	// Get query parameters
-	query := r.URL.Query().Get("query")
-	if query == "" {
-		http.Error(w, "Missing query parameter", http.StatusBadRequest)
+	if name != "" && name != indexPage {
+		if info, err := fs.Stat(s.static, name); err == nil && !info.IsDir() {
+			s.files.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	// Serve the page itself rather than through the file server, which
+	// would redirect requests for simple.html to its directory
+	page, err := fs.ReadFile(s.static, indexPage)
+	if err != nil {
+		http.Error(w, "UI not available", http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
+
+// Bounds for the search parameters accepted by the API
+const (
+	maxQueryLength = 2000 // Characters, after control characters are removed
+	maxLimit       = 100
+	maxOffset      = 1000
+	maxContext     = 50 // Chunks in an LLM prompt
+	maxTemperature = 2
+
+	maxAnswerTokens = 8192 // Tokens the LLM may generate for an answer
+)
+
+// languageListPattern matches a comma-separated list of language names
+var languageListPattern = regexp.MustCompile(`^[A-Za-z0-9+#._-]+(,[A-Za-z0-9+#._-]+)*$`)
+
+// searchParams are the validated search parameters of an API request
+type searchParams struct {
+	query    string
+	language string
+	minScore float64
+	limit    int // 0 leaves the main binary's default
+	offset   int
+	context  int // Chunks in an LLM prompt; 0 leaves the default
+
+	// LLM generation settings; maxTokens 0 and a nil temperature leave the
+	// defaults
+	maxTokens   int
+	temperature *float64
+}
+
+// parseSearchParams validates the query, language, min_score, limit, offset,
+// context_chunks, max_tokens and temperature parameters. Control characters are removed from the query, so
+// pasted text cannot smuggle terminal escapes or extra lines into the
+// arguments and logs.
+func parseSearchParams(values url.Values) (searchParams, error) {
+	params := searchParams{minScore: 0.1}
+
+	params.query = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return ' '
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, values.Get("query")))
+	if params.query == "" {
+		return params, fmt.Errorf("missing query parameter")
+	}
+	if n := len([]rune(params.query)); n > maxQueryLength {
+		return params, fmt.Errorf("query is %d characters long, the limit is %d", n, maxQueryLength)
+	}
+
+	if params.language = values.Get("language"); params.language != "" && !languageListPattern.MatchString(params.language) {
+		return params, fmt.Errorf("invalid language %q: expected a comma-separated list of language names", params.language)
+	}
+
+	if value := values.Get("min_score"); value != "" {
+		minScore, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(minScore) || minScore < 0 || minScore > 1 {
+			return params, fmt.Errorf("invalid min_score %q: expected a number between 0 and 1", value)
+		}
+		params.minScore = minScore
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return params, fmt.Errorf("invalid limit %q: expected a whole number between 1 and %d", value, maxLimit)
+		}
+		params.limit = limit
+	}
+
+	if value := values.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 || offset > maxOffset {
+			return params, fmt.Errorf("invalid offset %q: expected a whole number between 0 and %d", value, maxOffset)
+		}
+		params.offset = offset
+	}
+
+	if value := values.Get("context_chunks"); value != "" {
+		context, err := strconv.Atoi(value)
+		if err != nil || context < 1 || context > maxContext {
+			return params, fmt.Errorf("invalid context_chunks %q: expected a whole number between 1 and %d", value, maxContext)
+		}
+		params.context = context
+	}
+
+	if value := values.Get("max_tokens"); value != "" {
+		tokens, err := strconv.Atoi(value)
+		if err != nil || tokens < 1 || tokens > maxAnswerTokens {
+			return params, fmt.Errorf("invalid max_tokens %q: expected a whole number between 1 and %d", value, maxAnswerTokens)
+		}
+		params.maxTokens = tokens
+	}
 
-	language := r.URL.Query().Get("language")
-	minScore := r.URL.Query().Get("min_score")
-	if minScore == "" {
-		minScore = "0.1"
+	if value := values.Get("temperature"); value != "" {
+		temperature, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(temperature) || temperature < 0 || temperature > maxTemperature {
+			return params, fmt.Errorf("invalid temperature %q: expected a number between 0 and %d", value, maxTemperature)
+		}
+		params.temperature = &temperature
 	}
 
-	// Build command arguments with properly quoted query string
-	args := []string{"--query", "--query-string", fmt.Sprintf("%q", query)}
+	return params, nil
+}
 
-	// Add language filter if provided
-	if language != "" {
-		args = append(args, "--languages", language)
+// args returns the main binary's arguments for the parameters. Values are
+// passed as separate arguments without a shell, so they need no quoting.
+func (p searchParams) args() []string {
+	args := []string{"--query-string", p.query, "--min-score", strconv.FormatFloat(p.minScore, 'f', -1, 64)}
+	if p.language != "" {
+		args = append(args, "--languages", p.language)
+	}
+	if p.limit > 0 {
+		args = append(args, "--limit", strconv.Itoa(p.limit))
+	}
+	// Page past earlier results for "show more"
+	if p.offset > 0 {
+		args = append(args, "--offset", strconv.Itoa(p.offset))
+	}
+	if p.context > 0 {
+		args = append(args, "--context-chunks", strconv.Itoa(p.context))
+	}
+	if p.maxTokens > 0 {
+		args = append(args, "--max-tokens", strconv.Itoa(p.maxTokens))
+	}
+	if p.temperature != nil {
+		args = append(args, "--temperature", strconv.FormatFloat(*p.temperature, 'f', -1, 64))
 	}
+	return args
+}
+
+// command returns a command running name in the main binary's directory. It
+// is killed when ctx is done, and runs in its own process group (see
+// detachFromTerminal) so in-flight queries survive the Ctrl-C that starts a
+// graceful shutdown; if the shutdown times out, closing the connections
+// cancels the request contexts and so kills them.
+func (s *SimpleServer) command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = filepath.Dir(s.mainBinary)
+	cmd.Env = os.Environ()
+	detachFromTerminal(cmd)
+	return cmd
+}
 
-	// Add min score
-	args = append(args, "--min-score", minScore)
+// handleTestSearch executes the main binary with search arguments
+func (s *SimpleServer) handleTestSearch(w http.ResponseWriter, r *http.Request) {
+	params, err := parseSearchParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	args := append([]string{"--query"}, params.args()...)
 
 	// Log the command
 	s.logger.Printf("Executing command: go run %s %s", filepath.Base(s.mainBinary), strings.Join(args, " "))
 
 	// Create command - use 'go run' instead of direct execution
-	mainDir := filepath.Dir(s.mainBinary)
 	mainFile := filepath.Base(s.mainBinary)
 	allArgs := append([]string{"run", mainFile}, args...)
-	cmd := exec.Command("go", allArgs...)
-	cmd.Dir = mainDir
-	cmd.Env = os.Environ()
+	cmd := s.command(r.Context(), "go", allArgs...)
 
 	// Execute command
 	output, err := cmd.CombinedOutput()
@@ -133,81 +403,162 @@ func (s *SimpleServer) handleTestSearch(w http.ResponseWriter, r *http.Request)
 
 // handleLLMQuery executes the main binary with LLM query arguments
 func (s *SimpleServer) handleLLMQuery(w http.ResponseWriter, r *http.Request) {
-	// Set CORS headers
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	// Handle OPTIONS request
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
+	params, err := parseSearchParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Get query parameters
-	query := r.URL.Query().Get("query")
-	if query == "" {
-		http.Error(w, "Missing query parameter", http.StatusBadRequest)
+	// Set a longer timeout for LLM queries to accommodate LMStudio's single-threaded processing
+	timeoutDuration := 3 * time.Minute
+	
+	args := append([]string{"--query", "--llm-response"}, params.args()...)
+
+	// Log the command
+	s.logger.Printf("Executing LLM query command: %s %s", s.mainBinary, strings.Join(args, " "))
+
+	// Create command with timeout - execute the binary directly. The
+	// process is killed on timeout or when the client goes away.
+	ctx, cancel := context.WithTimeout(r.Context(), timeoutDuration)
+	defer cancel()
+	cmd := s.command(ctx, s.mainBinary, args...)
+
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		s.logger.Printf("LLM query timed out after %v", timeoutDuration)
+		http.Error(w, fmt.Sprintf("LLM query timed out after %v", timeoutDuration), http.StatusGatewayTimeout)
 		return
 	}
+	if err != nil {
+		s.logger.Printf("Error executing LLM query: %v, Output: %s", err, string(output))
+		http.Error(w, fmt.Sprintf("Error executing LLM query: %v\nOutput: %s", err, string(output)), http.StatusInternalServerError)
+		return
+	}
+
+	// Return output
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(output)
+}
 
-	language := r.URL.Query().Get("language")
-	minScore := r.URL.Query().Get("min_score")
-	if minScore == "" {
-		minScore = "0.1"
+// handleStreamSearch runs a streaming search and relays its newline-delimited
+// JSON events to the client as they are produced
+func (s *SimpleServer) handleStreamSearch(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
 	}
 
-	// Set a longer timeout for LLM queries to accommodate LMStudio's single-threaded processing
-	timeoutDuration := 3 * time.Minute
-	
-	// Build command arguments with properly quoted query string
-	args := []string{"--query", "--llm-response", "--query-string", fmt.Sprintf("%q", query)}
+	params, err := parseSearchParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	args := append([]string{"--query", "--stream"}, params.args()...)
+
+	s.logger.Printf("Executing streaming search command: %s %s", s.mainBinary, strings.Join(args, " "))
+
+	// Tie the process to the request so a disconnected client stops the search
+	cmd := s.command(r.Context(), s.mainBinary, args...)
 
-	// Add language filter if provided
-	if language != "" {
-		args = append(args, "--languages", language)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error starting search: %v", err), http.StatusInternalServerError)
+		return
 	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
 
-	// Add min score
-	args = append(args, "--min-score", minScore)
+	if err := cmd.Start(); err != nil {
+		http.Error(w, fmt.Sprintf("Error starting search: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-	// Log the command
-	s.logger.Printf("Executing LLM query command: %s %s", s.mainBinary, strings.Join(args, " "))
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
 
-	// Create command with timeout - execute the binary directly
-	cmd := exec.Command(s.mainBinary, args...)
-	cmd.Dir = filepath.Dir(s.mainBinary)
-	cmd.Env = os.Environ()
+	// Relay only the JSON event lines; the binary also logs to stdout
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+		w.Write(line)
+		w.Write([]byte("\n"))
+		flusher.Flush()
+	}
 
-	// Execute command with timeout
-	outputChan := make(chan struct {
-		output []byte
-		err    error
-	})
+	if err := cmd.Wait(); err != nil {
+		s.logger.Printf("Streaming search failed: %v, Stderr: %s", err, stderr.String())
+		errorEvent, _ := json.Marshal(map[string]string{"type": "error", "error": err.Error()})
+		w.Write(append(errorEvent, '\n'))
+		flusher.Flush()
+	}
+}
 
-	go func() {
-		output, err := cmd.CombinedOutput()
-		outputChan <- struct {
-			output []byte
-			err    error
-		}{output, err}
-	}()
+// handleHealth runs the main binary's health check and returns its
+// per-dependency JSON report, with status 503 when a dependency is down
+func (s *SimpleServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-	// Wait for command to complete or timeout
-	select {
-	case result := <-outputChan:
-		if result.err != nil {
-			s.logger.Printf("Error executing LLM query: %v, Output: %s", result.err, string(result.output))
-			http.Error(w, fmt.Sprintf("Error executing LLM query: %v\nOutput: %s", result.err, string(result.output)), http.StatusInternalServerError)
+	cmd := s.command(r.Context(), s.mainBinary, "--health")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	// The binary exits non-zero when a dependency is unhealthy but still
+	// prints the report
+	output, err := cmd.Output()
+	var report json.RawMessage
+	if jsonErr := json.Unmarshal(output, &report); jsonErr != nil {
+		s.logger.Printf("Health check failed: %v, Stderr: %s", err, stderr.String())
+		http.Error(w, fmt.Sprintf("Error running health check: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(report)
+}
+
+// handleStats returns the index statistics of the main binary's --stats as
+// JSON: chunk, file and project counts, chunks per language, entity type and
+// project, embedding dimensions and the last index time
+func (s *SimpleServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Holding the lock while fetching makes concurrent requests share one run
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	if s.stats.body == nil || time.Since(s.stats.fetched) > s.stats.ttl {
+		cmd := s.command(r.Context(), s.mainBinary, "--stats", "--json-output")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+
+		output, err := cmd.Output()
+		var stats json.RawMessage
+		if err == nil {
+			err = json.Unmarshal(output, &stats)
+		}
+		if err != nil {
+			s.logger.Printf("Index stats failed: %v, Stderr: %s", err, stderr.String())
+			http.Error(w, fmt.Sprintf("Error getting index stats: %v", err), http.StatusInternalServerError)
 			return
 		}
-		// Return output
-		w.Header().Set("Content-Type", "text/plain")
-		w.Write(result.output)
-	case <-time.After(timeoutDuration):
-		// Kill the process if it times out
-		cmd.Process.Kill()
-		s.logger.Printf("LLM query timed out after %v", timeoutDuration)
-		http.Error(w, fmt.Sprintf("LLM query timed out after %v", timeoutDuration), http.StatusGatewayTimeout)
+		s.stats.body = stats
+		s.stats.fetched = time.Now()
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(s.stats.body)
 }